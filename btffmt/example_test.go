@@ -0,0 +1,25 @@
+package btffmt_test
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/kyleseneker/tinybpf/btffmt"
+)
+
+func ExampleSprint() {
+	event := &btf.Struct{Name: "event", Size: 8, Members: []btf.Member{
+		{Name: "pid", Type: &btf.Int{Name: "u32", Size: 4}},
+		{Name: "comm", Type: &btf.Array{Type: &btf.Int{Name: "char", Size: 1}, Nelems: 4}, Offset: 32},
+	}}
+	raw := []byte{42, 0, 0, 0, 's', 'h', 0, 0}
+
+	text, _ := btffmt.Sprint(event, raw, btffmt.Text)
+	fmt.Println(text)
+	json, _ := btffmt.Sprint(event, raw, btffmt.JSON)
+	fmt.Println(json)
+	// Output:
+	// {pid: 42, comm: "sh"}
+	// {"pid":42,"comm":"sh"}
+}
@@ -0,0 +1,401 @@
+// Package btffmt renders raw BPF map keys, values and event records as text
+// or JSON using the BTF type information of a BPF ELF object.
+//
+// Data is decoded as little-endian, the byte order of BPF objects on x86-64
+// and arm64. Byte arrays whose contents up to the first NUL are printable
+// ASCII render as strings.
+package btffmt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// Format selects how [Sprint] and [Append] render a value.
+type Format int
+
+const (
+	// Text renders values as compact Go-like text, for example
+	// {pid: 42, comm: "curl", state: TCP_ESTABLISHED}.
+	Text Format = iota
+
+	// JSON renders values as a single line of JSON: structs as objects,
+	// arrays as arrays, enums as their name when known.
+	JSON
+)
+
+// ParseFormat returns the Format named "text" or "json".
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "text":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	}
+	return 0, fmt.Errorf("unknown format %q: expected text or json", s)
+}
+
+// LoadType returns the type called name from the BTF of the BPF ELF object
+// at path. TinyGo qualifies package-level types, so "connEvent" also finds
+// "main_connEvent".
+func LoadType(path, name string) (btf.Type, error) {
+	spec, err := btf.LoadSpec(path)
+	if err != nil {
+		return nil, fmt.Errorf("load BTF from %s: %w", path, err)
+	}
+	return TypeByName(spec, name)
+}
+
+// TypeByName returns the type called name, or "main_"+name, from spec,
+// skipping forward declarations.
+func TypeByName(spec *btf.Spec, name string) (btf.Type, error) {
+	for _, n := range []string{name, "main_" + name} {
+		candidates, err := spec.AnyTypesByName(n)
+		if errors.Is(err, btf.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("type %q: %w", name, err)
+		}
+		for _, t := range candidates {
+			if _, fwd := t.(*btf.Fwd); !fwd {
+				return t, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("type %q: %w", name, btf.ErrNotFound)
+}
+
+// Sprint decodes data as typ and returns it rendered in format f. Bytes
+// beyond the size of typ, such as perf sample padding, are ignored.
+func Sprint(typ btf.Type, data []byte, f Format) (string, error) {
+	buf, err := Append(nil, typ, data, f)
+	return string(buf), err
+}
+
+// Append is like [Sprint] but appends the rendered value to buf.
+func Append(buf []byte, typ btf.Type, data []byte, f Format) ([]byte, error) {
+	size, err := btf.Sizeof(typ)
+	if err != nil {
+		return buf, fmt.Errorf("size of %s: %w", typ, err)
+	}
+	if len(data) < size {
+		return buf, fmt.Errorf("%d bytes of data is too short for %s of %d bytes", len(data), typ, size)
+	}
+	p := printer{buf: buf, json: f == JSON}
+	err = p.value(typ, data[:size])
+	return p.buf, err
+}
+
+// printer renders decoded values into buf.
+type printer struct {
+	buf  []byte
+	json bool
+}
+
+func (p *printer) value(typ btf.Type, data []byte) error {
+	switch t := btf.UnderlyingType(typ).(type) {
+	case *btf.Int:
+		p.integer(t, data, 0, 0)
+	case *btf.Enum:
+		p.enum(t, readUint(data, int(t.Size)), t.Size*8)
+	case *btf.Float:
+		p.float(t, data)
+	case *btf.Pointer:
+		p.word("0x" + strconv.FormatUint(readUint(data, 8), 16))
+	case *btf.Array:
+		return p.array(t, data)
+	case *btf.Struct:
+		return p.composite(t.Members, data)
+	case *btf.Union:
+		return p.composite(t.Members, data)
+	case *btf.Var:
+		return p.value(t.Type, data)
+	case *btf.Datasec:
+		return p.datasec(t, data)
+	default:
+		return fmt.Errorf("cannot format %s", typ)
+	}
+	return nil
+}
+
+// integer renders an integer of t read from data, or a bitfield of bits
+// bits at bit offset shift when bits is not zero.
+func (p *printer) integer(t *btf.Int, data []byte, shift, bits uint32) {
+	var v uint64
+	if bits == 0 {
+		if t.Size > 8 {
+			p.word("0x" + hexLE(data[:t.Size]))
+			return
+		}
+		v = readUint(data, int(t.Size))
+		bits = t.Size * 8
+	} else {
+		v = readBits(data, shift, bits)
+	}
+	switch {
+	case t.Encoding == btf.Bool:
+		p.buf = strconv.AppendBool(p.buf, v != 0)
+	case t.Encoding&btf.Signed != 0:
+		p.buf = strconv.AppendInt(p.buf, signExtend(v, bits), 10)
+	default:
+		p.buf = strconv.AppendUint(p.buf, v, 10)
+	}
+}
+
+// enum renders v, an enum value of bits bits, as the name of its
+// enumerator, or as a number if t has no enumerator with that value.
+func (p *printer) enum(t *btf.Enum, v uint64, bits uint32) {
+	if t.Signed {
+		v = uint64(signExtend(v, bits))
+	}
+	for _, ev := range t.Values {
+		if ev.Value == v {
+			p.word(ev.Name)
+			return
+		}
+	}
+	if t.Signed {
+		p.buf = strconv.AppendInt(p.buf, int64(v), 10)
+	} else {
+		p.buf = strconv.AppendUint(p.buf, v, 10)
+	}
+}
+
+func (p *printer) float(t *btf.Float, data []byte) {
+	var f float64
+	bits := 64
+	switch t.Size {
+	case 4:
+		f, bits = float64(math.Float32frombits(uint32(readUint(data, 4)))), 32
+	case 8:
+		f = math.Float64frombits(readUint(data, 8))
+	default:
+		p.word("0x" + hexLE(data[:t.Size]))
+		return
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		p.word(strconv.FormatFloat(f, 'g', -1, bits))
+		return
+	}
+	p.buf = strconv.AppendFloat(p.buf, f, 'g', -1, bits)
+}
+
+func (p *printer) array(t *btf.Array, data []byte) error {
+	elemSize, err := btf.Sizeof(t.Type)
+	if err != nil {
+		return fmt.Errorf("size of %s: %w", t.Type, err)
+	}
+	if s, ok := byteString(t, data); ok {
+		p.str(s)
+		return nil
+	}
+	p.buf = append(p.buf, '[')
+	for i := range int(t.Nelems) {
+		if i > 0 {
+			p.sep()
+		}
+		if err := p.value(t.Type, data[i*elemSize:(i+1)*elemSize]); err != nil {
+			return err
+		}
+	}
+	p.buf = append(p.buf, ']')
+	return nil
+}
+
+// composite renders the members of a struct or union as fields of one
+// object, flattening anonymous struct and union members into it.
+func (p *printer) composite(members []btf.Member, data []byte) error {
+	p.buf = append(p.buf, '{')
+	first := true
+	if err := p.members(members, data, 0, &first); err != nil {
+		return err
+	}
+	p.buf = append(p.buf, '}')
+	return nil
+}
+
+// members renders members whose offsets are relative to base bits into data.
+func (p *printer) members(members []btf.Member, data []byte, base uint32, first *bool) error {
+	for _, m := range members {
+		off := base + uint32(m.Offset)
+		if nested, ok := anonymousMembers(m); ok {
+			if err := p.members(nested, data, off, first); err != nil {
+				return err
+			}
+			continue
+		}
+		if m.Name == "_" || m.Name == "" {
+			continue
+		}
+		if !*first {
+			p.sep()
+		}
+		*first = false
+		p.key(m.Name)
+		if err := p.member(m, data, off); err != nil {
+			return fmt.Errorf("field %s: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// anonymousMembers returns the members of m if it is an anonymous struct or
+// union.
+func anonymousMembers(m btf.Member) ([]btf.Member, bool) {
+	if m.Name != "" {
+		return nil, false
+	}
+	switch t := btf.UnderlyingType(m.Type).(type) {
+	case *btf.Struct:
+		return t.Members, true
+	case *btf.Union:
+		return t.Members, true
+	}
+	return nil, false
+}
+
+// member renders the member m at bit offset off into data.
+func (p *printer) member(m btf.Member, data []byte, off uint32) error {
+	if m.BitfieldSize > 0 {
+		switch t := btf.UnderlyingType(m.Type).(type) {
+		case *btf.Int:
+			p.integer(t, data, off, uint32(m.BitfieldSize))
+			return nil
+		case *btf.Enum:
+			p.enum(t, readBits(data, off, uint32(m.BitfieldSize)), uint32(m.BitfieldSize))
+			return nil
+		}
+		return fmt.Errorf("bitfield of %s", m.Type)
+	}
+	if off%8 != 0 {
+		return fmt.Errorf("offset %d is not byte aligned", off)
+	}
+	size, err := btf.Sizeof(m.Type)
+	if err != nil {
+		return fmt.Errorf("size of %s: %w", m.Type, err)
+	}
+	start := int(off / 8)
+	if start+size > len(data) {
+		return fmt.Errorf("extends beyond its parent")
+	}
+	return p.value(m.Type, data[start:start+size])
+}
+
+// datasec renders the variables of a data section, such as .data or .bss.
+func (p *printer) datasec(t *btf.Datasec, data []byte) error {
+	p.buf = append(p.buf, '{')
+	for i, v := range t.Vars {
+		if i > 0 {
+			p.sep()
+		}
+		p.key(v.Type.TypeName())
+		if int(v.Offset+v.Size) > len(data) {
+			return fmt.Errorf("variable %s extends beyond its section", v.Type.TypeName())
+		}
+		if err := p.value(v.Type, data[v.Offset:v.Offset+v.Size]); err != nil {
+			return fmt.Errorf("variable %s: %w", v.Type.TypeName(), err)
+		}
+	}
+	p.buf = append(p.buf, '}')
+	return nil
+}
+
+func (p *printer) key(name string) {
+	if p.json {
+		p.str(name)
+		p.buf = append(p.buf, ':')
+	} else {
+		p.buf = append(p.buf, name...)
+		p.buf = append(p.buf, ": "...)
+	}
+}
+
+func (p *printer) sep() {
+	if p.json {
+		p.buf = append(p.buf, ',')
+	} else {
+		p.buf = append(p.buf, ", "...)
+	}
+}
+
+// word appends a symbol such as an enumerator name, quoted only in JSON.
+func (p *printer) word(s string) {
+	if p.json {
+		p.str(s)
+	} else {
+		p.buf = append(p.buf, s...)
+	}
+}
+
+// str appends s quoted. Callers only pass printable ASCII, for which Go
+// and JSON quoting agree.
+func (p *printer) str(s string) {
+	p.buf = strconv.AppendQuote(p.buf, s)
+}
+
+// byteString returns the contents of a byte array up to its first NUL if
+// they are printable ASCII and only NULs follow.
+func byteString(t *btf.Array, data []byte) (string, bool) {
+	elem, ok := btf.UnderlyingType(t.Type).(*btf.Int)
+	if !ok || elem.Size != 1 || elem.Encoding == btf.Bool {
+		return "", false
+	}
+	s, rest, _ := strings.Cut(string(data), "\x00")
+	if strings.Trim(rest, "\x00") != "" {
+		return "", false
+	}
+	for i := range len(s) {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// readUint reads a little-endian unsigned integer of size bytes.
+func readUint(data []byte, size int) uint64 {
+	switch size {
+	case 1:
+		return uint64(data[0])
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(data))
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(data))
+	default:
+		return binary.LittleEndian.Uint64(data)
+	}
+}
+
+// readBits reads bits bits at bit offset off of little-endian data.
+func readBits(data []byte, off, bits uint32) uint64 {
+	var v uint64
+	for i := range bits {
+		bit := off + i
+		if int(bit/8) < len(data) && data[bit/8]&(1<<(bit%8)) != 0 {
+			v |= 1 << i
+		}
+	}
+	return v
+}
+
+// signExtend interprets the low bits bits of v as a signed integer.
+func signExtend(v uint64, bits uint32) int64 {
+	shift := 64 - bits
+	return int64(v<<shift) >> shift
+}
+
+// hexLE returns the hex digits of a little-endian integer of any size.
+func hexLE(data []byte) string {
+	var b strings.Builder
+	for i := len(data) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%02x", data[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,223 @@
+package btffmt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+var (
+	u8    = &btf.Int{Name: "u8", Size: 1}
+	char  = &btf.Int{Name: "char", Size: 1, Encoding: btf.Char}
+	boolT = &btf.Int{Name: "bool", Size: 1, Encoding: btf.Bool}
+	s16   = &btf.Int{Name: "s16", Size: 2, Encoding: btf.Signed}
+	u32   = &btf.Int{Name: "u32", Size: 4}
+	s32   = &btf.Int{Name: "s32", Size: 4, Encoding: btf.Signed}
+	state = &btf.Enum{Name: "state", Size: 4, Values: []btf.EnumValue{
+		{Name: "ESTABLISHED", Value: 1},
+		{Name: "CLOSE", Value: 7},
+	}}
+)
+
+// connEvent has plain integers, a string, an enum, bitfields and a bool.
+var connEvent = &btf.Struct{Name: "conn_event", Size: 24, Members: []btf.Member{
+	{Name: "pid", Type: u32, Offset: 0},
+	{Name: "comm", Type: &btf.Array{Type: char, Nelems: 8}, Offset: 32},
+	{Name: "state", Type: state, Offset: 96},
+	{Name: "flags", Type: u32, Offset: 128, BitfieldSize: 3},
+	{Name: "kind", Type: s32, Offset: 131, BitfieldSize: 5},
+	{Name: "ok", Type: boolT, Offset: 160},
+	{Name: "delta", Type: s16, Offset: 176},
+}}
+
+var connEventData = []byte{
+	42, 0, 0, 0,
+	'c', 'u', 'r', 'l', 0, 0, 0, 0,
+	1, 0, 0, 0,
+	0xfd, 0, 0, 0, // flags 5, kind -1
+	1, 0,
+	0xfd, 0xff,
+}
+
+// flowKey has a binary byte array, an anonymous union, an unknown enum
+// value, a pointer and a float.
+var flowKey = &btf.Struct{Name: "flow_key", Size: 32, Members: []btf.Member{
+	{Name: "addr", Type: &btf.Array{Type: u8, Nelems: 4}, Offset: 0},
+	{Type: &btf.Union{Size: 4, Members: []btf.Member{
+		{Name: "v4", Type: u32},
+		{Name: "raw", Type: &btf.Array{Type: u8, Nelems: 4}},
+	}}, Offset: 32},
+	{Name: "state", Type: &btf.Typedef{Name: "state_t", Type: state}, Offset: 64},
+	{Name: "ptr", Type: &btf.Pointer{Target: u32}, Offset: 128},
+	{Name: "ratio", Type: &btf.Float{Name: "double", Size: 8}, Offset: 192},
+}}
+
+var flowKeyData = []byte{
+	10, 0, 0, 1,
+	1, 0, 0, 0,
+	9, 0, 0, 0,
+	0, 0, 0, 0,
+	0, 0, 0xff, 0xff, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0xe0, 0x3f,
+}
+
+func TestSprint(t *testing.T) {
+	tests := []struct {
+		name   string
+		typ    btf.Type
+		data   []byte
+		format Format
+		want   string
+	}{
+		{
+			name:   "struct text",
+			typ:    connEvent,
+			data:   connEventData,
+			format: Text,
+			want:   `{pid: 42, comm: "curl", state: ESTABLISHED, flags: 5, kind: -1, ok: true, delta: -3}`,
+		},
+		{
+			name:   "struct json",
+			typ:    connEvent,
+			data:   connEventData,
+			format: JSON,
+			want:   `{"pid":42,"comm":"curl","state":"ESTABLISHED","flags":5,"kind":-1,"ok":true,"delta":-3}`,
+		},
+		{
+			name:   "union and array text",
+			typ:    flowKey,
+			data:   flowKeyData,
+			format: Text,
+			want:   `{addr: [10, 0, 0, 1], v4: 1, raw: [1, 0, 0, 0], state: 9, ptr: 0xffff0000, ratio: 0.5}`,
+		},
+		{
+			name:   "union and array json",
+			typ:    flowKey,
+			data:   flowKeyData,
+			format: JSON,
+			want:   `{"addr":[10,0,0,1],"v4":1,"raw":[1,0,0,0],"state":9,"ptr":"0xffff0000","ratio":0.5}`,
+		},
+		{
+			name:   "trailing bytes ignored",
+			typ:    u32,
+			data:   []byte{7, 0, 0, 0, 0xff, 0xff},
+			format: Text,
+			want:   "7",
+		},
+		{
+			name:   "enum json",
+			typ:    state,
+			data:   []byte{7, 0, 0, 0},
+			format: JSON,
+			want:   `"CLOSE"`,
+		},
+		{
+			name:   "empty string",
+			typ:    &btf.Array{Type: char, Nelems: 4},
+			data:   []byte{0, 0, 0, 0},
+			format: JSON,
+			want:   `""`,
+		},
+		{
+			name:   "bytes after NUL",
+			typ:    &btf.Array{Type: char, Nelems: 4},
+			data:   []byte{'a', 0, 'b', 0},
+			format: Text,
+			want:   "[97, 0, 98, 0]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Sprint(tt.typ, tt.data, tt.format)
+			if err != nil {
+				t.Fatalf("Sprint: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSprintErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  btf.Type
+		data []byte
+		want string
+	}{
+		{
+			name: "short data",
+			typ:  connEvent,
+			data: connEventData[:8],
+			want: "8 bytes of data is too short",
+		},
+		{
+			name: "unaligned member",
+			typ: &btf.Struct{Name: "bad", Size: 4, Members: []btf.Member{
+				{Name: "x", Type: u8, Offset: 4},
+			}},
+			data: make([]byte, 4),
+			want: "field x: offset 4 is not byte aligned",
+		},
+		{
+			name: "member beyond parent",
+			typ: &btf.Struct{Name: "bad", Size: 4, Members: []btf.Member{
+				{Name: "x", Type: u32, Offset: 16},
+			}},
+			data: make([]byte, 4),
+			want: "field x: extends beyond its parent",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Sprint(tt.typ, tt.data, Text)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %v should contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, s := range []string{"text", "json"} {
+		if _, err := ParseFormat(s); err != nil {
+			t.Errorf("ParseFormat(%q): %v", s, err)
+		}
+	}
+	if _, err := ParseFormat("yaml"); err == nil || !strings.Contains(err.Error(), `unknown format "yaml"`) {
+		t.Errorf("ParseFormat(yaml) error = %v", err)
+	}
+}
+
+func TestTypeByName(t *testing.T) {
+	b, err := btf.NewBuilder([]btf.Type{
+		&btf.Fwd{Name: "main_connEvent"},
+		&btf.Struct{Name: "main_connEvent", Size: 4, Members: []btf.Member{{Name: "pid", Type: u32}}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := b.Marshal(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := btf.LoadSpecFromReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typ, err := TypeByName(spec, "connEvent")
+	if err != nil {
+		t.Fatalf("TypeByName: %v", err)
+	}
+	if _, ok := typ.(*btf.Struct); !ok {
+		t.Errorf("TypeByName returned %s, want the struct", typ)
+	}
+	if _, err := TypeByName(spec, "missing"); !errors.Is(err, btf.ErrNotFound) {
+		t.Errorf("TypeByName(missing) error = %v, want ErrNotFound", err)
+	}
+}
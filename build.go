@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -24,8 +25,12 @@ func Build(ctx context.Context, req Request) (*Result, error) {
 		return nil, err
 	}
 	applyRequestDefaults(&req)
+	if len(req.Variants) > 0 {
+		return buildVariants(ctx, req)
+	}
 
 	inputs := req.Inputs
+	var fieldTags map[string]string
 	var cleanTempDir func()
 
 	if req.Package != "" {
@@ -53,9 +58,30 @@ func Build(ctx context.Context, req Request) (*Result, error) {
 			return nil, err
 		}
 		inputs = []string{irFile}
+
+		pkg, err := parsePackage(req.Package, req.Tags)
+		if err != nil {
+			return nil, err
+		}
+		globals, err := scanGlobalFuncs(pkg)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range globals {
+			if !slices.Contains(req.GlobalFuncs, name) {
+				req.GlobalFuncs = append(req.GlobalFuncs, name)
+			}
+		}
+		for _, name := range scanGlobalVars(pkg) {
+			if !slices.Contains(req.GlobalVars, name) {
+				req.GlobalVars = append(req.GlobalVars, name)
+			}
+		}
+		fieldTags = scanFieldTags(pkg)
 	}
 
 	cfg := requestToPipelineConfig(req, inputs)
+	cfg.FieldTags = fieldTags
 	artifacts, err := pipeline.Run(ctx, cfg)
 	if err != nil {
 		return nil, err
@@ -78,6 +104,44 @@ func Build(ctx context.Context, req Request) (*Result, error) {
 	return result, nil
 }
 
+// buildVariants builds one object per variant of req, most preferred first.
+func buildVariants(ctx context.Context, req Request) (*Result, error) {
+	var result *Result
+	for _, v := range req.Variants {
+		vreq := req
+		vreq.Variants = nil
+		vreq.Output = VariantPath(req.Output, v.Name)
+		vreq.Tags = append(slices.Clip(req.Tags), v.Tags...)
+		if v.CPU != "" {
+			vreq.CPU = v.CPU
+		}
+		if req.TempDir != "" {
+			vreq.TempDir = filepath.Join(req.TempDir, v.Name)
+		}
+		vresult, err := Build(ctx, vreq)
+		if err != nil {
+			return nil, fmt.Errorf("variant %s: %w", v.Name, err)
+		}
+		if result == nil {
+			result = vresult
+		}
+		result.Variants = append(result.Variants, VariantOutput{Name: v.Name, Output: vresult.Output})
+	}
+	return result, nil
+}
+
+// VariantPath returns the path [Build] writes a variant's object to for
+// the given output: the variant name inserted before the ".bpf.o" or ".o"
+// extension.
+func VariantPath(output, name string) string {
+	for _, ext := range []string{".bpf.o", ".o"} {
+		if strings.HasSuffix(output, ext) {
+			return strings.TrimSuffix(output, ext) + "." + name + ext
+		}
+	}
+	return output + "." + name
+}
+
 // validateRequest validates the request and returns an error if the request is invalid.
 func validateRequest(req *Request) error {
 	hasPackage := req.Package != ""
@@ -85,9 +149,43 @@ func validateRequest(req *Request) error {
 	if hasPackage == hasInputs {
 		return fmt.Errorf("exactly one of Package or Inputs must be set")
 	}
+	if hasInputs && len(req.Tags) > 0 {
+		return fmt.Errorf("tags require Package: they are passed to TinyGo")
+	}
+	return validateVariants(req)
+}
+
+// validateVariants checks that variant names are usable in file names and
+// unique, and that tags are only set when compiling a Package.
+func validateVariants(req *Request) error {
+	seen := make(map[string]bool)
+	for _, v := range req.Variants {
+		if !validVariantName(v.Name) {
+			return fmt.Errorf("invalid variant name %q: use letters, digits, '-' and '_'", v.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("duplicate variant %q", v.Name)
+		}
+		seen[v.Name] = true
+		if len(v.Tags) > 0 && req.Package == "" {
+			return fmt.Errorf("variant %q sets tags, which require Package", v.Name)
+		}
+	}
 	return nil
 }
 
+func validVariantName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 // applyRequestDefaults applies the default values to the request.
 func applyRequestDefaults(req *Request) {
 	if req.Output == "" {
@@ -122,6 +220,8 @@ func requestToPipelineConfig(req Request, inputs []string) pipeline.Config {
 		EnableBTF:    req.EnableBTF,
 		Programs:     req.Programs,
 		Sections:     req.Sections,
+		GlobalFuncs:  req.GlobalFuncs,
+		GlobalVars:   req.GlobalVars,
 		Tools: llvm.ToolOverrides{
 			LLVMLink: req.Toolchain.LLVMLink,
 			Opt:      req.Toolchain.Opt,
@@ -147,8 +247,11 @@ func compileTinyGo(ctx context.Context, req Request, tinygo, pkg, workDir string
 		"build",
 		"-gc=none", "-scheduler=none", "-panic=trap", "-opt=1",
 		"-o", irFile,
-		pkg,
 	}
+	if len(req.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(req.Tags, ","))
+	}
+	args = append(args, pkg)
 
 	if req.Verbose {
 		fmt.Fprintf(req.Stdout, "[tinygo-compile] %s %s\n", tinygo, strings.Join(args, " "))
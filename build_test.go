@@ -28,6 +28,26 @@ func TestBuildValidation(t *testing.T) {
 			},
 			want: "exactly one of Package or Inputs",
 		},
+		{
+			name: "tags with Inputs",
+			req:  tinybpf.Request{Inputs: []string{"a.ll"}, Tags: []string{"kfuncs"}},
+			want: "tags require Package",
+		},
+		{
+			name: "invalid variant name",
+			req:  tinybpf.Request{Package: "./bpf", Variants: []tinybpf.Variant{{Name: "v3/kfuncs"}}},
+			want: `invalid variant name "v3/kfuncs"`,
+		},
+		{
+			name: "duplicate variant",
+			req:  tinybpf.Request{Package: "./bpf", Variants: []tinybpf.Variant{{Name: "v3"}, {Name: "v3"}}},
+			want: `duplicate variant "v3"`,
+		},
+		{
+			name: "variant tags with Inputs",
+			req:  tinybpf.Request{Inputs: []string{"a.ll"}, Variants: []tinybpf.Variant{{Name: "kfuncs", Tags: []string{"kfuncs"}}}},
+			want: `variant "kfuncs" sets tags`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -42,3 +62,15 @@ func TestBuildValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestVariantPath(t *testing.T) {
+	for output, want := range map[string]string{
+		"probe.bpf.o":     "probe.v3.bpf.o",
+		"build/probe.o":   "build/probe.v3.o",
+		"build/probe.elf": "build/probe.elf.v3",
+	} {
+		if got := tinybpf.VariantPath(output, "v3"); got != want {
+			t.Errorf("VariantPath(%q) = %q, want %q", output, got, want)
+		}
+	}
+}
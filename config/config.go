@@ -19,6 +19,7 @@ const Filename = "tinybpf.json"
 // Config holds all user-provided project settings.
 type Config struct {
 	Build     Build     `json:"build"`
+	Generate  Generate  `json:"generate"`
 	Toolchain Toolchain `json:"toolchain"`
 }
 
@@ -32,6 +33,17 @@ type Build struct {
 	Timeout      string            `json:"timeout"`
 	Programs     map[string]string `json:"programs"`
 	CustomPasses []string          `json:"custom_passes"`
+
+	// PinPath is the bpffs directory generated loaders pin maps, programs
+	// and links under by default.
+	PinPath string `json:"pin_path"`
+}
+
+// Generate holds loader generation settings.
+type Generate struct {
+	// Events maps ring buffer and perf event array names to the BTF type
+	// of their records.
+	Events map[string]string `json:"events"`
 }
 
 // Toolchain holds LLVM and TinyGo tool path overrides.
@@ -109,5 +121,8 @@ func validate(cfg *Config, path string) error {
 			return fmt.Errorf("config %q: %w", path, err)
 		}
 	}
+	if cfg.Build.PinPath != "" && !filepath.IsAbs(cfg.Build.PinPath) {
+		return fmt.Errorf("config %q: pin_path %q must be an absolute path", path, cfg.Build.PinPath)
+	}
 	return nil
 }
@@ -32,6 +32,12 @@ func checkFullConfig(t *testing.T, cfg *Config) {
 	if len(cfg.Build.CustomPasses) != 2 {
 		t.Fatalf("custom_passes len = %d, want 2", len(cfg.Build.CustomPasses))
 	}
+	if cfg.Build.PinPath != "/sys/fs/bpf/probe" {
+		t.Errorf("pin_path = %q, want %q", cfg.Build.PinPath, "/sys/fs/bpf/probe")
+	}
+	if cfg.Generate.Events["events"] != "main_connEvent" {
+		t.Errorf("generate.events[events] = %q", cfg.Generate.Events["events"])
+	}
 	if cfg.Toolchain.LLVMDir != "/usr/lib/llvm-20/bin" {
 		t.Errorf("llvm_dir = %q", cfg.Toolchain.LLVMDir)
 	}
@@ -58,7 +64,11 @@ func TestLoad(t *testing.T) {
 					"btf": true,
 					"timeout": "60s",
 					"programs": {"probe_connect": "kprobe/sys_connect"},
-					"custom_passes": ["inline", "dce"]
+					"custom_passes": ["inline", "dce"],
+					"pin_path": "/sys/fs/bpf/probe"
+				},
+				"generate": {
+					"events": {"events": "main_connEvent"}
 				},
 				"toolchain": {
 					"llvm_dir": "/usr/lib/llvm-20/bin",
@@ -113,6 +123,11 @@ func TestLoad(t *testing.T) {
 			json:    `{"build": {"timeout": "-5s"}}`,
 			wantErr: true,
 		},
+		{
+			name:    "relative pin path",
+			json:    `{"build": {"pin_path": "bpf/probe"}}`,
+			wantErr: true,
+		},
 		{
 			name:    "missing file",
 			noFile:  true,
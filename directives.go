@@ -0,0 +1,157 @@
+package tinybpf
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// globalDirective marks a Go function to keep as a global BPF subprogram, or
+// a package variable to expose to loaders as a global symbol.
+const globalDirective = "//bpf:global"
+
+// goPackage is a Go package parsed for its bpf directives and struct tags.
+type goPackage struct {
+	prefix string // prefix of the IR names of the package's declarations
+	fset   *token.FileSet
+	files  []*ast.File
+}
+
+// scanGlobalFuncs returns the IR names of the functions in p marked
+// //bpf:global.
+func scanGlobalFuncs(p *goPackage) ([]string, error) {
+	var names []string
+	for _, f := range p.files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || !hasDirective(fn.Doc, globalDirective) {
+				continue
+			}
+			if fn.Recv != nil {
+				return nil, fmt.Errorf("%s: %s is only supported on functions, not methods", p.fset.Position(fn.Pos()), globalDirective)
+			}
+			names = append(names, p.prefix+fn.Name.Name)
+		}
+	}
+	return names, nil
+}
+
+// scanGlobalVars returns the IR names of the package-level variables in p
+// marked //bpf:global. The directive may precede a var declaration or a
+// variable inside a var block.
+func scanGlobalVars(p *goPackage) []string {
+	var names []string
+	for _, f := range p.files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				if !hasDirective(vs.Doc, globalDirective) && (gd.Lparen.IsValid() || !hasDirective(gd.Doc, globalDirective)) {
+					continue
+				}
+				for _, name := range vs.Names {
+					if name.Name != "_" {
+						names = append(names, p.prefix+name.Name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// scanFieldTags returns the bpf struct tags of the fields of the struct types
+// declared in p, keyed by type and field name as in "main.taskRef.Task".
+func scanFieldTags(p *goPackage) map[string]string {
+	fieldTags := make(map[string]string)
+	for _, f := range p.files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok || ts.TypeParams != nil {
+					continue
+				}
+				for _, field := range st.Fields.List {
+					value, ok := bpfTag(field)
+					if !ok {
+						continue
+					}
+					for _, name := range field.Names {
+						fieldTags[p.prefix+ts.Name.Name+"."+name.Name] = value
+					}
+				}
+			}
+		}
+	}
+	return fieldTags
+}
+
+// bpfTag returns the value of the bpf key in the struct tag of field.
+func bpfTag(field *ast.Field) (string, bool) {
+	if field.Tag == nil {
+		return "", false
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return "", false
+	}
+	return reflect.StructTag(tag).Lookup("bpf")
+}
+
+// parsePackage parses the files TinyGo compiles in the Go package pkg with
+// the given build tags.
+func parsePackage(pkg string, tags []string) (*goPackage, error) {
+	bctx := build.Default
+	bctx.BuildTags = append(slices.Clip(bctx.BuildTags), append([]string{"tinygo"}, tags...)...)
+	var bp *build.Package
+	var err error
+	if build.IsLocalImport(pkg) || filepath.IsAbs(pkg) {
+		bp, err = bctx.ImportDir(pkg, 0)
+	} else {
+		bp, err = bctx.Import(pkg, ".", 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading package %s for bpf directives: %w", pkg, err)
+	}
+	prefix := bp.ImportPath + "."
+	if bp.Name == "main" {
+		prefix = "main."
+	}
+
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0, len(bp.GoFiles))
+	for _, file := range bp.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(bp.Dir, file), nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return &goPackage{prefix: prefix, fset: fset, files: files}, nil
+}
+
+// hasDirective reports whether the doc comment has a line that is exactly
+// the directive.
+func hasDirective(doc *ast.CommentGroup, directive string) bool {
+	if doc == nil {
+		return false
+	}
+	return slices.ContainsFunc(doc.List, func(c *ast.Comment) bool {
+		return strings.TrimSpace(c.Text) == directive
+	})
+}
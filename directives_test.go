@@ -0,0 +1,179 @@
+package tinybpf
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestScanGlobalFuncs(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		tags    []string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "marked functions",
+			files: map[string]string{"main.go": `package main
+
+// parseIPv4 is verified on its own.
+//
+//bpf:global
+//go:noinline
+func parseIPv4(ctx uintptr) int32 { return 0 }
+
+//go:noinline
+func parseIPv6(ctx uintptr) int32 { return 0 }
+
+// The //bpf:global directive must be on a line of its own.
+func mentionsDirective() {}
+
+func main() {}
+`},
+			want: []string{"main.parseIPv4"},
+		},
+		{
+			name: "build tags",
+			files: map[string]string{
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"kfuncs.go": "//go:build kfuncs\n\npackage main\n\n//bpf:global\nfunc withKfuncs() {}\n",
+				"tinygo.go": "//go:build tinygo\n\npackage main\n\n//bpf:global\nfunc withTinyGo() {}\n",
+			},
+			tags: []string{"kfuncs"},
+			want: []string{"main.withKfuncs", "main.withTinyGo"},
+		},
+		{
+			name: "method",
+			files: map[string]string{"main.go": `package main
+
+type parser struct{}
+
+//bpf:global
+func (p parser) parse() {}
+
+func main() {}
+`},
+			wantErr: "//bpf:global is only supported on functions, not methods",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, src := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := scanGlobalFuncs(parseTestPackage(t, dir, tt.tags))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("scanGlobalFuncs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanGlobalVars(t *testing.T) {
+	dir := t.TempDir()
+	src := `package main
+
+//bpf:global
+var targetPID uint32
+
+var unmarked uint32
+
+var (
+	//bpf:global
+	hits, drops uint64
+
+	// The //bpf:global directive must be on a line of its own.
+	limit = 64
+
+	//bpf:global
+	_ uint64
+)
+
+// Directives on a var block do not mark its variables.
+//
+//bpf:global
+var (
+	grouped uint32
+)
+
+func main() {
+	//bpf:global
+	var local uint32
+	_ = local
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got := scanGlobalVars(parseTestPackage(t, dir, nil))
+	want := []string{"main.targetPID", "main.hits", "main.drops"}
+	if !slices.Equal(got, want) {
+		t.Errorf("scanGlobalVars() = %v, want %v", got, want)
+	}
+}
+
+func TestScanFieldTags(t *testing.T) {
+	dir := t.TempDir()
+	src := "package main\n\n" +
+		"type bpfCoreTaskStruct struct{ Pid int32 }\n\n" +
+		"type taskRef struct {\n" +
+		"\tTask  *bpfCoreTaskStruct `bpf:\"kptr\"`\n" +
+		"\tA, B  *bpfCoreTaskStruct `json:\"ab\" bpf:\"kptr_untrusted,decl_tag=pair\"`\n" +
+		"\tCount uint64 `json:\"count\"`\n" +
+		"}\n\n" +
+		"type pair[T any] struct {\n" +
+		"\tP *T `bpf:\"kptr\"`\n" +
+		"}\n\n" +
+		"func main() {\n" +
+		"\ttype local struct {\n" +
+		"\t\tP *bpfCoreTaskStruct `bpf:\"kptr\"`\n" +
+		"\t}\n" +
+		"}\n"
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got := scanFieldTags(parseTestPackage(t, dir, nil))
+	want := map[string]string{
+		"main.taskRef.Task": "kptr",
+		"main.taskRef.A":    "kptr_untrusted,decl_tag=pair",
+		"main.taskRef.B":    "kptr_untrusted,decl_tag=pair",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("scanFieldTags() = %v, want %v", got, want)
+	}
+}
+
+func TestParsePackageError(t *testing.T) {
+	_, err := parsePackage(filepath.Join(t.TempDir(), "missing"), nil)
+	if err == nil || !strings.Contains(err.Error(), "for bpf directives") {
+		t.Fatalf("expected a reading package error, got %v", err)
+	}
+}
+
+// parseTestPackage parses the Go package in dir with the given build tags.
+func parseTestPackage(t *testing.T, dir string, tags []string) *goPackage {
+	t.Helper()
+	p, err := parsePackage(dir, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
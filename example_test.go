@@ -31,3 +31,27 @@ func ExampleBuild_inputs() {
 	}
 	fmt.Println("wrote", result.Output)
 }
+
+func ExampleGenerate() {
+	result, err := tinybpf.Generate(tinybpf.GenerateRequest{
+		Object:    "probe.bpf.o",
+		Package:   "probe",
+		Output:    "probe_bpf.go",
+		EmbedPath: "probe.bpf.o",
+	})
+	if err != nil {
+		fmt.Println("generate failed:", err)
+		return
+	}
+	fmt.Println("generated loader for", len(result.Programs), "programs")
+}
+
+func ExampleBuildAndGenerate() {
+	_, _, err := tinybpf.BuildAndGenerate(context.Background(),
+		tinybpf.Request{Package: "./bpf", Output: "bpf/probe.bpf.o"},
+		tinybpf.GenerateRequest{Package: "bpf", Output: "bpf/probe_bpf.go", EmbedPath: "probe.bpf.o"},
+	)
+	if err != nil {
+		fmt.Println("build failed:", err)
+	}
+}
@@ -5,18 +5,13 @@ package loader
 
 //go:generate tinybpf generate --output connect_bpf.go --package loader ../../build/connect.bpf.o
 
-import (
-	"fmt"
-
-	"github.com/cilium/ebpf"
-	"github.com/cilium/ebpf/link"
-)
+import "fmt"
 
 // Loaded holds the resources obtained after a successful load and attach.
 type Loaded struct {
 	Objects         *Objects
-	Link            link.Link
-	BlockedAddrsMap *ebpf.Map
+	Links           *Links
+	BlockedAddrsMap BlockedAddrsMap
 }
 
 // LoadAndAttach loads the eBPF collection from objectPath and attaches the
@@ -27,19 +22,15 @@ func LoadAndAttach(objectPath, cgroupPath string) (*Loaded, error) {
 		return nil, err
 	}
 
-	cgLink, err := link.AttachCgroup(link.CgroupOptions{
-		Path:    cgroupPath,
-		Attach:  ebpf.AttachCGroupInet4Connect,
-		Program: objs.CheckConnect4,
-	})
+	links, err := objs.AttachAll(AttachOptions{CgroupPath: cgroupPath})
 	if err != nil {
 		objs.Close()
-		return nil, fmt.Errorf("attach cgroup/connect4: %w", err)
+		return nil, fmt.Errorf("attach to cgroup %s: %w", cgroupPath, err)
 	}
 
 	return &Loaded{
 		Objects:         objs,
-		Link:            cgLink,
+		Links:           links,
 		BlockedAddrsMap: objs.BlockedAddrs,
 	}, nil
 }
@@ -49,9 +40,7 @@ func (l *Loaded) Close() {
 	if l == nil {
 		return
 	}
-	if l.Link != nil {
-		_ = l.Link.Close()
-	}
+	_ = l.Links.Close()
 	if l.Objects != nil {
 		l.Objects.Close()
 	}
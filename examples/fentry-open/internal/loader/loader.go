@@ -38,7 +38,7 @@ func LoadAndAttach(objectPath string) (*Loaded, error) {
 	return &Loaded{
 		Objects:   objs,
 		Link:      tp,
-		EventsMap: objs.Events,
+		EventsMap: objs.Events.Map,
 	}, nil
 }
 
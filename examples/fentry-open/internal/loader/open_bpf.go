@@ -3,9 +3,22 @@
 package loader
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/ringbuf"
 )
 
 // Objects contains all programs and maps from the BPF object.
@@ -21,22 +34,620 @@ type Programs struct {
 
 // Maps contains all BPF maps.
 type Maps struct {
-	Events *ebpf.Map `ebpf:"events"`
+	Events EventsMap
 }
 
-// Load loads the BPF object from objectPath and returns populated Objects.
-func Load(objectPath string) (*Objects, error) {
+// EventsMap wraps the events map (RingBuf).
+type EventsMap struct {
+	*ebpf.Map `ebpf:"events"`
+}
+
+// EventsReader reads raw records from the events ring buffer.
+type EventsReader struct {
+	rd     *ringbuf.Reader
+	record ringbuf.Record
+	rec    io.Writer
+}
+
+// NewReader opens a reader on the ring buffer. The caller must Close it.
+func (m EventsMap) NewReader() (*EventsReader, error) {
+	rd, err := ringbuf.NewReader(m.Map)
+	if err != nil {
+		return nil, fmt.Errorf("open events ring buffer: %w", err)
+	}
+	return &EventsReader{rd: rd}, nil
+}
+
+// read waits for the next record, flushing the reader to wake it once ctx is done.
+func (r *EventsReader) read(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { _ = r.rd.Flush() })
+	defer stop()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := r.rd.ReadInto(&r.record)
+		if errors.Is(err, ringbuf.ErrFlushed) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+// Record writes every sample read from now on to w, with the time it was read
+// and the CPU that produced it (-1 for ring buffers), for NewEventsReplay to
+// replay. A failed write is returned by the Read that hit it, together with
+// the record it read. Wrap files in a bufio.Writer, flushed after the last
+// Read, to avoid a write per sample.
+func (r *EventsReader) Record(w io.Writer) error {
+	if err := writeRecordingHeader(w, "events", 0); err != nil {
+		return fmt.Errorf("record events: %w", err)
+	}
+	r.rec = w
+	return nil
+}
+
+// recordSample writes a sample to the recording started by Record, if any.
+func (r *EventsReader) recordSample(cpu int, lost uint64, raw []byte) error {
+	if r.rec == nil {
+		return nil
+	}
+	sample := RecordedSample{Time: time.Now(), CPU: cpu, Lost: lost, Raw: raw}
+	if err := writeRecordedSample(r.rec, sample); err != nil {
+		return fmt.Errorf("record events sample: %w", err)
+	}
+	return nil
+}
+
+// Read blocks until the next record is available and decodes it. It returns
+// ctx.Err() once ctx is done and ringbuf.ErrClosed after Close.
+func (r *EventsReader) Read(ctx context.Context) ([]byte, error) {
+	if err := r.read(ctx); err != nil {
+		var zero []byte
+		return zero, err
+	}
+	return r.decodeRecord()
+}
+
+// decodeRecord decodes the record read last and writes it to the recording
+// started by Record, if any. A failed write is returned with the record.
+func (r *EventsReader) decodeRecord() ([]byte, error) {
+	event, err := decodeEventsRecord(r.record.RawSample)
+	if recErr := r.recordSample(-1, 0, r.record.RawSample); recErr != nil {
+		return event, errors.Join(err, recErr)
+	}
+	return event, err
+}
+
+// All returns an iterator over decoded records. It stops once ctx is done or
+// the reader is closed; other errors are yielded alongside a zero record.
+func (r *EventsReader) All(ctx context.Context) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		for {
+			event, err := r.Read(ctx)
+			if err != nil && (ctx.Err() != nil || errors.Is(err, ringbuf.ErrClosed)) {
+				return
+			}
+			if !yield(event, err) {
+				return
+			}
+		}
+	}
+}
+
+// Close releases the reader, interrupting any blocked Read.
+func (r *EventsReader) Close() error {
+	return r.rd.Close()
+}
+
+// EventsReplay replays the samples recorded by EventsReader.Record, decoding them
+// as the reader does, without a kernel. Samples are returned as fast as they
+// are read; Sample reports when each one was recorded.
+type EventsReplay struct {
+	src    *bufio.Reader
+	sample RecordedSample
+	closed atomic.Bool
+}
+
+// NewEventsReplay returns a replay of the recording read from r. Close does not close r.
+func NewEventsReplay(r io.Reader) (*EventsReplay, error) {
+	src := bufio.NewReader(r)
+	if _, err := readRecordingHeader(src, "events"); err != nil {
+		return nil, fmt.Errorf("replay events: %w", err)
+	}
+	return &EventsReplay{src: src}, nil
+}
+
+// Read decodes the next recorded sample. It returns io.EOF at the end of the
+// recording, ctx.Err() once ctx is done and ringbuf.ErrClosed after Close.
+func (r *EventsReplay) Read(ctx context.Context) ([]byte, error) {
+	var zero []byte
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	if r.closed.Load() {
+		return zero, ringbuf.ErrClosed
+	}
+	sample, err := readRecordedSample(r.src)
+	if err != nil {
+		return zero, err
+	}
+	r.sample = sample
+	return decodeEventsRecord(sample.Raw)
+}
+
+// Sample returns the recorded sample behind the record Read last returned.
+func (r *EventsReplay) Sample() RecordedSample {
+	return r.sample
+}
+
+// All returns an iterator over decoded records. It stops once ctx is done or
+// the reader is closed; other errors are yielded alongside a zero record.
+func (r *EventsReplay) All(ctx context.Context) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		for {
+			event, err := r.Read(ctx)
+			if err != nil && (ctx.Err() != nil || errors.Is(err, io.EOF) || errors.Is(err, ringbuf.ErrClosed)) {
+				return
+			}
+			if !yield(event, err) {
+				return
+			}
+		}
+	}
+}
+
+// Close stops the replay; later reads fail.
+func (r *EventsReplay) Close() error {
+	r.closed.Store(true)
+	return nil
+}
+
+// decodeEventsRecord decodes a raw events record, as read by EventsReader and EventsReplay.
+func decodeEventsRecord(raw []byte) ([]byte, error) {
+	return bytes.Clone(raw), nil
+}
+
+// RecordedSample is a raw sample written by a reader's Record.
+type RecordedSample struct {
+	Time time.Time // when the sample was read
+	CPU  int       // CPU that produced the sample, or -1 for ring buffers
+	Lost uint64    // perf samples lost on CPU; Raw is empty if set
+	Raw  []byte
+}
+
+// recordingMagic starts a recording; the last byte is the format version.
+const recordingMagic = "tinybpf-events\x00\x01"
+
+// writeRecordingHeader starts a recording of the named map.
+func writeRecordingHeader(w io.Writer, name string, cpus int) error {
+	buf := []byte(recordingMagic)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(cpus))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(name)))
+	_, err := w.Write(append(buf, name...))
+	return err
+}
+
+// readRecordingHeader checks that r holds a recording of the named map and
+// returns the number of CPUs it was recorded with.
+func readRecordingHeader(r io.Reader, name string) (int, error) {
+	buf := make([]byte, len(recordingMagic)+8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, fmt.Errorf("read recording header: %w", err)
+	}
+	if string(buf[:len(recordingMagic)]) != recordingMagic {
+		return 0, errors.New("not a recording, or of an unsupported version")
+	}
+	cpus := binary.LittleEndian.Uint32(buf[len(recordingMagic):])
+	n := binary.LittleEndian.Uint32(buf[len(recordingMagic)+4:])
+	recorded, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("read recording header: %w", err)
+	}
+	if string(recorded) != name {
+		return 0, fmt.Errorf("recording is of map %q, not %q", recorded, name)
+	}
+	return int(cpus), nil
+}
+
+// writeRecordedSample appends s to a recording.
+func writeRecordedSample(w io.Writer, s RecordedSample) error {
+	buf := make([]byte, 0, 24+len(s.Raw))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(s.Time.UnixNano()))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(s.CPU)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s.Raw)))
+	buf = binary.LittleEndian.AppendUint64(buf, s.Lost)
+	_, err := w.Write(append(buf, s.Raw...))
+	return err
+}
+
+// readRecordedSample reads the next sample of a recording. It returns io.EOF
+// at the end and io.ErrUnexpectedEOF if the recording is truncated.
+func readRecordedSample(r io.Reader) (RecordedSample, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return RecordedSample{}, err
+	}
+	size := int64(binary.LittleEndian.Uint32(hdr[12:]))
+	raw, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return RecordedSample{}, err
+	}
+	if int64(len(raw)) != size {
+		return RecordedSample{}, io.ErrUnexpectedEOF
+	}
+	return RecordedSample{
+		Time: time.Unix(0, int64(binary.LittleEndian.Uint64(hdr[0:]))),
+		CPU:  int(int32(binary.LittleEndian.Uint32(hdr[8:]))),
+		Lost: binary.LittleEndian.Uint64(hdr[16:]),
+		Raw:  raw,
+	}, nil
+}
+
+// AttachOptions supplies the attach targets that program sections do not encode.
+type AttachOptions struct {
+	// Interface is the network interface index for XDP and TC programs.
+	Interface int
+	// XDPFlags selects the XDP attach mode (optional).
+	XDPFlags link.XDPAttachFlags
+	// CgroupPath is the cgroup v2 directory for cgroup programs.
+	CgroupPath string
+}
+
+// Links holds the links created by AttachAll.
+type Links struct {
+	TraceOpenat2 link.Link
+}
+
+// Close detaches all links.
+func (l *Links) Close() error {
+	if l == nil {
+		return nil
+	}
+	var errs []error
+	if l.TraceOpenat2 != nil {
+		errs = append(errs, l.TraceOpenat2.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// AttachTraceOpenat2 attaches trace_openat2 to fentry/do_sys_openat2.
+func (p *Programs) AttachTraceOpenat2(opts AttachOptions) (link.Link, error) {
+	l, err := link.AttachTracing(link.TracingOptions{Program: p.TraceOpenat2})
+	if err != nil {
+		return nil, fmt.Errorf("attach trace_openat2 (fentry/do_sys_openat2): %w", err)
+	}
+	return l, nil
+}
+
+// AttachAll attaches every program whose section names an attach point. On
+// error, the links created so far are closed.
+func (p *Programs) AttachAll(opts AttachOptions) (*Links, error) {
+	var (
+		l   Links
+		err error
+	)
+	if l.TraceOpenat2, err = p.AttachTraceOpenat2(opts); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Pin pins every link under pinPath so that the attachments outlive the
+// process. Reopen them with LoadPinnedLinks.
+func (l *Links) Pin(pinPath string) error {
+	if l == nil {
+		return nil
+	}
+	if l.TraceOpenat2 != nil {
+		if err := l.TraceOpenat2.Pin(filepath.Join(pinPath, "trace_openat2_link")); err != nil {
+			return fmt.Errorf("pin link trace_openat2: %w", err)
+		}
+	}
+	return nil
+}
+
+// Unpin removes the pins created by Pin. The programs are detached once the
+// links are closed as well.
+func (l *Links) Unpin() error {
+	if l == nil {
+		return nil
+	}
+	var errs []error
+	if l.TraceOpenat2 != nil {
+		errs = append(errs, l.TraceOpenat2.Unpin())
+	}
+	return errors.Join(errs...)
+}
+
+// LoadPinnedLinks opens the links pinned under pinPath by Links.Pin, for
+// example after a restart. Links that are not pinned are left nil.
+func LoadPinnedLinks(pinPath string) (*Links, error) {
+	var (
+		l   Links
+		err error
+	)
+	if l.TraceOpenat2, err = loadPinnedLink(filepath.Join(pinPath, "trace_openat2_link")); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("load pinned link trace_openat2: %w", err)
+	}
+	return &l, nil
+}
+
+// loadPinnedLink opens the link pinned at path, or returns nil if there is none.
+func loadPinnedLink(path string) (link.Link, error) {
+	l, err := link.LoadPinnedLink(path, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return l, err
+}
+
+// ProgramSpecs contains the specs of all BPF programs.
+type ProgramSpecs struct {
+	TraceOpenat2 *ebpf.ProgramSpec `ebpf:"trace_openat2"`
+}
+
+// MapSpecs contains the specs of all BPF maps.
+type MapSpecs struct {
+	Events *ebpf.MapSpec `ebpf:"events"`
+}
+
+// Specs contains the program and map specs of the BPF object. Changes to
+// them, such as a different MaxEntries, apply when Load is called.
+type Specs struct {
+	ProgramSpecs
+	MapSpecs
+
+	collection *ebpf.CollectionSpec
+}
+
+// CollectionSpec returns the spec the program and map specs belong to.
+func (s *Specs) CollectionSpec() *ebpf.CollectionSpec {
+	return s.collection
+}
+
+// Load loads the specs into the kernel and returns populated Objects.
+// opts may be nil.
+func (s *Specs) Load(opts *ebpf.CollectionOptions) (*Objects, error) {
+	return loadObjects(s.collection, opts)
+}
+
+// loadCollectionSpec parses the BPF object from objectPath.
+func loadCollectionSpec(objectPath string) (*ebpf.CollectionSpec, error) {
 	spec, err := ebpf.LoadCollectionSpec(objectPath)
 	if err != nil {
 		return nil, fmt.Errorf("load BPF spec: %w", err)
 	}
+	return spec, nil
+}
+
+// Load loads the BPF object from objectPath and returns populated Objects.
+func Load(objectPath string) (*Objects, error) {
+	return LoadWithOptions(objectPath, nil)
+}
+
+// LoadWithOptions is like Load, but passes opts (e.g. verifier log settings
+// or MapReplacements) to the loader. opts may be nil.
+func LoadWithOptions(objectPath string, opts *ebpf.CollectionOptions) (*Objects, error) {
+	spec, err := loadCollectionSpec(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	return loadObjects(spec, opts)
+}
+
+// loadObjects loads spec into the kernel and assigns its programs and maps.
+func loadObjects(spec *ebpf.CollectionSpec, opts *ebpf.CollectionOptions) (*Objects, error) {
 	var objs Objects
-	if err := spec.LoadAndAssign(&objs, nil); err != nil {
+	if err := spec.LoadAndAssign(&objs, opts); err != nil {
 		return nil, fmt.Errorf("load and assign: %w", err)
 	}
 	return &objs, nil
 }
 
+// LoadSpec parses the BPF object at objectPath and returns its specs without
+// loading anything into the kernel.
+func LoadSpec(objectPath string) (*Specs, error) {
+	spec, err := loadCollectionSpec(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	s := Specs{collection: spec}
+	if err := spec.Assign(&s.ProgramSpecs); err != nil {
+		return nil, fmt.Errorf("assign program specs: %w", err)
+	}
+	if err := spec.Assign(&s.MapSpecs); err != nil {
+		return nil, fmt.Errorf("assign map specs: %w", err)
+	}
+	return &s, nil
+}
+
+// IncompatiblePinError is returned when a map pinned under the pin path does
+// not match the object's definition. Remove the pin, or use another pin path,
+// to create the map afresh.
+type IncompatiblePinError struct {
+	Map  string // map symbol name
+	Path string // bpffs path of the pinned map
+	Err  error  // wraps ebpf.ErrMapIncompatible
+}
+
+func (e *IncompatiblePinError) Error() string {
+	return fmt.Sprintf("pinned map %s at %s: %v", e.Map, e.Path, e.Err)
+}
+
+func (e *IncompatiblePinError) Unwrap() error {
+	return e.Err
+}
+
+// checkPinnedMaps returns an *IncompatiblePinError for the first map pinned
+// by name under pinPath that spec cannot reuse.
+func checkPinnedMaps(spec *ebpf.CollectionSpec, pinPath string) error {
+	for _, name := range []string{"events"} {
+		ms := spec.Maps[name]
+		if ms == nil || ms.Pinning != ebpf.PinByName {
+			continue
+		}
+		path := filepath.Join(pinPath, ms.Name)
+		m, err := ebpf.LoadPinnedMap(path, nil)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("load pinned map %s: %w", name, err)
+		}
+		err = ms.Compatible(m)
+		_ = m.Close()
+		if err != nil {
+			return &IncompatiblePinError{Map: name, Path: path, Err: err}
+		}
+	}
+	return nil
+}
+
+// LoadPinned loads the BPF object from objectPath like Load, but pins each map whose
+// definition sets Pinning under pinPath, reusing a map already pinned there.
+// It returns an *IncompatiblePinError if a pinned map's definition differs.
+func LoadPinned(objectPath, pinPath string) (*Objects, error) {
+	spec, err := loadCollectionSpec(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	return loadPinnedObjects(spec, pinPath, nil)
+}
+
+// LoadPinned is like Load, but pins maps under pinPath as the package-level
+// LoadPinned does. Set a map spec's Pinning to ebpf.PinByName to pin it.
+func (s *Specs) LoadPinned(pinPath string, opts *ebpf.CollectionOptions) (*Objects, error) {
+	return loadPinnedObjects(s.collection, pinPath, opts)
+}
+
+// loadPinnedObjects loads spec with its pinned maps under pinPath. It sets
+// the pin path on a copy of opts, leaving the caller's options unchanged.
+func loadPinnedObjects(spec *ebpf.CollectionSpec, pinPath string, opts *ebpf.CollectionOptions) (*Objects, error) {
+	if err := checkPinnedMaps(spec, pinPath); err != nil {
+		return nil, err
+	}
+	o := ebpf.CollectionOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	o.Maps.PinPath = pinPath
+	return loadObjects(spec, &o)
+}
+
+// IncompatibleMapError is returned by Reload when a map of the new object
+// does not match the definition of the existing map it would replace.
+type IncompatibleMapError struct {
+	Map string // map symbol name
+	Err error  // wraps ebpf.ErrMapIncompatible
+}
+
+func (e *IncompatibleMapError) Error() string {
+	return fmt.Sprintf("reload map %s: %v", e.Map, e.Err)
+}
+
+func (e *IncompatibleMapError) Unwrap() error {
+	return e.Err
+}
+
+// replacements returns the maps of m that spec defines, to be reused when
+// loading it. It returns an *IncompatibleMapError if a definition differs.
+func (m *Maps) replacements(spec *ebpf.CollectionSpec) (map[string]*ebpf.Map, error) {
+	replacements := make(map[string]*ebpf.Map)
+	for _, r := range []struct {
+		name string
+		m    *ebpf.Map
+	}{
+		{"events", m.Events.Map},
+	} {
+		ms := spec.Maps[r.name]
+		if ms == nil || r.m == nil {
+			continue
+		}
+		if err := ms.Compatible(r.m); err != nil {
+			return nil, &IncompatibleMapError{Map: r.name, Err: err}
+		}
+		replacements[r.name] = r.m
+	}
+	return replacements, nil
+}
+
+// Reload loads a new version of the BPF object from objectPath, reusing the
+// maps of o so that their contents carry over. Maps the new object does not
+// define are dropped and new ones are created empty; global variables start
+// from the new object's values.
+// It returns an *IncompatibleMapError if a map's definition changed.
+//
+// Each link in links (which may be nil) is moved to the new program with
+// link.Update where the link type supports it. Otherwise the new program is
+// attached with opts and the old link is unpinned and closed, so for a moment
+// both run; pin the links again if needed. On error, the links are moved back.
+//
+// On success, reopen readers on the new Objects and close o.
+func (o *Objects) Reload(objectPath string, links *Links, opts AttachOptions) (*Objects, error) {
+	spec, err := ebpf.LoadCollectionSpec(objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("load BPF spec: %w", err)
+	}
+	return o.ReloadSpec(spec, links, opts)
+}
+
+// ReloadSpec is like Reload, but loads spec, for example one parsed from
+// memory or taken from Specs.CollectionSpec after adjusting the specs.
+func (o *Objects) ReloadSpec(spec *ebpf.CollectionSpec, links *Links, opts AttachOptions) (*Objects, error) {
+	replacements, err := o.Maps.replacements(spec)
+	if err != nil {
+		return nil, err
+	}
+	n, err := loadObjects(spec, &ebpf.CollectionOptions{MapReplacements: replacements})
+	if err != nil {
+		return nil, err
+	}
+	if err := n.Programs.swapLinks(links, opts); err != nil {
+		if rerr := o.Programs.swapLinks(links, opts); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("move links back: %w", rerr))
+		}
+		n.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+// swapLinks moves every link in links to the matching program of p.
+func (p *Programs) swapLinks(links *Links, opts AttachOptions) error {
+	if links == nil {
+		return nil
+	}
+	if err := swapLink(&links.TraceOpenat2, p.TraceOpenat2, func() (link.Link, error) { return p.AttachTraceOpenat2(opts) }); err != nil {
+		return fmt.Errorf("swap link trace_openat2: %w", err)
+	}
+	return nil
+}
+
+// swapLink points *l at prog atomically with link.Update. If the link type
+// does not support that, it attaches prog and then closes the old link.
+func swapLink(l *link.Link, prog *ebpf.Program, attach func() (link.Link, error)) error {
+	if *l == nil {
+		return nil
+	}
+	err := (*l).Update(prog)
+	if !errors.Is(err, link.ErrNotSupported) {
+		return err
+	}
+	next, err := attach()
+	if err != nil {
+		return err
+	}
+	prev := *l
+	*l = next
+	return errors.Join(prev.Unpin(), prev.Close())
+}
+
 // Close releases all resources held by Objects.
 func (o *Objects) Close() {
 	if o == nil {
@@ -53,9 +664,28 @@ func (p *Programs) Close() {
 	}
 }
 
+// Pin pins every loaded program under pinPath, named after its symbol.
+func (p *Programs) Pin(pinPath string) error {
+	if p.TraceOpenat2 != nil {
+		if err := p.TraceOpenat2.Pin(filepath.Join(pinPath, "trace_openat2")); err != nil {
+			return fmt.Errorf("pin program trace_openat2: %w", err)
+		}
+	}
+	return nil
+}
+
+// Unpin removes the pins created by Pin. The programs stay loaded until closed.
+func (p *Programs) Unpin() error {
+	var errs []error
+	if p.TraceOpenat2 != nil {
+		errs = append(errs, p.TraceOpenat2.Unpin())
+	}
+	return errors.Join(errs...)
+}
+
 // Close releases all maps.
 func (m *Maps) Close() {
-	if m.Events != nil {
-		_ = m.Events.Close()
+	if m.Events.Map != nil {
+		_ = m.Events.Map.Close()
 	}
 }
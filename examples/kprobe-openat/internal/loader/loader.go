@@ -36,7 +36,7 @@ func LoadAndAttach(objectPath string) (*Loaded, error) {
 	return &Loaded{
 		Objects:   objs,
 		Link:      kp,
-		EventsMap: objs.Events,
+		EventsMap: objs.Events.Map,
 	}, nil
 }
 
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"os"
@@ -43,16 +42,14 @@ func main() {
 			fmt.Fprintln(os.Stdout, "detaching tracepoint program")
 			return
 		case <-ticker.C:
-			var key uint32
-			values := make([]byte, 8*ncpu)
-			if err := loaded.CountersMap.Lookup(key, &values); err != nil {
+			values, err := loaded.CountersMap.Lookup(0)
+			if err != nil {
 				fmt.Fprintf(os.Stderr, "lookup error: %v\n", err)
 				continue
 			}
 			var total uint64
-			for cpu := 0; cpu < ncpu; cpu++ {
-				off := cpu * 8
-				total += binary.LittleEndian.Uint64(values[off : off+8])
+			for _, v := range values {
+				total += v
 			}
 			rate := total - prevTotal
 			prevTotal = total
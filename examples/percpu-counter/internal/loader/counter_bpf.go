@@ -3,9 +3,13 @@
 package loader
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
 )
 
 // Objects contains all programs and maps from the BPF object.
@@ -21,22 +25,414 @@ type Programs struct {
 
 // Maps contains all BPF maps.
 type Maps struct {
-	Counters *ebpf.Map `ebpf:"counters"`
+	Counters CountersMap
 }
 
-// Load loads the BPF object from objectPath and returns populated Objects.
-func Load(objectPath string) (*Objects, error) {
+// CountersMap wraps the counters map (PerCPUArray).
+type CountersMap struct {
+	*ebpf.Map `ebpf:"counters"`
+}
+
+// Lookup returns the per-CPU values stored at index, one per possible CPU.
+func (m CountersMap) Lookup(index uint32) ([]uint64, error) {
+	var value []uint64
+	err := m.Map.Lookup(index, &value)
+	return value, err
+}
+
+// Put stores one value per possible CPU at index.
+func (m CountersMap) Put(index uint32, values []uint64) error {
+	return m.Map.Put(index, values)
+}
+
+// Iterate calls fn for each entry until fn returns false.
+func (m CountersMap) Iterate(fn func(index uint32, value []uint64) bool) error {
+	var (
+		index uint32
+		value []uint64
+	)
+	it := m.Map.Iterate()
+	for it.Next(&index, &value) {
+		if !fn(index, value) {
+			return nil
+		}
+	}
+	return it.Err()
+}
+
+// BatchLookup reads up to len(keys) entries starting at cursor and returns
+// the number read. Per-CPU values are laid out key by key, one per possible CPU.
+func (m CountersMap) BatchLookup(cursor *ebpf.MapBatchCursor, keys []uint32, values []uint64) (int, error) {
+	return m.Map.BatchLookup(cursor, keys, values, nil)
+}
+
+// BatchPut stores values at keys and returns the number of entries
+// written. Per-CPU values are laid out key by key, one per possible CPU.
+func (m CountersMap) BatchPut(keys []uint32, values []uint64) (int, error) {
+	return m.Map.BatchUpdate(keys, values, nil)
+}
+
+// AttachOptions supplies the attach targets that program sections do not encode.
+type AttachOptions struct {
+	// Interface is the network interface index for XDP and TC programs.
+	Interface int
+	// XDPFlags selects the XDP attach mode (optional).
+	XDPFlags link.XDPAttachFlags
+	// CgroupPath is the cgroup v2 directory for cgroup programs.
+	CgroupPath string
+}
+
+// Links holds the links created by AttachAll.
+type Links struct {
+	TracepointSyscallsSysEnter link.Link
+}
+
+// Close detaches all links.
+func (l *Links) Close() error {
+	if l == nil {
+		return nil
+	}
+	var errs []error
+	if l.TracepointSyscallsSysEnter != nil {
+		errs = append(errs, l.TracepointSyscallsSysEnter.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// AttachTracepointSyscallsSysEnter attaches tracepoint_syscalls_sys_enter to tracepoint raw_syscalls/sys_enter.
+func (p *Programs) AttachTracepointSyscallsSysEnter(opts AttachOptions) (link.Link, error) {
+	l, err := link.Tracepoint("raw_syscalls", "sys_enter", p.TracepointSyscallsSysEnter, nil)
+	if err != nil {
+		return nil, fmt.Errorf("attach tracepoint_syscalls_sys_enter (tracepoint/raw_syscalls/sys_enter): %w", err)
+	}
+	return l, nil
+}
+
+// AttachAll attaches every program whose section names an attach point. On
+// error, the links created so far are closed.
+func (p *Programs) AttachAll(opts AttachOptions) (*Links, error) {
+	var (
+		l   Links
+		err error
+	)
+	if l.TracepointSyscallsSysEnter, err = p.AttachTracepointSyscallsSysEnter(opts); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Pin pins every link under pinPath so that the attachments outlive the
+// process. Reopen them with LoadPinnedLinks.
+func (l *Links) Pin(pinPath string) error {
+	if l == nil {
+		return nil
+	}
+	if l.TracepointSyscallsSysEnter != nil {
+		if err := l.TracepointSyscallsSysEnter.Pin(filepath.Join(pinPath, "tracepoint_syscalls_sys_enter_link")); err != nil {
+			return fmt.Errorf("pin link tracepoint_syscalls_sys_enter: %w", err)
+		}
+	}
+	return nil
+}
+
+// Unpin removes the pins created by Pin. The programs are detached once the
+// links are closed as well.
+func (l *Links) Unpin() error {
+	if l == nil {
+		return nil
+	}
+	var errs []error
+	if l.TracepointSyscallsSysEnter != nil {
+		errs = append(errs, l.TracepointSyscallsSysEnter.Unpin())
+	}
+	return errors.Join(errs...)
+}
+
+// LoadPinnedLinks opens the links pinned under pinPath by Links.Pin, for
+// example after a restart. Links that are not pinned are left nil.
+func LoadPinnedLinks(pinPath string) (*Links, error) {
+	var (
+		l   Links
+		err error
+	)
+	if l.TracepointSyscallsSysEnter, err = loadPinnedLink(filepath.Join(pinPath, "tracepoint_syscalls_sys_enter_link")); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("load pinned link tracepoint_syscalls_sys_enter: %w", err)
+	}
+	return &l, nil
+}
+
+// loadPinnedLink opens the link pinned at path, or returns nil if there is none.
+func loadPinnedLink(path string) (link.Link, error) {
+	l, err := link.LoadPinnedLink(path, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return l, err
+}
+
+// ProgramSpecs contains the specs of all BPF programs.
+type ProgramSpecs struct {
+	TracepointSyscallsSysEnter *ebpf.ProgramSpec `ebpf:"tracepoint_syscalls_sys_enter"`
+}
+
+// MapSpecs contains the specs of all BPF maps.
+type MapSpecs struct {
+	Counters *ebpf.MapSpec `ebpf:"counters"`
+}
+
+// Specs contains the program and map specs of the BPF object. Changes to
+// them, such as a different MaxEntries, apply when Load is called.
+type Specs struct {
+	ProgramSpecs
+	MapSpecs
+
+	collection *ebpf.CollectionSpec
+}
+
+// CollectionSpec returns the spec the program and map specs belong to.
+func (s *Specs) CollectionSpec() *ebpf.CollectionSpec {
+	return s.collection
+}
+
+// Load loads the specs into the kernel and returns populated Objects.
+// opts may be nil.
+func (s *Specs) Load(opts *ebpf.CollectionOptions) (*Objects, error) {
+	return loadObjects(s.collection, opts)
+}
+
+// loadCollectionSpec parses the BPF object from objectPath.
+func loadCollectionSpec(objectPath string) (*ebpf.CollectionSpec, error) {
 	spec, err := ebpf.LoadCollectionSpec(objectPath)
 	if err != nil {
 		return nil, fmt.Errorf("load BPF spec: %w", err)
 	}
+	return spec, nil
+}
+
+// Load loads the BPF object from objectPath and returns populated Objects.
+func Load(objectPath string) (*Objects, error) {
+	return LoadWithOptions(objectPath, nil)
+}
+
+// LoadWithOptions is like Load, but passes opts (e.g. verifier log settings
+// or MapReplacements) to the loader. opts may be nil.
+func LoadWithOptions(objectPath string, opts *ebpf.CollectionOptions) (*Objects, error) {
+	spec, err := loadCollectionSpec(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	return loadObjects(spec, opts)
+}
+
+// loadObjects loads spec into the kernel and assigns its programs and maps.
+func loadObjects(spec *ebpf.CollectionSpec, opts *ebpf.CollectionOptions) (*Objects, error) {
 	var objs Objects
-	if err := spec.LoadAndAssign(&objs, nil); err != nil {
+	if err := spec.LoadAndAssign(&objs, opts); err != nil {
 		return nil, fmt.Errorf("load and assign: %w", err)
 	}
 	return &objs, nil
 }
 
+// LoadSpec parses the BPF object at objectPath and returns its specs without
+// loading anything into the kernel.
+func LoadSpec(objectPath string) (*Specs, error) {
+	spec, err := loadCollectionSpec(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	s := Specs{collection: spec}
+	if err := spec.Assign(&s.ProgramSpecs); err != nil {
+		return nil, fmt.Errorf("assign program specs: %w", err)
+	}
+	if err := spec.Assign(&s.MapSpecs); err != nil {
+		return nil, fmt.Errorf("assign map specs: %w", err)
+	}
+	return &s, nil
+}
+
+// IncompatiblePinError is returned when a map pinned under the pin path does
+// not match the object's definition. Remove the pin, or use another pin path,
+// to create the map afresh.
+type IncompatiblePinError struct {
+	Map  string // map symbol name
+	Path string // bpffs path of the pinned map
+	Err  error  // wraps ebpf.ErrMapIncompatible
+}
+
+func (e *IncompatiblePinError) Error() string {
+	return fmt.Sprintf("pinned map %s at %s: %v", e.Map, e.Path, e.Err)
+}
+
+func (e *IncompatiblePinError) Unwrap() error {
+	return e.Err
+}
+
+// checkPinnedMaps returns an *IncompatiblePinError for the first map pinned
+// by name under pinPath that spec cannot reuse.
+func checkPinnedMaps(spec *ebpf.CollectionSpec, pinPath string) error {
+	for _, name := range []string{"counters"} {
+		ms := spec.Maps[name]
+		if ms == nil || ms.Pinning != ebpf.PinByName {
+			continue
+		}
+		path := filepath.Join(pinPath, ms.Name)
+		m, err := ebpf.LoadPinnedMap(path, nil)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("load pinned map %s: %w", name, err)
+		}
+		err = ms.Compatible(m)
+		_ = m.Close()
+		if err != nil {
+			return &IncompatiblePinError{Map: name, Path: path, Err: err}
+		}
+	}
+	return nil
+}
+
+// LoadPinned loads the BPF object from objectPath like Load, but pins each map whose
+// definition sets Pinning under pinPath, reusing a map already pinned there.
+// It returns an *IncompatiblePinError if a pinned map's definition differs.
+func LoadPinned(objectPath, pinPath string) (*Objects, error) {
+	spec, err := loadCollectionSpec(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	return loadPinnedObjects(spec, pinPath, nil)
+}
+
+// LoadPinned is like Load, but pins maps under pinPath as the package-level
+// LoadPinned does. Set a map spec's Pinning to ebpf.PinByName to pin it.
+func (s *Specs) LoadPinned(pinPath string, opts *ebpf.CollectionOptions) (*Objects, error) {
+	return loadPinnedObjects(s.collection, pinPath, opts)
+}
+
+// loadPinnedObjects loads spec with its pinned maps under pinPath. It sets
+// the pin path on a copy of opts, leaving the caller's options unchanged.
+func loadPinnedObjects(spec *ebpf.CollectionSpec, pinPath string, opts *ebpf.CollectionOptions) (*Objects, error) {
+	if err := checkPinnedMaps(spec, pinPath); err != nil {
+		return nil, err
+	}
+	o := ebpf.CollectionOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	o.Maps.PinPath = pinPath
+	return loadObjects(spec, &o)
+}
+
+// IncompatibleMapError is returned by Reload when a map of the new object
+// does not match the definition of the existing map it would replace.
+type IncompatibleMapError struct {
+	Map string // map symbol name
+	Err error  // wraps ebpf.ErrMapIncompatible
+}
+
+func (e *IncompatibleMapError) Error() string {
+	return fmt.Sprintf("reload map %s: %v", e.Map, e.Err)
+}
+
+func (e *IncompatibleMapError) Unwrap() error {
+	return e.Err
+}
+
+// replacements returns the maps of m that spec defines, to be reused when
+// loading it. It returns an *IncompatibleMapError if a definition differs.
+func (m *Maps) replacements(spec *ebpf.CollectionSpec) (map[string]*ebpf.Map, error) {
+	replacements := make(map[string]*ebpf.Map)
+	for _, r := range []struct {
+		name string
+		m    *ebpf.Map
+	}{
+		{"counters", m.Counters.Map},
+	} {
+		ms := spec.Maps[r.name]
+		if ms == nil || r.m == nil {
+			continue
+		}
+		if err := ms.Compatible(r.m); err != nil {
+			return nil, &IncompatibleMapError{Map: r.name, Err: err}
+		}
+		replacements[r.name] = r.m
+	}
+	return replacements, nil
+}
+
+// Reload loads a new version of the BPF object from objectPath, reusing the
+// maps of o so that their contents carry over. Maps the new object does not
+// define are dropped and new ones are created empty; global variables start
+// from the new object's values.
+// It returns an *IncompatibleMapError if a map's definition changed.
+//
+// Each link in links (which may be nil) is moved to the new program with
+// link.Update where the link type supports it. Otherwise the new program is
+// attached with opts and the old link is unpinned and closed, so for a moment
+// both run; pin the links again if needed. On error, the links are moved back.
+//
+// On success, reopen readers on the new Objects and close o.
+func (o *Objects) Reload(objectPath string, links *Links, opts AttachOptions) (*Objects, error) {
+	spec, err := ebpf.LoadCollectionSpec(objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("load BPF spec: %w", err)
+	}
+	return o.ReloadSpec(spec, links, opts)
+}
+
+// ReloadSpec is like Reload, but loads spec, for example one parsed from
+// memory or taken from Specs.CollectionSpec after adjusting the specs.
+func (o *Objects) ReloadSpec(spec *ebpf.CollectionSpec, links *Links, opts AttachOptions) (*Objects, error) {
+	replacements, err := o.Maps.replacements(spec)
+	if err != nil {
+		return nil, err
+	}
+	n, err := loadObjects(spec, &ebpf.CollectionOptions{MapReplacements: replacements})
+	if err != nil {
+		return nil, err
+	}
+	if err := n.Programs.swapLinks(links, opts); err != nil {
+		if rerr := o.Programs.swapLinks(links, opts); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("move links back: %w", rerr))
+		}
+		n.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+// swapLinks moves every link in links to the matching program of p.
+func (p *Programs) swapLinks(links *Links, opts AttachOptions) error {
+	if links == nil {
+		return nil
+	}
+	if err := swapLink(&links.TracepointSyscallsSysEnter, p.TracepointSyscallsSysEnter, func() (link.Link, error) { return p.AttachTracepointSyscallsSysEnter(opts) }); err != nil {
+		return fmt.Errorf("swap link tracepoint_syscalls_sys_enter: %w", err)
+	}
+	return nil
+}
+
+// swapLink points *l at prog atomically with link.Update. If the link type
+// does not support that, it attaches prog and then closes the old link.
+func swapLink(l *link.Link, prog *ebpf.Program, attach func() (link.Link, error)) error {
+	if *l == nil {
+		return nil
+	}
+	err := (*l).Update(prog)
+	if !errors.Is(err, link.ErrNotSupported) {
+		return err
+	}
+	next, err := attach()
+	if err != nil {
+		return err
+	}
+	prev := *l
+	*l = next
+	return errors.Join(prev.Unpin(), prev.Close())
+}
+
 // Close releases all resources held by Objects.
 func (o *Objects) Close() {
 	if o == nil {
@@ -53,9 +449,28 @@ func (p *Programs) Close() {
 	}
 }
 
+// Pin pins every loaded program under pinPath, named after its symbol.
+func (p *Programs) Pin(pinPath string) error {
+	if p.TracepointSyscallsSysEnter != nil {
+		if err := p.TracepointSyscallsSysEnter.Pin(filepath.Join(pinPath, "tracepoint_syscalls_sys_enter")); err != nil {
+			return fmt.Errorf("pin program tracepoint_syscalls_sys_enter: %w", err)
+		}
+	}
+	return nil
+}
+
+// Unpin removes the pins created by Pin. The programs stay loaded until closed.
+func (p *Programs) Unpin() error {
+	var errs []error
+	if p.TracepointSyscallsSysEnter != nil {
+		errs = append(errs, p.TracepointSyscallsSysEnter.Unpin())
+	}
+	return errors.Join(errs...)
+}
+
 // Close releases all maps.
 func (m *Maps) Close() {
-	if m.Counters != nil {
-		_ = m.Counters.Close()
+	if m.Counters.Map != nil {
+		_ = m.Counters.Map.Close()
 	}
 }
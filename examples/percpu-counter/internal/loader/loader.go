@@ -8,7 +8,6 @@ package loader
 import (
 	"fmt"
 
-	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
 )
 
@@ -16,7 +15,7 @@ import (
 type Loaded struct {
 	Objects     *Objects
 	Link        link.Link
-	CountersMap *ebpf.Map
+	CountersMap CountersMap
 }
 
 // LoadAndAttach loads the eBPF collection from objectPath and attaches the
@@ -9,15 +9,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
-
-	"github.com/cilium/ebpf"
-	"github.com/cilium/ebpf/link"
 )
 
 // Loaded holds the resources obtained after a successful load and attach.
 type Loaded struct {
 	Objects *Objects
-	Link    link.Link
+	Links   *Links
 }
 
 // LoadAndAttach loads the eBPF collection from objectPath, attaches the TC
@@ -35,28 +32,23 @@ func LoadAndAttach(objectPath, iface string, port uint16) (*Loaded, error) {
 		return nil, fmt.Errorf("interface %q: %w", iface, err)
 	}
 
-	tcLink, err := link.AttachTCX(link.TCXOptions{
-		Interface: ifObj.Index,
-		Program:   objs.ClassifyIngress,
-		Attach:    ebpf.AttachTCXIngress,
-	})
+	links, err := objs.AttachAll(AttachOptions{Interface: ifObj.Index})
 	if err != nil {
 		objs.Close()
-		return nil, fmt.Errorf("attach TC classifier to %s ingress: %w", iface, err)
+		return nil, fmt.Errorf("attach to interface %s: %w", iface, err)
 	}
 
-	portBE := make([]byte, 2)
-	binary.BigEndian.PutUint16(portBE, port)
-	val := uint8(1)
-	if err := objs.BlockedPorts.Put(portBE, val); err != nil {
-		_ = tcLink.Close()
+	var portBE [2]byte
+	binary.BigEndian.PutUint16(portBE[:], port)
+	if err := objs.BlockedPorts.Put(binary.NativeEndian.Uint16(portBE[:]), 1); err != nil {
+		_ = links.Close()
 		objs.Close()
 		return nil, fmt.Errorf("populate blocked_ports map with port %d: %w", port, err)
 	}
 
 	return &Loaded{
 		Objects: objs,
-		Link:    tcLink,
+		Links:   links,
 	}, nil
 }
 
@@ -65,9 +57,7 @@ func (l *Loaded) Close() {
 	if l == nil {
 		return
 	}
-	if l.Link != nil {
-		_ = l.Link.Close()
-	}
+	_ = l.Links.Close()
 	if l.Objects != nil {
 		l.Objects.Close()
 	}
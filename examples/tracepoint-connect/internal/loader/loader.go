@@ -8,7 +8,6 @@ package loader
 import (
 	"fmt"
 
-	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
 )
 
@@ -16,7 +15,7 @@ import (
 type Loaded struct {
 	Objects   *Objects
 	Link      link.Link
-	EventsMap *ebpf.Map
+	EventsMap EventsMap
 }
 
 // LoadAndAttach loads the eBPF collection from objectPath and attaches the
@@ -4,49 +4,34 @@ package reader
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"time"
 
-	"github.com/cilium/ebpf"
-	"github.com/cilium/ebpf/ringbuf"
 	"github.com/kyleseneker/tinybpf/examples/tracepoint-connect/internal/event"
+	"github.com/kyleseneker/tinybpf/examples/tracepoint-connect/internal/loader"
 )
 
-// Run reads from the ring buffer until ctx is cancelled, decoding each
-// sample and writing the formatted event to out.
-func Run(ctx context.Context, eventsMap *ebpf.Map, out io.Writer) error {
-	rb, err := ringbuf.NewReader(eventsMap)
+// Run reads from the ring buffer until ctx is cancelled, writing each
+// decoded event to out.
+func Run(ctx context.Context, events loader.EventsMap, out io.Writer) error {
+	rd, err := events.NewReader()
 	if err != nil {
-		return fmt.Errorf("create ringbuf reader: %w", err)
+		return err
 	}
-	defer rb.Close()
+	defer rd.Close()
 
-	go func() {
-		<-ctx.Done()
-		_ = rb.Close()
-	}()
-
-	for {
-		record, err := rb.Read()
-		if err != nil {
-			if errors.Is(err, ringbuf.ErrClosed) {
-				return nil
-			}
-			return fmt.Errorf("read ringbuf: %w", err)
-		}
-		ev, err := event.Decode(record.RawSample)
+	for ev, err := range rd.All(ctx) {
 		if err != nil {
-			fmt.Fprintf(out, "decode error: %v\n", err)
-			continue
+			return fmt.Errorf("read events: %w", err)
 		}
 		fmt.Fprintf(out, "%s pid=%d proto=%d dst=%s:%d\n",
 			time.Now().Format(time.RFC3339Nano),
 			ev.PID,
 			ev.Proto,
-			ev.IP().String(),
-			ev.Port(),
+			event.IP(ev.DstAddrBE),
+			event.Port(ev.DstPortBE),
 		)
 	}
+	return nil
 }
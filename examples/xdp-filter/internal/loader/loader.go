@@ -8,16 +8,13 @@ package loader
 import (
 	"fmt"
 	"net"
-
-	"github.com/cilium/ebpf"
-	"github.com/cilium/ebpf/link"
 )
 
 // Loaded holds the resources obtained after a successful load and attach.
 type Loaded struct {
 	Objects      *Objects
-	Link         link.Link
-	BlocklistMap *ebpf.Map
+	Links        *Links
+	BlocklistMap BlocklistMap
 }
 
 // LoadAndAttach loads the eBPF collection from objectPath and attaches the
@@ -34,18 +31,15 @@ func LoadAndAttach(objectPath, iface string) (*Loaded, error) {
 		return nil, fmt.Errorf("interface %q: %w", iface, err)
 	}
 
-	xdpLink, err := link.AttachXDP(link.XDPOptions{
-		Program:   objs.XdpFilter,
-		Interface: ifObj.Index,
-	})
+	links, err := objs.AttachAll(AttachOptions{Interface: ifObj.Index})
 	if err != nil {
 		objs.Close()
-		return nil, fmt.Errorf("attach XDP to %s: %w", iface, err)
+		return nil, fmt.Errorf("attach to interface %s: %w", iface, err)
 	}
 
 	return &Loaded{
 		Objects:      objs,
-		Link:         xdpLink,
+		Links:        links,
 		BlocklistMap: objs.Blocklist,
 	}, nil
 }
@@ -55,9 +49,7 @@ func (l *Loaded) Close() {
 	if l == nil {
 		return
 	}
-	if l.Link != nil {
-		_ = l.Link.Close()
-	}
+	_ = l.Links.Close()
 	if l.Objects != nil {
 		l.Objects.Close()
 	}
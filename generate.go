@@ -0,0 +1,230 @@
+package tinybpf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kyleseneker/tinybpf/internal/codegen"
+)
+
+// Generate produces type-safe Go loader code for a compiled BPF ELF object,
+// writing it to req.Output when set.
+func Generate(req GenerateRequest) (*GenerateResult, error) {
+	if err := validateGenerateRequest(&req); err != nil {
+		return nil, err
+	}
+
+	info, err := generateInfo(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &GenerateResult{Programs: info.Programs, Maps: info.Maps}
+	if req.Style == "bpf2go" {
+		result.Source, err = codegen.GenerateBPF2Go(req.Package, req.Ident, info, req.EmbedPath)
+	} else {
+		result.Source, err = codegen.Generate(req.Package, info, req.EmbedPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.WithFakes {
+		if result.Fakes, err = codegen.GenerateFakes(req.Package, info); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Output != "" {
+		if err := writeGenerated(req, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// generateInfo extracts the object's ELF info and applies the requested
+// types, event types and pin path.
+func generateInfo(req GenerateRequest) (*codegen.ELFInfo, error) {
+	var info *codegen.ELFInfo
+	var err error
+	if req.Object != "" {
+		info, err = codegen.ExtractELFInfo(req.Object)
+	} else {
+		info, err = codegen.ExtractELFInfoFromReader(bytes.NewReader(req.ObjectBytes), "ObjectBytes")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range req.Types {
+		if err := info.IncludeType(name); err != nil {
+			return nil, err
+		}
+	}
+	events := make([]string, 0, len(req.Events))
+	for name := range req.Events {
+		events = append(events, name)
+	}
+	sort.Strings(events)
+	for _, name := range events {
+		if err := info.SetEventType(name, req.Events[name]); err != nil {
+			return nil, err
+		}
+	}
+	info.PinPath = req.PinPath
+	if len(req.Variants) > 0 {
+		if err := addVariants(req, info); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// addVariants sets info.Variants to the requested object followed by its
+// fallback variants, after checking that they all declare the same
+// programs, maps and variables.
+func addVariants(req GenerateRequest, info *codegen.ELFInfo) error {
+	objects := append([]GenerateVariant{{Object: req.Object, EmbedPath: req.EmbedPath}}, req.Variants...)
+	seen := make(map[string]bool)
+	for i, gv := range objects {
+		name := filepath.Base(gv.EmbedPath)
+		if seen[name] {
+			return fmt.Errorf("duplicate variant %s", name)
+		}
+		seen[name] = true
+
+		var data []byte
+		if i == 0 && gv.Object == "" {
+			data = req.ObjectBytes
+		} else {
+			var err error
+			if data, err = os.ReadFile(gv.Object); err != nil {
+				return fmt.Errorf("read variant: %w", err)
+			}
+			other, err := codegen.ExtractELFInfoFromReader(bytes.NewReader(data), gv.Object)
+			if err != nil {
+				return err
+			}
+			if err := info.CheckVariant(name, other); err != nil {
+				return err
+			}
+		}
+		requires, err := codegen.Requirements(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("variant %s: %w", name, err)
+		}
+		info.Variants = append(info.Variants, codegen.Variant{Name: name, EmbedPath: gv.EmbedPath, Requires: requires})
+	}
+	return nil
+}
+
+// writeGenerated writes the generated loader, and fakes if requested, next
+// to req.Output.
+func writeGenerated(req GenerateRequest, result *GenerateResult) error {
+	if err := os.WriteFile(req.Output, result.Source, 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", req.Output, err)
+	}
+	if req.WithFakes {
+		fakes := FakesPath(req.Output)
+		if err := os.WriteFile(fakes, result.Fakes, 0o600); err != nil {
+			return fmt.Errorf("write %s: %w", fakes, err)
+		}
+	}
+	return nil
+}
+
+// BuildAndGenerate builds a BPF ELF object as [Build] does, then generates
+// a loader for it as [Generate] does. gen.Object and gen.ObjectBytes must be
+// empty; the built object is used. If req has variants, the loader embeds all
+// of them, next to gen.EmbedPath, unless gen.Variants is set.
+func BuildAndGenerate(ctx context.Context, req Request, gen GenerateRequest) (*Result, *GenerateResult, error) {
+	if gen.Object != "" || len(gen.ObjectBytes) > 0 {
+		return nil, nil, errors.New("generate request must not set Object or ObjectBytes: the built object is used")
+	}
+	result, err := Build(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	gen.Object = result.Output
+	if len(gen.Variants) == 0 && len(result.Variants) > 1 && gen.EmbedPath != "" {
+		// EmbedPath names req.Output; the variants are written next to it.
+		dir := filepath.Dir(gen.EmbedPath)
+		embedPath := func(output string) string {
+			return filepath.ToSlash(filepath.Join(dir, filepath.Base(output)))
+		}
+		gen.EmbedPath = embedPath(result.Output)
+		for _, v := range result.Variants[1:] {
+			gen.Variants = append(gen.Variants, GenerateVariant{Object: v.Output, EmbedPath: embedPath(v.Output)})
+		}
+	}
+	genResult, err := Generate(gen)
+	if err != nil {
+		return result, nil, fmt.Errorf("generate loader for %s: %w", result.Output, err)
+	}
+	return result, genResult, nil
+}
+
+// FakesPath returns the path [Generate] writes map fakes to for a loader
+// written to output.
+func FakesPath(output string) string {
+	return strings.TrimSuffix(output, ".go") + "_fakes.go"
+}
+
+// validateGenerateRequest validates the request and applies its defaults.
+func validateGenerateRequest(req *GenerateRequest) error {
+	if (req.Object != "") == (len(req.ObjectBytes) > 0) {
+		return errors.New("exactly one of Object or ObjectBytes must be set")
+	}
+	if req.Package == "" {
+		return errors.New("a Package name must be set")
+	}
+	if req.PinPath != "" && !filepath.IsAbs(req.PinPath) {
+		return fmt.Errorf("pin path %q must be absolute", req.PinPath)
+	}
+	if err := validateGenerateVariants(req); err != nil {
+		return err
+	}
+	if req.Style == "" {
+		req.Style = "tinybpf"
+	}
+	switch req.Style {
+	case "tinybpf":
+		if req.Ident != "" {
+			return errors.New("an Ident is only valid with the bpf2go style")
+		}
+	case "bpf2go":
+		if req.Ident == "" {
+			return errors.New("the bpf2go style requires an Ident")
+		}
+		if req.WithFakes {
+			return errors.New("fakes are not supported with the bpf2go style")
+		}
+		if len(req.Variants) > 0 {
+			return errors.New("variants are not supported with the bpf2go style")
+		}
+	default:
+		return fmt.Errorf("unknown style %q: expected tinybpf or bpf2go", req.Style)
+	}
+	return nil
+}
+
+// validateGenerateVariants checks that every variant can be embedded.
+func validateGenerateVariants(req *GenerateRequest) error {
+	if len(req.Variants) == 0 {
+		return nil
+	}
+	if req.EmbedPath == "" {
+		return errors.New("variants require an EmbedPath")
+	}
+	for _, v := range req.Variants {
+		if v.Object == "" || v.EmbedPath == "" {
+			return errors.New("each variant needs an Object and an EmbedPath")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package tinybpf_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kyleseneker/tinybpf"
+	"github.com/kyleseneker/tinybpf/internal/testutil"
+)
+
+func TestGenerateValidation(t *testing.T) {
+	object := testutil.BPFObject()
+	tests := []struct {
+		name string
+		req  tinybpf.GenerateRequest
+		want string
+	}{
+		{
+			name: "neither Object nor ObjectBytes",
+			req:  tinybpf.GenerateRequest{Package: "loader"},
+			want: "exactly one of Object or ObjectBytes",
+		},
+		{
+			name: "both Object and ObjectBytes",
+			req:  tinybpf.GenerateRequest{Object: "probe.bpf.o", ObjectBytes: object, Package: "loader"},
+			want: "exactly one of Object or ObjectBytes",
+		},
+		{
+			name: "missing Package",
+			req:  tinybpf.GenerateRequest{ObjectBytes: object},
+			want: "Package name must be set",
+		},
+		{
+			name: "relative PinPath",
+			req:  tinybpf.GenerateRequest{ObjectBytes: object, Package: "loader", PinPath: "bpf/probe"},
+			want: "must be absolute",
+		},
+		{
+			name: "unknown Style",
+			req:  tinybpf.GenerateRequest{ObjectBytes: object, Package: "loader", Style: "libbpf"},
+			want: `unknown style "libbpf"`,
+		},
+		{
+			name: "Ident without bpf2go",
+			req:  tinybpf.GenerateRequest{ObjectBytes: object, Package: "loader", Ident: "probe"},
+			want: "only valid with the bpf2go style",
+		},
+		{
+			name: "bpf2go without Ident",
+			req:  tinybpf.GenerateRequest{ObjectBytes: object, Package: "loader", Style: "bpf2go"},
+			want: "requires an Ident",
+		},
+		{
+			name: "bpf2go with fakes",
+			req: tinybpf.GenerateRequest{
+				ObjectBytes: object, Package: "loader", Style: "bpf2go", Ident: "probe", WithFakes: true,
+			},
+			want: "fakes are not supported",
+		},
+		{
+			name: "variants without EmbedPath",
+			req: tinybpf.GenerateRequest{
+				ObjectBytes: object, Package: "loader",
+				Variants: []tinybpf.GenerateVariant{{Object: "probe.v2.bpf.o", EmbedPath: "probe.v2.bpf.o"}},
+			},
+			want: "variants require an EmbedPath",
+		},
+		{
+			name: "variant without Object",
+			req: tinybpf.GenerateRequest{
+				ObjectBytes: object, Package: "loader", EmbedPath: "probe.bpf.o",
+				Variants: []tinybpf.GenerateVariant{{EmbedPath: "probe.v2.bpf.o"}},
+			},
+			want: "each variant needs an Object and an EmbedPath",
+		},
+		{
+			name: "variants with bpf2go",
+			req: tinybpf.GenerateRequest{
+				ObjectBytes: object, Package: "loader", EmbedPath: "probe.bpf.o", Style: "bpf2go", Ident: "probe",
+				Variants: []tinybpf.GenerateVariant{{Object: "probe.v2.bpf.o", EmbedPath: "probe.v2.bpf.o"}},
+			},
+			want: "variants are not supported with the bpf2go style",
+		},
+		{
+			name: "duplicate variant",
+			req: tinybpf.GenerateRequest{
+				ObjectBytes: object, Package: "loader", EmbedPath: "probe.bpf.o",
+				Variants: []tinybpf.GenerateVariant{{Object: "probe.bpf.o", EmbedPath: "other/probe.bpf.o"}},
+			},
+			want: "duplicate variant probe.bpf.o",
+		},
+		{
+			name: "invalid object",
+			req:  tinybpf.GenerateRequest{ObjectBytes: []byte("not an ELF"), Package: "loader"},
+			want: `open ELF "ObjectBytes"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tinybpf.Generate(tt.req)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q should contain %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	objectPath := filepath.Join(dir, "probe.bpf.o")
+	if err := os.WriteFile(objectPath, testutil.BPFObject(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		req      tinybpf.GenerateRequest
+		contains []string
+	}{
+		{
+			name:     "object path",
+			req:      tinybpf.GenerateRequest{Object: objectPath, Package: "loader"},
+			contains: []string{"package loader", "func Load(objectPath string)", "TestProg *ebpf.Program"},
+		},
+		{
+			name:     "object bytes with embed path",
+			req:      tinybpf.GenerateRequest{ObjectBytes: testutil.BPFObject(), Package: "loader", EmbedPath: "probe.bpf.o"},
+			contains: []string{"//go:embed probe.bpf.o", "func Load()"},
+		},
+		{
+			name: "bpf2go style",
+			req: tinybpf.GenerateRequest{
+				Object: objectPath, Package: "loader", EmbedPath: "probe.bpf.o", Style: "bpf2go", Ident: "probe",
+			},
+			contains: []string{"func loadProbeObjects(obj interface{}, opts *ebpf.CollectionOptions) error"},
+		},
+		{
+			name: "variants",
+			req: tinybpf.GenerateRequest{
+				Object: objectPath, Package: "loader", EmbedPath: "probe.bpf.o",
+				Variants: []tinybpf.GenerateVariant{{Object: objectPath, EmbedPath: "probe.v2.bpf.o"}},
+			},
+			contains: []string{
+				"//go:embed probe.bpf.o", "//go:embed probe.v2.bpf.o",
+				`{"probe.v2.bpf.o", _bpfVariant1, []func() error{`,
+				"func Variant() (string, error) {",
+			},
+		},
+		{
+			name:     "written to Output",
+			req:      tinybpf.GenerateRequest{Object: objectPath, Package: "loader", Output: filepath.Join(dir, "probe_bpf.go")},
+			contains: []string{"package loader"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tinybpf.Generate(tt.req)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			if len(result.Programs) != 1 || result.Programs[0] != "test_prog" {
+				t.Errorf("Programs = %v, want [test_prog]", result.Programs)
+			}
+			if result.Fakes != nil {
+				t.Error("Fakes should be nil without WithFakes")
+			}
+			src := string(result.Source)
+			for _, s := range tt.contains {
+				if !strings.Contains(src, s) {
+					t.Errorf("generated source missing %q\n%s", s, src)
+				}
+			}
+			if tt.req.Output != "" {
+				data, err := os.ReadFile(tt.req.Output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != src {
+					t.Error("written file differs from Source")
+				}
+			}
+		})
+	}
+}
+
+func TestBuildAndGenerateRejectsObject(t *testing.T) {
+	_, _, err := tinybpf.BuildAndGenerate(context.Background(),
+		tinybpf.Request{Inputs: []string{"a.ll"}},
+		tinybpf.GenerateRequest{Object: "probe.bpf.o", Package: "loader"})
+	if err == nil || !strings.Contains(err.Error(), "must not set Object or ObjectBytes") {
+		t.Fatalf("expected object error, got %v", err)
+	}
+}
+
+func TestFakesPath(t *testing.T) {
+	for output, want := range map[string]string{
+		"probe_bpf.go":                   "probe_bpf_fakes.go",
+		"internal/loader/objects_bpf.go": "internal/loader/objects_bpf_fakes.go",
+		"loader":                         "loader_fakes.go",
+	} {
+		if got := tinybpf.FakesPath(output); got != want {
+			t.Errorf("FakesPath(%q) = %q, want %q", output, got, want)
+		}
+	}
+}
@@ -11,12 +11,14 @@ import (
 func runBuild(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 	var programs multiStringFlag
 	var sectionFlags multiStringFlag
+	var variantFlags multiStringFlag
 	var configPath string
 	var req tinybpf.Request
 
 	fs := newFlagSet(stderr, "tinybpf build [flags] <package>", "Compile Go source to a BPF ELF object in one step.")
 	registerBuildFlags(fs, &req, &programs, &sectionFlags, &configPath)
 	fs.StringVar(&req.Toolchain.TinyGo, "tinygo", "", "Path to tinygo binary (default: discovered from PATH).")
+	fs.Var(&variantFlags, "variant", "Build variant with extra Go build tags (e.g., kfuncs=kfuncs,ringbuf). Repeat for multiple, most preferred first.")
 
 	if code, ok := parseFlags(fs, args); !ok {
 		return code
@@ -49,5 +51,9 @@ func runBuild(ctx context.Context, args []string, stdout, stderr io.Writer) int
 		req.Sections = sections
 	}
 
+	if err := resolveVariants(&req, variantFlags); err != nil {
+		return cliErrorf(stderr, "%v", err)
+	}
+
 	return runBuildAndReport(ctx, req, stdout, stderr)
 }
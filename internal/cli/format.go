@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/kyleseneker/tinybpf/btffmt"
+)
+
+type typeLoader func(path, name string) (btf.Type, error)
+
+// runFormat renders raw map keys, values or event records read from stdin.
+func runFormat(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	return runFormatWith(ctx, args, os.Stdin, stdout, stderr, btffmt.LoadType)
+}
+
+// runFormatWith is the testable core of runFormat with injected input and
+// BTF type loader.
+func runFormatWith(_ context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, load typeLoader) int {
+	var object, typeName, format string
+
+	fs := newFlagSet(stderr, "tinybpf format --object <file> --type <name> [flags] [input]",
+		"Render raw bytes, such as map keys, values or ring buffer records, using the\n"+
+			"BTF of a BPF ELF object. Input is read from the named file or stdin and may\n"+
+			"hold several records back to back; each is printed on its own line.")
+	fs.StringVar(&object, "object", "", "Path to the BPF ELF object whose BTF describes the input.")
+	fs.StringVar(&typeName, "type", "", "Name of the BTF type of each record, e.g. conn_event.")
+	fs.StringVar(&format, "format", "text", "Output format: text or json.")
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if object == "" {
+		return usageErrorf(fs, stderr, "--object is required")
+	}
+	if typeName == "" {
+		return usageErrorf(fs, stderr, "--type is required")
+	}
+	if fs.NArg() > 1 {
+		return usageErrorf(fs, stderr, "expected at most one input file")
+	}
+	f, err := btffmt.ParseFormat(format)
+	if err != nil {
+		return usageErrorf(fs, stderr, "%v", err)
+	}
+
+	typ, err := load(object, typeName)
+	if err != nil {
+		return cliErrorf(stderr, "%v", err)
+	}
+	data, err := readFormatInput(fs.Arg(0), stdin)
+	if err != nil {
+		return cliErrorf(stderr, "%v", err)
+	}
+	size, err := btf.Sizeof(typ)
+	if err != nil {
+		return cliErrorf(stderr, "size of %s: %v", typeName, err)
+	}
+	if size == 0 {
+		return cliErrorf(stderr, "%s has size 0; records of it cannot be formatted", typeName)
+	}
+	if len(data) == 0 || len(data)%size != 0 {
+		return cliErrorf(stderr, "input is %d bytes, not a multiple of the %d-byte size of %s", len(data), size, typeName)
+	}
+
+	var buf []byte
+	for off := 0; off < len(data); off += size {
+		buf, err = btffmt.Append(buf[:0], typ, data[off:off+size], f)
+		if err != nil {
+			return cliErrorf(stderr, "record at offset %d: %v", off, err)
+		}
+		buf = append(buf, '\n')
+		if _, err := stdout.Write(buf); err != nil {
+			return cliErrorf(stderr, "%v", err)
+		}
+	}
+	return 0
+}
+
+// readFormatInput reads the file at path, or stdin when path is empty.
+func readFormatInput(path string, stdin io.Reader) ([]byte, error) {
+	if path == "" {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("read stdin: %w", err)
+		}
+		return data, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read input: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestRunFormat(t *testing.T) {
+	event := &btf.Struct{Name: "event", Size: 8, Members: []btf.Member{
+		{Name: "pid", Type: &btf.Int{Name: "u32", Size: 4}},
+		{Name: "comm", Type: &btf.Array{Type: &btf.Int{Name: "char", Size: 1}, Nelems: 4}, Offset: 32},
+	}}
+	load := func(path, name string) (btf.Type, error) {
+		switch name {
+		case "event":
+			return event, nil
+		case "empty":
+			return &btf.Struct{Name: "empty"}, nil
+		}
+		return nil, errors.New("type not found")
+	}
+	twoEvents := []byte{1, 0, 0, 0, 's', 'h', 0, 0, 2, 0, 0, 0, 'c', 'a', 't', 0}
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    []byte
+		wantCode int
+		wantOut  string
+		wantErr  string
+	}{
+		{
+			name:     "--help",
+			args:     []string{"--help"},
+			wantCode: 0,
+			wantErr:  "Usage:",
+		},
+		{
+			name:     "missing object",
+			args:     []string{"--type", "event"},
+			wantCode: 2,
+			wantErr:  "--object is required",
+		},
+		{
+			name:     "missing type",
+			args:     []string{"--object", "x.bpf.o"},
+			wantCode: 2,
+			wantErr:  "--type is required",
+		},
+		{
+			name:     "bad format",
+			args:     []string{"--object", "x.bpf.o", "--type", "event", "--format", "yaml"},
+			wantCode: 2,
+			wantErr:  `unknown format "yaml"`,
+		},
+		{
+			name:     "unknown type",
+			args:     []string{"--object", "x.bpf.o", "--type", "missing"},
+			wantCode: 1,
+			wantErr:  "type not found",
+		},
+		{
+			name:     "text records",
+			args:     []string{"--object", "x.bpf.o", "--type", "event"},
+			stdin:    twoEvents,
+			wantCode: 0,
+			wantOut:  "{pid: 1, comm: \"sh\"}\n{pid: 2, comm: \"cat\"}\n",
+		},
+		{
+			name:     "json records",
+			args:     []string{"--object", "x.bpf.o", "--type", "event", "--format", "json"},
+			stdin:    twoEvents,
+			wantCode: 0,
+			wantOut:  "{\"pid\":1,\"comm\":\"sh\"}\n{\"pid\":2,\"comm\":\"cat\"}\n",
+		},
+		{
+			name:     "partial record",
+			args:     []string{"--object", "x.bpf.o", "--type", "event"},
+			stdin:    twoEvents[:12],
+			wantCode: 1,
+			wantErr:  "input is 12 bytes, not a multiple of the 8-byte size of event",
+		},
+		{
+			name:     "empty input",
+			args:     []string{"--object", "x.bpf.o", "--type", "event"},
+			wantCode: 1,
+			wantErr:  "input is 0 bytes",
+		},
+		{
+			name:     "zero-size type",
+			args:     []string{"--object", "x.bpf.o", "--type", "empty"},
+			stdin:    twoEvents,
+			wantCode: 1,
+			wantErr:  "empty has size 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := runFormatWith(context.Background(), tt.args, bytes.NewReader(tt.stdin), &stdout, &stderr, load)
+			if code != tt.wantCode {
+				t.Fatalf("exit code: got %d, want %d, stderr=%s", code, tt.wantCode, stderr.String())
+			}
+			if tt.wantOut != "" && stdout.String() != tt.wantOut {
+				t.Fatalf("stdout: got %q, want %q", stdout.String(), tt.wantOut)
+			}
+			if tt.wantErr != "" && !strings.Contains(stderr.String(), tt.wantErr) {
+				t.Fatalf("expected %q in stderr, got: %s", tt.wantErr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunFormatInputFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "raw.bin")
+	if err := os.WriteFile(p, []byte{7, 0, 0, 0}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	load := func(string, string) (btf.Type, error) { return &btf.Int{Name: "u32", Size: 4}, nil }
+
+	var stdout, stderr bytes.Buffer
+	code := runFormatWith(context.Background(), []string{"--object", "x.bpf.o", "--type", "u32", p}, nil, &stdout, &stderr, load)
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr=%s", code, stderr.String())
+	}
+	if stdout.String() != "7\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "7\n")
+	}
+}
+
+func TestRunFormatMissingObject(t *testing.T) {
+	_, stderr, code := runCLI(t, "format", "--object", filepath.Join(t.TempDir(), "none.bpf.o"), "--type", "event")
+	if code != 1 || !strings.Contains(stderr, "load BTF from") {
+		t.Fatalf("exit code %d, stderr=%s", code, stderr)
+	}
+}
@@ -2,34 +2,80 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/kyleseneker/tinybpf/internal/codegen"
+	"github.com/kyleseneker/tinybpf"
+	"github.com/kyleseneker/tinybpf/config"
 )
 
 // runGenerate generates Go loader code from a compiled BPF ELF object.
 func runGenerate(_ context.Context, args []string, stdout, stderr io.Writer) int {
-	var pkg, output string
+	var pkg, output, configPath, pinPath, style, ident string
+	var types, events multiStringFlag
+	var withFakes, check bool
 
 	fs := newFlagSet(stderr,
-		"tinybpf generate [flags] <object.bpf.o>",
-		"Generate type-safe Go loader code from a compiled BPF ELF object.")
+		"tinybpf generate [flags] <object.bpf.o> [<variant.bpf.o>...]",
+		"Generate type-safe Go loader code from a compiled BPF ELF object.\n"+
+			"Extra objects are variants of it, embedded as fallbacks in order of preference.")
 	fs.StringVar(&pkg, "package", "", "Go package name for generated code (default: directory name of output).")
 	fs.StringVar(&output, "output", "", "Output file path (default: <basename>_bpf.go in current directory).")
+	fs.Var(&types, "type", "BTF type name to emit as a Go type (e.g. an event struct). Repeat for multiple.")
+	fs.Var(&events, "event", "Ring buffer or perf event array record type (e.g., events=main_connEvent). Repeat for multiple.")
+	fs.StringVar(&pinPath, "pin-path", "", "bpffs directory emitted as the loader's DefaultPinPath (default: build.pin_path from config).")
+	fs.BoolVar(&withFakes, "with-fakes", false, "Also write map interfaces and in-memory fakes to <output>_fakes.go.")
+	fs.StringVar(&style, "style", "tinybpf", "Output style: tinybpf, or bpf2go for a loader API-compatible with cilium/ebpf's bpf2go.")
+	fs.StringVar(&ident, "ident", "", "Identifier prefix for --style=bpf2go (e.g. counter for loadCounterObjects and counterObjects).")
+	fs.BoolVar(&check, "check", false, "Do not write files; exit 1 with a diff if the generated files are out of date.")
+	fs.StringVar(&configPath, "config", "", "Path to tinybpf.json (default: auto-discover).")
 
 	if code, ok := parseFlags(fs, args); !ok {
 		return code
 	}
-	if fs.NArg() != 1 {
-		return usageErrorf(fs, stderr, "exactly one BPF object argument is required")
+	if fs.NArg() == 0 {
+		return usageErrorf(fs, stderr, "a BPF object argument is required")
+	}
+	if pinPath != "" && !filepath.IsAbs(pinPath) {
+		return usageErrorf(fs, stderr, "--pin-path must be an absolute path")
+	}
+	switch style {
+	case "tinybpf":
+		if ident != "" {
+			return usageErrorf(fs, stderr, "--ident requires --style=bpf2go")
+		}
+	case "bpf2go":
+		if ident == "" {
+			return usageErrorf(fs, stderr, "--style=bpf2go requires --ident")
+		}
+		if withFakes {
+			return usageErrorf(fs, stderr, "--with-fakes is not supported with --style=bpf2go")
+		}
+		if fs.NArg() > 1 {
+			return usageErrorf(fs, stderr, "variant objects are not supported with --style=bpf2go")
+		}
+	default:
+		return usageErrorf(fs, stderr, "unknown --style %q: expected tinybpf or bpf2go", style)
 	}
 
 	objectPath := fs.Arg(0)
 
+	fileCfg, err := loadGenerateConfig(configPath)
+	if err != nil {
+		return cliErrorf(stderr, "%v", err)
+	}
+	eventTypes, err := resolveEventTypes(fileCfg, events)
+	if err != nil {
+		return cliErrorf(stderr, "%v", err)
+	}
+	if pinPath == "" {
+		pinPath = fileCfg.Build.PinPath
+	}
+
 	if output == "" {
 		base := filepath.Base(objectPath)
 		base = strings.TrimSuffix(base, ".bpf.o")
@@ -45,25 +91,120 @@ func runGenerate(_ context.Context, args []string, stdout, stderr io.Writer) int
 		pkg = filepath.Base(filepath.Dir(absOutput))
 	}
 
-	info, err := codegen.ExtractELFInfo(objectPath)
-	if err != nil {
+	req := tinybpf.GenerateRequest{
+		Object:    objectPath,
+		Package:   pkg,
+		EmbedPath: computeEmbedPath(objectPath, output),
+		Style:     style,
+		Ident:     ident,
+		Types:     types,
+		Events:    eventTypes,
+		PinPath:   pinPath,
+		WithFakes: withFakes,
+	}
+	for _, variant := range fs.Args()[1:] {
+		req.Variants = append(req.Variants, tinybpf.GenerateVariant{
+			Object:    variant,
+			EmbedPath: computeEmbedPath(variant, output),
+		})
+	}
+	if err := checkVariantEmbedPaths(req); err != nil {
 		return cliErrorf(stderr, "%v", err)
 	}
-
-	embedPath := computeEmbedPath(objectPath, output)
-	src, err := codegen.Generate(pkg, info, embedPath)
+	if !check {
+		req.Output = output
+	}
+	result, err := tinybpf.Generate(req)
 	if err != nil {
 		return cliErrorf(stderr, "%v", err)
 	}
 
-	if err := os.WriteFile(output, src, 0o600); err != nil {
-		return cliErrorf(stderr, "write %s: %v", output, err)
+	if check {
+		stale := checkGenerated(stdout, output, result.Source)
+		if withFakes {
+			stale = checkGenerated(stdout, tinybpf.FakesPath(output), result.Fakes) || stale
+		}
+		if stale {
+			return cliErrorf(stderr, "generated code is out of date with %s; rerun tinybpf generate", objectPath)
+		}
+		return 0
 	}
 
-	fmt.Fprintf(stdout, "wrote %s (%d programs, %d maps)\n", output, len(info.Programs), len(info.Maps))
+	fmt.Fprintf(stdout, "wrote %s (%d programs, %d maps)\n", output, len(result.Programs), len(result.Maps))
+	if withFakes {
+		fmt.Fprintf(stdout, "wrote %s\n", tinybpf.FakesPath(output))
+	}
 	return 0
 }
 
+// checkGenerated compares the file at path with src, printing a unified diff
+// to w and reporting true if it is missing or differs.
+func checkGenerated(w io.Writer, path string, src []byte) bool {
+	current, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(w, "%s: %v\n", path, err)
+		return true
+	}
+	diff := unifiedDiff(path, path+" (generated)", current, src)
+	if diff == "" && err == nil {
+		fmt.Fprintf(w, "%s is up to date\n", path)
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(w, "%s does not exist\n", path)
+	}
+	fmt.Fprint(w, diff)
+	return true
+}
+
+// loadGenerateConfig loads tinybpf.json for generate, returning an empty
+// config when there is none.
+func loadGenerateConfig(configPath string) (*config.Config, error) {
+	path, err := resolveConfigPath(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return &config.Config{}, nil
+	}
+	return config.Load(path)
+}
+
+// resolveEventTypes merges generate.events from tinybpf.json with --event
+// flags, which take precedence per map.
+func resolveEventTypes(cfg *config.Config, flags []string) (map[string]string, error) {
+	eventTypes := make(map[string]string)
+	for name, typ := range cfg.Generate.Events {
+		eventTypes[name] = typ
+	}
+	for _, f := range flags {
+		name, typ, ok := strings.Cut(f, "=")
+		name, typ = strings.TrimSpace(name), strings.TrimSpace(typ)
+		if !ok || name == "" || typ == "" {
+			return nil, fmt.Errorf("invalid --event %q: expected format map=type", f)
+		}
+		eventTypes[name] = typ
+	}
+	return eventTypes, nil
+}
+
+// checkVariantEmbedPaths reports an object that cannot be embedded when
+// generating a loader with variants.
+func checkVariantEmbedPaths(req tinybpf.GenerateRequest) error {
+	if len(req.Variants) == 0 {
+		return nil
+	}
+	if req.EmbedPath == "" {
+		return fmt.Errorf("%s must be in the output directory or below to embed variants", req.Object)
+	}
+	for _, v := range req.Variants {
+		if v.EmbedPath == "" {
+			return fmt.Errorf("%s must be in the output directory or below to embed variants", v.Object)
+		}
+	}
+	return nil
+}
+
 // computeEmbedPath returns the relative path from the output file's directory
 // to the BPF object, suitable for a //go:embed directive.
 func computeEmbedPath(objectPath, outputPath string) string {
@@ -1,94 +1,20 @@
 package cli
 
 import (
-	"encoding/binary"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/kyleseneker/tinybpf/internal/testutil"
 )
 
-// bpfELFWithProgram builds a minimal BPF ELF with one program symbol properly
-// bound to an executable section, suitable for codegen extraction.
+// bpfELFWithProgram writes a minimal BPF ELF with one program symbol,
+// suitable for codegen extraction.
 func bpfELFWithProgram(t *testing.T) string {
 	t.Helper()
-
-	shstrtab := "\x00.text\x00.symtab\x00.strtab\x00.shstrtab\x00"
-	for len(shstrtab)%8 != 0 {
-		shstrtab += "\x00"
-	}
-
-	symstrtab := "\x00test_prog\x00"
-	for len(symstrtab)%8 != 0 {
-		symstrtab += "\x00"
-	}
-
-	code := []byte{0x95, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-
-	off := uint64(64)
-	codeOff := off
-	off += uint64(len(code))
-	symstrtabOff := off
-	off += uint64(len(symstrtab))
-	symtabOff := off
-
-	nullSym := make([]byte, 24)
-	progSym := make([]byte, 24)
-	binary.LittleEndian.PutUint32(progSym[0:4], 1)
-	progSym[4] = 0x12                              // STT_FUNC | STB_GLOBAL
-	binary.LittleEndian.PutUint16(progSym[6:8], 1) // st_shndx = 1 (.text)
-
-	off += uint64(len(nullSym) + len(progSym))
-	shstrtabOff := off
-	off += uint64(len(shstrtab))
-	shOff := off
-
-	makeSH := func(nameOff int, shType uint32, flags uint64, shOffset, size uint64, link, info uint32, entsize uint64) []byte {
-		sh := make([]byte, 64)
-		binary.LittleEndian.PutUint32(sh[0:4], uint32(nameOff))
-		binary.LittleEndian.PutUint32(sh[4:8], shType)
-		binary.LittleEndian.PutUint64(sh[8:16], flags)
-		binary.LittleEndian.PutUint64(sh[24:32], shOffset)
-		binary.LittleEndian.PutUint64(sh[32:40], size)
-		binary.LittleEndian.PutUint32(sh[40:44], link)
-		binary.LittleEndian.PutUint32(sh[44:48], info)
-		binary.LittleEndian.PutUint64(sh[48:56], 8)
-		binary.LittleEndian.PutUint64(sh[56:64], entsize)
-		return sh
-	}
-
-	var sections []byte
-	sections = append(sections, makeSH(0, 0, 0, 0, 0, 0, 0, 0)...)
-	sections = append(sections, makeSH(1, 1, 6, codeOff, uint64(len(code)), 0, 0, 0)...)
-	sections = append(sections, makeSH(7, 3, 0, symstrtabOff, uint64(len(symstrtab)), 0, 0, 0)...)
-	sections = append(sections, makeSH(15, 2, 0, symtabOff, uint64(len(nullSym)+len(progSym)), 2, 1, 24)...)
-	sections = append(sections, makeSH(23, 3, 0, shstrtabOff, uint64(len(shstrtab)), 0, 0, 0)...)
-
-	hdr := make([]byte, 64)
-	copy(hdr[0:4], []byte{0x7f, 'E', 'L', 'F'})
-	hdr[4] = 2
-	hdr[5] = 1
-	hdr[6] = 1
-	binary.LittleEndian.PutUint16(hdr[16:18], 1)
-	binary.LittleEndian.PutUint16(hdr[18:20], 0xF7)
-	binary.LittleEndian.PutUint32(hdr[20:24], 1)
-	binary.LittleEndian.PutUint16(hdr[52:54], 64)
-	binary.LittleEndian.PutUint16(hdr[58:60], 64)
-	binary.LittleEndian.PutUint64(hdr[40:48], shOff)
-	binary.LittleEndian.PutUint16(hdr[60:62], 5)
-	binary.LittleEndian.PutUint16(hdr[62:64], 4)
-
-	var out []byte
-	out = append(out, hdr...)
-	out = append(out, code...)
-	out = append(out, []byte(symstrtab)...)
-	out = append(out, nullSym...)
-	out = append(out, progSym...)
-	out = append(out, []byte(shstrtab)...)
-	out = append(out, sections...)
-
 	p := filepath.Join(t.TempDir(), "test.bpf.o")
-	if err := os.WriteFile(p, out, 0o644); err != nil {
+	if err := os.WriteFile(p, testutil.BPFObject(), 0o644); err != nil {
 		t.Fatal(err)
 	}
 	return p
@@ -112,16 +38,45 @@ func TestRunGenerate(t *testing.T) {
 			name:     "missing argument",
 			setup:    func(t *testing.T) []string { t.Helper(); return []string{"generate"} },
 			wantCode: 2,
-			wantErr:  "exactly one BPF object argument is required",
+			wantErr:  "a BPF object argument is required",
 		},
 		{
-			name: "too many arguments",
+			name: "variant outside output directory",
 			setup: func(t *testing.T) []string {
 				t.Helper()
-				return []string{"generate", "a.o", "b.o"}
+				outPath := filepath.Join(t.TempDir(), "probe_bpf.go")
+				return []string{"generate", "--output", outPath, bpfELFWithProgram(t), "/no/such/probe.v2.bpf.o"}
+			},
+			wantCode: 1,
+			wantErr:  "must be in the output directory or below to embed variants",
+		},
+		{
+			name: "variants with bpf2go",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				return []string{"generate", "--style", "bpf2go", "--ident", "probe", "a.o", "b.o"}
 			},
 			wantCode: 2,
-			wantErr:  "exactly one BPF object argument is required",
+			wantErr:  "variant objects are not supported",
+		},
+		{
+			name: "variants generate output",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				dir := t.TempDir()
+				var objects []string
+				for _, name := range []string{"probe.v3.bpf.o", "probe.v2.bpf.o"} {
+					p := filepath.Join(dir, name)
+					if err := os.WriteFile(p, testutil.BPFObject(), 0o600); err != nil {
+						t.Fatal(err)
+					}
+					objects = append(objects, p)
+				}
+				args := []string{"generate", "--output", filepath.Join(dir, "probe_bpf.go"), "--package", "testpkg"}
+				return append(args, objects...)
+			},
+			wantCode: 0,
+			wantOut:  "wrote",
 		},
 		{
 			name: "non-existent file",
@@ -155,6 +110,124 @@ func TestRunGenerate(t *testing.T) {
 			wantCode: 0,
 			wantOut:  "wrote",
 		},
+		{
+			name: "--type without BTF",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				elfPath := bpfELFWithProgram(t)
+				outPath := filepath.Join(t.TempDir(), "probe_bpf.go")
+				return []string{"generate", "--output", outPath, "--type", "conn_event", elfPath}
+			},
+			wantCode: 1,
+			wantErr:  "object has no BTF",
+		},
+		{
+			name: "malformed --event",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				elfPath := bpfELFWithProgram(t)
+				outPath := filepath.Join(t.TempDir(), "probe_bpf.go")
+				return []string{"generate", "--output", outPath, "--event", "events", elfPath}
+			},
+			wantCode: 1,
+			wantErr:  "expected format map=type",
+		},
+		{
+			name: "--event for unknown map",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				elfPath := bpfELFWithProgram(t)
+				outPath := filepath.Join(t.TempDir(), "probe_bpf.go")
+				return []string{"generate", "--output", outPath, "--event", "events=main_connEvent", elfPath}
+			},
+			wantCode: 1,
+			wantErr:  `event type for "events": no such map`,
+		},
+		{
+			name: "config generate.events applied",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				elfPath := bpfELFWithProgram(t)
+				dir := t.TempDir()
+				cfgPath := filepath.Join(dir, "tinybpf.json")
+				os.WriteFile(cfgPath, []byte(`{"generate":{"events":{"events":"main_connEvent"}}}`), 0o644)
+				outPath := filepath.Join(dir, "probe_bpf.go")
+				return []string{"generate", "--config", cfgPath, "--output", outPath, elfPath}
+			},
+			wantCode: 1,
+			wantErr:  `event type for "events": no such map`,
+		},
+		{
+			name: "relative --pin-path",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				return []string{"generate", "--pin-path", "bpf/probe", "probe.bpf.o"}
+			},
+			wantCode: 2,
+			wantErr:  "--pin-path must be an absolute path",
+		},
+		{
+			name: "config build.pin_path validated",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				elfPath := bpfELFWithProgram(t)
+				dir := t.TempDir()
+				cfgPath := filepath.Join(dir, "tinybpf.json")
+				os.WriteFile(cfgPath, []byte(`{"build":{"pin_path":"bpf/probe"}}`), 0o644)
+				return []string{"generate", "--config", cfgPath, "--output", filepath.Join(dir, "probe_bpf.go"), elfPath}
+			},
+			wantCode: 1,
+			wantErr:  "must be an absolute path",
+		},
+		{
+			name: "--with-fakes without typed maps",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				elfPath := bpfELFWithProgram(t)
+				outPath := filepath.Join(t.TempDir(), "probe_bpf.go")
+				return []string{"generate", "--output", outPath, "--package", "loader", "--with-fakes", elfPath}
+			},
+			wantCode: 1,
+			wantErr:  "no hash, array, ring buffer or perf event array maps to fake",
+		},
+		{
+			name: "unknown --style",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				return []string{"generate", "--style", "libbpf", "probe.bpf.o"}
+			},
+			wantCode: 2,
+			wantErr:  `unknown --style "libbpf"`,
+		},
+		{
+			name: "--style=bpf2go without --ident",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				return []string{"generate", "--style", "bpf2go", "probe.bpf.o"}
+			},
+			wantCode: 2,
+			wantErr:  "--style=bpf2go requires --ident",
+		},
+		{
+			name: "--ident without --style=bpf2go",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				return []string{"generate", "--ident", "probe", "probe.bpf.o"}
+			},
+			wantCode: 2,
+			wantErr:  "--ident requires --style=bpf2go",
+		},
+		{
+			name: "--style=bpf2go with an object outside the output directory",
+			setup: func(t *testing.T) []string {
+				t.Helper()
+				elfPath := bpfELFWithProgram(t)
+				outPath := filepath.Join(t.TempDir(), "probe_bpf.go")
+				return []string{"generate", "--output", outPath, "--package", "loader", "--style", "bpf2go", "--ident", "probe", elfPath}
+			},
+			wantCode: 1,
+			wantErr:  "bpf2go style requires an embedded object",
+		},
 		{
 			name: "default output name from object path",
 			setup: func(t *testing.T) []string {
@@ -189,6 +262,8 @@ func TestRunGenerateOutputContent(t *testing.T) {
 	tests := []struct {
 		name     string
 		pkg      string
+		args     []string
+		embed    bool // write the output next to the object
 		contains []string
 	}{
 		{
@@ -204,14 +279,41 @@ func TestRunGenerateOutputContent(t *testing.T) {
 				"func (o *Objects) Close()",
 			},
 		},
+		{
+			name:  "bpf2go style",
+			pkg:   "loader",
+			args:  []string{"--style", "bpf2go", "--ident", "probe"},
+			embed: true,
+			contains: []string{
+				"func loadProbe() (*ebpf.CollectionSpec, error)",
+				"func loadProbeObjects(obj interface{}, opts *ebpf.CollectionOptions) error",
+				"type probeObjects struct",
+				"TestProg *ebpf.Program `ebpf:\"test_prog\"`",
+				"//go:embed test.bpf.o",
+				"var _ProbeBytes []byte",
+			},
+		},
+		{
+			name: "pin path emitted as DefaultPinPath",
+			pkg:  "loader",
+			args: []string{"--pin-path", "/sys/fs/bpf/probe"},
+			contains: []string{
+				`const DefaultPinPath = "/sys/fs/bpf/probe"`,
+				"func LoadPinned(objectPath, pinPath string) (*Objects, error)",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			elfPath := bpfELFWithProgram(t)
 			outDir := t.TempDir()
+			if tt.embed {
+				outDir = filepath.Dir(elfPath)
+			}
 			outPath := filepath.Join(outDir, "objects_bpf.go")
 
-			_, _, code := runCLI(t, "generate", "--output", outPath, "--package", tt.pkg, elfPath)
+			args := append([]string{"generate", "--output", outPath, "--package", tt.pkg}, tt.args...)
+			_, _, code := runCLI(t, append(args, elfPath)...)
 			if code != 0 {
 				t.Fatalf("expected exit code 0, got %d", code)
 			}
@@ -231,6 +333,52 @@ func TestRunGenerateOutputContent(t *testing.T) {
 	}
 }
 
+func TestRunGenerateCheck(t *testing.T) {
+	elfPath := bpfELFWithProgram(t)
+	outPath := filepath.Join(t.TempDir(), "probe_bpf.go")
+	args := []string{"generate", "--output", outPath, "--package", "loader"}
+
+	stdout, stderr, code := runCLI(t, append(args, "--check", elfPath)...)
+	if code != 1 || !strings.Contains(stdout, "does not exist") || !strings.Contains(stdout, "+// Code generated by tinybpf; DO NOT EDIT.") {
+		t.Fatalf("missing file: code=%d\nstdout=%s\nstderr=%s", code, stdout, stderr)
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Fatalf("--check wrote %s", outPath)
+	}
+
+	if _, _, code := runCLI(t, append(args, elfPath)...); code != 0 {
+		t.Fatalf("generate: exit code %d", code)
+	}
+	stdout, _, code = runCLI(t, append(args, "--check", elfPath)...)
+	if code != 0 || !strings.Contains(stdout, "is up to date") {
+		t.Fatalf("fresh file: code=%d\nstdout=%s", code, stdout)
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := strings.Replace(string(data), "TestProg", "OldProg", 1)
+	if err := os.WriteFile(outPath, []byte(stale), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code = runCLI(t, append(args, "--check", elfPath)...)
+	if code != 1 {
+		t.Fatalf("stale file: exit code %d, want 1", code)
+	}
+	for _, want := range []string{"--- " + outPath, "+++ " + outPath + " (generated)", "@@ ", "-\tOldProg", "+\tTestProg"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("diff missing %q:\n%s", want, stdout)
+		}
+	}
+	if !strings.Contains(stderr, "out of date") {
+		t.Errorf("expected out of date error, got: %s", stderr)
+	}
+	if data, _ := os.ReadFile(outPath); string(data) != stale {
+		t.Error("--check modified the output file")
+	}
+}
+
 func TestComputeEmbedPath(t *testing.T) {
 	tests := []struct {
 		name       string
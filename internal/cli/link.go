@@ -59,6 +59,10 @@ func runLinkWith(ctx context.Context, args []string, stdout, stderr io.Writer, w
 		req.Sections = sections
 	}
 
+	if err := resolveVariants(&req, nil); err != nil {
+		return cliErrorf(stderr, "%v", err)
+	}
+
 	if profilePath != "" {
 		cleanup, err := startProfiling(profilePath, stderr, writeHeap)
 		if err != nil {
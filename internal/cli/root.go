@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -62,6 +63,8 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 		return runVerify(ctx, args[1:], stdout, stderr)
 	case "generate":
 		return runGenerate(ctx, args[1:], stdout, stderr)
+	case "format":
+		return runFormat(ctx, args[1:], stdout, stderr)
 	case "clean-cache":
 		return runCleanCache(stdout, stderr)
 	case "version", "--version", "-version":
@@ -83,6 +86,7 @@ Usage:
   tinybpf init <name>               Scaffold a new BPF project
   tinybpf verify --input <file>     Validate a BPF ELF object
   tinybpf generate <object.bpf.o>  Generate Go loader from BPF ELF
+  tinybpf format --object <file> --type <name>   Render raw bytes using BTF
   tinybpf clean-cache               Remove cached build artifacts
   tinybpf doctor [flags]            Check toolchain installation
   tinybpf version                   Print version information
@@ -144,7 +148,7 @@ func registerBuildFlags(fs *flag.FlagSet, req *tinybpf.Request, programs, sectio
 	fs.StringVar(configPath, "config", "", "Path to tinybpf.json project config (default: auto-discovered).")
 	fs.StringVar(&req.Output, "output", "bpf.o", "Output eBPF ELF object path.")
 	fs.StringVar(&req.Output, "o", "bpf.o", "Output eBPF ELF object path (shorthand).")
-	fs.StringVar(&req.CPU, "cpu", "v3", "BPF CPU version passed to llc as -mcpu.")
+	fs.StringVar(&req.CPU, "cpu", "v3", "BPF CPU version passed to llc as -mcpu. A comma-separated list (e.g. v2,v3,v4) builds one variant per version.")
 	fs.BoolVar(&req.KeepTemp, "keep-temp", false, "Keep temporary intermediate files after run.")
 	fs.BoolVar(&req.Verbose, "verbose", false, "Enable verbose stage logging.")
 	fs.BoolVar(&req.Verbose, "v", false, "Enable verbose stage logging (shorthand).")
@@ -158,6 +162,8 @@ func registerBuildFlags(fs *flag.FlagSet, req *tinybpf.Request, programs, sectio
 	fs.StringVar(&req.ProgramType, "program-type", "", "BPF program type (e.g. kprobe, xdp, tracepoint). Auto-inferred from --section values when omitted.")
 	fs.Var(programs, "program", "Program function name to keep. Repeat for multiple programs. Auto-detected if omitted.")
 	fs.Var(sections, "section", "Program-to-section mapping (e.g., handle_connect=tracepoint/syscalls/sys_enter_connect). Repeat for multiple.")
+	fs.Var((*multiStringFlag)(&req.GlobalFuncs), "global-func", "Go function to keep as a global BPF subprogram (e.g., main.parseIPv4), like //bpf:global. Repeat for multiple.")
+	fs.Var((*multiStringFlag)(&req.GlobalVars), "global-var", "Go package variable to expose to loaders as a Config or Globals field (e.g., main.targetPID), like //bpf:global. Repeat for multiple.")
 	registerToolFlags(fs, &req.Toolchain)
 }
 
@@ -284,7 +290,88 @@ func runBuildAndReport(ctx context.Context, req tinybpf.Request, stdout, stderr
 	if req.Verbose || req.KeepTemp || req.TempDir != "" {
 		fmt.Fprintf(stdout, "intermediates: %s\n", result.TempDir)
 	}
-	fmt.Fprintf(stdout, "wrote %s\n", result.Output)
+	if len(result.Variants) == 0 {
+		fmt.Fprintf(stdout, "wrote %s\n", result.Output)
+	}
+	for _, v := range result.Variants {
+		fmt.Fprintf(stdout, "wrote %s (variant %s)\n", v.Output, v.Name)
+	}
+	return 0
+}
+
+// resolveVariants sets req.Variants from a comma-separated --cpu list and
+// --variant name=tag,... flags. Tag sets come first in flag order, each
+// built for every CPU version from newest to oldest. A list may only name
+// the versions v1 to v4, which have a preference order. A single CPU and no
+// --variant flags leave req without variants.
+func resolveVariants(req *tinybpf.Request, variantFlags []string) error {
+	var cpus []string
+	for _, cpu := range strings.Split(req.CPU, ",") {
+		if cpu = strings.TrimSpace(cpu); cpu != "" {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return fmt.Errorf("invalid --cpu %q: expected a version such as v3", req.CPU)
+	}
+	if len(cpus) > 1 {
+		for _, cpu := range cpus {
+			if cpuVersion(cpu) == 0 {
+				return fmt.Errorf("invalid --cpu %q: %q cannot be ranked in a list; use versions v1 to v4", req.CPU, cpu)
+			}
+		}
+		slices.SortStableFunc(cpus, func(a, b string) int { return cpuVersion(b) - cpuVersion(a) })
+	}
+	req.CPU = cpus[0]
+
+	type tagSet struct {
+		name string
+		tags []string
+	}
+	var sets []tagSet
+	for _, f := range variantFlags {
+		name, tags, _ := strings.Cut(f, "=")
+		set := tagSet{name: strings.TrimSpace(name)}
+		if set.name == "" {
+			return fmt.Errorf("invalid --variant %q: expected format name=tag,tag", f)
+		}
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				set.tags = append(set.tags, tag)
+			}
+		}
+		sets = append(sets, set)
+	}
+	if len(sets) == 0 {
+		if len(cpus) == 1 {
+			return nil
+		}
+		sets = []tagSet{{}}
+	}
+
+	req.Variants = nil
+	for _, set := range sets {
+		for _, cpu := range cpus {
+			name := set.name
+			switch {
+			case name == "":
+				name = cpu
+			case len(cpus) > 1:
+				name += "-" + cpu
+			}
+			req.Variants = append(req.Variants, tinybpf.Variant{Name: name, CPU: cpu, Tags: set.tags})
+		}
+	}
+	return nil
+}
+
+// cpuVersion returns the instruction set version of an llc -mcpu value v1
+// to v4, or 0 for any other value.
+func cpuVersion(cpu string) int {
+	switch cpu {
+	case "v1", "v2", "v3", "v4":
+		return int(cpu[1] - '0')
+	}
 	return 0
 }
 
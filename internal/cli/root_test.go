@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"flag"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
@@ -425,6 +426,97 @@ func TestParseSectionFlags(t *testing.T) {
 	}
 }
 
+func TestResolveVariants(t *testing.T) {
+	tests := []struct {
+		name    string
+		cpu     string
+		flags   []string
+		wantCPU string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "single cpu",
+			cpu:     "v3",
+			wantCPU: "v3",
+		},
+		{
+			name:    "cpu list newest first",
+			cpu:     "v2, v4,v3",
+			wantCPU: "v4",
+			want:    []string{"v4 cpu=v4 tags=[]", "v3 cpu=v3 tags=[]", "v2 cpu=v2 tags=[]"},
+		},
+		{
+			name:    "tag sets",
+			cpu:     "v3",
+			flags:   []string{"kfuncs=kfuncs, ringbuf", "base"},
+			wantCPU: "v3",
+			want:    []string{"kfuncs cpu=v3 tags=[kfuncs ringbuf]", "base cpu=v3 tags=[]"},
+		},
+		{
+			name:    "tag sets and cpu list",
+			cpu:     "v2,v3",
+			flags:   []string{"kfuncs=kfuncs", "base="},
+			wantCPU: "v3",
+			want: []string{
+				"kfuncs-v3 cpu=v3 tags=[kfuncs]", "kfuncs-v2 cpu=v2 tags=[kfuncs]",
+				"base-v3 cpu=v3 tags=[]", "base-v2 cpu=v2 tags=[]",
+			},
+		},
+		{
+			name:    "cpu version out of range",
+			cpu:     "v10,v1, v4",
+			wantErr: `"v10" cannot be ranked`,
+		},
+		{
+			name:    "unknown cpu in list",
+			cpu:     "probe,v2",
+			wantErr: `"probe" cannot be ranked`,
+		},
+		{
+			name:    "single cpu is not ranked",
+			cpu:     "probe",
+			wantCPU: "probe",
+		},
+		{
+			name:    "empty cpu list",
+			cpu:     " , ",
+			wantErr: "invalid --cpu",
+		},
+		{
+			name:    "empty variant name",
+			cpu:     "v3",
+			flags:   []string{"=kfuncs"},
+			wantErr: "invalid --variant",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tinybpf.Request{CPU: tt.cpu}
+			err := resolveVariants(&req, tt.flags)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected %q in error, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.CPU != tt.wantCPU {
+				t.Errorf("CPU = %q, want %q", req.CPU, tt.wantCPU)
+			}
+			var got []string
+			for _, v := range req.Variants {
+				got = append(got, fmt.Sprintf("%s cpu=%s tags=%v", v.Name, v.CPU, v.Tags))
+			}
+			if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("variants = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestUsageErrorf(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -0,0 +1,241 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// attachPoint describes how a program is attached, derived from its ELF section.
+type attachPoint struct {
+	desc   string // attach target, for generated comments
+	option string // required AttachOptions field, if any
+	expr   string // Go expression returning (link.Link, error); %[1]s is the program
+
+	// unsupported, if set, explains the error the attach returns when the
+	// kernel lacks the link type.
+	unsupported string
+}
+
+// cgroupAttachTypes maps cgroup/ section suffixes to cilium/ebpf attach types.
+var cgroupAttachTypes = map[string]string{
+	"connect4":     "AttachCGroupInet4Connect",
+	"connect6":     "AttachCGroupInet6Connect",
+	"bind4":        "AttachCGroupInet4Bind",
+	"bind6":        "AttachCGroupInet6Bind",
+	"post_bind4":   "AttachCGroupInet4PostBind",
+	"post_bind6":   "AttachCGroupInet6PostBind",
+	"sendmsg4":     "AttachCGroupUDP4Sendmsg",
+	"sendmsg6":     "AttachCGroupUDP6Sendmsg",
+	"recvmsg4":     "AttachCGroupUDP4Recvmsg",
+	"recvmsg6":     "AttachCGroupUDP6Recvmsg",
+	"getpeername4": "AttachCgroupInet4GetPeername",
+	"getpeername6": "AttachCgroupInet6GetPeername",
+	"getsockname4": "AttachCgroupInet4GetSockname",
+	"getsockname6": "AttachCgroupInet6GetSockname",
+	"sysctl":       "AttachCGroupSysctl",
+	"getsockopt":   "AttachCGroupGetsockopt",
+	"setsockopt":   "AttachCGroupSetsockopt",
+	"dev":          "AttachCGroupDevice",
+	"sock":         "AttachCGroupInetSockCreate",
+	"sock_create":  "AttachCGroupInetSockCreate",
+	"sock_release": "AttachCgroupInetSockRelease",
+	"skb":          "AttachCGroupInetIngress",
+}
+
+// attachPointFor returns the attach point for a program section, or false
+// when the section does not identify one (e.g. uprobes, socket filters).
+func attachPointFor(section string) (attachPoint, bool) {
+	kind, target, _ := strings.Cut(section, "/")
+	switch kind {
+	case "kprobe", "kretprobe":
+		if target == "" {
+			return attachPoint{}, false
+		}
+		fn := "Kprobe"
+		if kind == "kretprobe" {
+			fn = "Kretprobe"
+		}
+		return attachPoint{
+			desc: kind + " " + target,
+			expr: fmt.Sprintf("link.%s(%q, %%[1]s, nil)", fn, target),
+		}, true
+	case "tracepoint", "tp":
+		group, name, ok := strings.Cut(target, "/")
+		if !ok || group == "" || name == "" {
+			return attachPoint{}, false
+		}
+		return attachPoint{
+			desc: "tracepoint " + target,
+			expr: fmt.Sprintf("link.Tracepoint(%q, %q, %%[1]s, nil)", group, name),
+		}, true
+	case "raw_tracepoint", "raw_tp":
+		if target == "" {
+			return attachPoint{}, false
+		}
+		return attachPoint{
+			desc: "raw tracepoint " + target,
+			expr: fmt.Sprintf("link.AttachRawTracepoint(link.RawTracepointOptions{Name: %q, Program: %%[1]s})", target),
+		}, true
+	case "fentry", "fexit", "fmod_ret", "tp_btf":
+		return attachPoint{
+			desc: section,
+			expr: "link.AttachTracing(link.TracingOptions{Program: %[1]s})",
+		}, true
+	case "lsm", "lsm.s":
+		return attachPoint{
+			desc: section,
+			expr: "link.AttachLSM(link.LSMOptions{Program: %[1]s})",
+		}, true
+	case "xdp":
+		return attachPoint{
+			desc:   "the XDP hook of opts.Interface",
+			option: "Interface",
+			expr:   "link.AttachXDP(link.XDPOptions{Program: %[1]s, Interface: opts.Interface, Flags: opts.XDPFlags})",
+		}, true
+	case "tc", "tcx", "classifier":
+		dir := "Ingress"
+		if strings.HasSuffix(target, "egress") {
+			dir = "Egress"
+		}
+		return attachPoint{
+			desc:   "the TCX " + strings.ToLower(dir) + " hook of opts.Interface",
+			option: "Interface",
+			expr:   fmt.Sprintf("link.AttachTCX(link.TCXOptions{Interface: opts.Interface, Program: %%[1]s, Attach: ebpf.AttachTCX%s})", dir),
+			unsupported: "TCX links need Linux 6.6 or later; on older kernels, pin the program and attach it with " +
+				"a clsact qdisc and a direct-action tc filter",
+		}, true
+	case "cgroup", "cgroup_skb", "sockops":
+		attach, ok := cgroupAttachType(kind, target)
+		if !ok {
+			return attachPoint{}, false
+		}
+		return attachPoint{
+			desc:   section + " on opts.CgroupPath",
+			option: "CgroupPath",
+			expr:   fmt.Sprintf("link.AttachCgroup(link.CgroupOptions{Path: opts.CgroupPath, Attach: ebpf.%s, Program: %%[1]s})", attach),
+		}, true
+	}
+	return attachPoint{}, false
+}
+
+// cgroupAttachType returns the attach type constant for a cgroup section.
+func cgroupAttachType(kind, target string) (string, bool) {
+	switch kind {
+	case "sockops":
+		return "AttachCGroupSockOps", true
+	case "cgroup_skb":
+		if target == "egress" {
+			return "AttachCGroupInetEgress", true
+		}
+		return "AttachCGroupInetIngress", target == "ingress"
+	}
+	if target == "skb/egress" {
+		return "AttachCGroupInetEgress", true
+	}
+	if target == "skb/ingress" {
+		target = "skb"
+	}
+	attach, ok := cgroupAttachTypes[target]
+	return attach, ok
+}
+
+// attachTarget pairs a program with its attach point.
+type attachTarget struct {
+	symbol  string
+	field   string
+	section string
+	point   attachPoint
+}
+
+// attachTargets returns the programs whose sections identify an attach point.
+func attachTargets(info *ELFInfo) []attachTarget {
+	var targets []attachTarget
+	for _, name := range info.Programs {
+		section := info.Sections[name]
+		point, ok := attachPointFor(section)
+		if !ok {
+			continue
+		}
+		targets = append(targets, attachTarget{symbol: name, field: exportedName(name), section: section, point: point})
+	}
+	return targets
+}
+
+func writeAttachOptions(b *strings.Builder) {
+	fmt.Fprintf(b, "// AttachOptions supplies the attach targets that program sections do not encode.\n")
+	fmt.Fprintf(b, "type AttachOptions struct {\n")
+	fmt.Fprintf(b, "\t// Interface is the network interface index for XDP and TC programs.\n")
+	fmt.Fprintf(b, "\tInterface int\n")
+	fmt.Fprintf(b, "\t// XDPFlags selects the XDP attach mode (optional).\n")
+	fmt.Fprintf(b, "\tXDPFlags link.XDPAttachFlags\n")
+	fmt.Fprintf(b, "\t// CgroupPath is the cgroup v2 directory for cgroup programs.\n")
+	fmt.Fprintf(b, "\tCgroupPath string\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeLinksStruct(b *strings.Builder, targets []attachTarget) {
+	fmt.Fprintf(b, "// Links holds the links created by AttachAll.\n")
+	fmt.Fprintf(b, "type Links struct {\n")
+	for _, t := range targets {
+		fmt.Fprintf(b, "\t%s link.Link\n", t.field)
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Close detaches all links.\n")
+	fmt.Fprintf(b, "func (l *Links) Close() error {\n")
+	fmt.Fprintf(b, "\tif l == nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tvar errs []error\n")
+	for _, t := range targets {
+		fmt.Fprintf(b, "\tif l.%s != nil {\n", t.field)
+		fmt.Fprintf(b, "\t\terrs = append(errs, l.%s.Close())\n", t.field)
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn errors.Join(errs...)\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeAttachMethods(b *strings.Builder, targets []attachTarget) {
+	for _, t := range targets {
+		fmt.Fprintf(b, "// Attach%s attaches %s to %s.\n", t.field, t.symbol, t.point.desc)
+		fmt.Fprintf(b, "func (p *Programs) Attach%s(opts AttachOptions) (link.Link, error) {\n", t.field)
+		switch t.point.option {
+		case "Interface":
+			fmt.Fprintf(b, "\tif opts.Interface == 0 {\n")
+		case "CgroupPath":
+			fmt.Fprintf(b, "\tif opts.CgroupPath == \"\" {\n")
+		}
+		if t.point.option != "" {
+			fmt.Fprintf(b, "\t\treturn nil, errors.New(\"attach %s: AttachOptions.%s is required\")\n", t.symbol, t.point.option)
+			fmt.Fprintf(b, "\t}\n")
+		}
+		fmt.Fprintf(b, "\tl, err := "+t.point.expr+"\n", "p."+t.field)
+		if t.point.unsupported != "" {
+			fmt.Fprintf(b, "\tif errors.Is(err, ebpf.ErrNotSupported) {\n")
+			fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"attach %s (%s): %s: %%w\", err)\n", t.symbol, t.section, t.point.unsupported)
+			fmt.Fprintf(b, "\t}\n")
+		}
+		fmt.Fprintf(b, "\tif err != nil {\n")
+		fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"attach %s (%s): %%w\", err)\n", t.symbol, t.section)
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\treturn l, nil\n")
+		fmt.Fprintf(b, "}\n\n")
+	}
+
+	fmt.Fprintf(b, "// AttachAll attaches every program whose section names an attach point. On\n")
+	fmt.Fprintf(b, "// error, the links created so far are closed.\n")
+	fmt.Fprintf(b, "func (p *Programs) AttachAll(opts AttachOptions) (*Links, error) {\n")
+	fmt.Fprintf(b, "\tvar (\n")
+	fmt.Fprintf(b, "\t\tl   Links\n")
+	fmt.Fprintf(b, "\t\terr error\n")
+	fmt.Fprintf(b, "\t)\n")
+	for _, t := range targets {
+		fmt.Fprintf(b, "\tif l.%[1]s, err = p.Attach%[1]s(opts); err != nil {\n", t.field)
+		fmt.Fprintf(b, "\t\t_ = l.Close()\n")
+		fmt.Fprintf(b, "\t\treturn nil, err\n")
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn &l, nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
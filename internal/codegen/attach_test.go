@@ -0,0 +1,153 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestAttachPointFor(t *testing.T) {
+	tests := []struct {
+		section    string
+		wantOK     bool
+		wantExpr   string
+		wantOption string
+	}{
+		{"kprobe/do_sys_openat2", true, `link.Kprobe("do_sys_openat2", %[1]s, nil)`, ""},
+		{"kretprobe/do_sys_openat2", true, `link.Kretprobe("do_sys_openat2", %[1]s, nil)`, ""},
+		{"tracepoint/syscalls/sys_enter_connect", true, `link.Tracepoint("syscalls", "sys_enter_connect", %[1]s, nil)`, ""},
+		{"tp/raw_syscalls/sys_enter", true, `link.Tracepoint("raw_syscalls", "sys_enter", %[1]s, nil)`, ""},
+		{"raw_tracepoint/sched_process_exec", true, `link.AttachRawTracepoint(link.RawTracepointOptions{Name: "sched_process_exec", Program: %[1]s})`, ""},
+		{"fentry/do_sys_openat2", true, "link.AttachTracing(link.TracingOptions{Program: %[1]s})", ""},
+		{"tp_btf/sched_switch", true, "link.AttachTracing(link.TracingOptions{Program: %[1]s})", ""},
+		{"lsm/file_open", true, "link.AttachLSM(link.LSMOptions{Program: %[1]s})", ""},
+		{"xdp", true, "link.AttachXDP(link.XDPOptions{Program: %[1]s, Interface: opts.Interface, Flags: opts.XDPFlags})", "Interface"},
+		{"tc/ingress", true, "link.AttachTCX(link.TCXOptions{Interface: opts.Interface, Program: %[1]s, Attach: ebpf.AttachTCXIngress})", "Interface"},
+		{"tcx/egress", true, "link.AttachTCX(link.TCXOptions{Interface: opts.Interface, Program: %[1]s, Attach: ebpf.AttachTCXEgress})", "Interface"},
+		{"cgroup/connect4", true, "link.AttachCgroup(link.CgroupOptions{Path: opts.CgroupPath, Attach: ebpf.AttachCGroupInet4Connect, Program: %[1]s})", "CgroupPath"},
+		{"cgroup/skb/egress", true, "link.AttachCgroup(link.CgroupOptions{Path: opts.CgroupPath, Attach: ebpf.AttachCGroupInetEgress, Program: %[1]s})", "CgroupPath"},
+		{"cgroup_skb/ingress", true, "link.AttachCgroup(link.CgroupOptions{Path: opts.CgroupPath, Attach: ebpf.AttachCGroupInetIngress, Program: %[1]s})", "CgroupPath"},
+		{"sockops", true, "link.AttachCgroup(link.CgroupOptions{Path: opts.CgroupPath, Attach: ebpf.AttachCGroupSockOps, Program: %[1]s})", "CgroupPath"},
+		{"tracepoint/syscalls", false, "", ""},
+		{"kprobe/", false, "", ""},
+		{"cgroup/unknown", false, "", ""},
+		{"cgroup_skb/sideways", false, "", ""},
+		{"uprobe/libc.so:malloc", false, "", ""},
+		{"socket", false, "", ""},
+		{".text", false, "", ""},
+		{"", false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.section, func(t *testing.T) {
+			point, ok := attachPointFor(tt.section)
+			if ok != tt.wantOK {
+				t.Fatalf("attachPointFor(%q) ok = %v, want %v", tt.section, ok, tt.wantOK)
+			}
+			if point.expr != tt.wantExpr {
+				t.Errorf("expr = %q, want %q", point.expr, tt.wantExpr)
+			}
+			if point.option != tt.wantOption {
+				t.Errorf("option = %q, want %q", point.option, tt.wantOption)
+			}
+		})
+	}
+}
+
+func TestGenerateAttachHelpers(t *testing.T) {
+	info := &ELFInfo{
+		Programs: []string{"check_connect4", "tc_ingress", "handle_connect", "helper", "xdp_filter"},
+		Sections: map[string]string{
+			"check_connect4": "cgroup/connect4",
+			"tc_ingress":     "tc/ingress",
+			"handle_connect": "tracepoint/syscalls/sys_enter_connect",
+			"helper":         ".text",
+			"xdp_filter":     "xdp",
+		},
+	}
+	src, err := Generate("loader", info, "")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	text := string(src)
+	for _, s := range []string{
+		`"github.com/cilium/ebpf/link"`,
+		"type AttachOptions struct",
+		"CgroupPath string",
+		"type Links struct",
+		"HandleConnect link.Link",
+		"func (l *Links) Close() error",
+		"errors.Join(errs...)",
+		"// AttachHandleConnect attaches handle_connect to tracepoint syscalls/sys_enter_connect.",
+		"func (p *Programs) AttachHandleConnect(opts AttachOptions) (link.Link, error)",
+		`link.Tracepoint("syscalls", "sys_enter_connect", p.HandleConnect, nil)`,
+		`fmt.Errorf("attach handle_connect (tracepoint/syscalls/sys_enter_connect): %w", err)`,
+		`errors.New("attach xdp_filter: AttachOptions.Interface is required")`,
+		`errors.New("attach check_connect4: AttachOptions.CgroupPath is required")`,
+		"Attach: ebpf.AttachCGroupInet4Connect",
+		"func (p *Programs) AttachAll(opts AttachOptions) (*Links, error)",
+		"if l.XdpFilter, err = p.AttachXdpFilter(opts); err != nil {",
+		"if errors.Is(err, ebpf.ErrNotSupported) {\n\t\treturn nil, fmt.Errorf(\"attach tc_ingress (tc/ingress): TCX links need Linux 6.6 or later;",
+	} {
+		if !strings.Contains(text, s) {
+			t.Errorf("generated source missing %q\n%s", s, text)
+		}
+	}
+	for _, s := range []string{"AttachHelper", "Helper link.Link"} {
+		if strings.Contains(text, s) {
+			t.Errorf("generated source should not contain %q", s)
+		}
+	}
+	if n := strings.Count(text, "ebpf.ErrNotSupported"); n != 1 {
+		t.Errorf("%d attach methods explain ErrNotSupported, want only the TCX one", n)
+	}
+}
+
+func TestGenerateWithoutAttachPoints(t *testing.T) {
+	info := &ELFInfo{
+		Programs: []string{"handler"},
+		Sections: map[string]string{"handler": ".text"},
+	}
+	src, err := Generate("loader", info, "")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, s := range []string{"cilium/ebpf/link", "AttachOptions", "AttachAll", "type Links"} {
+		if strings.Contains(string(src), s) {
+			t.Errorf("generated source should not contain %q", s)
+		}
+	}
+}
+
+func TestAttachNameCollision(t *testing.T) {
+	tests := []struct {
+		name     string
+		programs []string
+		sections map[string]string
+		types    []string
+	}{
+		{
+			name:     "program named all",
+			programs: []string{"all"},
+			sections: map[string]string{"all": "xdp"},
+		},
+		{
+			name:     "BTF type named links",
+			programs: []string{"handler"},
+			sections: map[string]string{"handler": "lsm/file_open"},
+			types:    []string{"links"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ELFInfo{Programs: tt.programs, Sections: tt.sections}
+			for _, name := range tt.types {
+				info.Types = addType(info.Types, &btf.Struct{Name: name})
+			}
+			_, err := Generate("loader", info, "")
+			if err == nil || !strings.Contains(err.Error(), "name collision") {
+				t.Fatalf("expected name collision, got %v", err)
+			}
+		})
+	}
+}
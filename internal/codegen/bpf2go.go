@@ -0,0 +1,245 @@
+package codegen
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// bpf2goNames are the identifiers a bpf2go loader declares for one ident.
+type bpf2goNames struct {
+	ident string
+	upper string // ident with its first letter upper-cased
+}
+
+func newBPF2GoNames(ident string) bpf2goNames {
+	return bpf2goNames{ident: ident, upper: strings.ToUpper(ident[:1]) + ident[1:]}
+}
+
+func (n bpf2goNames) load() string          { return "load" + n.upper }
+func (n bpf2goNames) loadObjects() string   { return "load" + n.upper + "Objects" }
+func (n bpf2goNames) specs() string         { return n.ident + "Specs" }
+func (n bpf2goNames) programSpecs() string  { return n.ident + "ProgramSpecs" }
+func (n bpf2goNames) mapSpecs() string      { return n.ident + "MapSpecs" }
+func (n bpf2goNames) variableSpecs() string { return n.ident + "VariableSpecs" }
+func (n bpf2goNames) objects() string       { return n.ident + "Objects" }
+func (n bpf2goNames) programs() string      { return n.ident + "Programs" }
+func (n bpf2goNames) maps() string          { return n.ident + "Maps" }
+func (n bpf2goNames) variables() string     { return n.ident + "Variables" }
+func (n bpf2goNames) closeHelper() string   { return "_" + n.upper + "Close" }
+func (n bpf2goNames) bytes() string         { return "_" + n.upper + "Bytes" }
+
+// typeName returns the name of a BTF type's declaration, prefixed with the
+// ident as bpf2go does.
+func (n bpf2goNames) typeName(t btf.Type) string {
+	return n.ident + goTypeName(t)
+}
+
+// GenerateBPF2Go produces formatted Go source with the identifiers and layout
+// of a loader generated by cilium/ebpf's bpf2go for ident (loadXxxObjects,
+// xxxObjects, xxxSpecs, ...), so that existing callers work unchanged. The
+// object must be embedded, as bpf2go always embeds it.
+func GenerateBPF2Go(pkg, ident string, info *ELFInfo, embedPath string) ([]byte, error) {
+	if !token.IsIdentifier(ident) || ident == "_" {
+		return nil, fmt.Errorf("bpf2go ident %q is not a valid Go identifier", ident)
+	}
+	if embedPath == "" {
+		return nil, errors.New("bpf2go style requires an embedded object: the object must be inside the output directory")
+	}
+	names := newBPF2GoNames(ident)
+	if err := checkBPF2GoCollisions(names, info); err != nil {
+		return nil, err
+	}
+	decls, err := namedTypeDecls(info.Types, names.typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	imports := importSet{"bytes": true, "embed": true, "fmt": true, "io": true, "github.com/cilium/ebpf": true}
+	for _, d := range decls {
+		if strings.Contains(d, "structs.HostLayout") {
+			imports["structs"] = true
+		}
+	}
+
+	var b strings.Builder
+	writeHeader(&b, pkg, imports)
+	writeTypes(&b, info.Types, decls, names.typeName)
+	writeBPF2GoLoadFuncs(&b, names)
+	writeBPF2GoSpecs(&b, names, info)
+	writeBPF2GoObjects(&b, names, info)
+	fmt.Fprintf(&b, "// Do not access this directly.\n")
+	fmt.Fprintf(&b, "//\n")
+	fmt.Fprintf(&b, "//go:embed %s\n", embedPath)
+	fmt.Fprintf(&b, "var %s []byte\n", names.bytes())
+
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("format generated source: %w", err)
+	}
+	return src, nil
+}
+
+func checkBPF2GoCollisions(names bpf2goNames, info *ELFInfo) error {
+	top := make(map[string]string)
+	for _, name := range []string{
+		names.load(), names.loadObjects(), names.specs(), names.programSpecs(), names.mapSpecs(),
+		names.variableSpecs(), names.objects(), names.programs(), names.maps(), names.variables(),
+		names.closeHelper(), names.bytes(),
+	} {
+		top[name] = "generated " + name
+	}
+	for _, t := range info.Types {
+		name := names.typeName(t)
+		if prev, ok := top[name]; ok {
+			return fmt.Errorf("name collision: %q and type %q both map to %q", prev, t.TypeName(), name)
+		}
+		top[name] = "type " + t.TypeName()
+	}
+
+	seen := map[string]string{"Close": "method Close"}
+	fields := make([]string, 0, len(info.Programs)+len(info.Maps)+len(info.Variables))
+	fields = append(fields, info.Programs...)
+	fields = append(fields, info.Maps...)
+	for _, v := range info.Variables {
+		fields = append(fields, v.Name)
+	}
+	for _, name := range fields {
+		exported := goVariableName(name)
+		if prev, ok := seen[exported]; ok {
+			return fmt.Errorf("name collision: %q and %q both map to %q", prev, name, exported)
+		}
+		seen[exported] = name
+	}
+	return nil
+}
+
+func writeBPF2GoLoadFuncs(b *strings.Builder, n bpf2goNames) {
+	fmt.Fprintf(b, "// %s returns the embedded CollectionSpec for %s.\n", n.load(), n.ident)
+	fmt.Fprintf(b, "func %s() (*ebpf.CollectionSpec, error) {\n", n.load())
+	fmt.Fprintf(b, "\treader := bytes.NewReader(%s)\n", n.bytes())
+	fmt.Fprintf(b, "\tspec, err := ebpf.LoadCollectionSpecFromReader(reader)\n")
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"can't load %s: %%w\", err)\n", n.ident)
+	fmt.Fprintf(b, "\t}\n\n")
+	fmt.Fprintf(b, "\treturn spec, err\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// %s loads %s and converts it into a struct.\n", n.loadObjects(), n.ident)
+	fmt.Fprintf(b, "//\n")
+	fmt.Fprintf(b, "// The following types are suitable as obj argument:\n")
+	fmt.Fprintf(b, "//\n")
+	fmt.Fprintf(b, "//\t*%s\n", n.objects())
+	fmt.Fprintf(b, "//\t*%s\n", n.programs())
+	fmt.Fprintf(b, "//\t*%s\n", n.maps())
+	fmt.Fprintf(b, "//\n")
+	fmt.Fprintf(b, "// See ebpf.CollectionSpec.LoadAndAssign documentation for details.\n")
+	fmt.Fprintf(b, "func %s(obj interface{}, opts *ebpf.CollectionOptions) error {\n", n.loadObjects())
+	fmt.Fprintf(b, "\tspec, err := %s()\n", n.load())
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn err\n")
+	fmt.Fprintf(b, "\t}\n\n")
+	fmt.Fprintf(b, "\treturn spec.LoadAndAssign(obj, opts)\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+// writeBPF2GoFields emits one tagged field per symbol with the given type.
+func writeBPF2GoFields(b *strings.Builder, symbols []string, typ string) {
+	for _, name := range symbols {
+		fmt.Fprintf(b, "\t%s %s `ebpf:\"%s\"`\n", goVariableName(name), typ, name)
+	}
+}
+
+// variableNames returns the symbol names of vars.
+func variableNames(vars []Variable) []string {
+	names := make([]string, len(vars))
+	for i, v := range vars {
+		names[i] = v.Name
+	}
+	return names
+}
+
+func writeBPF2GoSpecs(b *strings.Builder, n bpf2goNames, info *ELFInfo) {
+	fmt.Fprintf(b, "// %s contains maps and programs before they are loaded into the kernel.\n", n.specs())
+	fmt.Fprintf(b, "//\n")
+	fmt.Fprintf(b, "// It can be passed ebpf.CollectionSpec.Assign.\n")
+	fmt.Fprintf(b, "type %s struct {\n", n.specs())
+	fmt.Fprintf(b, "\t%s\n", n.programSpecs())
+	fmt.Fprintf(b, "\t%s\n", n.mapSpecs())
+	fmt.Fprintf(b, "\t%s\n", n.variableSpecs())
+	fmt.Fprintf(b, "}\n\n")
+
+	for _, s := range []struct {
+		name, what, typ string
+		symbols         []string
+	}{
+		{n.programSpecs(), "programs", "*ebpf.ProgramSpec", info.Programs},
+		{n.mapSpecs(), "maps", "*ebpf.MapSpec", info.Maps},
+		{n.variableSpecs(), "global variables", "*ebpf.VariableSpec", variableNames(info.Variables)},
+	} {
+		fmt.Fprintf(b, "// %s contains %s before they are loaded into the kernel.\n", s.name, s.what)
+		fmt.Fprintf(b, "//\n")
+		fmt.Fprintf(b, "// It can be passed ebpf.CollectionSpec.Assign.\n")
+		fmt.Fprintf(b, "type %s struct {\n", s.name)
+		writeBPF2GoFields(b, s.symbols, s.typ)
+		fmt.Fprintf(b, "}\n\n")
+	}
+}
+
+func writeBPF2GoObjects(b *strings.Builder, n bpf2goNames, info *ELFInfo) {
+	fmt.Fprintf(b, "// %s contains all objects after they have been loaded into the kernel.\n", n.objects())
+	fmt.Fprintf(b, "//\n")
+	fmt.Fprintf(b, "// It can be passed to %s or ebpf.CollectionSpec.LoadAndAssign.\n", n.loadObjects())
+	fmt.Fprintf(b, "type %s struct {\n", n.objects())
+	fmt.Fprintf(b, "\t%s\n", n.programs())
+	fmt.Fprintf(b, "\t%s\n", n.maps())
+	fmt.Fprintf(b, "\t%s\n", n.variables())
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (o *%s) Close() error {\n", n.objects())
+	fmt.Fprintf(b, "\treturn %s(\n", n.closeHelper())
+	fmt.Fprintf(b, "\t\t&o.%s,\n", n.programs())
+	fmt.Fprintf(b, "\t\t&o.%s,\n", n.maps())
+	fmt.Fprintf(b, "\t)\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	for _, s := range []struct {
+		name, what, typ, recv string
+		symbols               []string
+	}{
+		{n.maps(), "maps", "*ebpf.Map", "m", info.Maps},
+		{n.variables(), "global variables", "*ebpf.Variable", "", variableNames(info.Variables)},
+		{n.programs(), "programs", "*ebpf.Program", "p", info.Programs},
+	} {
+		fmt.Fprintf(b, "// %s contains all %s after they have been loaded into the kernel.\n", s.name, s.what)
+		fmt.Fprintf(b, "//\n")
+		fmt.Fprintf(b, "// It can be passed to %s or ebpf.CollectionSpec.LoadAndAssign.\n", n.loadObjects())
+		fmt.Fprintf(b, "type %s struct {\n", s.name)
+		writeBPF2GoFields(b, s.symbols, s.typ)
+		fmt.Fprintf(b, "}\n\n")
+		if s.recv == "" {
+			continue
+		}
+
+		fmt.Fprintf(b, "func (%s *%s) Close() error {\n", s.recv, s.name)
+		fmt.Fprintf(b, "\treturn %s(\n", n.closeHelper())
+		for _, name := range s.symbols {
+			fmt.Fprintf(b, "\t\t%s.%s,\n", s.recv, goVariableName(name))
+		}
+		fmt.Fprintf(b, "\t)\n")
+		fmt.Fprintf(b, "}\n\n")
+	}
+
+	fmt.Fprintf(b, "func %s(closers ...io.Closer) error {\n", n.closeHelper())
+	fmt.Fprintf(b, "\tfor _, closer := range closers {\n")
+	fmt.Fprintf(b, "\t\tif err := closer.Close(); err != nil {\n")
+	fmt.Fprintf(b, "\t\t\treturn err\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
@@ -0,0 +1,107 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestGenerateBPF2Go(t *testing.T) {
+	info := &ELFInfo{
+		Programs:  []string{"count_packets"},
+		Sections:  map[string]string{"count_packets": "xdp"},
+		Maps:      []string{"pkt_count"},
+		MapDefs:   map[string]MapDef{"pkt_count": {Type: ebpf.Hash, Key: connKey, KeySize: 8, ValueSize: 8}},
+		Variables: []Variable{{Name: "main.targetPID", Section: ".rodata", Size: 4}},
+	}
+	info.Types = collectMapTypes(info.MapDefs)
+	src, err := GenerateBPF2Go("main", "counter", info, "counter.bpf.o")
+	if err != nil {
+		t.Fatalf("GenerateBPF2Go: %v", err)
+	}
+	text := string(src)
+	for _, s := range []string{
+		"package main",
+		"// counterConnKey mirrors the BTF type main_connKey.",
+		"type counterConnKey struct {",
+		"func loadCounter() (*ebpf.CollectionSpec, error) {",
+		"reader := bytes.NewReader(_CounterBytes)",
+		"func loadCounterObjects(obj interface{}, opts *ebpf.CollectionOptions) error {",
+		"type counterSpecs struct {\n\tcounterProgramSpecs\n\tcounterMapSpecs\n\tcounterVariableSpecs\n}",
+		"CountPackets *ebpf.ProgramSpec `ebpf:\"count_packets\"`",
+		"PktCount *ebpf.MapSpec `ebpf:\"pkt_count\"`",
+		"TargetPID *ebpf.VariableSpec `ebpf:\"main.targetPID\"`",
+		"type counterObjects struct {\n\tcounterPrograms\n\tcounterMaps\n\tcounterVariables\n}",
+		"func (o *counterObjects) Close() error {",
+		"PktCount *ebpf.Map `ebpf:\"pkt_count\"`",
+		"func (m *counterMaps) Close() error {",
+		"TargetPID *ebpf.Variable `ebpf:\"main.targetPID\"`",
+		"func (p *counterPrograms) Close() error {",
+		"func _CounterClose(closers ...io.Closer) error {",
+		"//go:embed counter.bpf.o\nvar _CounterBytes []byte",
+	} {
+		if !strings.Contains(text, s) {
+			t.Errorf("generated source missing %q\n%s", s, text)
+		}
+	}
+	for _, s := range []string{"PktCountMap", "AttachAll", "LoadPinned", "Config"} {
+		if strings.Contains(text, s) {
+			t.Errorf("generated source should not contain %q", s)
+		}
+	}
+}
+
+func TestGenerateBPF2GoErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		ident     string
+		embedPath string
+		info      *ELFInfo
+		wantErr   string
+	}{
+		{
+			name:      "invalid ident",
+			ident:     "my-probe",
+			embedPath: "probe.bpf.o",
+			info:      &ELFInfo{Programs: []string{"handler"}},
+			wantErr:   "not a valid Go identifier",
+		},
+		{
+			name:    "object not embedded",
+			ident:   "probe",
+			info:    &ELFInfo{Programs: []string{"handler"}},
+			wantErr: "requires an embedded object",
+		},
+		{
+			name:      "type collides with generated name",
+			ident:     "probe",
+			embedPath: "probe.bpf.o",
+			info:      &ELFInfo{Programs: []string{"handler"}, Types: []btf.Type{&btf.Struct{Name: "objects"}}},
+			wantErr:   "name collision",
+		},
+		{
+			name:      "program collides with Close",
+			ident:     "probe",
+			embedPath: "probe.bpf.o",
+			info:      &ELFInfo{Programs: []string{"close"}},
+			wantErr:   "name collision",
+		},
+		{
+			name:      "program and map fields collide",
+			ident:     "probe",
+			embedPath: "probe.bpf.o",
+			info:      &ELFInfo{Programs: []string{"events"}, Maps: []string{"events"}},
+			wantErr:   "name collision",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := GenerateBPF2Go("loader", tt.ident, tt.info, tt.embedPath)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
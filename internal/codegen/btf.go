@@ -0,0 +1,365 @@
+package codegen
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+// MapDef describes a map definition as recorded in the object's BTF.
+type MapDef struct {
+	Type       ebpf.MapType
+	KeySize    uint32
+	ValueSize  uint32
+	MaxEntries uint32
+	Flags      uint32
+	Pinning    uint32
+
+	// Key and Value are the BTF key and value types, or nil when the
+	// definition only records key_size/value_size.
+	Key   btf.Type
+	Value btf.Type
+
+	// Inner is the inner map template of an array or hash of maps, or nil.
+	Inner *MapDef
+}
+
+// loadBTF parses the object's .BTF section, returning nil when it is absent.
+func loadBTF(f *elf.File) (*btf.Spec, error) {
+	sec := f.Section(".BTF")
+	if sec == nil {
+		return nil, nil
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return nil, fmt.Errorf("read .BTF section: %w", err)
+	}
+	spec, err := btf.LoadSpecFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse .BTF section: %w", err)
+	}
+	return spec, nil
+}
+
+// mapDefsFromBTF decodes every map definition in the .maps DATASEC.
+func mapDefsFromBTF(spec *btf.Spec) (map[string]MapDef, error) {
+	var ds *btf.Datasec
+	if err := spec.TypeByName(".maps", &ds); err != nil {
+		if errors.Is(err, btf.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find .maps DATASEC: %w", err)
+	}
+
+	defs := make(map[string]MapDef, len(ds.Vars))
+	for _, vsi := range ds.Vars {
+		v, ok := vsi.Type.(*btf.Var)
+		if !ok {
+			continue
+		}
+		def, ok := btf.UnderlyingType(v.Type).(*btf.Struct)
+		if !ok {
+			return nil, fmt.Errorf("map %q: definition is %s, not a struct", v.Name, v.Type)
+		}
+		md, err := decodeMapDef(def)
+		if err != nil {
+			return nil, fmt.Errorf("map %q: %w", v.Name, err)
+		}
+		defs[v.Name] = md
+	}
+	return defs, nil
+}
+
+// decodeMapDef reads the libbpf-style members of a BTF map definition.
+// Unknown members are ignored so that newer definitions still generate.
+func decodeMapDef(def *btf.Struct) (MapDef, error) {
+	var md MapDef
+	for _, m := range def.Members {
+		switch m.Name {
+		case "key", "value":
+			ptr, ok := btf.UnderlyingType(m.Type).(*btf.Pointer)
+			if !ok {
+				return MapDef{}, fmt.Errorf("member %q is not a pointer", m.Name)
+			}
+			size, err := btf.Sizeof(ptr.Target)
+			if err != nil {
+				return MapDef{}, fmt.Errorf("size of %s: %w", m.Name, err)
+			}
+			if m.Name == "key" {
+				md.Key, md.KeySize = ptr.Target, uint32(size)
+			} else {
+				md.Value, md.ValueSize = ptr.Target, uint32(size)
+			}
+		case "values":
+			inner, err := decodeInnerMapDef(m.Type)
+			if err != nil {
+				return MapDef{}, err
+			}
+			md.Inner = inner
+		case "type", "key_size", "value_size", "max_entries", "map_flags", "pinning":
+			n, err := uintFromBTF(m.Type)
+			if err != nil {
+				return MapDef{}, fmt.Errorf("member %q: %w", m.Name, err)
+			}
+			setMapDefField(&md, m.Name, n)
+		}
+	}
+	return md, nil
+}
+
+// decodeInnerMapDef reads the inner map template from the values member of
+// a map-in-map definition, an array of pointers to the template struct.
+// Arrays of other pointers, such as programs, have no template.
+func decodeInnerMapDef(typ btf.Type) (*MapDef, error) {
+	arr, ok := btf.UnderlyingType(typ).(*btf.Array)
+	if !ok {
+		return nil, errors.New(`member "values" is not an array`)
+	}
+	ptr, ok := btf.UnderlyingType(arr.Type).(*btf.Pointer)
+	if !ok {
+		return nil, errors.New(`member "values" is not an array of pointers`)
+	}
+	def, ok := btf.UnderlyingType(ptr.Target).(*btf.Struct)
+	if !ok {
+		return nil, nil
+	}
+	inner, err := decodeMapDef(def)
+	if err != nil {
+		return nil, fmt.Errorf("inner map: %w", err)
+	}
+	return &inner, nil
+}
+
+// setMapDefField assigns an integer-valued BTF map definition member.
+func setMapDefField(md *MapDef, name string, n uint32) {
+	switch name {
+	case "type":
+		md.Type = ebpf.MapType(n)
+	case "key_size":
+		md.KeySize = n
+	case "value_size":
+		md.ValueSize = n
+	case "max_entries":
+		md.MaxEntries = n
+	case "map_flags":
+		md.Flags = n
+	case "pinning":
+		md.Pinning = n
+	}
+}
+
+// uintFromBTF decodes an integer encoded as `int (*name)[N]` in a BTF map definition.
+func uintFromBTF(typ btf.Type) (uint32, error) {
+	ptr, ok := btf.UnderlyingType(typ).(*btf.Pointer)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a pointer", typ)
+	}
+	arr, ok := btf.UnderlyingType(ptr.Target).(*btf.Array)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a pointer to an array", typ)
+	}
+	return arr.Nelems, nil
+}
+
+// collectMapTypes returns the named key and value types of all map definitions.
+func collectMapTypes(defs map[string]MapDef) []btf.Type {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var types []btf.Type
+	for _, name := range names {
+		md := defs[name]
+		types = addType(types, md.Key)
+		types = addType(types, md.Value)
+		if md.Inner != nil {
+			types = addType(types, md.Inner.Key)
+			types = addType(types, md.Inner.Value)
+		}
+	}
+	return types
+}
+
+// addType appends the declarable type behind t, and the named types of its
+// members, unless already present. Arrays contribute their element type;
+// anonymous and scalar types are skipped. A different type of the same name
+// is appended too, so that Generate reports the collision.
+func addType(types []btf.Type, t btf.Type) []btf.Type {
+	t = declarableType(t)
+	if t == nil {
+		return types
+	}
+	if slices.ContainsFunc(types, func(e btf.Type) bool { return sameType(e, t) }) {
+		return types
+	}
+	types = append(types, t)
+
+	var members []btf.Member
+	switch u := btf.UnderlyingType(t).(type) {
+	case *btf.Struct:
+		members = u.Members
+	case *btf.Union:
+		members = u.Members
+	}
+	for _, m := range members {
+		types = addType(types, m.Type)
+	}
+	return types
+}
+
+// sameType reports whether a and b declare the same Go type: they are the
+// same BTF type, or share a name and an underlying type, as a struct and a
+// typedef of it do.
+func sameType(a, b btf.Type) bool {
+	if a == b {
+		return true
+	}
+	return a.TypeName() == b.TypeName() && btf.UnderlyingType(a) == btf.UnderlyingType(b)
+}
+
+// declarableType returns t with qualifiers stripped if it names a struct,
+// union or enum (directly or via a typedef), or nil otherwise.
+func declarableType(t btf.Type) btf.Type {
+	if t == nil {
+		return nil
+	}
+	switch u := btf.UnderlyingType(t).(type) {
+	case *btf.Struct, *btf.Union, *btf.Enum:
+	case *btf.Array:
+		return declarableType(u.Type)
+	default:
+		return nil
+	}
+	t = btf.QualifiedType(t)
+	if t.TypeName() == "" {
+		return nil
+	}
+	return t
+}
+
+// IncludeType adds the named BTF type to the declarations emitted by
+// [Generate], for types such as ring buffer events that no map definition
+// references.
+func (info *ELFInfo) IncludeType(name string) error {
+	t, err := info.lookupType(name)
+	if err != nil {
+		return err
+	}
+	info.Types = addType(info.Types, t)
+	return nil
+}
+
+// SetEventType declares typeName as the record type of the ring buffer or
+// perf event array mapName, so its generated reader decodes records into
+// that type.
+func (info *ELFInfo) SetEventType(mapName, typeName string) error {
+	def, ok := info.MapDefs[mapName]
+	if !ok {
+		if !slices.Contains(info.Maps, mapName) {
+			return fmt.Errorf("event type for %q: no such map", mapName)
+		}
+		return fmt.Errorf("event type for %q: object has no BTF (build with --btf)", mapName)
+	}
+	if kind, _ := classifyMap(def.Type); kind != mapKindRingBuf && kind != mapKindPerfEventArray {
+		return fmt.Errorf("event type for %q: map is %s, not a ring buffer or perf event array", mapName, def.Type)
+	}
+	t, err := info.lookupType(typeName)
+	if err != nil {
+		return err
+	}
+	if info.Events == nil {
+		info.Events = make(map[string]btf.Type)
+	}
+	info.Events[mapName] = t
+	info.Types = addType(info.Types, t)
+	return nil
+}
+
+// lookupType finds the declarable BTF type with the given name.
+func (info *ELFInfo) lookupType(name string) (btf.Type, error) {
+	if info.spec == nil {
+		return nil, fmt.Errorf("type %q: object has no BTF (build with --btf)", name)
+	}
+	candidates, err := info.spec.AnyTypesByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("type %q: %w", name, err)
+	}
+	for _, t := range candidates {
+		if d := declarableType(t); d != nil {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("type %q is not a struct, union or enum", name)
+}
+
+// goTypeName returns the exported Go identifier for a named BTF type. TinyGo
+// qualifies package-level types with their package, which the map-btf pass
+// sanitizes from "main.connEvent" to "main_connEvent"; the qualifier is dropped.
+func goTypeName(t btf.Type) string {
+	name := t.TypeName()
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return exportedName(strings.TrimPrefix(name, "main_"))
+}
+
+// goFieldName returns the Go identifier for a struct field or enum element,
+// keeping blank identifiers used for explicit padding in Go sources.
+func goFieldName(s string) string {
+	if name := exportedName(s); name != "" {
+		return name
+	}
+	return "_"
+}
+
+// typeDecls renders Go declarations for types, in order.
+func typeDecls(types []btf.Type) ([]string, error) {
+	return namedTypeDecls(types, goTypeName)
+}
+
+// namedTypeDecls is like typeDecls, but declares each type under name(t).
+func namedTypeDecls(types []btf.Type, name func(btf.Type) string) ([]string, error) {
+	gf := btf.GoFormatter{
+		Names:      make(map[btf.Type]string, len(types)),
+		Identifier: goFieldName,
+		EnumIdentifier: func(typ, element string) string {
+			return typ + goFieldName(element)
+		},
+	}
+	// A typedef stands for the type it names when that type is not declared
+	// itself, as when addType reached the typedef first, so that members
+	// referring to either get the same name.
+	declared := make([]btf.Type, len(types))
+	for i, t := range types {
+		gf.Names[t] = name(t)
+		declared[i] = t
+	}
+	for i, t := range types {
+		if _, ok := t.(*btf.Typedef); !ok {
+			continue
+		}
+		u := btf.UnderlyingType(t)
+		if _, named := gf.Names[u]; !named {
+			gf.Names[u] = gf.Names[t]
+			declared[i] = u
+		}
+	}
+
+	decls := make([]string, 0, len(types))
+	for i, t := range types {
+		decl, err := gf.TypeDeclaration(gf.Names[t], declared[i])
+		if err != nil {
+			return nil, fmt.Errorf("generate type %s: %w", t.TypeName(), err)
+		}
+		decls = append(decls, decl)
+	}
+	return decls, nil
+}
@@ -0,0 +1,454 @@
+package codegen
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+var (
+	btfU8  = &btf.Int{Name: "unsigned char", Size: 1}
+	btfU16 = &btf.Int{Name: "unsigned short", Size: 2}
+	btfU32 = &btf.Int{Name: "unsigned int", Size: 4}
+	btfU64 = &btf.Int{Name: "unsigned long long", Size: 8}
+	btfI32 = &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}
+)
+
+// btfMapField returns a libbpf-style `int (*name)[n]` map definition member.
+func btfMapField(name string, n uint32) btf.Member {
+	return btf.Member{
+		Name: name,
+		Type: &btf.Pointer{Target: &btf.Array{Index: btfI32, Type: btfI32, Nelems: n}},
+	}
+}
+
+// btfMapDefStruct returns an anonymous BTF map definition struct with
+// pointer-sized members laid out in order.
+func btfMapDefStruct(members ...btf.Member) *btf.Struct {
+	for i := range members {
+		members[i].Offset = btf.Bits(i * 64)
+	}
+	return &btf.Struct{Size: uint32(len(members) * 8), Members: members}
+}
+
+// marshalMapsBTF encodes the given map definitions as a .maps DATASEC.
+func marshalMapsBTF(t *testing.T, defs map[string]*btf.Struct, extra ...btf.Type) []byte {
+	t.Helper()
+	ds := &btf.Datasec{Name: ".maps"}
+	var off uint32
+	for name, def := range defs {
+		ds.Vars = append(ds.Vars, btf.VarSecinfo{
+			Type:   &btf.Var{Name: name, Type: def, Linkage: btf.GlobalVar},
+			Offset: off,
+			Size:   def.Size,
+		})
+		off += def.Size
+	}
+	ds.Size = off
+
+	b, err := btf.NewBuilder(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range append([]btf.Type{ds}, extra...) {
+		if _, err := b.Add(typ); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := b.Marshal(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// testBPFELFWithBTF builds a BPF ELF with one program, the given map symbols
+// and a .BTF section holding btfData.
+func testBPFELFWithBTF(t *testing.T, prog string, maps []string, btfData []byte) string {
+	t.Helper()
+
+	shstrtab := "\x00.text\x00.maps\x00.BTF\x00.symtab\x00.strtab\x00.shstrtab\x00"
+	nameOff := func(s string) uint32 { return uint32(strings.Index(shstrtab, "\x00"+s+"\x00") + 1) }
+
+	symstrtab := "\x00"
+	var syms []byte
+	syms = append(syms, make([]byte, 24)...)
+	addSym := func(name string, info byte, shndx uint16) {
+		sym := make([]byte, 24)
+		binary.LittleEndian.PutUint32(sym[0:4], uint32(len(symstrtab)))
+		sym[4] = info
+		binary.LittleEndian.PutUint16(sym[6:8], shndx)
+		syms = append(syms, sym...)
+		symstrtab += name + "\x00"
+	}
+	addSym(prog, 0x12, 1) // STT_FUNC | STB_GLOBAL in .text
+	for _, name := range maps {
+		addSym(name, 0x11, 2) // STT_OBJECT | STB_GLOBAL in .maps
+	}
+
+	type section struct {
+		name          string
+		typ           uint32
+		flags         uint64
+		data          []byte
+		link, entsize uint32
+	}
+	sections := []section{
+		{name: ".text", typ: 1, flags: 6, data: []byte{0x95, 0, 0, 0, 0, 0, 0, 0}},
+		{name: ".maps", typ: 1, flags: 3, data: make([]byte, 8*len(maps)+8)},
+		{name: ".BTF", typ: 1, data: btfData},
+		{name: ".strtab", typ: 3, data: []byte(symstrtab)},
+		{name: ".symtab", typ: 2, data: syms, link: 4, entsize: 24},
+		{name: ".shstrtab", typ: 3, data: []byte(shstrtab)},
+	}
+
+	out := make([]byte, 64)
+	offsets := make([]uint64, len(sections))
+	for i, s := range sections {
+		for len(out)%8 != 0 {
+			out = append(out, 0)
+		}
+		offsets[i] = uint64(len(out))
+		out = append(out, s.data...)
+	}
+	for len(out)%8 != 0 {
+		out = append(out, 0)
+	}
+	shOff := uint64(len(out))
+	out = append(out, make([]byte, 64)...) // null section header
+	for i, s := range sections {
+		sh := make([]byte, 64)
+		binary.LittleEndian.PutUint32(sh[0:4], nameOff(s.name))
+		binary.LittleEndian.PutUint32(sh[4:8], s.typ)
+		binary.LittleEndian.PutUint64(sh[8:16], s.flags)
+		binary.LittleEndian.PutUint64(sh[24:32], offsets[i])
+		binary.LittleEndian.PutUint64(sh[32:40], uint64(len(s.data)))
+		binary.LittleEndian.PutUint32(sh[40:44], s.link)
+		if s.typ == 2 {
+			binary.LittleEndian.PutUint32(sh[44:48], 1)
+		}
+		binary.LittleEndian.PutUint64(sh[48:56], 8)
+		binary.LittleEndian.PutUint64(sh[56:64], uint64(s.entsize))
+		out = append(out, sh...)
+	}
+
+	copy(out[0:4], []byte{0x7f, 'E', 'L', 'F'})
+	out[4], out[5], out[6] = 2, 1, 1                // ELFCLASS64, ELFDATA2LSB, EV_CURRENT
+	binary.LittleEndian.PutUint16(out[16:18], 1)    // ET_REL
+	binary.LittleEndian.PutUint16(out[18:20], 0xF7) // EM_BPF
+	binary.LittleEndian.PutUint32(out[20:24], 1)
+	binary.LittleEndian.PutUint64(out[40:48], shOff)
+	binary.LittleEndian.PutUint16(out[52:54], 64)
+	binary.LittleEndian.PutUint16(out[58:60], 64)
+	binary.LittleEndian.PutUint16(out[60:62], uint16(len(sections)+1))
+	binary.LittleEndian.PutUint16(out[62:64], uint16(len(sections)))
+
+	p := filepath.Join(t.TempDir(), "btf.bpf.o")
+	if err := os.WriteFile(p, out, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// connKey and connEvent mirror the shapes TinyGo emits for Go structs.
+var (
+	connKey = &btf.Struct{
+		Name: "main_connKey",
+		Size: 8,
+		Members: []btf.Member{
+			{Name: "Addr", Type: btfU32},
+			{Name: "Port", Type: btfU16, Offset: 32},
+		},
+	}
+	connState = &btf.Enum{
+		Name: "conn_state",
+		Size: 4,
+		Values: []btf.EnumValue{
+			{Name: "open", Value: 0},
+			{Name: "closed", Value: 1},
+		},
+	}
+	connEvent = &btf.Struct{
+		Name: "main_connEvent",
+		Size: 32,
+		Members: []btf.Member{
+			{Name: "PID", Type: btfU32},
+			{Name: "_", Type: &btf.Array{Index: btfU32, Type: btfU8, Nelems: 4}, Offset: 32},
+			{Name: "Ts", Type: btfU64, Offset: 64},
+			{Name: "State", Type: connState, Offset: 128},
+			{Name: "Comm", Type: &btf.Array{Index: btfU32, Type: btfU8, Nelems: 8}, Offset: 192},
+		},
+	}
+)
+
+func TestExtractELFInfoBTF(t *testing.T) {
+	defs := map[string]*btf.Struct{
+		"conns": btfMapDefStruct(
+			btfMapField("type", uint32(ebpf.Hash)),
+			btf.Member{Name: "key", Type: &btf.Pointer{Target: connKey}},
+			btf.Member{Name: "value", Type: &btf.Pointer{Target: btfU64}},
+			btfMapField("max_entries", 1024),
+		),
+		"events": btfMapDefStruct(
+			btfMapField("type", uint32(ebpf.RingBuf)),
+			btfMapField("key_size", 0),
+			btfMapField("value_size", 0),
+			btfMapField("max_entries", 1<<24),
+			btfMapField("map_flags", 0),
+		),
+	}
+	path := testBPFELFWithBTF(t, "handler", []string{"conns", "events"}, marshalMapsBTF(t, defs, connEvent))
+
+	info, err := ExtractELFInfo(path)
+	if err != nil {
+		t.Fatalf("ExtractELFInfo: %v", err)
+	}
+
+	conns, ok := info.MapDefs["conns"]
+	if !ok {
+		t.Fatalf("missing conns map def: %v", info.MapDefs)
+	}
+	if conns.Type != ebpf.Hash || conns.KeySize != 8 || conns.ValueSize != 8 || conns.MaxEntries != 1024 {
+		t.Errorf("conns = %+v", conns)
+	}
+	if conns.Key == nil || conns.Key.TypeName() != "main_connKey" {
+		t.Errorf("conns key = %v, want main_connKey", conns.Key)
+	}
+	events := info.MapDefs["events"]
+	if events.Type != ebpf.RingBuf || events.MaxEntries != 1<<24 || events.Key != nil {
+		t.Errorf("events = %+v", events)
+	}
+
+	if len(info.Types) != 1 || info.Types[0].TypeName() != "main_connKey" {
+		t.Fatalf("types = %v, want [main_connKey]", info.Types)
+	}
+
+	if err := info.IncludeType("main_connEvent"); err != nil {
+		t.Fatalf("IncludeType: %v", err)
+	}
+	if err := info.IncludeType("main_connEvent"); err != nil {
+		t.Fatalf("IncludeType twice: %v", err)
+	}
+	if len(info.Types) != 3 {
+		t.Fatalf("types = %v, want connKey, connEvent and its nested enum", info.Types)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		wantErr string
+	}{
+		{"no_such_type", "not found"},
+		{"unsigned int", "not a struct, union or enum"},
+	} {
+		err := info.IncludeType(tt.name)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("IncludeType(%q) = %v, want error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	src, err := Generate("loader", info, "")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, want := range []string{`"structs"`, "type ConnKey struct", "type ConnEvent struct", "State ConnState"} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated source missing %q", want)
+		}
+	}
+}
+
+func TestDecodeInnerMapDef(t *testing.T) {
+	inner := btfMapDefStruct(
+		btfMapField("type", uint32(ebpf.Hash)),
+		btf.Member{Name: "key", Type: &btf.Pointer{Target: connKey}},
+		btf.Member{Name: "value", Type: &btf.Pointer{Target: btfU64}},
+		btfMapField("max_entries", 128),
+	)
+	outer := func(values btf.Type) *btf.Struct {
+		return btfMapDefStruct(
+			btfMapField("type", uint32(ebpf.HashOfMaps)),
+			btfMapField("key_size", 4),
+			btfMapField("max_entries", 64),
+			btf.Member{Name: "values", Type: values},
+		)
+	}
+
+	md, err := decodeMapDef(outer(&btf.Array{Index: btfU32, Type: &btf.Pointer{Target: inner}}))
+	if err != nil {
+		t.Fatalf("decodeMapDef: %v", err)
+	}
+	if md.Type != ebpf.HashOfMaps || md.Inner == nil {
+		t.Fatalf("outer = %+v", md)
+	}
+	if md.Inner.Type != ebpf.Hash || md.Inner.KeySize != 8 || md.Inner.ValueSize != 8 || md.Inner.MaxEntries != 128 {
+		t.Errorf("inner = %+v", md.Inner)
+	}
+	if types := collectMapTypes(map[string]MapDef{"tenants": md}); len(types) != 1 || types[0] != connKey {
+		t.Errorf("types = %v, want the inner key type", types)
+	}
+
+	progs, err := decodeMapDef(outer(&btf.Array{Index: btfU32, Type: &btf.Pointer{Target: &btf.FuncProto{Return: btfI32}}}))
+	if err != nil || progs.Inner != nil {
+		t.Errorf("program values: %+v, %v", progs, err)
+	}
+	if _, err := decodeMapDef(outer(btfU32)); err == nil || !strings.Contains(err.Error(), `"values" is not an array`) {
+		t.Errorf("values not an array: %v", err)
+	}
+}
+
+func TestIncludeTypeWithoutBTF(t *testing.T) {
+	info, err := ExtractELFInfo(testBPFELF(t, []string{"handler"}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := info.IncludeType("event"); err == nil || !strings.Contains(err.Error(), "no BTF") {
+		t.Fatalf("expected no BTF error, got %v", err)
+	}
+}
+
+func TestTypeDecls(t *testing.T) {
+	tests := []struct {
+		name     string
+		types    []btf.Type
+		contains []string
+		wantErr  string
+	}{
+		{
+			name:  "struct with padding",
+			types: []btf.Type{connKey},
+			contains: []string{
+				"type ConnKey struct",
+				"Addr uint32",
+				"Port uint16",
+				"_ [2]byte",
+			},
+		},
+		{
+			name:  "nested enum referenced by name",
+			types: addType(nil, connEvent),
+			contains: []string{
+				"PID uint32",
+				"_ [4]uint8",
+				"Ts uint64",
+				"State ConnState",
+				"Comm [8]uint8",
+				"type ConnState uint32",
+				"ConnStateOpen ConnState = 0",
+				"ConnStateClosed ConnState = 1",
+			},
+		},
+		{
+			name: "typedef keeps its name",
+			types: []btf.Type{&btf.Typedef{Name: "flow_t", Type: &btf.Struct{
+				Size:    4,
+				Members: []btf.Member{{Name: "bytes", Type: btfU32}},
+			}}},
+			contains: []string{"type FlowT struct", "Bytes uint32"},
+		},
+		{
+			name: "bitfield is replaced by padding",
+			types: []btf.Type{&btf.Struct{Name: "flags", Size: 4, Members: []btf.Member{
+				{Name: "a", Type: btfU32, BitfieldSize: 3},
+			}}},
+			contains: []string{"type Flags struct", "_ [4]byte"},
+		},
+		{
+			name: "typedef reached before the struct it names",
+			types: func() []btf.Type {
+				conn := &btf.Struct{Name: "conn", Size: 4, Members: []btf.Member{{Name: "addr", Type: btfU32}}}
+				flow := &btf.Struct{Name: "flow", Size: 8, Members: []btf.Member{
+					{Name: "first", Type: &btf.Typedef{Name: "conn", Type: conn}},
+					{Name: "last", Type: conn, Offset: 32},
+				}}
+				return addType(nil, flow)
+			}(),
+			contains: []string{
+				"type Conn struct { _ structs.HostLayout Addr uint32 }",
+				"First Conn Last Conn",
+			},
+		},
+		{
+			name:    "unsupported member type",
+			types:   []btf.Type{&btf.Struct{Name: "bad", Size: 4, Members: []btf.Member{{Name: "f", Type: &btf.Float{Name: "float", Size: 4}}}}},
+			wantErr: "generate type bad",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decls, err := typeDecls(tt.types)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected %q in error, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("typeDecls: %v", err)
+			}
+			src, err := Generate("loader", &ELFInfo{Programs: []string{"handler"}, Types: tt.types}, "")
+			if err != nil {
+				t.Fatalf("Generate: %v\n%s", err, strings.Join(decls, "\n"))
+			}
+			text := strings.Join(strings.Fields(string(src)), " ")
+			for _, s := range tt.contains {
+				if !strings.Contains(text, s) {
+					t.Errorf("generated source missing %q:\n%s", s, src)
+				}
+			}
+		})
+	}
+}
+
+func TestGoTypeName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"conn_event", "ConnEvent"},
+		{"main_connEvent", "ConnEvent"},
+		{"main.connEvent", "ConnEvent"},
+		{"maintenance", "Maintenance"},
+	}
+	for _, tt := range tests {
+		if got := goTypeName(&btf.Struct{Name: tt.in}); got != tt.want {
+			t.Errorf("goTypeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTypeNameCollisions(t *testing.T) {
+	tests := []struct {
+		name  string
+		types []btf.Type
+	}{
+		{"reserved name", []btf.Type{&btf.Struct{Name: "objects"}}},
+		{"two types", []btf.Type{&btf.Struct{Name: "conn"}, &btf.Struct{Name: "main_conn"}}},
+		{"same name, different types", addType(addType(nil, &btf.Struct{Name: "conn", Size: 4}), &btf.Union{Name: "conn", Size: 8})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Generate("loader", &ELFInfo{Programs: []string{"handler"}, Types: tt.types}, "")
+			if err == nil || !strings.Contains(err.Error(), "name collision") {
+				t.Fatalf("expected name collision, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAddTypeDedup(t *testing.T) {
+	conn := &btf.Struct{Name: "conn", Size: 4}
+	typedef := &btf.Typedef{Name: "conn", Type: conn}
+	types := addType(addType(addType(nil, conn), conn), typedef)
+	if len(types) != 1 || types[0] != conn {
+		t.Errorf("struct and its same-named typedef: types = %v, want [conn]", types)
+	}
+	types = addType(types, &btf.Struct{Name: "conn", Size: 8})
+	if len(types) != 2 {
+		t.Errorf("distinct struct of the same name: got %d types, want 2", len(types))
+	}
+}
@@ -5,14 +5,49 @@ import (
 	"debug/elf"
 	"fmt"
 	"go/format"
+	"io"
 	"sort"
 	"strings"
+
+	"github.com/cilium/ebpf/btf"
 )
 
-// ELFInfo holds the programs and maps extracted from a BPF ELF object.
+// ELFInfo holds the programs, maps and BTF types extracted from a BPF ELF object.
 type ELFInfo struct {
 	Programs []string
 	Maps     []string
+
+	// Sections holds the ELF section of each program, keyed by program name.
+	// Programs whose section names an attach point get Attach methods.
+	Sections map[string]string
+
+	// MapDefs holds the BTF definition of each map, keyed by map name.
+	// It is empty when the object carries no BTF.
+	MapDefs map[string]MapDef
+
+	// Types are the named BTF types emitted as Go declarations: map keys
+	// and values, plus any added with [ELFInfo.IncludeType] or
+	// [ELFInfo.SetEventType].
+	Types []btf.Type
+
+	// Events holds the record type of each ring buffer and perf event array,
+	// keyed by map name. Readers of maps without an entry return raw bytes.
+	Events map[string]btf.Type
+
+	// Variables are the global variables in .data, .bss and .rodata,
+	// sorted by name. Read-only ones make up the generated Config; the
+	// rest are exposed through Globals.
+	Variables []Variable
+
+	// PinPath, if set, is emitted as the DefaultPinPath constant.
+	PinPath string
+
+	// Variants, if set, are the builds of the object embedded in the
+	// loader, most preferred first; Load picks the first one the kernel
+	// supports. The programs, maps and types above describe all of them.
+	Variants []Variant
+
+	spec *btf.Spec
 }
 
 // ExtractELFInfo reads a BPF ELF object and returns its program and map symbol names.
@@ -22,7 +57,20 @@ func ExtractELFInfo(path string) (*ELFInfo, error) {
 		return nil, fmt.Errorf("open ELF %q: %w", path, err)
 	}
 	defer func() { _ = f.Close() }()
+	return extractELFInfo(f, path)
+}
+
+// ExtractELFInfoFromReader is like [ExtractELFInfo] for an object held in
+// memory; name identifies it in errors.
+func ExtractELFInfoFromReader(r io.ReaderAt, name string) (*ELFInfo, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("open ELF %q: %w", name, err)
+	}
+	return extractELFInfo(f, name)
+}
 
+func extractELFInfo(f *elf.File, path string) (*ELFInfo, error) {
 	syms, err := f.Symbols()
 	if err != nil {
 		return nil, fmt.Errorf("read symbols from %q: %w", path, err)
@@ -36,7 +84,7 @@ func ExtractELFInfo(path string) (*ELFInfo, error) {
 		}
 	}
 
-	var info ELFInfo
+	info := ELFInfo{Sections: make(map[string]string)}
 	for _, sym := range syms {
 		if sym.Section == elf.SHN_UNDEF || int(sym.Section) >= len(f.Sections) {
 			continue
@@ -49,45 +97,119 @@ func ExtractELFInfo(path string) (*ELFInfo, error) {
 		if bind == elf.STB_GLOBAL && typ == elf.STT_FUNC &&
 			sec.Type == elf.SHT_PROGBITS && sec.Flags&elf.SHF_EXECINSTR != 0 {
 			info.Programs = append(info.Programs, sym.Name)
+			info.Sections[sym.Name] = sec.Name
 		}
 
 		if mapsSectionIdx >= 0 && int(sym.Section) == mapsSectionIdx {
 			info.Maps = append(info.Maps, sym.Name)
 		}
+
+		if v, ok := variableFromSymbol(sym, sec); ok {
+			info.Variables = append(info.Variables, v)
+		}
 	}
 
 	sort.Strings(info.Programs)
 	sort.Strings(info.Maps)
+	sort.Slice(info.Variables, func(i, j int) bool { return info.Variables[i].Name < info.Variables[j].Name })
 
 	if len(info.Programs) == 0 {
 		return nil, fmt.Errorf("no BPF programs found in %q", path)
 	}
 
+	if err := info.readBTF(f); err != nil {
+		return nil, fmt.Errorf("%q: %w", path, err)
+	}
+
 	return &info, nil
 }
 
+// readBTF populates MapDefs and Types from the object's .BTF section, if any.
+func (info *ELFInfo) readBTF(f *elf.File) error {
+	spec, err := loadBTF(f)
+	if err != nil || spec == nil {
+		return err
+	}
+	defs, err := mapDefsFromBTF(spec)
+	if err != nil {
+		return err
+	}
+	info.spec = spec
+	info.MapDefs = defs
+	info.Types = collectMapTypes(defs)
+	variableTypesFromBTF(spec, info.Variables)
+	for _, v := range info.Variables {
+		if v.Type != nil {
+			info.Types = addType(info.Types, v.Type)
+		}
+	}
+	return nil
+}
+
 // Generate produces formatted Go source code for loading the BPF objects.
 func Generate(pkg string, info *ELFInfo, embedPath string) ([]byte, error) {
 	if err := checkNameCollisions(info); err != nil {
 		return nil, err
 	}
+	decls, err := typeDecls(info.Types)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappers := newMapWrappers(info)
+	targets := attachTargets(info)
+	imports, kfuncs := loaderImports(info, embedPath, decls, wrappers, targets)
+	embedded := embedPath != "" || len(info.Variants) > 0
+
+	constants, writable := splitVariables(info.Variables)
 
 	var b strings.Builder
-	writeHeader(&b, pkg, embedPath)
-	if embedPath != "" {
+	writeHeader(&b, pkg, imports)
+	if len(info.Variants) > 0 {
+		writeVariants(&b, info.Variants, kfuncs)
+	} else if embedPath != "" {
 		writeEmbed(&b, embedPath)
 	}
-	writeObjectsStruct(&b)
+	if info.PinPath != "" {
+		writeDefaultPinPath(&b, info.PinPath)
+	}
+	writeTypes(&b, info.Types, decls, goTypeName)
+	writeObjectsStruct(&b, len(writable) > 0)
 	writeProgramsStruct(&b, info.Programs)
-	writeMapsStruct(&b, info.Maps)
-	if embedPath != "" {
-		writeEmbedLoadFunc(&b)
-	} else {
-		writeLoadFunc(&b)
+	writeMapsStruct(&b, wrappers)
+	writeMapWrappers(&b, wrappers)
+	writeReaders(&b, wrappers)
+	if len(constants) > 0 {
+		writeConfigStruct(&b, constants)
+	}
+	if len(writable) > 0 {
+		writeGlobalsStruct(&b, writable)
+	}
+	if len(targets) > 0 {
+		writeAttachOptions(&b)
+		writeLinksStruct(&b, targets)
+		writeAttachMethods(&b, targets)
+		writeLinksPin(&b, targets, info.PinPath != "")
+	}
+	writeSpecsStructs(&b, info.Programs, info.Maps)
+	writeLoadFuncs(&b, embedded, len(info.Variants) > 0)
+	writeLoadSpecFunc(&b, embedded)
+	if len(info.Maps) > 0 {
+		writeIncompatiblePinError(&b, info.Maps)
+	}
+	writeLoadPinnedFuncs(&b, embedded, len(info.Maps) > 0, info.PinPath != "")
+	if len(info.Maps) > 0 {
+		writeIncompatibleMapError(&b)
+		writeMapsReplacements(&b, wrappers)
+	}
+	writeReloadFuncs(&b, targets, len(info.Maps) > 0, embedded, len(info.Variants) > 0)
+	if len(constants) > 0 {
+		writeConfigLoadFuncs(&b, embedded)
 	}
 	writeObjectsClose(&b)
 	writeProgramsClose(&b, info.Programs)
-	writeMapsClose(&b, info.Maps)
+	writeProgramsPin(&b, info.Programs, info.PinPath != "")
+	writeMapsClose(&b, wrappers)
 
 	src, err := format.Source([]byte(b.String()))
 	if err != nil {
@@ -96,6 +218,34 @@ func Generate(pkg string, info *ELFInfo, embedPath string) ([]byte, error) {
 	return src, nil
 }
 
+// loaderImports returns the imports of the loader Generate writes, and
+// whether its variants need the haveKfunc probe.
+func loaderImports(info *ELFInfo, embedPath string, decls []string, wrappers []mapWrapper, targets []attachTarget) (importSet, bool) {
+	imports := importSet{"errors": true, "fmt": true, "path/filepath": true, "github.com/cilium/ebpf": true}
+	if embedPath != "" {
+		imports["bytes"] = true
+		imports["embed"] = true
+	}
+	var kfuncs bool
+	if len(info.Variants) > 0 {
+		kfuncs = variantImports(imports, info.Variants)
+	}
+	for _, d := range decls {
+		if strings.Contains(d, "structs.HostLayout") {
+			imports["structs"] = true
+		}
+	}
+	addReaderImports(imports, wrappers)
+	addReplayImports(imports, wrappers)
+	if len(targets) > 0 {
+		imports["github.com/cilium/ebpf/link"] = true
+	}
+	if len(info.Maps) > 0 || len(targets) > 0 {
+		imports["os"] = true
+	}
+	return imports, kfuncs
+}
+
 // exportedName converts a snake_case symbol name to PascalCase.
 func exportedName(s string) string {
 	var b strings.Builder
@@ -114,8 +264,110 @@ func exportedName(s string) string {
 	return b.String()
 }
 
+// reservedNames are the top-level identifiers every generated file declares.
+var reservedNames = []string{
+	"Objects", "Programs", "Maps", "Load", "LoadWithOptions",
+	"Specs", "ProgramSpecs", "MapSpecs", "LoadSpec", "LoadPinned",
+}
+
+// topLevelNames returns the top-level identifiers the generated loader
+// declares, each mapped to what it was derived from.
+func topLevelNames(info *ELFInfo) (map[string]string, error) {
+	top := make(map[string]string)
+	for _, name := range reservedNames {
+		top[name] = "generated " + name
+	}
+	if targets := attachTargets(info); len(targets) > 0 {
+		top["AttachOptions"] = "generated AttachOptions"
+		top["Links"] = "generated Links"
+		top["LoadPinnedLinks"] = "generated LoadPinnedLinks"
+		for _, t := range targets {
+			if t.field == "All" {
+				return nil, fmt.Errorf("name collision: program %q attach method conflicts with AttachAll", t.symbol)
+			}
+		}
+	}
+	if len(info.Maps) > 0 {
+		top["IncompatiblePinError"] = "generated IncompatiblePinError"
+		top["IncompatibleMapError"] = "generated IncompatibleMapError"
+	}
+	if info.PinPath != "" {
+		top["DefaultPinPath"] = "generated DefaultPinPath"
+	}
+	if len(info.Variants) > 0 {
+		top["Variant"] = "generated Variant"
+	}
+	for _, w := range newMapWrappers(info) {
+		if w.hasReader() {
+			top["RecordedSample"] = "generated RecordedSample"
+		}
+	}
+	constants, writable := splitVariables(info.Variables)
+	if len(constants) > 0 {
+		top["Config"] = "generated Config"
+		top["DefaultConfig"] = "generated DefaultConfig"
+		top["LoadWithConfig"] = "generated LoadWithConfig"
+	}
+	if len(writable) > 0 {
+		top["Globals"] = "generated Globals"
+	}
+	for _, t := range info.Types {
+		exported := goTypeName(t)
+		if prev, ok := top[exported]; ok {
+			return nil, fmt.Errorf("name collision: %q and type %q both map to %q", prev, t.TypeName(), exported)
+		}
+		top[exported] = "type " + t.TypeName()
+	}
+	for _, name := range info.Maps {
+		wrapper := mapWrapperName(name)
+		if prev, ok := top[wrapper]; ok {
+			return nil, fmt.Errorf("name collision: %q and map %q wrapper both map to %q", prev, name, wrapper)
+		}
+		top[wrapper] = "map " + name
+		if info.MapDefs[name].Inner != nil {
+			inner := innerMapWrapperName(name)
+			if prev, ok := top[inner]; ok {
+				return nil, fmt.Errorf("name collision: %q and map %q inner map wrapper both map to %q", prev, name, inner)
+			}
+			top[inner] = "map " + name + " inner map"
+		}
+		if kind, _ := classifyMap(info.MapDefs[name].Type); kind == mapKindRingBuf || kind == mapKindPerfEventArray {
+			reader := readerName(name)
+			if prev, ok := top[reader]; ok {
+				return nil, fmt.Errorf("name collision: %q and map %q reader both map to %q", prev, name, reader)
+			}
+			top[reader] = "map " + name + " reader"
+			for _, replay := range []string{replayName(name), "New" + replayName(name)} {
+				if prev, ok := top[replay]; ok {
+					return nil, fmt.Errorf("name collision: %q and map %q replay both map to %q", prev, name, replay)
+				}
+				top[replay] = "map " + name + " replay"
+			}
+		}
+	}
+	for _, v := range writable {
+		wrapper := variableWrapperName(v.Name)
+		if prev, ok := top[wrapper]; ok {
+			return nil, fmt.Errorf("name collision: %q and variable %q wrapper both map to %q", prev, v.Name, wrapper)
+		}
+		top[wrapper] = "variable " + v.Name
+	}
+	return top, nil
+}
+
 func checkNameCollisions(info *ELFInfo) error {
+	if _, err := topLevelNames(info); err != nil {
+		return err
+	}
+	constants, writable := splitVariables(info.Variables)
+
 	seen := make(map[string]string)
+	for _, method := range specsMethods {
+		seen[method] = "Specs." + method
+	}
+	for _, method := range pinMethods {
+		seen[method] = "method " + method
+	}
 	for _, name := range info.Programs {
 		exported := exportedName(name)
 		if prev, ok := seen[exported]; ok {
@@ -130,47 +382,82 @@ func checkNameCollisions(info *ELFInfo) error {
 		}
 		seen[exported] = name
 	}
+	for _, v := range writable {
+		exported := goVariableName(v.Name)
+		if prev, ok := seen[exported]; ok {
+			return fmt.Errorf("name collision: %q and %q both map to %q", prev, v.Name, exported)
+		}
+		seen[exported] = v.Name
+	}
+	configFields := make(map[string]string)
+	for _, v := range constants {
+		exported := goVariableName(v.Name)
+		if prev, ok := configFields[exported]; ok {
+			return fmt.Errorf("name collision: %q and %q both map to %q", prev, v.Name, exported)
+		}
+		configFields[exported] = v.Name
+	}
 	return nil
 }
 
-func writeHeader(b *strings.Builder, pkg, embedPath string) {
+// importSet collects the import paths a generated file needs.
+type importSet map[string]bool
+
+// sorted returns the standard library and third-party import paths, each sorted.
+func (s importSet) sorted() (std, ext []string) {
+	for path := range s {
+		if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+			ext = append(ext, path)
+		} else {
+			std = append(std, path)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(ext)
+	return std, ext
+}
+
+func writeHeader(b *strings.Builder, pkg string, imports importSet) {
 	fmt.Fprintf(b, "// Code generated by tinybpf; DO NOT EDIT.\n\n")
 	fmt.Fprintf(b, "package %s\n\n", pkg)
+	std, ext := imports.sorted()
 	fmt.Fprintf(b, "import (\n")
-	if embedPath != "" {
-		fmt.Fprintf(b, "\t\"bytes\"\n")
-		fmt.Fprintf(b, "\t_ \"embed\"\n")
+	for _, path := range std {
+		if path == "embed" {
+			fmt.Fprintf(b, "\t_ \"embed\"\n")
+			continue
+		}
+		fmt.Fprintf(b, "\t%q\n", path)
+	}
+	if len(std) > 0 && len(ext) > 0 {
+		fmt.Fprintf(b, "\n")
+	}
+	for _, path := range ext {
+		fmt.Fprintf(b, "\t%q\n", path)
 	}
-	fmt.Fprintf(b, "\t\"fmt\"\n\n")
-	fmt.Fprintf(b, "\t\"github.com/cilium/ebpf\"\n")
 	fmt.Fprintf(b, ")\n\n")
 }
 
+func writeTypes(b *strings.Builder, types []btf.Type, decls []string, name func(btf.Type) string) {
+	for i, d := range decls {
+		fmt.Fprintf(b, "// %s mirrors the BTF type %s.\n", name(types[i]), types[i].TypeName())
+		fmt.Fprintf(b, "%s\n\n", d)
+	}
+}
+
 func writeEmbed(b *strings.Builder, embedPath string) {
 	fmt.Fprintf(b, "//go:embed %s\n", embedPath)
 	fmt.Fprintf(b, "var _bpfBytes []byte\n\n")
 }
 
-func writeEmbedLoadFunc(b *strings.Builder) {
-	fmt.Fprintf(b, "// Load loads the embedded BPF object and returns populated Objects.\n")
-	fmt.Fprintf(b, "func Load() (*Objects, error) {\n")
-	fmt.Fprintf(b, "\tspec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(_bpfBytes))\n")
-	fmt.Fprintf(b, "\tif err != nil {\n")
-	fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"load BPF spec: %%w\", err)\n")
-	fmt.Fprintf(b, "\t}\n")
-	fmt.Fprintf(b, "\tvar objs Objects\n")
-	fmt.Fprintf(b, "\tif err := spec.LoadAndAssign(&objs, nil); err != nil {\n")
-	fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"load and assign: %%w\", err)\n")
-	fmt.Fprintf(b, "\t}\n")
-	fmt.Fprintf(b, "\treturn &objs, nil\n")
-	fmt.Fprintf(b, "}\n\n")
-}
-
-func writeObjectsStruct(b *strings.Builder) {
+func writeObjectsStruct(b *strings.Builder, globals bool) {
 	fmt.Fprintf(b, "// Objects contains all programs and maps from the BPF object.\n")
 	fmt.Fprintf(b, "type Objects struct {\n")
 	fmt.Fprintf(b, "\tPrograms\n")
 	fmt.Fprintf(b, "\tMaps\n")
+	if globals {
+		fmt.Fprintf(b, "\tGlobals\n")
+	}
 	fmt.Fprintf(b, "}\n\n")
 }
 
@@ -183,24 +470,60 @@ func writeProgramsStruct(b *strings.Builder, programs []string) {
 	fmt.Fprintf(b, "}\n\n")
 }
 
-func writeMapsStruct(b *strings.Builder, maps []string) {
-	fmt.Fprintf(b, "// Maps contains all BPF maps.\n")
-	fmt.Fprintf(b, "type Maps struct {\n")
-	for _, name := range maps {
-		fmt.Fprintf(b, "\t%s *ebpf.Map `ebpf:\"%s\"`\n", exportedName(name), name)
+func writeLoadFuncs(b *strings.Builder, embedded, variants bool) {
+	source, params, args := "the BPF object from objectPath", "objectPath string", "objectPath"
+	loadSpec := "ebpf.LoadCollectionSpec(objectPath)"
+	if embedded {
+		source, params, args = "the embedded BPF object", "", ""
+		loadSpec = "ebpf.LoadCollectionSpecFromReader(bytes.NewReader(_bpfBytes))"
+	}
+	if variants {
+		source = "the embedded BPF object variant best supported by the kernel"
+		loadSpec = "ebpf.LoadCollectionSpecFromReader(bytes.NewReader(v.object))"
+	}
+	withOptions := "opts *ebpf.CollectionOptions"
+	if params != "" {
+		withOptions = params + ", " + withOptions
 	}
-	fmt.Fprintf(b, "}\n\n")
-}
 
-func writeLoadFunc(b *strings.Builder) {
-	fmt.Fprintf(b, "// Load loads the BPF object from objectPath and returns populated Objects.\n")
-	fmt.Fprintf(b, "func Load(objectPath string) (*Objects, error) {\n")
-	fmt.Fprintf(b, "\tspec, err := ebpf.LoadCollectionSpec(objectPath)\n")
+	fmt.Fprintf(b, "// loadCollectionSpec parses %s.\n", source)
+	fmt.Fprintf(b, "func loadCollectionSpec(%s) (*ebpf.CollectionSpec, error) {\n", params)
+	if variants {
+		fmt.Fprintf(b, "\tv, err := selectVariant()\n")
+		fmt.Fprintf(b, "\tif err != nil {\n")
+		fmt.Fprintf(b, "\t\treturn nil, err\n")
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\tspec, err := %s\n", loadSpec)
 	fmt.Fprintf(b, "\tif err != nil {\n")
 	fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"load BPF spec: %%w\", err)\n")
 	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn spec, nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Load loads %s and returns populated Objects.\n", source)
+	fmt.Fprintf(b, "func Load(%s) (*Objects, error) {\n", params)
+	if args != "" {
+		fmt.Fprintf(b, "\treturn LoadWithOptions(%s, nil)\n", args)
+	} else {
+		fmt.Fprintf(b, "\treturn LoadWithOptions(nil)\n")
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// LoadWithOptions is like Load, but passes opts (e.g. verifier log settings\n")
+	fmt.Fprintf(b, "// or MapReplacements) to the loader. opts may be nil.\n")
+	fmt.Fprintf(b, "func LoadWithOptions(%s) (*Objects, error) {\n", withOptions)
+	fmt.Fprintf(b, "\tspec, err := loadCollectionSpec(%s)\n", args)
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn loadObjects(spec, opts)\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// loadObjects loads spec into the kernel and assigns its programs and maps.\n")
+	fmt.Fprintf(b, "func loadObjects(spec *ebpf.CollectionSpec, opts *ebpf.CollectionOptions) (*Objects, error) {\n")
 	fmt.Fprintf(b, "\tvar objs Objects\n")
-	fmt.Fprintf(b, "\tif err := spec.LoadAndAssign(&objs, nil); err != nil {\n")
+	fmt.Fprintf(b, "\tif err := spec.LoadAndAssign(&objs, opts); err != nil {\n")
 	fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"load and assign: %%w\", err)\n")
 	fmt.Fprintf(b, "\t}\n")
 	fmt.Fprintf(b, "\treturn &objs, nil\n")
@@ -228,14 +551,3 @@ func writeProgramsClose(b *strings.Builder, programs []string) {
 	}
 	fmt.Fprintf(b, "}\n\n")
 }
-
-func writeMapsClose(b *strings.Builder, maps []string) {
-	fmt.Fprintf(b, "// Close releases all maps.\n")
-	fmt.Fprintf(b, "func (m *Maps) Close() {\n")
-	for _, name := range maps {
-		fmt.Fprintf(b, "\tif m.%s != nil {\n", exportedName(name))
-		fmt.Fprintf(b, "\t\t_ = m.%s.Close()\n", exportedName(name))
-		fmt.Fprintf(b, "\t}\n")
-	}
-	fmt.Fprintf(b, "}\n")
-}
@@ -97,9 +97,11 @@ func TestGenerate(t *testing.T) {
 			},
 			contains: []string{
 				`TraceOpenat2 *ebpf.Program`,
-				`Events *ebpf.Map`,
+				`Events EventsMap`,
+				"type EventsMap struct",
+				"*ebpf.Map `ebpf:\"events\"`",
 				"p.TraceOpenat2.Close()",
-				"m.Events.Close()",
+				"m.Events.Map.Close()",
 			},
 		},
 		{
@@ -384,6 +386,9 @@ func TestExtractELFInfo(t *testing.T) {
 				if info.Programs[i] != name {
 					t.Errorf("programs[%d]: got %q, want %q", i, info.Programs[i], name)
 				}
+				if sec := info.Sections[name]; sec != ".text" {
+					t.Errorf("sections[%s]: got %q, want .text", name, sec)
+				}
 			}
 			if len(info.Maps) != len(tt.wantMaps) {
 				t.Fatalf("maps: got %v, want %v", info.Maps, tt.wantMaps)
@@ -0,0 +1,544 @@
+package codegen
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// hasFake reports whether the map gets an interface and an in-memory fake.
+func (w mapWrapper) hasFake() bool {
+	return w.kind != mapKindOpaque && w.kind != mapKindMapOfMaps
+}
+
+// lru reports whether the map evicts its least recently used entry when full.
+func (w mapWrapper) lru() bool {
+	return w.def.Type == ebpf.LRUHash || w.def.Type == ebpf.LRUCPUHash
+}
+
+// fakeTypeName returns the name of the map's fake: its reader's for ring
+// buffers and perf event arrays, its wrapper's otherwise.
+func (w mapWrapper) fakeTypeName() string {
+	if w.hasReader() {
+		return "Fake" + readerName(w.symbol)
+	}
+	return "Fake" + w.typeName
+}
+
+// interfaceName returns the name of the interface shared by the map's
+// generated type and its fake.
+func (w mapWrapper) interfaceName() string {
+	if w.hasReader() {
+		return readerName(w.symbol) + "API"
+	}
+	return w.typeName + "API"
+}
+
+// GenerateFakes produces formatted Go source, in the same package as the
+// loader from [Generate], declaring an interface for every map with typed
+// operations or a reader, and an in-memory fake implementing it. Userspace
+// code written against the interfaces can then be tested without loading
+// the BPF object.
+func GenerateFakes(pkg string, info *ELFInfo) ([]byte, error) {
+	if err := checkNameCollisions(info); err != nil {
+		return nil, err
+	}
+	top, err := topLevelNames(info)
+	if err != nil {
+		return nil, err
+	}
+
+	var wrappers []mapWrapper
+	for _, w := range newMapWrappers(info) {
+		if w.hasFake() {
+			wrappers = append(wrappers, w)
+		}
+	}
+	if len(wrappers) == 0 {
+		return nil, errors.New("no hash, array, ring buffer or perf event array maps to fake (build with --btf)")
+	}
+	for _, w := range wrappers {
+		for _, name := range []string{w.interfaceName(), w.fakeTypeName(), "New" + w.fakeTypeName()} {
+			if prev, ok := top[name]; ok {
+				return nil, fmt.Errorf("name collision: %q and map %q fake both map to %q", prev, w.symbol, name)
+			}
+			top[name] = "map " + w.symbol + " fake"
+		}
+	}
+
+	imports := importSet{}
+	var hashes, arrays, queues bool
+	for _, w := range wrappers {
+		switch w.kind {
+		case mapKindHash:
+			hashes = true
+		case mapKindArray:
+			arrays = true
+		default:
+			queues = true
+		}
+		if w.perCPU {
+			imports["slices"] = true
+		}
+	}
+	if hashes {
+		imports["slices"] = true
+	}
+	if hashes || arrays {
+		for _, path := range []string{"fmt", "sync", "syscall", "github.com/cilium/ebpf"} {
+			imports[path] = true
+		}
+	}
+	addReaderImports(imports, wrappers)
+	if queues {
+		imports["sync"] = true
+		delete(imports, "encoding/binary")
+	}
+
+	var b strings.Builder
+	writeHeader(&b, pkg, imports)
+	for _, w := range wrappers {
+		writeFakeInterface(&b, w)
+		switch w.kind {
+		case mapKindHash, mapKindArray:
+			writeFakeMap(&b, w)
+		default:
+			writeFakeReader(&b, w)
+		}
+	}
+	if hashes {
+		writeFakeHashStore(&b)
+	}
+	if arrays {
+		writeFakeArrayStore(&b)
+	}
+	if queues {
+		writeFakeQueue(&b)
+	}
+
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("format generated fakes: %w", err)
+	}
+	return src, nil
+}
+
+func writeFakeInterface(b *strings.Builder, w mapWrapper) {
+	impl := w.typeName
+	if w.hasReader() {
+		impl = readerName(w.symbol)
+	}
+	if w.hasReader() {
+		fmt.Fprintf(b, "// %s is implemented by %s, %s and %s.\n", w.interfaceName(), impl, replayName(w.symbol), w.fakeTypeName())
+	} else {
+		fmt.Fprintf(b, "// %s is implemented by %s and %s.\n", w.interfaceName(), impl, w.fakeTypeName())
+	}
+	if !w.hasReader() {
+		fmt.Fprintf(b, "// Batch operations are only available on %s.\n", w.typeName)
+	}
+	fmt.Fprintf(b, "type %s interface {\n", w.interfaceName())
+	if w.hasReader() {
+		rec := w.recordType()
+		fmt.Fprintf(b, "\tRead(ctx context.Context) (%s, error)\n", rec)
+		fmt.Fprintf(b, "\tAll(ctx context.Context) iter.Seq2[%s, error]\n", rec)
+		if w.kind == mapKindPerfEventArray {
+			fmt.Fprintf(b, "\tLostSamples() []uint64\n")
+		}
+		fmt.Fprintf(b, "\tClose() error\n")
+	} else {
+		k := w.keyParam()
+		fmt.Fprintf(b, "\tLookup(%s %s) (%s, error)\n", k, w.key, w.valueType())
+		fmt.Fprintf(b, "\tPut(%s %s, value %s) error\n", k, w.key, w.valueType())
+		if w.kind == mapKindHash {
+			fmt.Fprintf(b, "\tDelete(key %s) error\n", w.key)
+		}
+		fmt.Fprintf(b, "\tIterate(fn func(%s %s, value %s) bool) error\n", k, w.key, w.valueType())
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "var (\n")
+	if w.hasReader() {
+		fmt.Fprintf(b, "\t_ %s = (*%s)(nil)\n", w.interfaceName(), impl)
+		fmt.Fprintf(b, "\t_ %s = (*%s)(nil)\n", w.interfaceName(), replayName(w.symbol))
+	} else {
+		fmt.Fprintf(b, "\t_ %s = %s{}\n", w.interfaceName(), impl)
+	}
+	fmt.Fprintf(b, "\t_ %s = (*%s)(nil)\n", w.interfaceName(), w.fakeTypeName())
+	fmt.Fprintf(b, ")\n\n")
+}
+
+// fakeMapDoc describes the semantics a map's fake reproduces.
+func fakeMapDoc(w mapWrapper) string {
+	var s string
+	switch {
+	case w.kind == mapKindArray:
+		s = fmt.Sprintf("It has %d zeroed entries; Put fails beyond them.", w.def.MaxEntries)
+	case w.lru():
+		s = fmt.Sprintf("It holds up to %d entries and evicts the least recently used one when full.", w.def.MaxEntries)
+	default:
+		s = fmt.Sprintf("It holds up to %d entries; Put fails with E2BIG when full.", w.def.MaxEntries)
+	}
+	if w.def.Type == ebpf.LPMTrie {
+		s += " Keys match exactly, not by longest prefix."
+	}
+	if w.perCPU {
+		s += " Each entry holds one value per CPU."
+	}
+	return s
+}
+
+func writeFakeMap(b *strings.Builder, w mapWrapper) {
+	name := w.fakeTypeName()
+	k := w.keyParam()
+	value := w.valueType()
+	store := fmt.Sprintf("fakeHash[%s, %s]", w.key, value)
+	if w.kind == mapKindArray {
+		store = fmt.Sprintf("fakeArray[%s]", value)
+	}
+
+	fmt.Fprintf(b, "// %s is an in-memory %s with the semantics of the %s map (%s).\n", name, w.interfaceName(), w.symbol, w.def.Type)
+	fmt.Fprintf(b, "// %s It is safe for concurrent use.\n", fakeMapDoc(w))
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	if w.perCPU {
+		fmt.Fprintf(b, "\tcpus    int\n")
+	}
+	fmt.Fprintf(b, "\tentries *%s\n", store)
+	fmt.Fprintf(b, "}\n\n")
+
+	state := "an empty"
+	if w.kind == mapKindArray {
+		state = "a zeroed"
+	}
+	if w.perCPU {
+		fmt.Fprintf(b, "// New%s returns %s %s for cpus possible CPUs.\n", name, state, name)
+		fmt.Fprintf(b, "func New%s(cpus int) *%s {\n", name, name)
+	} else {
+		fmt.Fprintf(b, "// New%s returns %s %s.\n", name, state, name)
+		fmt.Fprintf(b, "func New%s() *%s {\n", name, name)
+	}
+	switch {
+	case w.kind == mapKindHash && w.perCPU:
+		fmt.Fprintf(b, "\treturn &%s{cpus: cpus, entries: newFakeHash[%s, %s](%d, %t)}\n", name, w.key, value, w.def.MaxEntries, w.lru())
+	case w.kind == mapKindHash:
+		fmt.Fprintf(b, "\treturn &%s{entries: newFakeHash[%s, %s](%d, %t)}\n", name, w.key, value, w.def.MaxEntries, w.lru())
+	case w.perCPU:
+		fmt.Fprintf(b, "\tvalues := make([]%s, %d)\n", value, w.def.MaxEntries)
+		fmt.Fprintf(b, "\tfor i := range values {\n")
+		fmt.Fprintf(b, "\t\tvalues[i] = make(%s, cpus)\n", value)
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\treturn &%s{cpus: cpus, entries: &fakeArray[%s]{values: values}}\n", name, value)
+	default:
+		fmt.Fprintf(b, "\treturn &%s{entries: &fakeArray[%s]{values: make([]%s, %d)}}\n", name, value, value, w.def.MaxEntries)
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	result := "value"
+	if w.perCPU {
+		result = "slices.Clone(value)"
+	}
+
+	fmt.Fprintf(b, "// Lookup returns the value stored at %s, or an error wrapping\n", k)
+	fmt.Fprintf(b, "// ebpf.ErrKeyNotExist if there is none.\n")
+	fmt.Fprintf(b, "func (m *%s) Lookup(%s %s) (%s, error) {\n", name, k, w.key, value)
+	fmt.Fprintf(b, "\tvalue, ok := m.entries.lookup(%s)\n", k)
+	fmt.Fprintf(b, "\tif !ok {\n")
+	fmt.Fprintf(b, "\t\treturn value, fmt.Errorf(\"lookup %s: %%w\", ebpf.ErrKeyNotExist)\n", w.symbol)
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn %s, nil\n", result)
+	fmt.Fprintf(b, "}\n\n")
+
+	if w.perCPU {
+		fmt.Fprintf(b, "// Put stores one value per CPU at %s.\n", k)
+	} else {
+		fmt.Fprintf(b, "// Put stores value at %s, creating or replacing the entry.\n", k)
+	}
+	fmt.Fprintf(b, "func (m *%s) Put(%s %s, value %s) error {\n", name, k, w.key, value)
+	stored := "value"
+	if w.perCPU {
+		fmt.Fprintf(b, "\tif len(value) != m.cpus {\n")
+		fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"put %s: %%d values for %%d CPUs\", len(value), m.cpus)\n", w.symbol)
+		fmt.Fprintf(b, "\t}\n")
+		stored = "slices.Clone(value)"
+	}
+	fmt.Fprintf(b, "\tif err := m.entries.put(%s, %s); err != nil {\n", k, stored)
+	fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"put %s: %%w\", err)\n", w.symbol)
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	if w.kind == mapKindHash {
+		fmt.Fprintf(b, "// Delete removes the entry stored at key, or returns an error wrapping\n")
+		fmt.Fprintf(b, "// ebpf.ErrKeyNotExist if there is none.\n")
+		fmt.Fprintf(b, "func (m *%s) Delete(key %s) error {\n", name, w.key)
+		fmt.Fprintf(b, "\tif !m.entries.delete(key) {\n")
+		fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"delete %s: %%w\", ebpf.ErrKeyNotExist)\n", w.symbol)
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\treturn nil\n")
+		fmt.Fprintf(b, "}\n\n")
+	}
+
+	order := "in index order"
+	if w.kind == mapKindHash {
+		order = "in insertion order"
+		if w.lru() {
+			order = "from least to most recently used"
+		}
+	}
+	fmt.Fprintf(b, "// Iterate calls fn for each entry, %s, until fn returns false.\n", order)
+	fmt.Fprintf(b, "func (m *%s) Iterate(fn func(%s %s, value %s) bool) error {\n", name, k, w.key, value)
+	fmt.Fprintf(b, "\tkeys, values := m.entries.snapshot()\n")
+	fmt.Fprintf(b, "\tfor i, value := range values {\n")
+	fmt.Fprintf(b, "\t\tif !fn(keys[i], %s) {\n", result)
+	fmt.Fprintf(b, "\t\t\treturn nil\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeFakeReader(b *strings.Builder, w mapWrapper) {
+	name := w.fakeTypeName()
+	rec := w.recordType()
+
+	fmt.Fprintf(b, "// %s is an in-memory %s that returns the records\n", name, w.interfaceName())
+	fmt.Fprintf(b, "// queued with Push in order, as the %s %s would.\n", w.symbol, w.transport())
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	fmt.Fprintf(b, "\trecords *fakeQueue[%s]\n", rec)
+	if w.kind == mapKindPerfEventArray {
+		fmt.Fprintf(b, "\tlost    []atomic.Uint64\n")
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	if w.kind == mapKindPerfEventArray {
+		fmt.Fprintf(b, "// New%s returns an empty %s for cpus CPUs.\n", name, name)
+		fmt.Fprintf(b, "func New%s(cpus int) *%s {\n", name, name)
+		fmt.Fprintf(b, "\treturn &%s{records: newFakeQueue[%s](), lost: make([]atomic.Uint64, cpus)}\n", name, rec)
+	} else {
+		fmt.Fprintf(b, "// New%s returns an empty %s.\n", name, name)
+		fmt.Fprintf(b, "func New%s() *%s {\n", name, name)
+		fmt.Fprintf(b, "\treturn &%s{records: newFakeQueue[%s]()}\n", name, rec)
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Push queues a record for Read, waking a blocked reader.\n")
+	fmt.Fprintf(b, "func (r *%s) Push(event %s) {\n", name, rec)
+	if w.event == "" {
+		fmt.Fprintf(b, "\tr.records.push(bytes.Clone(event))\n")
+	} else {
+		fmt.Fprintf(b, "\tr.records.push(event)\n")
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	if w.kind == mapKindPerfEventArray {
+		fmt.Fprintf(b, "// Lose records n samples as dropped on cpu, as reported by LostSamples.\n")
+		fmt.Fprintf(b, "func (r *%s) Lose(cpu int, n uint64) {\n", name)
+		fmt.Fprintf(b, "\tr.lost[cpu].Add(n)\n")
+		fmt.Fprintf(b, "}\n\n")
+
+		fmt.Fprintf(b, "// LostSamples returns, indexed by CPU, the number of samples recorded by Lose.\n")
+		fmt.Fprintf(b, "func (r *%s) LostSamples() []uint64 {\n", name)
+		fmt.Fprintf(b, "\tlost := make([]uint64, len(r.lost))\n")
+		fmt.Fprintf(b, "\tfor cpu := range r.lost {\n")
+		fmt.Fprintf(b, "\t\tlost[cpu] = r.lost[cpu].Load()\n")
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\treturn lost\n")
+		fmt.Fprintf(b, "}\n\n")
+	}
+
+	fmt.Fprintf(b, "// Read blocks until a record has been pushed and returns it. It returns\n")
+	fmt.Fprintf(b, "// ctx.Err() once ctx is done and %s.ErrClosed after Close.\n", w.readerPackage())
+	fmt.Fprintf(b, "func (r *%s) Read(ctx context.Context) (%s, error) {\n", name, rec)
+	fmt.Fprintf(b, "\treturn r.records.read(ctx, %s.ErrClosed)\n", w.readerPackage())
+	fmt.Fprintf(b, "}\n\n")
+
+	writeReaderAll(b, w, name, false)
+
+	fmt.Fprintf(b, "// Close interrupts any blocked Read; later reads fail.\n")
+	fmt.Fprintf(b, "func (r *%s) Close() error {\n", name)
+	fmt.Fprintf(b, "\tr.records.close()\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeFakeHashStore(b *strings.Builder) {
+	fmt.Fprintf(b, "// fakeHash stores the entries of a fake hash map.\n")
+	fmt.Fprintf(b, "type fakeHash[K comparable, V any] struct {\n")
+	fmt.Fprintf(b, "\tmu         sync.Mutex\n")
+	fmt.Fprintf(b, "\tmaxEntries int\n")
+	fmt.Fprintf(b, "\tlru        bool\n")
+	fmt.Fprintf(b, "\tkeys       []K // insertion order, or least to most recently used\n")
+	fmt.Fprintf(b, "\tvalues     map[K]V\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func newFakeHash[K comparable, V any](maxEntries int, lru bool) *fakeHash[K, V] {\n")
+	fmt.Fprintf(b, "\treturn &fakeHash[K, V]{maxEntries: maxEntries, lru: lru, values: make(map[K]V)}\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// touch moves key to the most recently used end of h.keys.\n")
+	fmt.Fprintf(b, "func (h *fakeHash[K, V]) touch(key K) {\n")
+	fmt.Fprintf(b, "\tif i := slices.Index(h.keys, key); i >= 0 {\n")
+	fmt.Fprintf(b, "\t\th.keys = append(slices.Delete(h.keys, i, i+1), key)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (h *fakeHash[K, V]) lookup(key K) (V, bool) {\n")
+	fmt.Fprintf(b, "\th.mu.Lock()\n")
+	fmt.Fprintf(b, "\tdefer h.mu.Unlock()\n")
+	fmt.Fprintf(b, "\tvalue, ok := h.values[key]\n")
+	fmt.Fprintf(b, "\tif ok && h.lru {\n")
+	fmt.Fprintf(b, "\t\th.touch(key)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn value, ok\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (h *fakeHash[K, V]) put(key K, value V) error {\n")
+	fmt.Fprintf(b, "\th.mu.Lock()\n")
+	fmt.Fprintf(b, "\tdefer h.mu.Unlock()\n")
+	fmt.Fprintf(b, "\tif _, ok := h.values[key]; ok {\n")
+	fmt.Fprintf(b, "\t\th.values[key] = value\n")
+	fmt.Fprintf(b, "\t\tif h.lru {\n")
+	fmt.Fprintf(b, "\t\t\th.touch(key)\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\treturn nil\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tif len(h.keys) >= h.maxEntries {\n")
+	fmt.Fprintf(b, "\t\tif !h.lru || len(h.keys) == 0 {\n")
+	fmt.Fprintf(b, "\t\t\treturn fmt.Errorf(\"map is full (%%d entries): %%w\", h.maxEntries, syscall.E2BIG)\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tdelete(h.values, h.keys[0])\n")
+	fmt.Fprintf(b, "\t\th.keys = h.keys[1:]\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\th.keys = append(h.keys, key)\n")
+	fmt.Fprintf(b, "\th.values[key] = value\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (h *fakeHash[K, V]) delete(key K) bool {\n")
+	fmt.Fprintf(b, "\th.mu.Lock()\n")
+	fmt.Fprintf(b, "\tdefer h.mu.Unlock()\n")
+	fmt.Fprintf(b, "\tif _, ok := h.values[key]; !ok {\n")
+	fmt.Fprintf(b, "\t\treturn false\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tdelete(h.values, key)\n")
+	fmt.Fprintf(b, "\th.keys = slices.DeleteFunc(h.keys, func(k K) bool { return k == key })\n")
+	fmt.Fprintf(b, "\treturn true\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// snapshot returns a copy of the entries, so that callers may modify the map\n")
+	fmt.Fprintf(b, "// while iterating.\n")
+	fmt.Fprintf(b, "func (h *fakeHash[K, V]) snapshot() ([]K, []V) {\n")
+	fmt.Fprintf(b, "\th.mu.Lock()\n")
+	fmt.Fprintf(b, "\tdefer h.mu.Unlock()\n")
+	fmt.Fprintf(b, "\tvalues := make([]V, len(h.keys))\n")
+	fmt.Fprintf(b, "\tfor i, key := range h.keys {\n")
+	fmt.Fprintf(b, "\t\tvalues[i] = h.values[key]\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn slices.Clone(h.keys), values\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeFakeArrayStore(b *strings.Builder) {
+	fmt.Fprintf(b, "// fakeArray stores the entries of a fake array map.\n")
+	fmt.Fprintf(b, "type fakeArray[V any] struct {\n")
+	fmt.Fprintf(b, "\tmu     sync.Mutex\n")
+	fmt.Fprintf(b, "\tvalues []V\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (a *fakeArray[V]) lookup(index uint32) (V, bool) {\n")
+	fmt.Fprintf(b, "\ta.mu.Lock()\n")
+	fmt.Fprintf(b, "\tdefer a.mu.Unlock()\n")
+	fmt.Fprintf(b, "\tif int(index) >= len(a.values) {\n")
+	fmt.Fprintf(b, "\t\tvar zero V\n")
+	fmt.Fprintf(b, "\t\treturn zero, false\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn a.values[index], true\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (a *fakeArray[V]) put(index uint32, value V) error {\n")
+	fmt.Fprintf(b, "\ta.mu.Lock()\n")
+	fmt.Fprintf(b, "\tdefer a.mu.Unlock()\n")
+	fmt.Fprintf(b, "\tif int(index) >= len(a.values) {\n")
+	fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"index %%d out of range (%%d entries): %%w\", index, len(a.values), syscall.E2BIG)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\ta.values[index] = value\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// snapshot returns the indexes and a copy of the values.\n")
+	fmt.Fprintf(b, "func (a *fakeArray[V]) snapshot() ([]uint32, []V) {\n")
+	fmt.Fprintf(b, "\ta.mu.Lock()\n")
+	fmt.Fprintf(b, "\tdefer a.mu.Unlock()\n")
+	fmt.Fprintf(b, "\tindexes := make([]uint32, len(a.values))\n")
+	fmt.Fprintf(b, "\tfor i := range indexes {\n")
+	fmt.Fprintf(b, "\t\tindexes[i] = uint32(i)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn indexes, append([]V(nil), a.values...)\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeFakeQueue(b *strings.Builder) {
+	fmt.Fprintf(b, "// fakeQueue holds the records pushed to a fake reader until they are read.\n")
+	fmt.Fprintf(b, "type fakeQueue[T any] struct {\n")
+	fmt.Fprintf(b, "\tmu      sync.Mutex\n")
+	fmt.Fprintf(b, "\trecords []T\n")
+	fmt.Fprintf(b, "\tready   chan struct{} // signalled while records are queued\n")
+	fmt.Fprintf(b, "\tclosed  chan struct{}\n")
+	fmt.Fprintf(b, "\tonce    sync.Once\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func newFakeQueue[T any]() *fakeQueue[T] {\n")
+	fmt.Fprintf(b, "\treturn &fakeQueue[T]{ready: make(chan struct{}, 1), closed: make(chan struct{})}\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// signal wakes a blocked reader, if there is not already a pending wakeup.\n")
+	fmt.Fprintf(b, "func (q *fakeQueue[T]) signal() {\n")
+	fmt.Fprintf(b, "\tselect {\n")
+	fmt.Fprintf(b, "\tcase q.ready <- struct{}{}:\n")
+	fmt.Fprintf(b, "\tdefault:\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (q *fakeQueue[T]) push(record T) {\n")
+	fmt.Fprintf(b, "\tq.mu.Lock()\n")
+	fmt.Fprintf(b, "\tq.records = append(q.records, record)\n")
+	fmt.Fprintf(b, "\tq.mu.Unlock()\n")
+	fmt.Fprintf(b, "\tq.signal()\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (q *fakeQueue[T]) read(ctx context.Context, errClosed error) (T, error) {\n")
+	fmt.Fprintf(b, "\tvar zero T\n")
+	fmt.Fprintf(b, "\tfor {\n")
+	fmt.Fprintf(b, "\t\tif err := ctx.Err(); err != nil {\n")
+	fmt.Fprintf(b, "\t\t\treturn zero, err\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tselect {\n")
+	fmt.Fprintf(b, "\t\tcase <-q.closed:\n")
+	fmt.Fprintf(b, "\t\t\treturn zero, errClosed\n")
+	fmt.Fprintf(b, "\t\tdefault:\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tq.mu.Lock()\n")
+	fmt.Fprintf(b, "\t\tif len(q.records) > 0 {\n")
+	fmt.Fprintf(b, "\t\t\trecord := q.records[0]\n")
+	fmt.Fprintf(b, "\t\t\tq.records = q.records[1:]\n")
+	fmt.Fprintf(b, "\t\t\tif len(q.records) > 0 {\n")
+	fmt.Fprintf(b, "\t\t\t\tq.signal()\n")
+	fmt.Fprintf(b, "\t\t\t}\n")
+	fmt.Fprintf(b, "\t\t\tq.mu.Unlock()\n")
+	fmt.Fprintf(b, "\t\t\treturn record, nil\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tq.mu.Unlock()\n")
+	fmt.Fprintf(b, "\t\tselect {\n")
+	fmt.Fprintf(b, "\t\tcase <-ctx.Done():\n")
+	fmt.Fprintf(b, "\t\tcase <-q.closed:\n")
+	fmt.Fprintf(b, "\t\tcase <-q.ready:\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (q *fakeQueue[T]) close() {\n")
+	fmt.Fprintf(b, "\tq.once.Do(func() { close(q.closed) })\n")
+	fmt.Fprintf(b, "}\n")
+}
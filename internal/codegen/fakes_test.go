@@ -0,0 +1,137 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestGenerateFakes(t *testing.T) {
+	tests := []struct {
+		name     string
+		def      MapDef
+		events   map[string]btf.Type
+		contains []string
+		absent   []string
+	}{
+		{
+			name: "hash",
+			def:  MapDef{Type: ebpf.Hash, Key: connKey, KeySize: 8, ValueSize: 8, MaxEntries: 1024},
+			contains: []string{
+				"type ConnsMapAPI interface {",
+				"Lookup(key ConnKey) (uint64, error)",
+				"Delete(key ConnKey) error",
+				"_ ConnsMapAPI = ConnsMap{}",
+				"_ ConnsMapAPI = (*FakeConnsMap)(nil)",
+				"// It holds up to 1024 entries; Put fails with E2BIG when full.",
+				"return &FakeConnsMap{entries: newFakeHash[ConnKey, uint64](1024, false)}",
+				`return value, fmt.Errorf("lookup conns: %w", ebpf.ErrKeyNotExist)`,
+				"func (m *FakeConnsMap) Iterate(fn func(key ConnKey, value uint64) bool) error {",
+				"type fakeHash[K comparable, V any] struct {",
+				"syscall.E2BIG",
+			},
+			absent: []string{"BatchLookup", "fakeArray", "fakeQueue", `"context"`},
+		},
+		{
+			name: "LRU hash evicts",
+			def:  MapDef{Type: ebpf.LRUHash, KeySize: 4, ValueSize: 8, MaxEntries: 2},
+			contains: []string{
+				"evicts the least recently used one when full",
+				"newFakeHash[uint32, uint64](2, true)",
+				"from least to most recently used",
+			},
+		},
+		{
+			name: "per-CPU array",
+			def:  MapDef{Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: 8, MaxEntries: 4},
+			contains: []string{
+				"Lookup(index uint32) ([]uint64, error)",
+				"func NewFakeConnsMap(cpus int) *FakeConnsMap {",
+				"values[i] = make([]uint64, cpus)",
+				"if len(value) != m.cpus {",
+				"m.entries.put(index, slices.Clone(value))",
+				"type fakeArray[V any] struct {",
+			},
+			absent: []string{"Delete(", "fakeHash"},
+		},
+		{
+			name:   "ring buffer reader",
+			def:    MapDef{Type: ebpf.RingBuf, MaxEntries: 4096},
+			events: map[string]btf.Type{"conns": connEvent},
+			contains: []string{
+				"type ConnsReaderAPI interface {",
+				"Read(ctx context.Context) (ConnEvent, error)",
+				"_ ConnsReaderAPI = (*ConnsReader)(nil)",
+				"_ ConnsReaderAPI = (*ConnsReplay)(nil)",
+				"func (r *FakeConnsReader) Push(event ConnEvent) {",
+				"return r.records.read(ctx, ringbuf.ErrClosed)",
+				"func (r *FakeConnsReader) All(ctx context.Context) iter.Seq2[ConnEvent, error] {",
+				"type fakeQueue[T any] struct {",
+			},
+			absent: []string{"LostSamples", `"encoding/binary"`, "fakeHash"},
+		},
+		{
+			name: "raw perf event array reader",
+			def:  MapDef{Type: ebpf.PerfEventArray},
+			contains: []string{
+				"LostSamples() []uint64",
+				"func NewFakeConnsReader(cpus int) *FakeConnsReader {",
+				"r.records.push(bytes.Clone(event))",
+				"func (r *FakeConnsReader) Lose(cpu int, n uint64) {",
+				"perf.ErrClosed",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ELFInfo{
+				Programs: []string{"handler"},
+				Maps:     []string{"conns"},
+				MapDefs:  map[string]MapDef{"conns": tt.def},
+				Events:   tt.events,
+			}
+			info.Types = collectMapTypes(info.MapDefs)
+			for _, typ := range tt.events {
+				info.Types = addType(info.Types, typ)
+			}
+			src, err := GenerateFakes("loader", info)
+			if err != nil {
+				t.Fatalf("GenerateFakes: %v", err)
+			}
+			text := string(src)
+			for _, s := range append(tt.contains, "// Code generated by tinybpf; DO NOT EDIT.", "package loader") {
+				if !strings.Contains(text, s) {
+					t.Errorf("generated source missing %q\n%s", s, text)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(text, s) {
+					t.Errorf("generated source should not contain %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateFakesWithoutTypedMaps(t *testing.T) {
+	info := &ELFInfo{Programs: []string{"handler"}, Maps: []string{"progs"}}
+	_, err := GenerateFakes("loader", info)
+	if err == nil || !strings.Contains(err.Error(), "no hash, array, ring buffer or perf event array maps") {
+		t.Fatalf("expected missing maps error, got %v", err)
+	}
+}
+
+func TestFakeNameCollision(t *testing.T) {
+	info := &ELFInfo{
+		Programs: []string{"handler"},
+		Maps:     []string{"conns"},
+		MapDefs:  map[string]MapDef{"conns": {Type: ebpf.Hash, KeySize: 4, ValueSize: 8}},
+		Types:    []btf.Type{&btf.Struct{Name: "fake_conns_map"}},
+	}
+	_, err := GenerateFakes("loader", info)
+	if err == nil || !strings.Contains(err.Error(), "fake both map to") {
+		t.Fatalf("expected fake name collision, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package codegen
+
+import (
+	"debug/elf"
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// Variable describes a global variable in a data section of the object.
+type Variable struct {
+	Name    string // ELF symbol name, as used by the loader
+	Section string // .data, .bss or .rodata (or a named subsection)
+	Size    uint32
+	Offset  uint32
+
+	// Type is the variable's BTF type, or nil when the object has no BTF.
+	Type btf.Type
+}
+
+// ReadOnly reports whether the variable lives in .rodata and can only be set
+// before the programs load.
+func (v Variable) ReadOnly() bool {
+	return isDataSection(v.Section, ".rodata")
+}
+
+// isDataSection reports whether name is base or one of its named subsections
+// (e.g. ".data.config").
+func isDataSection(name, base string) bool {
+	return name == base || strings.HasPrefix(name, base+".")
+}
+
+// variableFromSymbol returns the variable for a global data symbol, if sym is one.
+func variableFromSymbol(sym elf.Symbol, sec *elf.Section) (Variable, bool) {
+	if !isDataSection(sec.Name, ".data") && !isDataSection(sec.Name, ".bss") && !isDataSection(sec.Name, ".rodata") {
+		return Variable{}, false
+	}
+	bind := elf.ST_BIND(sym.Info)
+	if bind != elf.STB_GLOBAL && bind != elf.STB_WEAK {
+		return Variable{}, false
+	}
+	if elf.ST_TYPE(sym.Info) != elf.STT_OBJECT || strings.HasPrefix(sym.Name, ".") {
+		return Variable{}, false
+	}
+	// The loader does not expose hidden symbols as variables.
+	if vis := elf.ST_VISIBILITY(sym.Other); vis == elf.STV_HIDDEN || vis == elf.STV_INTERNAL {
+		return Variable{}, false
+	}
+	return Variable{Name: sym.Name, Section: sec.Name, Size: uint32(sym.Size), Offset: uint32(sym.Value)}, true
+}
+
+// variableTypesFromBTF fills in the BTF type of each variable from its
+// section's DATASEC, matching variables by offset.
+func variableTypesFromBTF(spec *btf.Spec, vars []Variable) {
+	for i := range vars {
+		var ds *btf.Datasec
+		if err := spec.TypeByName(vars[i].Section, &ds); err != nil {
+			continue
+		}
+		for _, vsi := range ds.Vars {
+			if v, ok := vsi.Type.(*btf.Var); ok && vsi.Offset == vars[i].Offset {
+				vars[i].Type = v.Type
+				break
+			}
+		}
+	}
+}
+
+// goVariableName returns the exported Go identifier for a variable symbol,
+// dropping the package qualifier TinyGo adds (e.g. "main.targetPID").
+func goVariableName(symbol string) string {
+	if i := strings.LastIndexByte(symbol, '.'); i >= 0 {
+		symbol = symbol[i+1:]
+	}
+	return exportedName(symbol)
+}
+
+// variableWrapperName returns the wrapper type name for a variable symbol.
+func variableWrapperName(symbol string) string {
+	return goVariableName(symbol) + "Variable"
+}
+
+// splitVariables separates read-only variables from writable ones.
+func splitVariables(vars []Variable) (constants, writable []Variable) {
+	for _, v := range vars {
+		if v.ReadOnly() {
+			constants = append(constants, v)
+		} else {
+			writable = append(writable, v)
+		}
+	}
+	return constants, writable
+}
+
+func writeConfigStruct(b *strings.Builder, constants []Variable) {
+	fmt.Fprintf(b, "// Config holds the read-only (.rodata) variables. They are fixed when the\n")
+	fmt.Fprintf(b, "// programs load, so the verifier treats them as constants.\n")
+	fmt.Fprintf(b, "type Config struct {\n")
+	for _, v := range constants {
+		fmt.Fprintf(b, "\t%s %s\n", goVariableName(v.Name), goTypeExpr(v.Type, v.Size))
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// read copies the values compiled into spec into c.\n")
+	fmt.Fprintf(b, "func (c *Config) read(spec *ebpf.CollectionSpec) error {\n")
+	for _, v := range constants {
+		fmt.Fprintf(b, "\tif err := getVariable(spec, %q, &c.%s); err != nil {\n", v.Name, goVariableName(v.Name))
+		fmt.Fprintf(b, "\t\treturn err\n")
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Apply writes c into the read-only variables of spec. Call it before loading.\n")
+	fmt.Fprintf(b, "func (c Config) Apply(spec *ebpf.CollectionSpec) error {\n")
+	for _, v := range constants {
+		fmt.Fprintf(b, "\tif err := setVariable(spec, %q, c.%s); err != nil {\n", v.Name, goVariableName(v.Name))
+		fmt.Fprintf(b, "\t\treturn err\n")
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// getVariable reads the named variable of spec into out.\n")
+	fmt.Fprintf(b, "func getVariable(spec *ebpf.CollectionSpec, name string, out any) error {\n")
+	fmt.Fprintf(b, "\tv := spec.Variables[name]\n")
+	fmt.Fprintf(b, "\tif v == nil {\n")
+	fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"variable %%s not found\", name)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tif err := v.Get(out); err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"read variable %%s: %%w\", name, err)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// setVariable writes value into the named variable of spec.\n")
+	fmt.Fprintf(b, "func setVariable(spec *ebpf.CollectionSpec, name string, value any) error {\n")
+	fmt.Fprintf(b, "\tv := spec.Variables[name]\n")
+	fmt.Fprintf(b, "\tif v == nil {\n")
+	fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"variable %%s not found\", name)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tif err := v.Set(value); err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"set variable %%s: %%w\", name, err)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeGlobalsStruct(b *strings.Builder, writable []Variable) {
+	fmt.Fprintf(b, "// Globals contains the writable (.data and .bss) variables of the loaded programs.\n")
+	fmt.Fprintf(b, "type Globals struct {\n")
+	for _, v := range writable {
+		fmt.Fprintf(b, "\t%s %s\n", goVariableName(v.Name), variableWrapperName(v.Name))
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	for _, v := range writable {
+		name := variableWrapperName(v.Name)
+		typ := goTypeExpr(v.Type, v.Size)
+		fmt.Fprintf(b, "// %s wraps the %s variable (%s).\n", name, v.Name, v.Section)
+		fmt.Fprintf(b, "type %s struct {\n", name)
+		fmt.Fprintf(b, "\t*ebpf.Variable `ebpf:\"%s\"`\n", v.Name)
+		fmt.Fprintf(b, "}\n\n")
+
+		fmt.Fprintf(b, "// Get reads the current value.\n")
+		fmt.Fprintf(b, "func (v %s) Get() (%s, error) {\n", name, typ)
+		fmt.Fprintf(b, "\tvar value %s\n", typ)
+		fmt.Fprintf(b, "\terr := v.Variable.Get(&value)\n")
+		fmt.Fprintf(b, "\treturn value, err\n")
+		fmt.Fprintf(b, "}\n\n")
+
+		fmt.Fprintf(b, "// Set writes value, visible to the programs immediately.\n")
+		fmt.Fprintf(b, "func (v %s) Set(value %s) error {\n", name, typ)
+		fmt.Fprintf(b, "\treturn v.Variable.Set(value)\n")
+		fmt.Fprintf(b, "}\n\n")
+	}
+}
+
+func writeConfigLoadFuncs(b *strings.Builder, embedded bool) {
+	source, params, args := "the BPF object at objectPath", "objectPath string", "objectPath"
+	if embedded {
+		source, params, args = "the embedded BPF object", "", ""
+	}
+	withConfig := "cfg Config"
+	if params != "" {
+		withConfig = params + ", " + withConfig
+	}
+
+	fmt.Fprintf(b, "// DefaultConfig returns the read-only variable values compiled into %s.\n", source)
+	fmt.Fprintf(b, "func DefaultConfig(%s) (Config, error) {\n", params)
+	fmt.Fprintf(b, "\tvar cfg Config\n")
+	fmt.Fprintf(b, "\tspec, err := loadCollectionSpec(%s)\n", args)
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn cfg, err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\terr = cfg.read(spec)\n")
+	fmt.Fprintf(b, "\treturn cfg, err\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// LoadWithConfig is like Load, but sets the read-only variables from cfg\n")
+	fmt.Fprintf(b, "// before the programs are verified.\n")
+	fmt.Fprintf(b, "func LoadWithConfig(%s) (*Objects, error) {\n", withConfig)
+	fmt.Fprintf(b, "\tspec, err := loadCollectionSpec(%s)\n", args)
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tif err := cfg.Apply(spec); err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn loadObjects(spec, nil)\n")
+	fmt.Fprintf(b, "}\n\n")
+}
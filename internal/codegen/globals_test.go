@@ -0,0 +1,215 @@
+package codegen
+
+import (
+	"bytes"
+	"debug/elf"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestVariableFromSymbol(t *testing.T) {
+	object := byte(elf.STB_GLOBAL)<<4 | byte(elf.STT_OBJECT)
+	tests := []struct {
+		name    string
+		sym     elf.Symbol
+		section string
+		want    bool
+	}{
+		{"global in .data", elf.Symbol{Name: "main.count", Info: object, Size: 8, Value: 16}, ".data", true},
+		{"global in .bss", elf.Symbol{Name: "main.hits", Info: object, Size: 8}, ".bss", true},
+		{"global in .rodata", elf.Symbol{Name: "main.targetPID", Info: object, Size: 4}, ".rodata", true},
+		{"named subsection", elf.Symbol{Name: "main.limit", Info: object, Size: 4}, ".rodata.config", true},
+		{"weak symbol", elf.Symbol{Name: "main.mode", Info: byte(elf.STB_WEAK)<<4 | byte(elf.STT_OBJECT), Size: 4}, ".data", true},
+		{"local symbol", elf.Symbol{Name: "main.internal", Info: byte(elf.STB_LOCAL)<<4 | byte(elf.STT_OBJECT), Size: 4}, ".data", false},
+		{"hidden symbol", elf.Symbol{Name: "main.hidden", Info: object, Other: byte(elf.STV_HIDDEN), Size: 4}, ".data", false},
+		{"section symbol", elf.Symbol{Name: ".rodata", Info: object}, ".rodata", false},
+		{"map symbol", elf.Symbol{Name: "events", Info: object, Size: 16}, ".maps", false},
+		{"function", elf.Symbol{Name: "handler", Info: byte(elf.STB_GLOBAL)<<4 | byte(elf.STT_FUNC)}, ".text", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sec := &elf.Section{SectionHeader: elf.SectionHeader{Name: tt.section}}
+			v, ok := variableFromSymbol(tt.sym, sec)
+			if ok != tt.want {
+				t.Fatalf("variableFromSymbol() ok = %v, want %v", ok, tt.want)
+			}
+			if ok && (v.Name != tt.sym.Name || v.Section != tt.section || v.Size != uint32(tt.sym.Size) || v.Offset != uint32(tt.sym.Value)) {
+				t.Errorf("variableFromSymbol() = %+v", v)
+			}
+		})
+	}
+}
+
+func TestVariableTypesFromBTF(t *testing.T) {
+	rodata := &btf.Datasec{Name: ".rodata", Size: 12, Vars: []btf.VarSecinfo{
+		{Type: &btf.Var{Name: "main.targetPID", Type: btfU32, Linkage: btf.GlobalVar}, Offset: 0, Size: 4},
+		{Type: &btf.Var{Name: "main.key", Type: connKey, Linkage: btf.GlobalVar}, Offset: 4, Size: 8},
+	}}
+	spec, err := btf.LoadSpecFromReader(bytes.NewReader(marshalMapsBTF(t, nil, rodata)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := []Variable{
+		{Name: "main.key", Section: ".rodata", Size: 8, Offset: 4},
+		{Name: "main.targetPID", Section: ".rodata", Size: 4},
+		{Name: "main.hits", Section: ".bss", Size: 8},
+	}
+	variableTypesFromBTF(spec, vars)
+	if got := vars[0].Type; got == nil || got.TypeName() != "main_connKey" {
+		t.Errorf("main.key type = %v, want main_connKey", got)
+	}
+	if got := vars[1].Type; got == nil || got.TypeName() != "unsigned int" {
+		t.Errorf("main.targetPID type = %v, want unsigned int", got)
+	}
+	if vars[2].Type != nil {
+		t.Errorf("main.hits type = %v, want nil without a .bss DATASEC", vars[2].Type)
+	}
+}
+
+func TestGoVariableName(t *testing.T) {
+	tests := []struct {
+		symbol, want string
+	}{
+		{"main.targetPID", "TargetPID"},
+		{"github.com/x/probe.max_events", "MaxEvents"},
+		{"sample_rate", "SampleRate"},
+	}
+	for _, tt := range tests {
+		if got := goVariableName(tt.symbol); got != tt.want {
+			t.Errorf("goVariableName(%q) = %q, want %q", tt.symbol, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateGlobals(t *testing.T) {
+	tests := []struct {
+		name      string
+		vars      []Variable
+		embedPath string
+		contains  []string
+		absent    []string
+	}{
+		{
+			name: "read-only variables",
+			vars: []Variable{
+				{Name: "main.key", Section: ".rodata", Size: 8, Type: connKey},
+				{Name: "main.targetPID", Section: ".rodata", Size: 4, Type: btfU32},
+			},
+			contains: []string{
+				"type Config struct {\n\tKey       ConnKey\n\tTargetPID uint32\n}",
+				`getVariable(spec, "main.targetPID", &c.TargetPID)`,
+				"func (c Config) Apply(spec *ebpf.CollectionSpec) error {",
+				`setVariable(spec, "main.key", c.Key)`,
+				"func DefaultConfig(objectPath string) (Config, error) {",
+				"func LoadWithConfig(objectPath string, cfg Config) (*Objects, error) {",
+				"if err := cfg.Apply(spec); err != nil {",
+			},
+			absent: []string{"Globals"},
+		},
+		{
+			name:      "read-only variables with embedded object",
+			vars:      []Variable{{Name: "main.targetPID", Section: ".rodata", Size: 4, Type: btfU32}},
+			embedPath: "probe.bpf.o",
+			contains: []string{
+				"func DefaultConfig() (Config, error) {",
+				"func LoadWithConfig(cfg Config) (*Objects, error) {",
+			},
+		},
+		{
+			name: "writable variables",
+			vars: []Variable{
+				{Name: "main.hits", Section: ".bss", Size: 8, Type: btfU64},
+				{Name: "main.sample", Section: ".data", Size: 4},
+			},
+			contains: []string{
+				"type Objects struct {\n\tPrograms\n\tMaps\n\tGlobals\n}",
+				"type Globals struct {\n\tHits   HitsVariable\n\tSample SampleVariable\n}",
+				"*ebpf.Variable `ebpf:\"main.hits\"`",
+				"func (v HitsVariable) Get() (uint64, error) {",
+				"func (v HitsVariable) Set(value uint64) error {",
+				"func (v SampleVariable) Get() (uint32, error) {",
+			},
+			absent: []string{"Config", "getVariable"},
+		},
+		{
+			name:     "no variables",
+			contains: []string{"type Objects struct {\n\tPrograms\n\tMaps\n}"},
+			absent:   []string{"Config", "Globals"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ELFInfo{Programs: []string{"handler"}, Variables: tt.vars}
+			for _, v := range tt.vars {
+				if v.Type != nil {
+					info.Types = addType(info.Types, v.Type)
+				}
+			}
+			src, err := Generate("loader", info, tt.embedPath)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			text := string(src)
+			for _, s := range tt.contains {
+				if !strings.Contains(text, s) {
+					t.Errorf("generated source missing %q\n%s", s, text)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(text, s) {
+					t.Errorf("generated source should not contain %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestGlobalsNameCollision(t *testing.T) {
+	tests := []struct {
+		name     string
+		programs []string
+		vars     []Variable
+		types    []string
+	}{
+		{
+			name:     "variable and program",
+			programs: []string{"hits"},
+			vars:     []Variable{{Name: "main.hits", Section: ".bss", Size: 8}},
+		},
+		{
+			name:     "variable wrapper and type",
+			programs: []string{"handler"},
+			vars:     []Variable{{Name: "main.hits", Section: ".bss", Size: 8}},
+			types:    []string{"hits_variable"},
+		},
+		{
+			name:     "BTF type named config",
+			programs: []string{"handler"},
+			vars:     []Variable{{Name: "main.limit", Section: ".rodata", Size: 4}},
+			types:    []string{"config"},
+		},
+		{
+			name:     "config fields",
+			programs: []string{"handler"},
+			vars: []Variable{
+				{Name: "main.limit", Section: ".rodata", Size: 4},
+				{Name: "other.limit", Section: ".rodata", Size: 4},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ELFInfo{Programs: tt.programs, Variables: tt.vars}
+			for _, name := range tt.types {
+				info.Types = addType(info.Types, &btf.Struct{Name: name})
+			}
+			_, err := Generate("loader", info, "")
+			if err == nil || !strings.Contains(err.Error(), "name collision") {
+				t.Fatalf("expected name collision, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+// mapKind classifies a map type by the typed operations its wrapper offers.
+type mapKind int
+
+const (
+	mapKindOpaque         mapKind = iota // no typed key/value operations
+	mapKindHash                          // keyed lookups, updates and deletes
+	mapKindArray                         // index lookups and updates; entries cannot be deleted
+	mapKindRingBuf                       // records consumed through a generated reader
+	mapKindPerfEventArray                // per-CPU samples consumed through a generated reader
+	mapKindMapOfMaps                     // inner maps stored and looked up by reference
+)
+
+// classifyMap returns the wrapper kind for a map type and whether values are per-CPU.
+func classifyMap(t ebpf.MapType) (kind mapKind, perCPU bool) {
+	switch t {
+	case ebpf.Hash, ebpf.LRUHash, ebpf.LPMTrie:
+		return mapKindHash, false
+	case ebpf.PerCPUHash, ebpf.LRUCPUHash:
+		return mapKindHash, true
+	case ebpf.Array:
+		return mapKindArray, false
+	case ebpf.PerCPUArray:
+		return mapKindArray, true
+	case ebpf.RingBuf:
+		return mapKindRingBuf, false
+	case ebpf.PerfEventArray:
+		return mapKindPerfEventArray, false
+	case ebpf.ArrayOfMaps, ebpf.HashOfMaps:
+		return mapKindMapOfMaps, false
+	default:
+		return mapKindOpaque, false
+	}
+}
+
+// mapWrapper describes the typed wrapper emitted for one map.
+type mapWrapper struct {
+	symbol   string // ELF symbol name, used in the ebpf struct tag
+	field    string // field name in Maps
+	typeName string // wrapper type name
+	def      MapDef
+	kind     mapKind
+	perCPU   bool
+	key      string      // Go key type expression
+	value    string      // Go value type expression
+	event    string      // Go record type expression for readers, empty for raw bytes
+	inner    *mapWrapper // inner map template of a map of maps, nil if unknown
+}
+
+// mapWrapperName returns the wrapper type name for a map symbol.
+func mapWrapperName(symbol string) string {
+	return exportedName(symbol) + "Map"
+}
+
+// innerMapWrapperName returns the wrapper type name for the inner maps of a
+// map of maps.
+func innerMapWrapperName(symbol string) string {
+	return exportedName(symbol) + "InnerMap"
+}
+
+// newMapWrappers builds the wrapper description for every map in info.
+func newMapWrappers(info *ELFInfo) []mapWrapper {
+	wrappers := make([]mapWrapper, 0, len(info.Maps))
+	for _, name := range info.Maps {
+		w := newMapWrapper(info.MapDefs[name])
+		w.symbol = name
+		w.field = exportedName(name)
+		w.typeName = mapWrapperName(name)
+		if t, ok := info.Events[name]; ok {
+			w.event = goTypeExpr(t, 0)
+		}
+		if w.def.Inner != nil {
+			inner := newMapWrapper(*w.def.Inner)
+			inner.typeName = innerMapWrapperName(name)
+			w.inner = &inner
+		}
+		wrappers = append(wrappers, w)
+	}
+	return wrappers
+}
+
+// newMapWrapper derives the kind and Go key and value types of a map definition.
+func newMapWrapper(def MapDef) mapWrapper {
+	w := mapWrapper{def: def}
+	w.kind, w.perCPU = classifyMap(def.Type)
+	if w.indexed() {
+		w.key = "uint32"
+	} else {
+		w.key = goTypeExpr(def.Key, def.KeySize)
+	}
+	w.value = goTypeExpr(def.Value, def.ValueSize)
+	return w
+}
+
+// indexed reports whether the map is keyed by a uint32 index.
+func (w mapWrapper) indexed() bool {
+	return w.kind == mapKindArray || w.def.Type == ebpf.ArrayOfMaps
+}
+
+// goTypeExpr returns the Go type expression for a key or value. Named BTF
+// types refer to their generated declaration; without BTF the type is derived
+// from its size.
+func goTypeExpr(t btf.Type, size uint32) string {
+	if t != nil {
+		if expr, ok := btfTypeExpr(t); ok {
+			return expr
+		}
+	}
+	switch size {
+	case 1:
+		return "uint8"
+	case 2:
+		return "uint16"
+	case 4:
+		return "uint32"
+	case 8:
+		return "uint64"
+	}
+	return fmt.Sprintf("[%d]byte", size)
+}
+
+// btfTypeExpr converts scalars, arrays and declared named types to Go syntax.
+func btfTypeExpr(t btf.Type) (string, bool) {
+	if d := declarableType(t); d != nil {
+		if _, isArray := btf.UnderlyingType(t).(*btf.Array); !isArray {
+			return goTypeName(d), true
+		}
+	}
+	switch u := btf.UnderlyingType(t).(type) {
+	case *btf.Int:
+		if u.Encoding == btf.Bool && u.Size == 1 {
+			return "bool", true
+		}
+		if u.Size != 1 && u.Size != 2 && u.Size != 4 && u.Size != 8 {
+			return "", false
+		}
+		stem := "uint"
+		if u.Encoding == btf.Signed {
+			stem = "int"
+		}
+		return fmt.Sprintf("%s%d", stem, u.Size*8), true
+	case *btf.Array:
+		elem, ok := btfTypeExpr(u.Type)
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("[%d]%s", u.Nelems, elem), true
+	}
+	return "", false
+}
+
+func writeMapsStruct(b *strings.Builder, wrappers []mapWrapper) {
+	fmt.Fprintf(b, "// Maps contains all BPF maps.\n")
+	fmt.Fprintf(b, "type Maps struct {\n")
+	for _, w := range wrappers {
+		fmt.Fprintf(b, "\t%s %s\n", w.field, w.typeName)
+	}
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeMapsClose(b *strings.Builder, wrappers []mapWrapper) {
+	fmt.Fprintf(b, "// Close releases all maps.\n")
+	fmt.Fprintf(b, "func (m *Maps) Close() {\n")
+	for _, w := range wrappers {
+		fmt.Fprintf(b, "\tif m.%s.Map != nil {\n", w.field)
+		fmt.Fprintf(b, "\t\t_ = m.%s.Map.Close()\n", w.field)
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "}\n")
+}
+
+// writeMapWrappers emits one wrapper type per map with typed operations for
+// hash and array maps, and for maps of maps with a wrapper for their inner
+// maps.
+func writeMapWrappers(b *strings.Builder, wrappers []mapWrapper) {
+	for _, w := range wrappers {
+		writeMapWrapperType(b, w)
+		if w.kind == mapKindMapOfMaps {
+			writeMapOfMaps(b, w)
+			if w.inner != nil {
+				writeInnerMapWrapperType(b, w)
+				writeMapOperations(b, *w.inner)
+			}
+			continue
+		}
+		writeMapOperations(b, w)
+	}
+}
+
+// writeMapOperations emits the typed operations of a hash or array map wrapper.
+func writeMapOperations(b *strings.Builder, w mapWrapper) {
+	if w.kind != mapKindHash && w.kind != mapKindArray {
+		return
+	}
+	writeMapLookup(b, w)
+	writeMapPut(b, w)
+	if w.kind == mapKindHash {
+		writeMapDelete(b, w)
+	}
+	writeMapIterate(b, w)
+	writeMapBatch(b, w)
+}
+
+func writeMapWrapperType(b *strings.Builder, w mapWrapper) {
+	fmt.Fprintf(b, "// %s wraps the %s map", w.typeName, w.symbol)
+	if w.def.Type != ebpf.UnspecifiedMap {
+		fmt.Fprintf(b, " (%s)", w.def.Type)
+	}
+	fmt.Fprintf(b, ".\n")
+	fmt.Fprintf(b, "type %s struct {\n", w.typeName)
+	fmt.Fprintf(b, "\t*ebpf.Map `ebpf:\"%s\"`\n", w.symbol)
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeInnerMapWrapperType(b *strings.Builder, w mapWrapper) {
+	fmt.Fprintf(b, "// %s wraps an inner map of the %s map (%s).\n", w.inner.typeName, w.symbol, w.inner.def.Type)
+	fmt.Fprintf(b, "type %s struct {\n", w.inner.typeName)
+	fmt.Fprintf(b, "\t*ebpf.Map\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+// writeMapOfMaps emits the operations of a map of maps, typed by the inner
+// map wrapper when the inner map template is known.
+func writeMapOfMaps(b *strings.Builder, w mapWrapper) {
+	k := w.keyParam()
+	inner, wrap, unwrap := "*ebpf.Map", "inner", "inner"
+	if w.inner != nil {
+		inner, wrap, unwrap = w.inner.typeName, w.inner.typeName+"{inner}", "inner.Map"
+	}
+
+	fmt.Fprintf(b, "// Lookup opens the inner map stored at %s. The caller closes it.\n", k)
+	fmt.Fprintf(b, "func (m %s) Lookup(%s %s) (%s, error) {\n", w.typeName, k, w.key, inner)
+	fmt.Fprintf(b, "\tvar inner *ebpf.Map\n")
+	fmt.Fprintf(b, "\terr := m.Map.Lookup(%s, &inner)\n", k)
+	fmt.Fprintf(b, "\treturn %s, err\n", wrap)
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Put stores a reference to inner at %s, creating or replacing the entry.\n", k)
+	fmt.Fprintf(b, "func (m %s) Put(%s %s, inner %s) error {\n", w.typeName, k, w.key, inner)
+	fmt.Fprintf(b, "\treturn m.Map.Put(%s, %s)\n", k, unwrap)
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Delete removes the inner map stored at %s.\n", k)
+	fmt.Fprintf(b, "func (m %s) Delete(%s %s) error {\n", w.typeName, k, w.key)
+	fmt.Fprintf(b, "\treturn m.Map.Delete(%s)\n", k)
+	fmt.Fprintf(b, "}\n\n")
+
+	if w.inner == nil {
+		return
+	}
+	d := w.inner.def
+	fmt.Fprintf(b, "// NewInner creates an empty map from the inner map template of %s, ready to\n", w.symbol)
+	fmt.Fprintf(b, "// fill and Put. The caller closes it once stored.\n")
+	fmt.Fprintf(b, "func (m %s) NewInner() (%s, error) {\n", w.typeName, inner)
+	fmt.Fprintf(b, "\tinner, err := ebpf.NewMap(&ebpf.MapSpec{\n")
+	fmt.Fprintf(b, "\t\tName:       %q,\n", sanitizeMapName(w.symbol+"_inner"))
+	fmt.Fprintf(b, "\t\tType:       %s,\n", constExpr("ebpf", "MapType", d.Type, uint64(d.Type)))
+	fmt.Fprintf(b, "\t\tKeySize:    %d,\n", d.KeySize)
+	fmt.Fprintf(b, "\t\tValueSize:  %d,\n", d.ValueSize)
+	fmt.Fprintf(b, "\t\tMaxEntries: %d,\n", d.MaxEntries)
+	fmt.Fprintf(b, "\t\tFlags:      %d,\n", d.Flags)
+	fmt.Fprintf(b, "\t})\n")
+	fmt.Fprintf(b, "\treturn %s, err\n", wrap)
+	fmt.Fprintf(b, "}\n\n")
+}
+
+// sanitizeMapName truncates a map name to the kernel's 15 characters.
+func sanitizeMapName(name string) string {
+	if len(name) > 15 {
+		return name[:15]
+	}
+	return name
+}
+
+// keyParam returns the parameter name for the map key.
+func (w mapWrapper) keyParam() string {
+	if w.indexed() {
+		return "index"
+	}
+	return "key"
+}
+
+// valueType returns the Go type of a single lookup result.
+func (w mapWrapper) valueType() string {
+	if w.perCPU {
+		return "[]" + w.value
+	}
+	return w.value
+}
+
+func writeMapLookup(b *strings.Builder, w mapWrapper) {
+	k := w.keyParam()
+	if w.perCPU {
+		fmt.Fprintf(b, "// Lookup returns the per-CPU values stored at %s, one per possible CPU.\n", k)
+	} else {
+		fmt.Fprintf(b, "// Lookup returns the value stored at %s.\n", k)
+	}
+	fmt.Fprintf(b, "func (m %s) Lookup(%s %s) (%s, error) {\n", w.typeName, k, w.key, w.valueType())
+	fmt.Fprintf(b, "\tvar value %s\n", w.valueType())
+	fmt.Fprintf(b, "\terr := m.Map.Lookup(%s, &value)\n", k)
+	fmt.Fprintf(b, "\treturn value, err\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeMapPut(b *strings.Builder, w mapWrapper) {
+	k := w.keyParam()
+	if w.perCPU {
+		fmt.Fprintf(b, "// Put stores one value per possible CPU at %s.\n", k)
+		fmt.Fprintf(b, "func (m %s) Put(%s %s, values %s) error {\n", w.typeName, k, w.key, w.valueType())
+		fmt.Fprintf(b, "\treturn m.Map.Put(%s, values)\n", k)
+	} else {
+		fmt.Fprintf(b, "// Put stores value at %s, creating or replacing the entry.\n", k)
+		fmt.Fprintf(b, "func (m %s) Put(%s %s, value %s) error {\n", w.typeName, k, w.key, w.valueType())
+		fmt.Fprintf(b, "\treturn m.Map.Put(%s, value)\n", k)
+	}
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeMapDelete(b *strings.Builder, w mapWrapper) {
+	fmt.Fprintf(b, "// Delete removes the entry stored at key.\n")
+	fmt.Fprintf(b, "func (m %s) Delete(key %s) error {\n", w.typeName, w.key)
+	fmt.Fprintf(b, "\treturn m.Map.Delete(key)\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeMapIterate(b *strings.Builder, w mapWrapper) {
+	k := w.keyParam()
+	fmt.Fprintf(b, "// Iterate calls fn for each entry until fn returns false.\n")
+	fmt.Fprintf(b, "func (m %s) Iterate(fn func(%s %s, value %s) bool) error {\n", w.typeName, k, w.key, w.valueType())
+	fmt.Fprintf(b, "\tvar (\n")
+	fmt.Fprintf(b, "\t\t%s %s\n", k, w.key)
+	fmt.Fprintf(b, "\t\tvalue %s\n", w.valueType())
+	fmt.Fprintf(b, "\t)\n")
+	fmt.Fprintf(b, "\tit := m.Map.Iterate()\n")
+	fmt.Fprintf(b, "\tfor it.Next(&%s, &value) {\n", k)
+	fmt.Fprintf(b, "\t\tif !fn(%s, value) {\n", k)
+	fmt.Fprintf(b, "\t\t\treturn nil\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn it.Err()\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeMapBatch(b *strings.Builder, w mapWrapper) {
+	valuesDoc := ""
+	if w.perCPU {
+		valuesDoc = " Per-CPU values are laid out key by key, one per possible CPU."
+	}
+
+	fmt.Fprintf(b, "// BatchLookup reads up to len(keys) entries starting at cursor and returns\n")
+	fmt.Fprintf(b, "// the number read.%s\n", valuesDoc)
+	fmt.Fprintf(b, "func (m %s) BatchLookup(cursor *ebpf.MapBatchCursor, keys []%s, values []%s) (int, error) {\n", w.typeName, w.key, w.value)
+	fmt.Fprintf(b, "\treturn m.Map.BatchLookup(cursor, keys, values, nil)\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// BatchPut stores values at keys and returns the number of entries\n")
+	fmt.Fprintf(b, "// written.%s\n", valuesDoc)
+	fmt.Fprintf(b, "func (m %s) BatchPut(keys []%s, values []%s) (int, error) {\n", w.typeName, w.key, w.value)
+	fmt.Fprintf(b, "\treturn m.Map.BatchUpdate(keys, values, nil)\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	if w.kind != mapKindHash {
+		return
+	}
+	fmt.Fprintf(b, "// BatchDelete removes the entries stored at keys and returns the number deleted.\n")
+	fmt.Fprintf(b, "func (m %s) BatchDelete(keys []%s) (int, error) {\n", w.typeName, w.key)
+	fmt.Fprintf(b, "\treturn m.Map.BatchDelete(keys, nil)\n")
+	fmt.Fprintf(b, "}\n\n")
+}
@@ -0,0 +1,201 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestClassifyMap(t *testing.T) {
+	tests := []struct {
+		typ        ebpf.MapType
+		wantKind   mapKind
+		wantPerCPU bool
+	}{
+		{ebpf.Hash, mapKindHash, false},
+		{ebpf.LRUHash, mapKindHash, false},
+		{ebpf.LPMTrie, mapKindHash, false},
+		{ebpf.PerCPUHash, mapKindHash, true},
+		{ebpf.LRUCPUHash, mapKindHash, true},
+		{ebpf.Array, mapKindArray, false},
+		{ebpf.PerCPUArray, mapKindArray, true},
+		{ebpf.RingBuf, mapKindRingBuf, false},
+		{ebpf.PerfEventArray, mapKindPerfEventArray, false},
+		{ebpf.ProgramArray, mapKindOpaque, false},
+		{ebpf.UnspecifiedMap, mapKindOpaque, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ.String(), func(t *testing.T) {
+			kind, perCPU := classifyMap(tt.typ)
+			if kind != tt.wantKind || perCPU != tt.wantPerCPU {
+				t.Errorf("classifyMap(%s) = (%d, %v), want (%d, %v)", tt.typ, kind, perCPU, tt.wantKind, tt.wantPerCPU)
+			}
+		})
+	}
+}
+
+func TestGoTypeExpr(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  btf.Type
+		size uint32
+		want string
+	}{
+		{"nil 4 bytes", nil, 4, "uint32"},
+		{"nil 8 bytes", nil, 8, "uint64"},
+		{"nil 2 bytes", nil, 2, "uint16"},
+		{"nil 1 byte", nil, 1, "uint8"},
+		{"nil odd size", nil, 12, "[12]byte"},
+		{"unsigned int", btfU32, 4, "uint32"},
+		{"signed int", btfI32, 4, "int32"},
+		{"bool", &btf.Int{Name: "_Bool", Size: 1, Encoding: btf.Bool}, 1, "bool"},
+		{"typedef to int", &btf.Typedef{Name: "__u64", Type: btfU64}, 8, "uint64"},
+		{"array", &btf.Array{Index: btfU32, Type: btfU8, Nelems: 16}, 16, "[16]uint8"},
+		{"named struct", connKey, 8, "ConnKey"},
+		{"array of structs", &btf.Array{Index: btfU32, Type: connKey, Nelems: 2}, 16, "[2]ConnKey"},
+		{"anonymous struct falls back to bytes", &btf.Struct{Size: 6}, 6, "[6]byte"},
+		{"wide int falls back to bytes", &btf.Int{Name: "__int128", Size: 16}, 16, "[16]byte"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := goTypeExpr(tt.typ, tt.size); got != tt.want {
+				t.Errorf("goTypeExpr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateMapWrappers(t *testing.T) {
+	tests := []struct {
+		name     string
+		def      MapDef
+		contains []string
+		absent   []string
+	}{
+		{
+			name: "hash with BTF key",
+			def:  MapDef{Type: ebpf.Hash, Key: connKey, KeySize: 8, ValueSize: 8},
+			contains: []string{
+				"// ConnsMap wraps the conns map (Hash).",
+				"func (m ConnsMap) Lookup(key ConnKey) (uint64, error)",
+				"func (m ConnsMap) Put(key ConnKey, value uint64) error",
+				"func (m ConnsMap) Delete(key ConnKey) error",
+				"func (m ConnsMap) Iterate(fn func(key ConnKey, value uint64) bool) error",
+				"func (m ConnsMap) BatchLookup(cursor *ebpf.MapBatchCursor, keys []ConnKey, values []uint64) (int, error)",
+				"func (m ConnsMap) BatchPut(keys []ConnKey, values []uint64) (int, error)",
+				"func (m ConnsMap) BatchDelete(keys []ConnKey) (int, error)",
+			},
+		},
+		{
+			name: "array from sizes takes an index",
+			def:  MapDef{Type: ebpf.Array, KeySize: 4, ValueSize: 16},
+			contains: []string{
+				"func (m ConnsMap) Lookup(index uint32) ([16]byte, error)",
+				"func (m ConnsMap) Put(index uint32, value [16]byte) error",
+				"it.Next(&index, &value)",
+			},
+			absent: []string{"Delete("},
+		},
+		{
+			name: "per-CPU array returns slices",
+			def:  MapDef{Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: 8},
+			contains: []string{
+				"func (m ConnsMap) Lookup(index uint32) ([]uint64, error)",
+				"func (m ConnsMap) Put(index uint32, values []uint64) error",
+				"func (m ConnsMap) Iterate(fn func(index uint32, value []uint64) bool) error",
+				"Per-CPU values are laid out key by key",
+			},
+		},
+		{
+			name: "per-CPU hash",
+			def:  MapDef{Type: ebpf.PerCPUHash, KeySize: 4, ValueSize: 8},
+			contains: []string{
+				"func (m ConnsMap) Lookup(key uint32) ([]uint64, error)",
+				"func (m ConnsMap) Delete(key uint32) error",
+			},
+		},
+		{
+			name: "hash of maps with inner template",
+			def: MapDef{Type: ebpf.HashOfMaps, KeySize: 4, ValueSize: 4, MaxEntries: 64,
+				Inner: &MapDef{Type: ebpf.Hash, Key: connKey, KeySize: 8, ValueSize: 8, MaxEntries: 128}},
+			contains: []string{
+				"// ConnsMap wraps the conns map (HashOfMaps).",
+				"func (m ConnsMap) Lookup(key uint32) (ConnsInnerMap, error)",
+				"return ConnsInnerMap{inner}, err",
+				"func (m ConnsMap) Put(key uint32, inner ConnsInnerMap) error",
+				"return m.Map.Put(key, inner.Map)",
+				"func (m ConnsMap) Delete(key uint32) error",
+				"func (m ConnsMap) NewInner() (ConnsInnerMap, error)",
+				"Name:       \"conns_inner\",\n\t\tType:       ebpf.Hash,\n\t\tKeySize:    8,\n\t\tValueSize:  8,\n\t\tMaxEntries: 128,",
+				"// ConnsInnerMap wraps an inner map of the conns map (Hash).",
+				"func (m ConnsInnerMap) Lookup(key ConnKey) (uint64, error)",
+				"func (m ConnsInnerMap) BatchDelete(keys []ConnKey) (int, error)",
+			},
+			absent: []string{"func (m ConnsMap) Iterate("},
+		},
+		{
+			name: "inner template with a map type the library cannot name",
+			def: MapDef{Type: ebpf.ArrayOfMaps, KeySize: 4, ValueSize: 4, MaxEntries: 8,
+				Inner: &MapDef{Type: ebpf.MapType(200), KeySize: 4, ValueSize: 8, MaxEntries: 16}},
+			contains: []string{
+				"func (m ConnsMap) NewInner() (ConnsInnerMap, error)",
+				"Type:       ebpf.MapType(200),",
+			},
+		},
+		{
+			name: "array of maps without template",
+			def:  MapDef{Type: ebpf.ArrayOfMaps, KeySize: 4, ValueSize: 4, MaxEntries: 8},
+			contains: []string{
+				"func (m ConnsMap) Lookup(index uint32) (*ebpf.Map, error)",
+				"func (m ConnsMap) Put(index uint32, inner *ebpf.Map) error",
+				"return m.Map.Put(index, inner)",
+			},
+			absent: []string{"NewInner", "InnerMap"},
+		},
+		{
+			name:     "ring buffer has no typed operations",
+			def:      MapDef{Type: ebpf.RingBuf, MaxEntries: 4096},
+			contains: []string{"type ConnsMap struct", "// ConnsMap wraps the conns map (RingBuf)."},
+			absent:   []string{"Lookup(", "Put(", "Iterate("},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ELFInfo{
+				Programs: []string{"handler"},
+				Maps:     []string{"conns"},
+				MapDefs:  map[string]MapDef{"conns": tt.def},
+			}
+			info.Types = collectMapTypes(info.MapDefs)
+			src, err := Generate("loader", info, "")
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			text := string(src)
+			for _, s := range append(tt.contains, "Conns ConnsMap", "m.Conns.Map.Close()") {
+				if !strings.Contains(text, s) {
+					t.Errorf("generated source missing %q\n%s", s, text)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(text, s) {
+					t.Errorf("generated source should not contain %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestMapWrapperNameCollision(t *testing.T) {
+	info := &ELFInfo{
+		Programs: []string{"handler"},
+		Maps:     []string{"conns"},
+		Types:    []btf.Type{&btf.Struct{Name: "conns_map"}},
+	}
+	_, err := Generate("loader", info, "")
+	if err == nil || !strings.Contains(err.Error(), "name collision") {
+		t.Fatalf("expected name collision, got %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pinMethods are the methods generated on Programs and Links; program and
+// map fields must not share their names.
+var pinMethods = []string{"Close", "Pin", "Unpin"}
+
+// linkPinName returns the bpffs file name a program's link is pinned under.
+func linkPinName(symbol string) string {
+	return symbol + "_link"
+}
+
+func writeDefaultPinPath(b *strings.Builder, pinPath string) {
+	fmt.Fprintf(b, "// DefaultPinPath is the bpffs directory configured as build.pin_path. The\n")
+	fmt.Fprintf(b, "// pinning functions use it when given an empty pin path.\n")
+	fmt.Fprintf(b, "const DefaultPinPath = %q\n\n", pinPath)
+}
+
+// writeDefaultPinPathFallback writes the statement that replaces an empty
+// pinPath with DefaultPinPath, if the loader has one.
+func writeDefaultPinPathFallback(b *strings.Builder, hasDefault bool) {
+	if !hasDefault {
+		return
+	}
+	fmt.Fprintf(b, "\tif pinPath == \"\" {\n")
+	fmt.Fprintf(b, "\t\tpinPath = DefaultPinPath\n")
+	fmt.Fprintf(b, "\t}\n")
+}
+
+func writeIncompatiblePinError(b *strings.Builder, maps []string) {
+	fmt.Fprintf(b, "// IncompatiblePinError is returned when a map pinned under the pin path does\n")
+	fmt.Fprintf(b, "// not match the object's definition. Remove the pin, or use another pin path,\n")
+	fmt.Fprintf(b, "// to create the map afresh.\n")
+	fmt.Fprintf(b, "type IncompatiblePinError struct {\n")
+	fmt.Fprintf(b, "\tMap  string // map symbol name\n")
+	fmt.Fprintf(b, "\tPath string // bpffs path of the pinned map\n")
+	fmt.Fprintf(b, "\tErr  error  // wraps ebpf.ErrMapIncompatible\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (e *IncompatiblePinError) Error() string {\n")
+	fmt.Fprintf(b, "\treturn fmt.Sprintf(\"pinned map %%s at %%s: %%v\", e.Map, e.Path, e.Err)\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (e *IncompatiblePinError) Unwrap() error {\n")
+	fmt.Fprintf(b, "\treturn e.Err\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// checkPinnedMaps returns an *IncompatiblePinError for the first map pinned\n")
+	fmt.Fprintf(b, "// by name under pinPath that spec cannot reuse.\n")
+	fmt.Fprintf(b, "func checkPinnedMaps(spec *ebpf.CollectionSpec, pinPath string) error {\n")
+	fmt.Fprintf(b, "\tfor _, name := range []string{")
+	for i, name := range maps {
+		if i > 0 {
+			fmt.Fprintf(b, ", ")
+		}
+		fmt.Fprintf(b, "%q", name)
+	}
+	fmt.Fprintf(b, "} {\n")
+	fmt.Fprintf(b, "\t\tms := spec.Maps[name]\n")
+	fmt.Fprintf(b, "\t\tif ms == nil || ms.Pinning != ebpf.PinByName {\n")
+	fmt.Fprintf(b, "\t\t\tcontinue\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tpath := filepath.Join(pinPath, ms.Name)\n")
+	fmt.Fprintf(b, "\t\tm, err := ebpf.LoadPinnedMap(path, nil)\n")
+	fmt.Fprintf(b, "\t\tif errors.Is(err, os.ErrNotExist) {\n")
+	fmt.Fprintf(b, "\t\t\tcontinue\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\t\treturn fmt.Errorf(\"load pinned map %%s: %%w\", name, err)\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\terr = ms.Compatible(m)\n")
+	fmt.Fprintf(b, "\t\t_ = m.Close()\n")
+	fmt.Fprintf(b, "\t\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\t\treturn &IncompatiblePinError{Map: name, Path: path, Err: err}\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeLoadPinnedFuncs(b *strings.Builder, embedded, hasMaps, hasDefault bool) {
+	source, params, args := "the BPF object from objectPath", "objectPath, ", "objectPath"
+	if embedded {
+		source, params, args = "the embedded BPF object", "", ""
+	}
+
+	fmt.Fprintf(b, "// LoadPinned loads %s like Load, but pins each map whose\n", source)
+	fmt.Fprintf(b, "// definition sets Pinning under pinPath, reusing a map already pinned there.\n")
+	if hasDefault {
+		fmt.Fprintf(b, "// An empty pinPath means DefaultPinPath.\n")
+	}
+	if hasMaps {
+		fmt.Fprintf(b, "// It returns an *IncompatiblePinError if a pinned map's definition differs.\n")
+	}
+	fmt.Fprintf(b, "func LoadPinned(%spinPath string) (*Objects, error) {\n", params)
+	fmt.Fprintf(b, "\tspec, err := loadCollectionSpec(%s)\n", args)
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn loadPinnedObjects(spec, pinPath, nil)\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// LoadPinned is like Load, but pins maps under pinPath as the package-level\n")
+	fmt.Fprintf(b, "// LoadPinned does. Set a map spec's Pinning to ebpf.PinByName to pin it.\n")
+	fmt.Fprintf(b, "func (s *Specs) LoadPinned(pinPath string, opts *ebpf.CollectionOptions) (*Objects, error) {\n")
+	fmt.Fprintf(b, "\treturn loadPinnedObjects(s.collection, pinPath, opts)\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// loadPinnedObjects loads spec with its pinned maps under pinPath. It sets\n")
+	fmt.Fprintf(b, "// the pin path on a copy of opts, leaving the caller's options unchanged.\n")
+	fmt.Fprintf(b, "func loadPinnedObjects(spec *ebpf.CollectionSpec, pinPath string, opts *ebpf.CollectionOptions) (*Objects, error) {\n")
+	writeDefaultPinPathFallback(b, hasDefault)
+	if hasMaps {
+		fmt.Fprintf(b, "\tif err := checkPinnedMaps(spec, pinPath); err != nil {\n")
+		fmt.Fprintf(b, "\t\treturn nil, err\n")
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\to := ebpf.CollectionOptions{}\n")
+	fmt.Fprintf(b, "\tif opts != nil {\n")
+	fmt.Fprintf(b, "\t\to = *opts\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\to.Maps.PinPath = pinPath\n")
+	fmt.Fprintf(b, "\treturn loadObjects(spec, &o)\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeProgramsPin(b *strings.Builder, programs []string, hasDefault bool) {
+	fmt.Fprintf(b, "// Pin pins every loaded program under pinPath, named after its symbol.\n")
+	if hasDefault {
+		fmt.Fprintf(b, "// An empty pinPath means DefaultPinPath.\n")
+	}
+	fmt.Fprintf(b, "func (p *Programs) Pin(pinPath string) error {\n")
+	writeDefaultPinPathFallback(b, hasDefault)
+	for _, name := range programs {
+		fmt.Fprintf(b, "\tif p.%s != nil {\n", exportedName(name))
+		fmt.Fprintf(b, "\t\tif err := p.%s.Pin(filepath.Join(pinPath, %q)); err != nil {\n", exportedName(name), name)
+		fmt.Fprintf(b, "\t\t\treturn fmt.Errorf(\"pin program %s: %%w\", err)\n", name)
+		fmt.Fprintf(b, "\t\t}\n")
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Unpin removes the pins created by Pin. The programs stay loaded until closed.\n")
+	fmt.Fprintf(b, "func (p *Programs) Unpin() error {\n")
+	fmt.Fprintf(b, "\tvar errs []error\n")
+	for _, name := range programs {
+		fmt.Fprintf(b, "\tif p.%s != nil {\n", exportedName(name))
+		fmt.Fprintf(b, "\t\terrs = append(errs, p.%s.Unpin())\n", exportedName(name))
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn errors.Join(errs...)\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeLinksPin(b *strings.Builder, targets []attachTarget, hasDefault bool) {
+	fmt.Fprintf(b, "// Pin pins every link under pinPath so that the attachments outlive the\n")
+	fmt.Fprintf(b, "// process. Reopen them with LoadPinnedLinks.\n")
+	if hasDefault {
+		fmt.Fprintf(b, "// An empty pinPath means DefaultPinPath.\n")
+	}
+	fmt.Fprintf(b, "func (l *Links) Pin(pinPath string) error {\n")
+	fmt.Fprintf(b, "\tif l == nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil\n")
+	fmt.Fprintf(b, "\t}\n")
+	writeDefaultPinPathFallback(b, hasDefault)
+	for _, t := range targets {
+		fmt.Fprintf(b, "\tif l.%s != nil {\n", t.field)
+		fmt.Fprintf(b, "\t\tif err := l.%s.Pin(filepath.Join(pinPath, %q)); err != nil {\n", t.field, linkPinName(t.symbol))
+		fmt.Fprintf(b, "\t\t\treturn fmt.Errorf(\"pin link %s: %%w\", err)\n", t.symbol)
+		fmt.Fprintf(b, "\t\t}\n")
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Unpin removes the pins created by Pin. The programs are detached once the\n")
+	fmt.Fprintf(b, "// links are closed as well.\n")
+	fmt.Fprintf(b, "func (l *Links) Unpin() error {\n")
+	fmt.Fprintf(b, "\tif l == nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tvar errs []error\n")
+	for _, t := range targets {
+		fmt.Fprintf(b, "\tif l.%s != nil {\n", t.field)
+		fmt.Fprintf(b, "\t\terrs = append(errs, l.%s.Unpin())\n", t.field)
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn errors.Join(errs...)\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// LoadPinnedLinks opens the links pinned under pinPath by Links.Pin, for\n")
+	fmt.Fprintf(b, "// example after a restart. Links that are not pinned are left nil.\n")
+	if hasDefault {
+		fmt.Fprintf(b, "// An empty pinPath means DefaultPinPath.\n")
+	}
+	fmt.Fprintf(b, "func LoadPinnedLinks(pinPath string) (*Links, error) {\n")
+	writeDefaultPinPathFallback(b, hasDefault)
+	fmt.Fprintf(b, "\tvar (\n")
+	fmt.Fprintf(b, "\t\tl   Links\n")
+	fmt.Fprintf(b, "\t\terr error\n")
+	fmt.Fprintf(b, "\t)\n")
+	for _, t := range targets {
+		fmt.Fprintf(b, "\tif l.%s, err = loadPinnedLink(filepath.Join(pinPath, %q)); err != nil {\n", t.field, linkPinName(t.symbol))
+		fmt.Fprintf(b, "\t\t_ = l.Close()\n")
+		fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"load pinned link %s: %%w\", err)\n", t.symbol)
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn &l, nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// loadPinnedLink opens the link pinned at path, or returns nil if there is none.\n")
+	fmt.Fprintf(b, "func loadPinnedLink(path string) (link.Link, error) {\n")
+	fmt.Fprintf(b, "\tl, err := link.LoadPinnedLink(path, nil)\n")
+	fmt.Fprintf(b, "\tif errors.Is(err, os.ErrNotExist) {\n")
+	fmt.Fprintf(b, "\t\treturn nil, nil\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn l, err\n")
+	fmt.Fprintf(b, "}\n\n")
+}
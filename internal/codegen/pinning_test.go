@@ -0,0 +1,154 @@
+package codegen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+func TestGeneratePinning(t *testing.T) {
+	tests := []struct {
+		name      string
+		info      *ELFInfo
+		embedPath string
+		contains  []string
+		absent    []string
+		defaults  int // functions that fall back to DefaultPinPath
+	}{
+		{
+			name: "maps and attach points",
+			info: &ELFInfo{
+				Programs: []string{"handler"},
+				Sections: map[string]string{"handler": "xdp"},
+				Maps:     []string{"conns"},
+				MapDefs:  map[string]MapDef{"conns": {Type: ebpf.Hash, KeySize: 4, ValueSize: 8, Pinning: 1}},
+				PinPath:  "/sys/fs/bpf/probe",
+			},
+			contains: []string{
+				`const DefaultPinPath = "/sys/fs/bpf/probe"`,
+				"type IncompatiblePinError struct {",
+				"func (e *IncompatiblePinError) Unwrap() error {",
+				`for _, name := range []string{"conns"} {`,
+				"return &IncompatiblePinError{Map: name, Path: path, Err: err}",
+				"func LoadPinned(objectPath, pinPath string) (*Objects, error) {",
+				"func (s *Specs) LoadPinned(pinPath string, opts *ebpf.CollectionOptions) (*Objects, error) {",
+				"o.Maps.PinPath = pinPath",
+				"if p.Handler != nil {\n\t\tif err := p.Handler.Pin(filepath.Join(pinPath, \"handler\")); err != nil {",
+				"func (p *Programs) Unpin() error {",
+				`l.Handler.Pin(filepath.Join(pinPath, "handler_link"))`,
+				"func (l *Links) Pin(pinPath string) error {\n\tif l == nil {\n\t\treturn nil\n\t}",
+				"func (l *Links) Unpin() error {\n\tif l == nil {\n\t\treturn nil\n\t}",
+				"func LoadPinnedLinks(pinPath string) (*Links, error) {",
+				`"os"`,
+			},
+			defaults: 4,
+		},
+		{
+			name:      "no maps or attach points",
+			info:      &ELFInfo{Programs: []string{"handler"}},
+			embedPath: "probe.bpf.o",
+			contains: []string{
+				"func LoadPinned(pinPath string) (*Objects, error) {",
+				"func (p *Programs) Pin(pinPath string) error {",
+			},
+			absent: []string{"DefaultPinPath", "IncompatiblePinError", "checkPinnedMaps", "LoadPinnedLinks", `"os"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := Generate("loader", tt.info, tt.embedPath)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			text := string(src)
+			for _, s := range tt.contains {
+				if !strings.Contains(text, s) {
+					t.Errorf("generated source missing %q\n%s", s, text)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(text, s) {
+					t.Errorf("generated source should not contain %q", s)
+				}
+			}
+			if n := strings.Count(text, "if pinPath == \"\" {\n\t\tpinPath = DefaultPinPath\n\t}"); n != tt.defaults {
+				t.Errorf("%d functions default to DefaultPinPath, want %d", n, tt.defaults)
+			}
+		})
+	}
+}
+
+func TestPinMethodNameCollision(t *testing.T) {
+	for _, name := range []string{"pin", "unpin", "close"} {
+		info := &ELFInfo{Programs: []string{name}}
+		_, err := Generate("loader", info, "")
+		if err == nil || !strings.Contains(err.Error(), "name collision") {
+			t.Errorf("program %q: expected name collision, got %v", name, err)
+		}
+	}
+}
+
+func TestLoadPinnedKeepsCallerOptions(t *testing.T) {
+	info := &ELFInfo{
+		Programs: []string{"handler"},
+		Maps:     []string{"conns"},
+		MapDefs:  map[string]MapDef{"conns": {Type: ebpf.Hash, KeySize: 4, ValueSize: 8, Pinning: 1}},
+	}
+	src, err := Generate("loader", info, "")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "loader.go", src, 0)
+	if err != nil {
+		t.Fatalf("parse generated source: %v", err)
+	}
+	var fn *ast.FuncDecl
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*ast.FuncDecl); ok && d.Name.Name == "loadPinnedObjects" {
+			fn = d
+		}
+	}
+	if fn == nil {
+		t.Fatal("loadPinnedObjects not generated")
+	}
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok {
+			return true
+		}
+		for _, lhs := range assign.Lhs {
+			if rootIdent(lhs) == "opts" {
+				t.Errorf("loadPinnedObjects writes to the caller's options: %s", src[assign.Pos()-1:assign.End()-1])
+			}
+		}
+		return true
+	})
+	if !strings.Contains(string(src), "\to = *opts\n") {
+		t.Error("loadPinnedObjects should copy the caller's options")
+	}
+}
+
+// rootIdent returns the name of the variable an assignable expression is
+// rooted at, or "" if it is not rooted at one.
+func rootIdent(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			return e.Name
+		case *ast.SelectorExpr:
+			expr = e.X
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		default:
+			return ""
+		}
+	}
+}
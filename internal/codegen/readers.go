@@ -0,0 +1,265 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// readerName returns the reader type name for a ring buffer or perf event
+// array map symbol.
+func readerName(symbol string) string {
+	return exportedName(symbol) + "Reader"
+}
+
+// hasReader reports whether a generated reader consumes the map's records.
+func (w mapWrapper) hasReader() bool {
+	return w.kind == mapKindRingBuf || w.kind == mapKindPerfEventArray
+}
+
+// readerPackage returns the cilium/ebpf package name behind the map's reader.
+func (w mapWrapper) readerPackage() string {
+	if w.kind == mapKindPerfEventArray {
+		return "perf"
+	}
+	return "ringbuf"
+}
+
+// transport describes the map's event transport in generated comments.
+func (w mapWrapper) transport() string {
+	if w.kind == mapKindPerfEventArray {
+		return "perf event array"
+	}
+	return "ring buffer"
+}
+
+// recordType returns the Go type a reader yields for each record.
+func (w mapWrapper) recordType() string {
+	if w.event == "" {
+		return "[]byte"
+	}
+	return w.event
+}
+
+// addReaderImports records the imports needed by the readers of wrappers.
+func addReaderImports(imports importSet, wrappers []mapWrapper) {
+	for _, w := range wrappers {
+		if !w.hasReader() {
+			continue
+		}
+		imports["context"] = true
+		imports["errors"] = true
+		imports["iter"] = true
+		imports["github.com/cilium/ebpf/"+w.readerPackage()] = true
+		if w.kind == mapKindPerfEventArray {
+			imports["sync/atomic"] = true
+		}
+		if w.event == "" {
+			imports["bytes"] = true
+		} else {
+			imports["encoding/binary"] = true
+		}
+	}
+}
+
+// writeReaders emits a reader type for every ring buffer and perf event array
+// in wrappers.
+func writeReaders(b *strings.Builder, wrappers []mapWrapper) {
+	for _, w := range wrappers {
+		switch w.kind {
+		case mapKindRingBuf:
+			writeRingBufReader(b, w)
+		case mapKindPerfEventArray:
+			writePerfReader(b, w)
+		default:
+			continue
+		}
+		writeReaderRecord(b, w)
+		writeReaderRead(b, w)
+		writeReaderAll(b, w, readerName(w.symbol), false)
+		writeReaderClose(b, w)
+		writeReplay(b, w)
+		writeDecoder(b, w)
+	}
+	for _, w := range wrappers {
+		if w.hasReader() {
+			writeRecordingFormat(b)
+			return
+		}
+	}
+}
+
+func writeReaderDoc(b *strings.Builder, w mapWrapper) {
+	name := readerName(w.symbol)
+	if w.event == "" {
+		fmt.Fprintf(b, "// %s reads raw records from the %s %s.\n", name, w.symbol, w.transport())
+	} else {
+		fmt.Fprintf(b, "// %s reads %s records from the %s %s.\n", name, w.event, w.symbol, w.transport())
+	}
+}
+
+func writeRingBufReader(b *strings.Builder, w mapWrapper) {
+	name := readerName(w.symbol)
+
+	writeReaderDoc(b, w)
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	fmt.Fprintf(b, "\trd     *ringbuf.Reader\n")
+	fmt.Fprintf(b, "\trecord ringbuf.Record\n")
+	fmt.Fprintf(b, "\trec    io.Writer\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// NewReader opens a reader on the ring buffer. The caller must Close it.\n")
+	fmt.Fprintf(b, "func (m %s) NewReader() (*%s, error) {\n", w.typeName, name)
+	fmt.Fprintf(b, "\trd, err := ringbuf.NewReader(m.Map)\n")
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"open %s ring buffer: %%w\", err)\n", w.symbol)
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn &%s{rd: rd}, nil\n", name)
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// read waits for the next record, flushing the reader to wake it once ctx is done.\n")
+	fmt.Fprintf(b, "func (r *%s) read(ctx context.Context) error {\n", name)
+	fmt.Fprintf(b, "\tstop := context.AfterFunc(ctx, func() { _ = r.rd.Flush() })\n")
+	fmt.Fprintf(b, "\tdefer stop()\n")
+	fmt.Fprintf(b, "\tfor {\n")
+	fmt.Fprintf(b, "\t\tif err := ctx.Err(); err != nil {\n")
+	fmt.Fprintf(b, "\t\t\treturn err\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\terr := r.rd.ReadInto(&r.record)\n")
+	fmt.Fprintf(b, "\t\tif errors.Is(err, ringbuf.ErrFlushed) {\n")
+	fmt.Fprintf(b, "\t\t\tcontinue\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\t\treturn err\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\treturn nil\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writePerfReader(b *strings.Builder, w mapWrapper) {
+	name := readerName(w.symbol)
+
+	writeReaderDoc(b, w)
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	fmt.Fprintf(b, "\trd     *perf.Reader\n")
+	fmt.Fprintf(b, "\trecord perf.Record\n")
+	fmt.Fprintf(b, "\tlost   []atomic.Uint64\n")
+	fmt.Fprintf(b, "\trec    io.Writer\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// NewReader opens a reader on the perf event array with perCPUBuffer bytes of\n")
+	fmt.Fprintf(b, "// buffer per CPU, rounded up to the page size. The caller must Close it.\n")
+	fmt.Fprintf(b, "func (m %s) NewReader(perCPUBuffer int) (*%s, error) {\n", w.typeName, name)
+	fmt.Fprintf(b, "\treturn m.NewReaderWithOptions(perCPUBuffer, perf.ReaderOptions{})\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// NewReaderWithOptions is like NewReader but configures wakeups and overwriting.\n")
+	fmt.Fprintf(b, "func (m %s) NewReaderWithOptions(perCPUBuffer int, opts perf.ReaderOptions) (*%s, error) {\n", w.typeName, name)
+	fmt.Fprintf(b, "\trd, err := perf.NewReaderWithOptions(m.Map, perCPUBuffer, opts)\n")
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"open %s perf event array: %%w\", err)\n", w.symbol)
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn &%s{rd: rd, lost: make([]atomic.Uint64, m.Map.MaxEntries())}, nil\n", name)
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// read waits for the next sample, counting lost samples per CPU and flushing\n")
+	fmt.Fprintf(b, "// the reader to wake it once ctx is done.\n")
+	fmt.Fprintf(b, "func (r *%s) read(ctx context.Context) error {\n", name)
+	fmt.Fprintf(b, "\tstop := context.AfterFunc(ctx, func() { _ = r.rd.Flush() })\n")
+	fmt.Fprintf(b, "\tdefer stop()\n")
+	fmt.Fprintf(b, "\tfor {\n")
+	fmt.Fprintf(b, "\t\tif err := ctx.Err(); err != nil {\n")
+	fmt.Fprintf(b, "\t\t\treturn err\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\terr := r.rd.ReadInto(&r.record)\n")
+	fmt.Fprintf(b, "\t\tif errors.Is(err, perf.ErrFlushed) {\n")
+	fmt.Fprintf(b, "\t\t\tcontinue\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\t\treturn err\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tif r.record.LostSamples == 0 {\n")
+	fmt.Fprintf(b, "\t\t\treturn nil\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tif cpu := r.record.CPU; cpu >= 0 && cpu < len(r.lost) {\n")
+	fmt.Fprintf(b, "\t\t\tr.lost[cpu].Add(r.record.LostSamples)\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tif err := r.recordSample(r.record.CPU, r.record.LostSamples, nil); err != nil {\n")
+	fmt.Fprintf(b, "\t\t\treturn err\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// LostSamples returns, indexed by CPU, the number of samples dropped because\n")
+	fmt.Fprintf(b, "// that CPU's buffer was full. It is safe to call while another goroutine reads.\n")
+	fmt.Fprintf(b, "func (r *%s) LostSamples() []uint64 {\n", name)
+	fmt.Fprintf(b, "\tlost := make([]uint64, len(r.lost))\n")
+	fmt.Fprintf(b, "\tfor cpu := range r.lost {\n")
+	fmt.Fprintf(b, "\t\tlost[cpu] = r.lost[cpu].Load()\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn lost\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeReaderRead(b *strings.Builder, w mapWrapper) {
+	name := readerName(w.symbol)
+	rec := w.recordType()
+	cpu := "-1"
+	if w.kind == mapKindPerfEventArray {
+		cpu = "r.record.CPU"
+	}
+
+	fmt.Fprintf(b, "// Read blocks until the next record is available and decodes it. It returns\n")
+	fmt.Fprintf(b, "// ctx.Err() once ctx is done and %s.ErrClosed after Close.\n", w.readerPackage())
+	fmt.Fprintf(b, "func (r *%s) Read(ctx context.Context) (%s, error) {\n", name, rec)
+	fmt.Fprintf(b, "\tif err := r.read(ctx); err != nil {\n")
+	fmt.Fprintf(b, "\t\tvar zero %s\n", rec)
+	fmt.Fprintf(b, "\t\treturn zero, err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn r.decodeRecord()\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// decodeRecord decodes the record read last and writes it to the recording\n")
+	fmt.Fprintf(b, "// started by Record, if any. A failed write is returned with the record.\n")
+	fmt.Fprintf(b, "func (r *%s) decodeRecord() (%s, error) {\n", name, rec)
+	fmt.Fprintf(b, "\tevent, err := %s(r.record.RawSample)\n", decoderName(w.symbol))
+	fmt.Fprintf(b, "\tif recErr := r.recordSample(%s, 0, r.record.RawSample); recErr != nil {\n", cpu)
+	fmt.Fprintf(b, "\t\treturn event, errors.Join(err, recErr)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn event, err\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+// writeReaderAll emits the All iterator of the reader type name, which may be
+// the generated reader, its replay or its fake. With eof, it also stops at
+// the end of a recording.
+func writeReaderAll(b *strings.Builder, w mapWrapper, name string, eof bool) {
+	rec := w.recordType()
+
+	fmt.Fprintf(b, "// All returns an iterator over decoded records. It stops once ctx is done or\n")
+	fmt.Fprintf(b, "// the reader is closed; other errors are yielded alongside a zero record.\n")
+	fmt.Fprintf(b, "func (r *%s) All(ctx context.Context) iter.Seq2[%s, error] {\n", name, rec)
+	fmt.Fprintf(b, "\treturn func(yield func(%s, error) bool) {\n", rec)
+	fmt.Fprintf(b, "\t\tfor {\n")
+	fmt.Fprintf(b, "\t\t\tevent, err := r.Read(ctx)\n")
+	if eof {
+		fmt.Fprintf(b, "\t\t\tif err != nil && (ctx.Err() != nil || errors.Is(err, io.EOF) || errors.Is(err, %s.ErrClosed)) {\n", w.readerPackage())
+	} else {
+		fmt.Fprintf(b, "\t\t\tif err != nil && (ctx.Err() != nil || errors.Is(err, %s.ErrClosed)) {\n", w.readerPackage())
+	}
+	fmt.Fprintf(b, "\t\t\t\treturn\n")
+	fmt.Fprintf(b, "\t\t\t}\n")
+	fmt.Fprintf(b, "\t\t\tif !yield(event, err) {\n")
+	fmt.Fprintf(b, "\t\t\t\treturn\n")
+	fmt.Fprintf(b, "\t\t\t}\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeReaderClose(b *strings.Builder, w mapWrapper) {
+	fmt.Fprintf(b, "// Close releases the reader, interrupting any blocked Read.\n")
+	fmt.Fprintf(b, "func (r *%s) Close() error {\n", readerName(w.symbol))
+	fmt.Fprintf(b, "\treturn r.rd.Close()\n")
+	fmt.Fprintf(b, "}\n\n")
+}
@@ -0,0 +1,197 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestSetEventType(t *testing.T) {
+	defs := map[string]*btf.Struct{
+		"conns": btfMapDefStruct(
+			btfMapField("type", uint32(ebpf.Hash)),
+			btf.Member{Name: "key", Type: &btf.Pointer{Target: connKey}},
+			btf.Member{Name: "value", Type: &btf.Pointer{Target: btfU64}},
+		),
+		"events": btfMapDefStruct(
+			btfMapField("type", uint32(ebpf.RingBuf)),
+			btfMapField("max_entries", 1<<24),
+		),
+	}
+	path := testBPFELFWithBTF(t, "handler", []string{"conns", "events"}, marshalMapsBTF(t, defs, connEvent))
+	info, err := ExtractELFInfo(path)
+	if err != nil {
+		t.Fatalf("ExtractELFInfo: %v", err)
+	}
+
+	for _, tt := range []struct {
+		mapName, typeName string
+		wantErr           string
+	}{
+		{"missing", "main_connEvent", "no such map"},
+		{"conns", "main_connEvent", "not a ring buffer or perf event array"},
+		{"events", "no_such_type", "not found"},
+	} {
+		err := info.SetEventType(tt.mapName, tt.typeName)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("SetEventType(%q, %q) = %v, want error containing %q", tt.mapName, tt.typeName, err, tt.wantErr)
+		}
+	}
+
+	if err := info.SetEventType("events", "main_connEvent"); err != nil {
+		t.Fatalf("SetEventType: %v", err)
+	}
+	if got := info.Events["events"]; got == nil || got.TypeName() != "main_connEvent" {
+		t.Fatalf("events type = %v, want main_connEvent", got)
+	}
+
+	src, err := Generate("loader", info, "")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, want := range []string{
+		"type ConnEvent struct",
+		"func (r *EventsReader) Read(ctx context.Context) (ConnEvent, error)",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated source missing %q", want)
+		}
+	}
+}
+
+func TestSetEventTypeWithoutBTF(t *testing.T) {
+	info := &ELFInfo{Programs: []string{"handler"}, Maps: []string{"events"}}
+	err := info.SetEventType("events", "main_connEvent")
+	if err == nil || !strings.Contains(err.Error(), "object has no BTF") {
+		t.Fatalf("expected missing BTF error, got %v", err)
+	}
+}
+
+func TestGenerateReaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		mapType  ebpf.MapType
+		events   map[string]btf.Type
+		contains []string
+		absent   []string
+	}{
+		{
+			name:    "typed ring buffer records",
+			mapType: ebpf.RingBuf,
+			events:  map[string]btf.Type{"events": connEvent},
+			contains: []string{
+				`"encoding/binary"`,
+				`"github.com/cilium/ebpf/ringbuf"`,
+				"// EventsReader reads ConnEvent records from the events ring buffer.",
+				"func (m EventsMap) NewReader() (*EventsReader, error)",
+				"func (r *EventsReader) Read(ctx context.Context) (ConnEvent, error)",
+				"event, err := decodeEventsRecord(r.record.RawSample)",
+				"binary.Decode(raw, binary.NativeEndian, &event)",
+				"func (r *EventsReader) All(ctx context.Context) iter.Seq2[ConnEvent, error]",
+				"context.AfterFunc(ctx, func() { _ = r.rd.Flush() })",
+				"func (r *EventsReader) Close() error",
+			},
+			absent: []string{`"bytes"`, "LostSamples", `"github.com/cilium/ebpf/perf"`},
+		},
+		{
+			name:    "raw ring buffer records without an event type",
+			mapType: ebpf.RingBuf,
+			contains: []string{
+				`"bytes"`,
+				"// EventsReader reads raw records from the events ring buffer.",
+				"func (r *EventsReader) Read(ctx context.Context) ([]byte, error)",
+				"func decodeEventsRecord(raw []byte) ([]byte, error) {",
+				"return bytes.Clone(raw), nil",
+				"iter.Seq2[[]byte, error]",
+			},
+			absent: []string{"binary.Decode("},
+		},
+		{
+			name:    "typed perf event array samples",
+			mapType: ebpf.PerfEventArray,
+			events:  map[string]btf.Type{"events": connEvent},
+			contains: []string{
+				`"github.com/cilium/ebpf/perf"`,
+				`"sync/atomic"`,
+				"// EventsReader reads ConnEvent records from the events perf event array.",
+				"func (m EventsMap) NewReader(perCPUBuffer int) (*EventsReader, error)",
+				"func (m EventsMap) NewReaderWithOptions(perCPUBuffer int, opts perf.ReaderOptions) (*EventsReader, error)",
+				"lost: make([]atomic.Uint64, m.Map.MaxEntries())",
+				"r.lost[cpu].Add(r.record.LostSamples)",
+				"func (r *EventsReader) LostSamples() []uint64",
+				"func (r *EventsReader) Read(ctx context.Context) (ConnEvent, error)",
+				"errors.Is(err, perf.ErrClosed)",
+			},
+			absent: []string{"ringbuf"},
+		},
+		{
+			name:    "raw perf event array samples",
+			mapType: ebpf.PerfEventArray,
+			contains: []string{
+				"// EventsReader reads raw records from the events perf event array.",
+				"func (r *EventsReader) Read(ctx context.Context) ([]byte, error)",
+				"func (r *EventsReader) LostSamples() []uint64",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ELFInfo{
+				Programs: []string{"handler"},
+				Maps:     []string{"events"},
+				MapDefs:  map[string]MapDef{"events": {Type: tt.mapType, MaxEntries: 4096}},
+				Events:   tt.events,
+			}
+			for _, typ := range tt.events {
+				info.Types = addType(info.Types, typ)
+			}
+			src, err := Generate("loader", info, "")
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			text := string(src)
+			for _, s := range tt.contains {
+				if !strings.Contains(text, s) {
+					t.Errorf("generated source missing %q\n%s", s, text)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(text, s) {
+					t.Errorf("generated source should not contain %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateWithoutEventMapsHasNoReaders(t *testing.T) {
+	info := &ELFInfo{
+		Programs: []string{"handler"},
+		Maps:     []string{"conns"},
+		MapDefs:  map[string]MapDef{"conns": {Type: ebpf.Hash, KeySize: 4, ValueSize: 8}},
+	}
+	src, err := Generate("loader", info, "")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, s := range []string{"ringbuf", "perf", `"context"`, "Reader", "Replay", "RecordedSample"} {
+		if strings.Contains(string(src), s) {
+			t.Errorf("generated source should not contain %q", s)
+		}
+	}
+}
+
+func TestReaderNameCollision(t *testing.T) {
+	info := &ELFInfo{
+		Programs: []string{"handler"},
+		Maps:     []string{"events"},
+		MapDefs:  map[string]MapDef{"events": {Type: ebpf.RingBuf}},
+		Types:    []btf.Type{&btf.Struct{Name: "events_reader"}},
+	}
+	_, err := Generate("loader", info, "")
+	if err == nil || !strings.Contains(err.Error(), "reader both map to") {
+		t.Fatalf("expected reader name collision, got %v", err)
+	}
+}
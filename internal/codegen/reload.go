@@ -0,0 +1,163 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+func writeIncompatibleMapError(b *strings.Builder) {
+	fmt.Fprintf(b, "// IncompatibleMapError is returned by Reload when a map of the new object\n")
+	fmt.Fprintf(b, "// does not match the definition of the existing map it would replace.\n")
+	fmt.Fprintf(b, "type IncompatibleMapError struct {\n")
+	fmt.Fprintf(b, "\tMap string // map symbol name\n")
+	fmt.Fprintf(b, "\tErr error  // wraps ebpf.ErrMapIncompatible\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (e *IncompatibleMapError) Error() string {\n")
+	fmt.Fprintf(b, "\treturn fmt.Sprintf(\"reload map %%s: %%v\", e.Map, e.Err)\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "func (e *IncompatibleMapError) Unwrap() error {\n")
+	fmt.Fprintf(b, "\treturn e.Err\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+// writeMapsReplacements emits Maps.replacements, which collects the maps
+// Reload passes as MapReplacements.
+func writeMapsReplacements(b *strings.Builder, wrappers []mapWrapper) {
+	fmt.Fprintf(b, "// replacements returns the maps of m that spec defines, to be reused when\n")
+	fmt.Fprintf(b, "// loading it. It returns an *IncompatibleMapError if a definition differs.\n")
+	fmt.Fprintf(b, "func (m *Maps) replacements(spec *ebpf.CollectionSpec) (map[string]*ebpf.Map, error) {\n")
+	fmt.Fprintf(b, "\treplacements := make(map[string]*ebpf.Map)\n")
+	fmt.Fprintf(b, "\tfor _, r := range []struct {\n")
+	fmt.Fprintf(b, "\t\tname string\n")
+	fmt.Fprintf(b, "\t\tm    *ebpf.Map\n")
+	fmt.Fprintf(b, "\t}{\n")
+	for _, w := range wrappers {
+		fmt.Fprintf(b, "\t\t{%q, m.%s.Map},\n", w.symbol, w.field)
+	}
+	fmt.Fprintf(b, "\t} {\n")
+	fmt.Fprintf(b, "\t\tms := spec.Maps[r.name]\n")
+	fmt.Fprintf(b, "\t\tif ms == nil || r.m == nil {\n")
+	fmt.Fprintf(b, "\t\t\tcontinue\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\tif err := ms.Compatible(r.m); err != nil {\n")
+	fmt.Fprintf(b, "\t\t\treturn nil, &IncompatibleMapError{Map: r.name, Err: err}\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\treplacements[r.name] = r.m\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn replacements, nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+// writeReloadFuncs emits Objects.Reload and Objects.ReloadSpec. With attach
+// targets they also move the given Links to the new programs. Reload always
+// reads a file, so for embedded and variant loaders its doc comment points
+// to ReloadSpec.
+func writeReloadFuncs(b *strings.Builder, targets []attachTarget, hasMaps, embedded, variants bool) {
+	params, args := "objectPath string", "spec"
+	if len(targets) > 0 {
+		params += ", links *Links, opts AttachOptions"
+		args += ", links, opts"
+	}
+	specParams := strings.Replace(params, "objectPath string", "spec *ebpf.CollectionSpec", 1)
+
+	fmt.Fprintf(b, "// Reload loads a new version of the BPF object from objectPath, reusing the\n")
+	fmt.Fprintf(b, "// maps of o so that their contents carry over. Maps the new object does not\n")
+	fmt.Fprintf(b, "// define are dropped and new ones are created empty; global variables start\n")
+	fmt.Fprintf(b, "// from the new object's values.\n")
+	if hasMaps {
+		fmt.Fprintf(b, "// It returns an *IncompatibleMapError if a map's definition changed.\n")
+	}
+	if len(targets) > 0 {
+		fmt.Fprintf(b, "//\n")
+		fmt.Fprintf(b, "// Each link in links (which may be nil) is moved to the new program with\n")
+		fmt.Fprintf(b, "// link.Update where the link type supports it. Otherwise the new program is\n")
+		fmt.Fprintf(b, "// attached with opts and the old link is unpinned and closed, so for a moment\n")
+		fmt.Fprintf(b, "// both run; pin the links again if needed. On error, the links are moved back.\n")
+	}
+	if variants {
+		fmt.Fprintf(b, "//\n")
+		fmt.Fprintf(b, "// Reload always reads objectPath from disk; it ignores the embedded variants\n")
+		fmt.Fprintf(b, "// and does not select one for the kernel. To reload the variant Load picks,\n")
+		fmt.Fprintf(b, "// pass LoadSpec's CollectionSpec to ReloadSpec.\n")
+	} else if embedded {
+		fmt.Fprintf(b, "//\n")
+		fmt.Fprintf(b, "// Reload always reads objectPath from disk; it ignores the embedded object.\n")
+		fmt.Fprintf(b, "// To reload the embedded object, pass LoadSpec's CollectionSpec to ReloadSpec.\n")
+	}
+	fmt.Fprintf(b, "//\n")
+	fmt.Fprintf(b, "// On success, reopen readers on the new Objects and close o.\n")
+	fmt.Fprintf(b, "func (o *Objects) Reload(%s) (*Objects, error) {\n", params)
+	fmt.Fprintf(b, "\tspec, err := ebpf.LoadCollectionSpec(objectPath)\n")
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"load BPF spec: %%w\", err)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn o.ReloadSpec(%s)\n", args)
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// ReloadSpec is like Reload, but loads spec, for example one parsed from\n")
+	fmt.Fprintf(b, "// memory or taken from Specs.CollectionSpec after adjusting the specs.\n")
+	fmt.Fprintf(b, "func (o *Objects) ReloadSpec(%s) (*Objects, error) {\n", specParams)
+	if hasMaps {
+		fmt.Fprintf(b, "\treplacements, err := o.Maps.replacements(spec)\n")
+		fmt.Fprintf(b, "\tif err != nil {\n")
+		fmt.Fprintf(b, "\t\treturn nil, err\n")
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\tn, err := loadObjects(spec, &ebpf.CollectionOptions{MapReplacements: replacements})\n")
+	} else {
+		fmt.Fprintf(b, "\tn, err := loadObjects(spec, nil)\n")
+	}
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, err\n")
+	fmt.Fprintf(b, "\t}\n")
+	if len(targets) > 0 {
+		fmt.Fprintf(b, "\tif err := n.Programs.swapLinks(links, opts); err != nil {\n")
+		fmt.Fprintf(b, "\t\tif rerr := o.Programs.swapLinks(links, opts); rerr != nil {\n")
+		fmt.Fprintf(b, "\t\t\terr = errors.Join(err, fmt.Errorf(\"move links back: %%w\", rerr))\n")
+		fmt.Fprintf(b, "\t\t}\n")
+		fmt.Fprintf(b, "\t\tn.Close()\n")
+		fmt.Fprintf(b, "\t\treturn nil, err\n")
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn n, nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	if len(targets) > 0 {
+		writeSwapLinks(b, targets)
+	}
+}
+
+func writeSwapLinks(b *strings.Builder, targets []attachTarget) {
+	fmt.Fprintf(b, "// swapLinks moves every link in links to the matching program of p.\n")
+	fmt.Fprintf(b, "func (p *Programs) swapLinks(links *Links, opts AttachOptions) error {\n")
+	fmt.Fprintf(b, "\tif links == nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil\n")
+	fmt.Fprintf(b, "\t}\n")
+	for _, t := range targets {
+		fmt.Fprintf(b, "\tif err := swapLink(&links.%[1]s, p.%[1]s, func() (link.Link, error) { return p.Attach%[1]s(opts) }); err != nil {\n", t.field)
+		fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"swap link %s: %%w\", err)\n", t.symbol)
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// swapLink points *l at prog atomically with link.Update. If the link type\n")
+	fmt.Fprintf(b, "// does not support that, it attaches prog and then closes the old link.\n")
+	fmt.Fprintf(b, "func swapLink(l *link.Link, prog *ebpf.Program, attach func() (link.Link, error)) error {\n")
+	fmt.Fprintf(b, "\tif *l == nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\terr := (*l).Update(prog)\n")
+	fmt.Fprintf(b, "\tif !errors.Is(err, link.ErrNotSupported) {\n")
+	fmt.Fprintf(b, "\t\treturn err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tnext, err := attach()\n")
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tprev := *l\n")
+	fmt.Fprintf(b, "\t*l = next\n")
+	fmt.Fprintf(b, "\treturn errors.Join(prev.Unpin(), prev.Close())\n")
+	fmt.Fprintf(b, "}\n\n")
+}
@@ -0,0 +1,87 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+func TestGenerateReload(t *testing.T) {
+	tests := []struct {
+		name      string
+		info      *ELFInfo
+		embedPath string
+		contains  []string
+		absent    []string
+	}{
+		{
+			name: "maps and attach points",
+			info: &ELFInfo{
+				Programs: []string{"handler"},
+				Sections: map[string]string{"handler": "xdp"},
+				Maps:     []string{"conns"},
+				MapDefs:  map[string]MapDef{"conns": {Type: ebpf.Hash, KeySize: 4, ValueSize: 8}},
+			},
+			embedPath: "probe.bpf.o",
+			contains: []string{
+				"type IncompatibleMapError struct {",
+				"func (e *IncompatibleMapError) Unwrap() error {",
+				`{"conns", m.Conns.Map},`,
+				"return nil, &IncompatibleMapError{Map: r.name, Err: err}",
+				"func (o *Objects) Reload(objectPath string, links *Links, opts AttachOptions) (*Objects, error) {",
+				"func (o *Objects) ReloadSpec(spec *ebpf.CollectionSpec, links *Links, opts AttachOptions) (*Objects, error) {",
+				"loadObjects(spec, &ebpf.CollectionOptions{MapReplacements: replacements})",
+				"if rerr := o.Programs.swapLinks(links, opts); rerr != nil {",
+				"swapLink(&links.Handler, p.Handler, func() (link.Link, error) { return p.AttachHandler(opts) })",
+				"err := (*l).Update(prog)",
+				"if !errors.Is(err, link.ErrNotSupported) {",
+				"// Reload always reads objectPath from disk; it ignores the embedded object.\n// To reload the embedded object, pass LoadSpec's CollectionSpec to ReloadSpec.",
+			},
+		},
+		{
+			name: "variants",
+			info: &ELFInfo{
+				Programs: []string{"handler"},
+				Variants: []Variant{
+					{Name: "probe.v4.bpf.o", EmbedPath: "probe.v4.bpf.o"},
+					{Name: "probe.v2.bpf.o", EmbedPath: "probe.v2.bpf.o"},
+				},
+			},
+			contains: []string{
+				"func (o *Objects) Reload(objectPath string) (*Objects, error) {\n\tspec, err := ebpf.LoadCollectionSpec(objectPath)",
+				"// Reload always reads objectPath from disk; it ignores the embedded variants\n// and does not select one for the kernel. To reload the variant Load picks,\n// pass LoadSpec's CollectionSpec to ReloadSpec.",
+				"func (o *Objects) ReloadSpec(spec *ebpf.CollectionSpec) (*Objects, error) {",
+			},
+		},
+		{
+			name: "no maps or attach points",
+			info: &ELFInfo{Programs: []string{"handler"}},
+			contains: []string{
+				"func (o *Objects) Reload(objectPath string) (*Objects, error) {",
+				"func (o *Objects) ReloadSpec(spec *ebpf.CollectionSpec) (*Objects, error) {",
+				"n, err := loadObjects(spec, nil)",
+			},
+			absent: []string{"IncompatibleMapError", "replacements", "swapLink", "ignores the embedded"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := Generate("loader", tt.info, tt.embedPath)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			text := string(src)
+			for _, s := range tt.contains {
+				if !strings.Contains(text, s) {
+					t.Errorf("generated source missing %q\n%s", s, text)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(text, s) {
+					t.Errorf("generated source should not contain %q", s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// recordingMagic starts every file written by a generated reader's Record.
+// The trailing byte is the format version.
+const recordingMagic = "tinybpf-events\x00\x01"
+
+// replayName returns the replay type name for a ring buffer or perf event
+// array map symbol.
+func replayName(symbol string) string {
+	return exportedName(symbol) + "Replay"
+}
+
+// decoderName returns the name of the function that decodes the map's raw
+// records for both its reader and its replay.
+func decoderName(symbol string) string {
+	return "decode" + exportedName(symbol) + "Record"
+}
+
+// addReplayImports records the imports needed by the recording and replay
+// code of wrappers.
+func addReplayImports(imports importSet, wrappers []mapWrapper) {
+	for _, w := range wrappers {
+		if w.hasReader() {
+			for _, path := range []string{"bufio", "encoding/binary", "io", "sync/atomic", "time"} {
+				imports[path] = true
+			}
+			return
+		}
+	}
+}
+
+// writeDecoder emits the decoder shared by the map's reader and replay.
+func writeDecoder(b *strings.Builder, w mapWrapper) {
+	rec := w.recordType()
+	fmt.Fprintf(b, "// %s decodes a raw %s record, as read by %s and %s.\n",
+		decoderName(w.symbol), w.symbol, readerName(w.symbol), replayName(w.symbol))
+	fmt.Fprintf(b, "func %s(raw []byte) (%s, error) {\n", decoderName(w.symbol), rec)
+	if w.event == "" {
+		fmt.Fprintf(b, "\treturn bytes.Clone(raw), nil\n")
+	} else {
+		fmt.Fprintf(b, "\tvar event %s\n", rec)
+		fmt.Fprintf(b, "\tif _, err := binary.Decode(raw, binary.NativeEndian, &event); err != nil {\n")
+		fmt.Fprintf(b, "\t\treturn event, fmt.Errorf(\"decode %s record: %%w\", err)\n", w.symbol)
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\treturn event, nil\n")
+	}
+	fmt.Fprintf(b, "}\n\n")
+}
+
+// writeReaderRecord emits the reader's Record method and the hook its read
+// method calls for every sample.
+func writeReaderRecord(b *strings.Builder, w mapWrapper) {
+	name := readerName(w.symbol)
+	cpus := "0"
+	if w.kind == mapKindPerfEventArray {
+		cpus = "len(r.lost)"
+	}
+
+	fmt.Fprintf(b, "// Record writes every sample read from now on to w, with the time it was read\n")
+	fmt.Fprintf(b, "// and the CPU that produced it (-1 for ring buffers), for New%s to\n", replayName(w.symbol))
+	fmt.Fprintf(b, "// replay. A failed write is returned by the Read that hit it, together with\n")
+	fmt.Fprintf(b, "// the record it read. Wrap files in a bufio.Writer, flushed after the last\n")
+	fmt.Fprintf(b, "// Read, to avoid a write per sample.\n")
+	fmt.Fprintf(b, "func (r *%s) Record(w io.Writer) error {\n", name)
+	fmt.Fprintf(b, "\tif err := writeRecordingHeader(w, %q, %s); err != nil {\n", w.symbol, cpus)
+	fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"record %s: %%w\", err)\n", w.symbol)
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tr.rec = w\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// recordSample writes a sample to the recording started by Record, if any.\n")
+	fmt.Fprintf(b, "func (r *%s) recordSample(cpu int, lost uint64, raw []byte) error {\n", name)
+	fmt.Fprintf(b, "\tif r.rec == nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tsample := RecordedSample{Time: time.Now(), CPU: cpu, Lost: lost, Raw: raw}\n")
+	fmt.Fprintf(b, "\tif err := writeRecordedSample(r.rec, sample); err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"record %s sample: %%w\", err)\n", w.symbol)
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+// writeReplay emits the replay type of a ring buffer or perf event array,
+// which has the methods of its reader but reads a recording.
+func writeReplay(b *strings.Builder, w mapWrapper) {
+	name := replayName(w.symbol)
+	rec := w.recordType()
+	perf := w.kind == mapKindPerfEventArray
+
+	fmt.Fprintf(b, "// %s replays the samples recorded by %s.Record, decoding them\n", name, readerName(w.symbol))
+	fmt.Fprintf(b, "// as the reader does, without a kernel. Samples are returned as fast as they\n")
+	fmt.Fprintf(b, "// are read; Sample reports when each one was recorded.\n")
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	fmt.Fprintf(b, "\tsrc    *bufio.Reader\n")
+	fmt.Fprintf(b, "\tsample RecordedSample\n")
+	fmt.Fprintf(b, "\tclosed atomic.Bool\n")
+	if perf {
+		fmt.Fprintf(b, "\tlost   []atomic.Uint64\n")
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// New%s returns a replay of the recording read from r. Close does not close r.\n", name)
+	fmt.Fprintf(b, "func New%s(r io.Reader) (*%s, error) {\n", name, name)
+	fmt.Fprintf(b, "\tsrc := bufio.NewReader(r)\n")
+	if perf {
+		fmt.Fprintf(b, "\tcpus, err := readRecordingHeader(src, %q)\n", w.symbol)
+		fmt.Fprintf(b, "\tif err != nil {\n")
+		fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"replay %s: %%w\", err)\n", w.symbol)
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\treturn &%s{src: src, lost: make([]atomic.Uint64, cpus)}, nil\n", name)
+	} else {
+		fmt.Fprintf(b, "\tif _, err := readRecordingHeader(src, %q); err != nil {\n", w.symbol)
+		fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"replay %s: %%w\", err)\n", w.symbol)
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\treturn &%s{src: src}, nil\n", name)
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Read decodes the next recorded sample. It returns io.EOF at the end of the\n")
+	fmt.Fprintf(b, "// recording, ctx.Err() once ctx is done and %s.ErrClosed after Close.\n", w.readerPackage())
+	fmt.Fprintf(b, "func (r *%s) Read(ctx context.Context) (%s, error) {\n", name, rec)
+	fmt.Fprintf(b, "\tvar zero %s\n", rec)
+	// Only perf replays loop, to skip the records of lost samples.
+	in := "\t"
+	if perf {
+		fmt.Fprintf(b, "\tfor {\n")
+		in = "\t\t"
+	}
+	fmt.Fprintf(b, "%sif err := ctx.Err(); err != nil {\n", in)
+	fmt.Fprintf(b, "%s\treturn zero, err\n", in)
+	fmt.Fprintf(b, "%s}\n", in)
+	fmt.Fprintf(b, "%sif r.closed.Load() {\n", in)
+	fmt.Fprintf(b, "%s\treturn zero, %s.ErrClosed\n", in, w.readerPackage())
+	fmt.Fprintf(b, "%s}\n", in)
+	fmt.Fprintf(b, "%ssample, err := readRecordedSample(r.src)\n", in)
+	fmt.Fprintf(b, "%sif err != nil {\n", in)
+	fmt.Fprintf(b, "%s\treturn zero, err\n", in)
+	fmt.Fprintf(b, "%s}\n", in)
+	if perf {
+		fmt.Fprintf(b, "\t\tif sample.Lost > 0 {\n")
+		fmt.Fprintf(b, "\t\t\tif sample.CPU >= 0 && sample.CPU < len(r.lost) {\n")
+		fmt.Fprintf(b, "\t\t\t\tr.lost[sample.CPU].Add(sample.Lost)\n")
+		fmt.Fprintf(b, "\t\t\t}\n")
+		fmt.Fprintf(b, "\t\t\tcontinue\n")
+		fmt.Fprintf(b, "\t\t}\n")
+	}
+	fmt.Fprintf(b, "%sr.sample = sample\n", in)
+	fmt.Fprintf(b, "%sreturn %s(sample.Raw)\n", in, decoderName(w.symbol))
+	if perf {
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Sample returns the recorded sample behind the record Read last returned.\n")
+	fmt.Fprintf(b, "func (r *%s) Sample() RecordedSample {\n", name)
+	fmt.Fprintf(b, "\treturn r.sample\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	if perf {
+		fmt.Fprintf(b, "// LostSamples returns, indexed by CPU, the number of samples the recorded\n")
+		fmt.Fprintf(b, "// reader had lost up to the last Read.\n")
+		fmt.Fprintf(b, "func (r *%s) LostSamples() []uint64 {\n", name)
+		fmt.Fprintf(b, "\tlost := make([]uint64, len(r.lost))\n")
+		fmt.Fprintf(b, "\tfor cpu := range r.lost {\n")
+		fmt.Fprintf(b, "\t\tlost[cpu] = r.lost[cpu].Load()\n")
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\treturn lost\n")
+		fmt.Fprintf(b, "}\n\n")
+	}
+
+	writeReaderAll(b, w, name, true)
+
+	fmt.Fprintf(b, "// Close stops the replay; later reads fail.\n")
+	fmt.Fprintf(b, "func (r *%s) Close() error {\n", name)
+	fmt.Fprintf(b, "\tr.closed.Store(true)\n")
+	fmt.Fprintf(b, "\treturn nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+// writeRecordingFormat emits RecordedSample and the functions that write and
+// read recordings. All fields are little-endian; raw samples are stored as
+// the kernel produced them, in native byte order.
+func writeRecordingFormat(b *strings.Builder) {
+	fmt.Fprintf(b, "// RecordedSample is a raw sample written by a reader's Record.\n")
+	fmt.Fprintf(b, "type RecordedSample struct {\n")
+	fmt.Fprintf(b, "\tTime time.Time // when the sample was read\n")
+	fmt.Fprintf(b, "\tCPU  int       // CPU that produced the sample, or -1 for ring buffers\n")
+	fmt.Fprintf(b, "\tLost uint64    // perf samples lost on CPU; Raw is empty if set\n")
+	fmt.Fprintf(b, "\tRaw  []byte\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// recordingMagic starts a recording; the last byte is the format version.\n")
+	fmt.Fprintf(b, "const recordingMagic = %q\n\n", recordingMagic)
+
+	fmt.Fprintf(b, "// writeRecordingHeader starts a recording of the named map.\n")
+	fmt.Fprintf(b, "func writeRecordingHeader(w io.Writer, name string, cpus int) error {\n")
+	fmt.Fprintf(b, "\tbuf := []byte(recordingMagic)\n")
+	fmt.Fprintf(b, "\tbuf = binary.LittleEndian.AppendUint32(buf, uint32(cpus))\n")
+	fmt.Fprintf(b, "\tbuf = binary.LittleEndian.AppendUint32(buf, uint32(len(name)))\n")
+	fmt.Fprintf(b, "\t_, err := w.Write(append(buf, name...))\n")
+	fmt.Fprintf(b, "\treturn err\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// readRecordingHeader checks that r holds a recording of the named map and\n")
+	fmt.Fprintf(b, "// returns the number of CPUs it was recorded with.\n")
+	fmt.Fprintf(b, "func readRecordingHeader(r io.Reader, name string) (int, error) {\n")
+	fmt.Fprintf(b, "\tbuf := make([]byte, len(recordingMagic)+8)\n")
+	fmt.Fprintf(b, "\tif _, err := io.ReadFull(r, buf); err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn 0, fmt.Errorf(\"read recording header: %%w\", err)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tif string(buf[:len(recordingMagic)]) != recordingMagic {\n")
+	fmt.Fprintf(b, "\t\treturn 0, errors.New(\"not a recording, or of an unsupported version\")\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tcpus := binary.LittleEndian.Uint32(buf[len(recordingMagic):])\n")
+	fmt.Fprintf(b, "\tn := binary.LittleEndian.Uint32(buf[len(recordingMagic)+4:])\n")
+	fmt.Fprintf(b, "\trecorded, err := io.ReadAll(io.LimitReader(r, int64(n)))\n")
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn 0, fmt.Errorf(\"read recording header: %%w\", err)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tif string(recorded) != name {\n")
+	fmt.Fprintf(b, "\t\treturn 0, fmt.Errorf(\"recording is of map %%q, not %%q\", recorded, name)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn int(cpus), nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// writeRecordedSample appends s to a recording.\n")
+	fmt.Fprintf(b, "func writeRecordedSample(w io.Writer, s RecordedSample) error {\n")
+	fmt.Fprintf(b, "\tbuf := make([]byte, 0, 24+len(s.Raw))\n")
+	fmt.Fprintf(b, "\tbuf = binary.LittleEndian.AppendUint64(buf, uint64(s.Time.UnixNano()))\n")
+	fmt.Fprintf(b, "\tbuf = binary.LittleEndian.AppendUint32(buf, uint32(int32(s.CPU)))\n")
+	fmt.Fprintf(b, "\tbuf = binary.LittleEndian.AppendUint32(buf, uint32(len(s.Raw)))\n")
+	fmt.Fprintf(b, "\tbuf = binary.LittleEndian.AppendUint64(buf, s.Lost)\n")
+	fmt.Fprintf(b, "\t_, err := w.Write(append(buf, s.Raw...))\n")
+	fmt.Fprintf(b, "\treturn err\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// readRecordedSample reads the next sample of a recording. It returns io.EOF\n")
+	fmt.Fprintf(b, "// at the end and io.ErrUnexpectedEOF if the recording is truncated.\n")
+	fmt.Fprintf(b, "func readRecordedSample(r io.Reader) (RecordedSample, error) {\n")
+	fmt.Fprintf(b, "\tvar hdr [24]byte\n")
+	fmt.Fprintf(b, "\tif _, err := io.ReadFull(r, hdr[:]); err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn RecordedSample{}, err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tsize := int64(binary.LittleEndian.Uint32(hdr[12:]))\n")
+	fmt.Fprintf(b, "\traw, err := io.ReadAll(io.LimitReader(r, size))\n")
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn RecordedSample{}, err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tif int64(len(raw)) != size {\n")
+	fmt.Fprintf(b, "\t\treturn RecordedSample{}, io.ErrUnexpectedEOF\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn RecordedSample{\n")
+	fmt.Fprintf(b, "\t\tTime: time.Unix(0, int64(binary.LittleEndian.Uint64(hdr[0:]))),\n")
+	fmt.Fprintf(b, "\t\tCPU:  int(int32(binary.LittleEndian.Uint32(hdr[8:]))),\n")
+	fmt.Fprintf(b, "\t\tLost: binary.LittleEndian.Uint64(hdr[16:]),\n")
+	fmt.Fprintf(b, "\t\tRaw:  raw,\n")
+	fmt.Fprintf(b, "\t}, nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
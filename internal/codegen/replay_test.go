@@ -0,0 +1,174 @@
+package codegen
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestGenerateReplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		mapType  ebpf.MapType
+		contains []string
+		absent   []string
+	}{
+		{
+			name:    "ring buffer",
+			mapType: ebpf.RingBuf,
+			contains: []string{
+				`"bufio"`,
+				`"time"`,
+				"rec    io.Writer",
+				"func (r *EventsReader) Record(w io.Writer) error {",
+				`writeRecordingHeader(w, "events", 0)`,
+				"if recErr := r.recordSample(-1, 0, r.record.RawSample); recErr != nil {\n\t\treturn event, errors.Join(err, recErr)",
+				"type EventsReplay struct {",
+				"func NewEventsReplay(r io.Reader) (*EventsReplay, error) {",
+				`if _, err := readRecordingHeader(src, "events"); err != nil {`,
+				"func (r *EventsReplay) Read(ctx context.Context) ([]byte, error) {\n\tvar zero []byte\n\tif err := ctx.Err(); err != nil {",
+				"\treturn zero, ringbuf.ErrClosed",
+				"return decodeEventsRecord(sample.Raw)",
+				"func (r *EventsReplay) Sample() RecordedSample {",
+				"errors.Is(err, io.EOF) || errors.Is(err, ringbuf.ErrClosed)",
+				"type RecordedSample struct {",
+				`const recordingMagic = "tinybpf-events\x00\x01"`,
+				"func writeRecordedSample(w io.Writer, s RecordedSample) error {",
+				"func readRecordedSample(r io.Reader) (RecordedSample, error) {",
+			},
+			absent: []string{"func (r *EventsReplay) LostSamples() []uint64"},
+		},
+		{
+			name:    "perf event array",
+			mapType: ebpf.PerfEventArray,
+			contains: []string{
+				`writeRecordingHeader(w, "events", len(r.lost))`,
+				"if recErr := r.recordSample(r.record.CPU, 0, r.record.RawSample); recErr != nil {",
+				"if err := r.recordSample(r.record.CPU, r.record.LostSamples, nil); err != nil {",
+				"return &EventsReplay{src: src, lost: make([]atomic.Uint64, cpus)}, nil",
+				"\tvar zero []byte\n\tfor {\n\t\tif err := ctx.Err(); err != nil {",
+				"r.lost[sample.CPU].Add(sample.Lost)",
+				"func (r *EventsReplay) LostSamples() []uint64",
+				"errors.Is(err, io.EOF) || errors.Is(err, perf.ErrClosed)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ELFInfo{
+				Programs: []string{"handler"},
+				Maps:     []string{"events"},
+				MapDefs:  map[string]MapDef{"events": {Type: tt.mapType, MaxEntries: 4096}},
+			}
+			src, err := Generate("loader", info, "")
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			text := string(src)
+			for _, s := range tt.contains {
+				if !strings.Contains(text, s) {
+					t.Errorf("generated source missing %q\n%s", s, text)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(text, s) {
+					t.Errorf("generated source should not contain %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayNameCollision(t *testing.T) {
+	for _, typeName := range []string{"events_replay", "recorded_sample"} {
+		info := &ELFInfo{
+			Programs: []string{"handler"},
+			Maps:     []string{"events"},
+			MapDefs:  map[string]MapDef{"events": {Type: ebpf.RingBuf}},
+			Types:    []btf.Type{&btf.Struct{Name: typeName}},
+		}
+		if _, err := Generate("loader", info, ""); err == nil || !strings.Contains(err.Error(), "name collision") {
+			t.Errorf("type %s: expected name collision, got %v", typeName, err)
+		}
+	}
+}
+
+// TestReaderRecordWriteFailure compiles a generated loader and checks that a
+// failed recording write is returned together with the record read.
+func TestReaderRecordWriteFailure(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiles a generated loader")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	info := &ELFInfo{
+		Programs: []string{"handler"},
+		Maps:     []string{"events"},
+		MapDefs:  map[string]MapDef{"events": {Type: ebpf.RingBuf, MaxEntries: 4096}},
+	}
+	src, err := Generate("loader", info, "")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	sum, err := os.ReadFile(filepath.Join("..", "..", "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const goMod = `module example.com/loader
+
+go 1.24.0
+
+require github.com/cilium/ebpf v0.21.0
+
+require golang.org/x/sys v0.37.0 // indirect
+`
+	const test = `package loader
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestRecordWriteFailure(t *testing.T) {
+	r := &EventsReader{rec: failingWriter{}}
+	r.record.RawSample = []byte{1, 2, 3}
+	event, err := r.decodeRecord()
+	if err == nil || !strings.Contains(err.Error(), "record events sample: disk full") {
+		t.Errorf("error = %v, want the recording error", err)
+	}
+	if !bytes.Equal(event, []byte{1, 2, 3}) {
+		t.Errorf("record = %v, want the sample read", event)
+	}
+}
+`
+	dir := t.TempDir()
+	for name, content := range map[string][]byte{
+		"go.mod":         []byte(goMod),
+		"go.sum":         sum,
+		"loader_bpf.go":  src,
+		"loader_test.go": []byte(test),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(goTool, "test", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go test of the generated loader: %v\n%s", err, out)
+	}
+}
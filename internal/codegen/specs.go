@@ -0,0 +1,68 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// specsMethods are the methods of the generated Specs type; program and map
+// fields promoted into Specs must not share their names.
+var specsMethods = []string{"CollectionSpec", "Load", "LoadPinned"}
+
+func writeSpecsStructs(b *strings.Builder, programs, maps []string) {
+	fmt.Fprintf(b, "// ProgramSpecs contains the specs of all BPF programs.\n")
+	fmt.Fprintf(b, "type ProgramSpecs struct {\n")
+	for _, name := range programs {
+		fmt.Fprintf(b, "\t%s *ebpf.ProgramSpec `ebpf:\"%s\"`\n", exportedName(name), name)
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// MapSpecs contains the specs of all BPF maps.\n")
+	fmt.Fprintf(b, "type MapSpecs struct {\n")
+	for _, name := range maps {
+		fmt.Fprintf(b, "\t%s *ebpf.MapSpec `ebpf:\"%s\"`\n", exportedName(name), name)
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Specs contains the program and map specs of the BPF object. Changes to\n")
+	fmt.Fprintf(b, "// them, such as a different MaxEntries, apply when Load is called.\n")
+	fmt.Fprintf(b, "type Specs struct {\n")
+	fmt.Fprintf(b, "\tProgramSpecs\n")
+	fmt.Fprintf(b, "\tMapSpecs\n\n")
+	fmt.Fprintf(b, "\tcollection *ebpf.CollectionSpec\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// CollectionSpec returns the spec the program and map specs belong to.\n")
+	fmt.Fprintf(b, "func (s *Specs) CollectionSpec() *ebpf.CollectionSpec {\n")
+	fmt.Fprintf(b, "\treturn s.collection\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Load loads the specs into the kernel and returns populated Objects.\n")
+	fmt.Fprintf(b, "// opts may be nil.\n")
+	fmt.Fprintf(b, "func (s *Specs) Load(opts *ebpf.CollectionOptions) (*Objects, error) {\n")
+	fmt.Fprintf(b, "\treturn loadObjects(s.collection, opts)\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeLoadSpecFunc(b *strings.Builder, embedded bool) {
+	source, params, args := "the BPF object at objectPath", "objectPath string", "objectPath"
+	if embedded {
+		source, params, args = "the embedded BPF object", "", ""
+	}
+	fmt.Fprintf(b, "// LoadSpec parses %s and returns its specs without\n", source)
+	fmt.Fprintf(b, "// loading anything into the kernel.\n")
+	fmt.Fprintf(b, "func LoadSpec(%s) (*Specs, error) {\n", params)
+	fmt.Fprintf(b, "\tspec, err := loadCollectionSpec(%s)\n", args)
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\ts := Specs{collection: spec}\n")
+	fmt.Fprintf(b, "\tif err := spec.Assign(&s.ProgramSpecs); err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"assign program specs: %%w\", err)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\tif err := spec.Assign(&s.MapSpecs); err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn nil, fmt.Errorf(\"assign map specs: %%w\", err)\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn &s, nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
@@ -0,0 +1,65 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateSpecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		embedPath string
+		contains  []string
+	}{
+		{
+			name: "file path mode",
+			contains: []string{
+				"type ProgramSpecs struct {\n\tHandler *ebpf.ProgramSpec `ebpf:\"handler\"`\n}",
+				"type MapSpecs struct {\n\tEvents *ebpf.MapSpec `ebpf:\"events\"`\n}",
+				"type Specs struct {\n\tProgramSpecs\n\tMapSpecs\n\n\tcollection *ebpf.CollectionSpec\n}",
+				"func LoadSpec(objectPath string) (*Specs, error) {",
+				"spec.Assign(&s.ProgramSpecs)",
+				"spec.Assign(&s.MapSpecs)",
+				"func (s *Specs) CollectionSpec() *ebpf.CollectionSpec {",
+				"func (s *Specs) Load(opts *ebpf.CollectionOptions) (*Objects, error) {",
+				"func Load(objectPath string) (*Objects, error) {\n\treturn LoadWithOptions(objectPath, nil)\n}",
+				"func LoadWithOptions(objectPath string, opts *ebpf.CollectionOptions) (*Objects, error) {",
+				"spec.LoadAndAssign(&objs, opts)",
+			},
+		},
+		{
+			name:      "embed mode",
+			embedPath: "probe.bpf.o",
+			contains: []string{
+				"func LoadSpec() (*Specs, error) {",
+				"func Load() (*Objects, error) {\n\treturn LoadWithOptions(nil)\n}",
+				"func LoadWithOptions(opts *ebpf.CollectionOptions) (*Objects, error) {",
+				"func loadCollectionSpec() (*ebpf.CollectionSpec, error) {",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ELFInfo{Programs: []string{"handler"}, Maps: []string{"events"}}
+			src, err := Generate("loader", info, tt.embedPath)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(string(src), s) {
+					t.Errorf("generated source missing %q\n%s", s, src)
+				}
+			}
+		})
+	}
+}
+
+func TestSpecsNameCollision(t *testing.T) {
+	for _, name := range []string{"load", "collection_spec"} {
+		info := &ELFInfo{Programs: []string{"handler"}, Maps: []string{name}}
+		_, err := Generate("loader", info, "")
+		if err == nil || !strings.Contains(err.Error(), "name collision") {
+			t.Errorf("map %q: expected name collision, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package codegen
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+)
+
+// Variant is one build of the BPF object embedded in a loader that selects
+// the best variant for the running kernel.
+type Variant struct {
+	// Name identifies the variant, e.g. its object file name.
+	Name string
+
+	// EmbedPath is the object's path relative to the generated file.
+	EmbedPath string
+
+	// Requires are the kernel features the variant needs.
+	Requires []Requirement
+}
+
+// Requirement is a kernel feature a variant needs, checked at load time.
+type Requirement struct {
+	// Desc describes the feature in generated comments.
+	Desc string
+
+	// Probe is a Go expression of type func() error that returns nil when
+	// the running kernel has the feature.
+	Probe string
+}
+
+// Requirements returns the kernel features needed by the BPF object read
+// from r: the instruction set version, map types, helpers and kfuncs.
+func Requirements(r io.ReaderAt) ([]Requirement, error) {
+	spec, err := ebpf.LoadCollectionSpecFromReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("load collection spec: %w", err)
+	}
+	return requirementsFromSpec(spec), nil
+}
+
+// requirementsFromSpec derives the requirements of spec in a stable order.
+func requirementsFromSpec(spec *ebpf.CollectionSpec) []Requirement {
+	var reqs []Requirement
+	isa := 1
+	helpers := make(map[string]Requirement)
+	kfuncs := make(map[string]Requirement)
+	for _, prog := range spec.Programs {
+		for i := range prog.Instructions {
+			ins := &prog.Instructions[i]
+			isa = max(isa, isaVersion(ins.OpCode))
+			switch {
+			case ins.IsBuiltinCall():
+				if helperProbeImplemented(prog.Type) {
+					fn := asm.BuiltinFunc(ins.Constant)
+					key := prog.Type.String() + "/" + fn.String()
+					helpers[key] = Requirement{
+						Desc: fmt.Sprintf("helper %s in %s programs", fn, prog.Type),
+						Probe: fmt.Sprintf("func() error { return features.HaveProgramHelper(%s, %s) }",
+							constExpr("ebpf", "ProgramType", prog.Type, uint64(prog.Type)),
+							constExpr("asm", "BuiltinFunc", fn, uint64(fn))),
+					}
+				}
+			case ins.IsKfuncCall():
+				if fn := btf.FuncMetadata(ins); fn != nil {
+					kfuncs[fn.Name] = Requirement{
+						Desc:  "kfunc " + fn.Name,
+						Probe: fmt.Sprintf("func() error { return haveKfunc(%q) }", fn.Name),
+					}
+				}
+			}
+		}
+	}
+	if isa > 1 {
+		reqs = append(reqs, Requirement{
+			Desc:  fmt.Sprintf("BPF ISA v%d", isa),
+			Probe: fmt.Sprintf("features.HaveV%dISA", isa),
+		})
+	}
+
+	mapTypes := make(map[string]Requirement)
+	for _, m := range spec.Maps {
+		if m.Type == ebpf.Hash || m.Type == ebpf.Array {
+			continue
+		}
+		mapTypes[m.Type.String()] = Requirement{
+			Desc:  m.Type.String() + " maps",
+			Probe: fmt.Sprintf("func() error { return features.HaveMapType(%s) }", constExpr("ebpf", "MapType", m.Type, uint64(m.Type))),
+		}
+	}
+	for _, set := range []map[string]Requirement{mapTypes, helpers, kfuncs} {
+		for _, key := range slices.Sorted(maps.Keys(set)) {
+			reqs = append(reqs, set[key])
+		}
+	}
+	return reqs
+}
+
+// constExpr returns the Go expression for the constant v of pkg.typ: its
+// name if the Stringer knows it, otherwise a conversion.
+func constExpr(pkg, typ string, v fmt.Stringer, n uint64) string {
+	if name := v.String(); token.IsIdentifier(name) {
+		return pkg + "." + name
+	}
+	return fmt.Sprintf("%s.%s(%d)", pkg, typ, n)
+}
+
+// isaVersion returns the lowest BPF instruction set version (-mcpu) that
+// has op.
+func isaVersion(op asm.OpCode) int {
+	class := op.Class()
+	switch {
+	case class.IsLoad() && op.Mode() == asm.MemSXMode:
+		return 4
+	case class.IsALU():
+		switch op.ALUOp() {
+		case asm.SDiv, asm.SMod, asm.MovSX8, asm.MovSX16, asm.MovSX32:
+			return 4
+		case asm.Swap:
+			if class == asm.ALU64Class {
+				return 4
+			}
+		}
+	case class == asm.Jump32Class:
+		if op.JumpOp() == asm.Ja {
+			return 4
+		}
+		return 3
+	case class == asm.JumpClass:
+		switch op.JumpOp() {
+		case asm.JLT, asm.JLE, asm.JSLT, asm.JSLE:
+			return 2
+		}
+	}
+	return 1
+}
+
+// helperProbeImplemented reports whether features.HaveProgramHelper can
+// probe helpers for programs of type pt.
+func helperProbeImplemented(pt ebpf.ProgramType) bool {
+	switch pt {
+	case ebpf.UnspecifiedProgram, ebpf.Extension, ebpf.LSM, ebpf.StructOps, ebpf.Tracing:
+		return false
+	}
+	return true
+}
+
+// CheckVariant returns an error unless other, another build of the object
+// described by info, declares the same programs, maps and variables, so
+// that the generated types fit both.
+func (info *ELFInfo) CheckVariant(name string, other *ELFInfo) error {
+	if !slices.Equal(info.Programs, other.Programs) {
+		return fmt.Errorf("variant %s: programs %v differ from %v", name, other.Programs, info.Programs)
+	}
+	if !slices.Equal(info.Maps, other.Maps) {
+		return fmt.Errorf("variant %s: maps %v differ from %v", name, other.Maps, info.Maps)
+	}
+	for _, m := range info.Maps {
+		a, b := info.MapDefs[m], other.MapDefs[m]
+		if a.Type != b.Type || a.KeySize != b.KeySize || a.ValueSize != b.ValueSize {
+			return fmt.Errorf("variant %s: map %s is defined differently", name, m)
+		}
+	}
+	if !slices.Equal(variableNames(info.Variables), variableNames(other.Variables)) {
+		return fmt.Errorf("variant %s: global variables differ", name)
+	}
+	return nil
+}
+
+func writeVariants(b *strings.Builder, variants []Variant, kfuncs bool) {
+	for i, v := range variants {
+		fmt.Fprintf(b, "//go:embed %s\n", v.EmbedPath)
+		fmt.Fprintf(b, "var _bpfVariant%d []byte\n\n", i)
+	}
+
+	fmt.Fprintf(b, "// variant is one embedded build of the BPF object and the kernel features\n")
+	fmt.Fprintf(b, "// it needs.\n")
+	fmt.Fprintf(b, "type variant struct {\n")
+	fmt.Fprintf(b, "\tname     string\n")
+	fmt.Fprintf(b, "\tobject   []byte\n")
+	fmt.Fprintf(b, "\trequires []func() error\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// variants are the embedded builds, most preferred first.\n")
+	fmt.Fprintf(b, "var variants = []variant{\n")
+	for i, v := range variants {
+		fmt.Fprintf(b, "\t{%q, _bpfVariant%d, []func() error{\n", v.Name, i)
+		for _, r := range v.Requires {
+			fmt.Fprintf(b, "\t\t%s, // %s\n", r.Probe, r.Desc)
+		}
+		fmt.Fprintf(b, "\t}},\n")
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// selectVariant probes the kernel once and returns the first variant whose\n")
+	fmt.Fprintf(b, "// features are all supported.\n")
+	fmt.Fprintf(b, "var selectVariant = sync.OnceValues(func() (*variant, error) {\n")
+	fmt.Fprintf(b, "\tvar errs []error\n")
+	fmt.Fprintf(b, "next:\n")
+	fmt.Fprintf(b, "\tfor i := range variants {\n")
+	fmt.Fprintf(b, "\t\tfor _, probe := range variants[i].requires {\n")
+	fmt.Fprintf(b, "\t\t\tif err := probe(); err != nil {\n")
+	fmt.Fprintf(b, "\t\t\t\terrs = append(errs, fmt.Errorf(\"%%s: %%w\", variants[i].name, err))\n")
+	fmt.Fprintf(b, "\t\t\t\tcontinue next\n")
+	fmt.Fprintf(b, "\t\t\t}\n")
+	fmt.Fprintf(b, "\t\t}\n")
+	fmt.Fprintf(b, "\t\treturn &variants[i], nil\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn nil, fmt.Errorf(\"no BPF object variant is supported by this kernel: %%w\", errors.Join(errs...))\n")
+	fmt.Fprintf(b, "})\n\n")
+
+	fmt.Fprintf(b, "// Variant returns the name of the embedded object variant that Load uses on\n")
+	fmt.Fprintf(b, "// this kernel, probing the kernel's features on first use.\n")
+	fmt.Fprintf(b, "func Variant() (string, error) {\n")
+	fmt.Fprintf(b, "\tv, err := selectVariant()\n")
+	fmt.Fprintf(b, "\tif err != nil {\n")
+	fmt.Fprintf(b, "\t\treturn \"\", err\n")
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "\treturn v.name, nil\n")
+	fmt.Fprintf(b, "}\n\n")
+
+	if kfuncs {
+		fmt.Fprintf(b, "// haveKfunc returns nil if the kernel's BTF declares the kfunc name.\n")
+		fmt.Fprintf(b, "func haveKfunc(name string) error {\n")
+		fmt.Fprintf(b, "\tspec, err := btf.LoadKernelSpec()\n")
+		fmt.Fprintf(b, "\tif err != nil {\n")
+		fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"kfunc %%s: %%w\", name, err)\n")
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\tvar fn *btf.Func\n")
+		fmt.Fprintf(b, "\tif err := spec.TypeByName(name, &fn); err != nil {\n")
+		fmt.Fprintf(b, "\t\treturn fmt.Errorf(\"kfunc %%s: %%w\", name, ebpf.ErrNotSupported)\n")
+		fmt.Fprintf(b, "\t}\n")
+		fmt.Fprintf(b, "\treturn nil\n")
+		fmt.Fprintf(b, "}\n\n")
+	}
+}
+
+// variantImports adds the imports the variant selection code needs.
+func variantImports(imports importSet, variants []Variant) (kfuncs bool) {
+	imports["bytes"] = true
+	imports["embed"] = true
+	imports["sync"] = true
+	for _, v := range variants {
+		for _, r := range v.Requires {
+			if strings.Contains(r.Probe, "features.") {
+				imports["github.com/cilium/ebpf/features"] = true
+			}
+			if strings.Contains(r.Probe, "asm.") {
+				imports["github.com/cilium/ebpf/asm"] = true
+			}
+			if strings.Contains(r.Probe, "haveKfunc(") {
+				imports["github.com/cilium/ebpf/btf"] = true
+				kfuncs = true
+			}
+		}
+	}
+	return kfuncs
+}
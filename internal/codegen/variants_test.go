@@ -0,0 +1,166 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestRequirementsFromSpec(t *testing.T) {
+	kfunc := asm.Instruction{OpCode: asm.OpCode(asm.JumpClass).SetJumpOp(asm.Call), Src: asm.PseudoKfuncCall, Constant: -1}
+	kfunc = btf.WithFuncMetadata(kfunc, &btf.Func{Name: "bpf_task_acquire"})
+
+	spec := &ebpf.CollectionSpec{
+		Programs: map[string]*ebpf.ProgramSpec{
+			"handler": {
+				Type: ebpf.XDP,
+				Instructions: asm.Instructions{
+					asm.JLT.Imm(asm.R1, 4, "out"),
+					asm.FnKtimeGetNs.Call(),
+					kfunc,
+					asm.Mov.Imm(asm.R0, 0).WithSymbol("out"),
+					asm.Return(),
+				},
+			},
+			"tracer": {
+				Type:         ebpf.Tracing,
+				Instructions: asm.Instructions{asm.FnGetCurrentPidTgid.Call(), asm.Return()},
+			},
+		},
+		Maps: map[string]*ebpf.MapSpec{
+			"events": {Type: ebpf.RingBuf},
+			"conns":  {Type: ebpf.Hash},
+		},
+	}
+
+	var got []string
+	for _, r := range requirementsFromSpec(spec) {
+		got = append(got, r.Desc+": "+r.Probe)
+	}
+	want := []string{
+		"BPF ISA v2: features.HaveV2ISA",
+		"RingBuf maps: func() error { return features.HaveMapType(ebpf.RingBuf) }",
+		"helper FnKtimeGetNs in XDP programs: func() error { return features.HaveProgramHelper(ebpf.XDP, asm.FnKtimeGetNs) }",
+		`kfunc bpf_task_acquire: func() error { return haveKfunc("bpf_task_acquire") }`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requirements:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestISAVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		ins  asm.Instruction
+		want int
+	}{
+		{"mov", asm.Mov.Imm(asm.R0, 0), 1},
+		{"jgt", asm.JGT.Imm(asm.R1, 0, "l"), 1},
+		{"jlt", asm.JLT.Imm(asm.R1, 0, "l"), 2},
+		{"jeq32", asm.JEq.Imm32(asm.R1, 0, "l"), 3},
+		{"sdiv", asm.SDiv.Imm(asm.R1, 2), 4},
+		{"movsx", asm.MovSX8.Reg(asm.R1, asm.R2), 4},
+		{"ldxsx", asm.LoadMemSX(asm.R1, asm.R2, 0, asm.Byte), 4},
+		{"bswap", asm.BSwap(asm.R1, asm.Half), 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isaVersion(tt.ins.OpCode); got != tt.want {
+				t.Errorf("isaVersion(%v) = %d, want %d", tt.ins, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateVariants(t *testing.T) {
+	info := &ELFInfo{
+		Programs: []string{"handler"},
+		Variants: []Variant{
+			{Name: "probe.v4.bpf.o", EmbedPath: "probe.v4.bpf.o", Requires: []Requirement{
+				{Desc: "BPF ISA v4", Probe: "features.HaveV4ISA"},
+				{Desc: "kfunc bpf_task_acquire", Probe: `func() error { return haveKfunc("bpf_task_acquire") }`},
+			}},
+			{Name: "probe.v2.bpf.o", EmbedPath: "probe.v2.bpf.o"},
+		},
+	}
+	src, err := Generate("loader", info, "")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	text := string(src)
+	for _, s := range []string{
+		`"github.com/cilium/ebpf/features"`,
+		"//go:embed probe.v4.bpf.o\nvar _bpfVariant0 []byte",
+		"//go:embed probe.v2.bpf.o\nvar _bpfVariant1 []byte",
+		"features.HaveV4ISA, // BPF ISA v4",
+		`{"probe.v2.bpf.o", _bpfVariant1, []func() error{`,
+		"var selectVariant = sync.OnceValues(func() (*variant, error) {",
+		"func Variant() (string, error) {",
+		"func haveKfunc(name string) error {",
+		"spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(v.object))",
+		"func Load() (*Objects, error) {",
+	} {
+		if !strings.Contains(text, s) {
+			t.Errorf("generated code missing %q\n%s", s, text)
+		}
+	}
+}
+
+func TestCheckVariant(t *testing.T) {
+	base := &ELFInfo{
+		Programs: []string{"handler"},
+		Maps:     []string{"conns"},
+		MapDefs:  map[string]MapDef{"conns": {Type: ebpf.Hash, KeySize: 4, ValueSize: 8}},
+	}
+	tests := []struct {
+		name  string
+		other *ELFInfo
+		want  string
+	}{
+		{
+			name:  "same",
+			other: base,
+		},
+		{
+			name:  "different programs",
+			other: &ELFInfo{Programs: []string{"other"}, Maps: base.Maps, MapDefs: base.MapDefs},
+			want:  "programs [other] differ from [handler]",
+		},
+		{
+			name: "different map definition",
+			other: &ELFInfo{
+				Programs: base.Programs,
+				Maps:     base.Maps,
+				MapDefs:  map[string]MapDef{"conns": {Type: ebpf.LRUHash, KeySize: 4, ValueSize: 8}},
+			},
+			want: "map conns is defined differently",
+		},
+		{
+			name: "different variables",
+			other: &ELFInfo{
+				Programs:  base.Programs,
+				Maps:      base.Maps,
+				MapDefs:   base.MapDefs,
+				Variables: []Variable{{Name: "debug"}},
+			},
+			want: "global variables differ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := base.CheckVariant("v2", tt.other)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %v should contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -289,6 +289,11 @@ func findTypeEnd(s string) int {
 		return 0
 	}
 	if s[0] == '%' {
+		if len(s) > 1 && s[1] == '"' {
+			if q := strings.IndexByte(s[2:], '"'); q >= 0 {
+				return q + 3
+			}
+		}
 		end := 1
 		for end < len(s) && isIdentChar(s[end]) {
 			end++
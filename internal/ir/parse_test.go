@@ -788,6 +788,13 @@ func TestParseGlobalBody(t *testing.T) {
 			wantType:    "i32",
 			wantInit:    "42",
 		},
+		{
+			name:        "quoted struct type",
+			input:       `internal global %"main.bpfMap[main.hashMap, uint32, uint64]" { ptr null, ptr null, i32 8 }, align 8`,
+			wantLinkage: "internal global",
+			wantType:    `%"main.bpfMap[main.hashMap, uint32, uint64]"`,
+			wantInit:    "{ ptr null, ptr null, i32 8 }",
+		},
 		{
 			name:        "unmatched braces no init",
 			input:       "global %t { unclosed",
@@ -864,6 +871,7 @@ func TestFindTypeEnd(t *testing.T) {
 		{"empty", "", 0},
 		{"percent type", "%main.foo rest", 9},
 		{"percent type at end", "%abc", 4},
+		{"quoted percent type", `%"main.m[main.k, uint64]" rest`, 25},
 		{"array type", "[4 x i32] rest", 9},
 		{"unmatched bracket", "[4 x i32 rest", 0},
 		{"i8", "i8 val", 2},
@@ -128,6 +128,14 @@ func serializeTypeDef(b *strings.Builder, td *TypeDef) {
 	fmt.Fprintf(b, "%s = type { %s }", td.Name, strings.Join(td.Fields, ", "))
 }
 
+// SerializeGlobal returns the text representation of a global definition
+// built from its parsed fields.
+func SerializeGlobal(g *Global) string {
+	var b strings.Builder
+	serializeGlobal(&b, g)
+	return b.String()
+}
+
 // serializeGlobal writes a modified global definition.
 func serializeGlobal(b *strings.Builder, g *Global) {
 	fmt.Fprintf(b, "@%s = %s", g.Name, g.Linkage)
@@ -0,0 +1,138 @@
+package pipeline
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/kyleseneker/tinybpf/diag"
+)
+
+// BTF kinds from include/uapi/linux/btf.h.
+const (
+	btfKindInt       = 1
+	btfKindArray     = 3
+	btfKindStruct    = 4
+	btfKindUnion     = 5
+	btfKindEnum      = 6
+	btfKindFwd       = 7
+	btfKindTypedef   = 8
+	btfKindVolatile  = 9
+	btfKindConst     = 10
+	btfKindRestrict  = 11
+	btfKindFuncProto = 13
+	btfKindVar       = 14
+	btfKindDatasec   = 15
+	btfKindDeclTag   = 17
+	btfKindTypeTag   = 18
+	btfKindEnum64    = 19
+)
+
+// kptrTypeTags are the type tags the verifier reads as kernel object
+// references in map values.
+var kptrTypeTags = []string{"kptr", "kptr_untrusted", "percpu_kptr"}
+
+// completeKptrBTF makes the structs behind kptr fields complete in the
+// output's .BTF section. llc emits a struct that is only reached through a
+// pointer in a struct member as a forward declaration, but the verifier wants
+// a kptr to point to a struct, which it then resolves by name in the kernel's
+// BTF. Each such forward declaration is turned into an empty struct in place,
+// so type IDs and .BTF.ext stay valid.
+func (rc *runContext) completeKptrBTF() error {
+	data, err := readELFSection(rc.cfg.Output, ".BTF")
+	if err != nil || data == nil {
+		return err
+	}
+	n, err := completeKptrTargets(data)
+	if err != nil {
+		return diag.Wrap(diag.StageBTF, err, "read .BTF section from ELF")
+	}
+	if n == 0 {
+		return nil
+	}
+	if err := replaceELFSection(rc.cfg.Output, ".BTF", data, rc.tools.Objcopy); err != nil {
+		return diag.Wrap(diag.StageBTF, err, "update .BTF section in ELF")
+	}
+	if rc.cfg.Verbose {
+		fmt.Fprintf(rc.cfg.Stdout, "[btf] completed %d kptr target struct(s)\n", n)
+	}
+	return nil
+}
+
+// completeKptrTargets rewrites the forward-declared structs behind kptr type
+// tags in the raw BTF data to empty structs and returns how many it changed.
+func completeKptrTargets(data []byte) (int, error) {
+	if len(data) < 24 {
+		return 0, fmt.Errorf("BTF header truncated")
+	}
+	var bo binary.ByteOrder = binary.LittleEndian
+	if bo.Uint16(data) != 0xeb9f {
+		bo = binary.BigEndian
+		if bo.Uint16(data) != 0xeb9f {
+			return 0, fmt.Errorf("bad BTF magic %#x", data[:2])
+		}
+	}
+	hdrLen := bo.Uint32(data[4:])
+	typeOff, typeLen := hdrLen+bo.Uint32(data[8:]), bo.Uint32(data[12:])
+	strOff, strLen := hdrLen+bo.Uint32(data[16:]), bo.Uint32(data[20:])
+	if uint64(typeOff)+uint64(typeLen) > uint64(len(data)) || uint64(strOff)+uint64(strLen) > uint64(len(data)) {
+		return 0, fmt.Errorf("BTF sections out of bounds")
+	}
+
+	// offsets[id] is the offset of type id in data; id 0 is void.
+	offsets := []uint32{0}
+	for off := typeOff; off < typeOff+typeLen; {
+		if off+12 > typeOff+typeLen {
+			return 0, fmt.Errorf("BTF type %d truncated", len(offsets))
+		}
+		offsets = append(offsets, off)
+		info := bo.Uint32(data[off+4:])
+		vlen := info & 0xffff
+		off += 12
+		switch (info >> 24) & 0x1f {
+		case btfKindInt, btfKindVar, btfKindDeclTag:
+			off += 4
+		case btfKindArray:
+			off += 12
+		case btfKindStruct, btfKindUnion, btfKindDatasec, btfKindEnum64:
+			off += 12 * vlen
+		case btfKindEnum, btfKindFuncProto:
+			off += 8 * vlen
+		}
+	}
+	kind := func(id uint32) uint32 { return (bo.Uint32(data[offsets[id]+4:]) >> 24) & 0x1f }
+	next := func(id uint32) uint32 { return bo.Uint32(data[offsets[id]+8:]) }
+	name := func(id uint32) string {
+		start := strOff + bo.Uint32(data[offsets[id]:])
+		if start >= strOff+strLen {
+			return ""
+		}
+		end := start
+		for end < strOff+strLen && data[end] != 0 {
+			end++
+		}
+		return string(data[start:end])
+	}
+	valid := func(id uint32) bool { return id > 0 && int(id) < len(offsets) }
+
+	n := 0
+	for id := uint32(1); int(id) < len(offsets); id++ {
+		if kind(id) != btfKindTypeTag || !slices.Contains(kptrTypeTags, name(id)) {
+			continue
+		}
+		target := next(id)
+		for range len(offsets) {
+			if !valid(target) || !slices.Contains([]uint32{btfKindTypedef, btfKindVolatile, btfKindConst, btfKindRestrict, btfKindTypeTag}, kind(target)) {
+				break
+			}
+			target = next(target)
+		}
+		if !valid(target) || kind(target) != btfKindFwd || bo.Uint32(data[offsets[target]+4:])>>31 != 0 {
+			continue
+		}
+		bo.PutUint32(data[offsets[target]+4:], btfKindStruct<<24)
+		bo.PutUint32(data[offsets[target]+8:], 0)
+		n++
+	}
+	return n, nil
+}
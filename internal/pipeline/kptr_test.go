@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestCompleteKptrTargets(t *testing.T) {
+	task := &btf.Fwd{Name: "task_struct", Kind: btf.FwdStruct}
+	node := &btf.Fwd{Name: "node", Kind: btf.FwdStruct}
+	unionFwd := &btf.Fwd{Name: "u", Kind: btf.FwdUnion}
+	u64 := &btf.Int{Name: "u64", Size: 8}
+	value := &btf.Struct{Name: "value", Size: 32, Members: []btf.Member{
+		{Name: "task", Type: &btf.Pointer{Target: &btf.TypeTag{Value: "kptr", Type: &btf.Typedef{Name: "task_struct", Type: task}}}},
+		{Name: "next", Type: &btf.Pointer{Target: &btf.TypeTag{Value: "rcu", Type: node}}, Offset: 64},
+		{Name: "u", Type: &btf.Pointer{Target: &btf.TypeTag{Value: "kptr_untrusted", Type: unionFwd}}, Offset: 128},
+		{Name: "count", Type: u64, Offset: 192},
+	}}
+	b, err := btf.NewBuilder([]btf.Type{value}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Marshal(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := btf.LoadSpecFromReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fwd *btf.Fwd
+	if err := before.TypeByName("task_struct", &fwd); err != nil {
+		t.Fatal(err)
+	}
+	fwdID, _ := before.TypeID(fwd)
+
+	n, err := completeKptrTargets(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("completed %d targets, want 1", n)
+	}
+	spec, err := btf.LoadSpecFromReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st *btf.Struct
+	if err := spec.TypeByName("task_struct", &st); err != nil {
+		t.Fatalf("task_struct should be a struct: %v", err)
+	}
+	if st.Size != 0 || len(st.Members) != 0 {
+		t.Errorf("task_struct = %v, want an empty struct", st)
+	}
+	if id, _ := spec.TypeID(st); id != fwdID {
+		t.Errorf("task_struct has ID %d, want %d", id, fwdID)
+	}
+	for _, name := range []string{"node", "u"} {
+		var fwd *btf.Fwd
+		if err := spec.TypeByName(name, &fwd); err != nil {
+			t.Errorf("%s should stay a forward declaration: %v", name, err)
+		}
+	}
+}
+
+func TestCompleteKptrTargetsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"truncated", []byte{0x9f, 0xeb}, "BTF header truncated"},
+		{"bad magic", make([]byte, 24), "bad BTF magic"},
+		{"out of bounds", []byte{0x9f, 0xeb, 1, 0, 24, 0, 0, 0, 0, 0, 0, 0, 12, 0, 0, 0, 12, 0, 0, 0, 1, 0, 0, 0}, "BTF sections out of bounds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := completeKptrTargets(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error %v should contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -38,6 +38,9 @@ type Config struct {
 	EnableBTF    bool
 	Programs     []string
 	Sections     map[string]string
+	GlobalFuncs  []string
+	GlobalVars   []string
+	FieldTags    map[string]string
 	Tools        llvm.ToolOverrides
 	Stdout       io.Writer
 	Stderr       io.Writer
@@ -187,7 +190,10 @@ func (rc *runContext) runTransformStage() error {
 		if hashErr == nil {
 			key := cache.Key("transform", inputHash,
 				strings.Join(rc.cfg.Programs, ","),
-				cache.SortedSections(rc.cfg.Sections))
+				cache.SortedSections(rc.cfg.Sections),
+				strings.Join(rc.cfg.GlobalFuncs, ","),
+				strings.Join(rc.cfg.GlobalVars, ","),
+				cache.SortedSections(rc.cfg.FieldTags))
 			if cached, hit := rc.store.Lookup(key); hit {
 				rc.logCache("transform", key, true)
 				return copyFile(cached, rc.artifacts.TransformedLL)
@@ -198,11 +204,14 @@ func (rc *runContext) runTransformStage() error {
 	}
 
 	transformOpts := transform.Options{
-		Programs: rc.cfg.Programs,
-		Sections: rc.cfg.Sections,
-		Verbose:  rc.cfg.Verbose,
-		Stdout:   rc.cfg.Stdout,
-		DumpDir:  dumpDir,
+		Programs:    rc.cfg.Programs,
+		Sections:    rc.cfg.Sections,
+		GlobalFuncs: rc.cfg.GlobalFuncs,
+		GlobalVars:  rc.cfg.GlobalVars,
+		FieldTags:   rc.cfg.FieldTags,
+		Verbose:     rc.cfg.Verbose,
+		Stdout:      rc.cfg.Stdout,
+		DumpDir:     dumpDir,
 	}
 	if err := transform.Run(rc.ctx, rc.artifacts.LinkedBC, rc.artifacts.TransformedLL, transformOpts); err != nil {
 		if diag.IsStage(err, diag.StageTransform) {
@@ -480,7 +489,8 @@ func (rc *runContext) runCodegenAndFinalize() error {
 	return rc.finalizeOutput()
 }
 
-// finalizeOutput copies the codegen object to the output path and optionally injects BTF.
+// finalizeOutput copies the codegen object to the output path, optionally
+// injects BTF, and completes kptr target structs.
 func (rc *runContext) finalizeOutput() error {
 	if err := os.MkdirAll(filepath.Dir(rc.cfg.Output), 0o755); err != nil {
 		return diag.Wrap(diag.StageFinalize, err, "failed to create output directory")
@@ -496,7 +506,7 @@ func (rc *runContext) finalizeOutput() error {
 			return err
 		}
 	}
-	return nil
+	return rc.completeKptrBTF()
 }
 
 // setupDumpIR creates the dump-ir directory when --dump-ir is enabled
@@ -851,20 +861,26 @@ func findUndefinedFuncSymbols(elfPath string) ([]string, error) {
 // loadELFBTFSection reads the ELF's .BTF section and parses it via cilium/ebpf.
 // Returns (nil, nil) if the section is absent.
 func loadELFBTFSection(elfPath string) (*btf.Spec, error) {
+	data, err := readELFSection(elfPath, ".BTF")
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return btf.LoadSpecFromReader(bytes.NewReader(data))
+}
+
+// readELFSection returns the contents of the named section of the ELF, or nil
+// if it has none.
+func readELFSection(elfPath, name string) ([]byte, error) {
 	f, err := elf.Open(filepath.Clean(elfPath))
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = f.Close() }()
-	sec := f.Section(".BTF")
+	sec := f.Section(name)
 	if sec == nil {
 		return nil, nil
 	}
-	data, err := sec.Data()
-	if err != nil {
-		return nil, err
-	}
-	return btf.LoadSpecFromReader(bytes.NewReader(data))
+	return sec.Data()
 }
 
 // replaceELFSection rewrites the named section of the ELF at path using
@@ -2,6 +2,7 @@
 package testutil
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -86,3 +87,84 @@ func BadPath(segments ...string) string {
 	parts := append([]string{os.DevNull, "impossible"}, segments...)
 	return filepath.Join(parts...)
 }
+
+// BPFObject returns a minimal BPF ELF object with one program symbol,
+// test_prog, bound to an executable section, suitable for codegen extraction.
+func BPFObject() []byte {
+	shstrtab := "\x00.text\x00.symtab\x00.strtab\x00.shstrtab\x00"
+	for len(shstrtab)%8 != 0 {
+		shstrtab += "\x00"
+	}
+
+	symstrtab := "\x00test_prog\x00"
+	for len(symstrtab)%8 != 0 {
+		symstrtab += "\x00"
+	}
+
+	code := []byte{0x95, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	off := uint64(64)
+	codeOff := off
+	off += uint64(len(code))
+	symstrtabOff := off
+	off += uint64(len(symstrtab))
+	symtabOff := off
+
+	nullSym := make([]byte, 24)
+	progSym := make([]byte, 24)
+	binary.LittleEndian.PutUint32(progSym[0:4], 1)
+	progSym[4] = 0x12                              // STT_FUNC | STB_GLOBAL
+	binary.LittleEndian.PutUint16(progSym[6:8], 1) // st_shndx = 1 (.text)
+	binary.LittleEndian.PutUint64(progSym[16:24], uint64(len(code)))
+
+	off += uint64(len(nullSym) + len(progSym))
+	shstrtabOff := off
+	off += uint64(len(shstrtab))
+	shOff := off
+
+	makeSH := func(nameOff int, shType uint32, flags uint64, shOffset, size uint64, link, info uint32, entsize uint64) []byte {
+		sh := make([]byte, 64)
+		binary.LittleEndian.PutUint32(sh[0:4], uint32(nameOff))
+		binary.LittleEndian.PutUint32(sh[4:8], shType)
+		binary.LittleEndian.PutUint64(sh[8:16], flags)
+		binary.LittleEndian.PutUint64(sh[24:32], shOffset)
+		binary.LittleEndian.PutUint64(sh[32:40], size)
+		binary.LittleEndian.PutUint32(sh[40:44], link)
+		binary.LittleEndian.PutUint32(sh[44:48], info)
+		binary.LittleEndian.PutUint64(sh[48:56], 8)
+		binary.LittleEndian.PutUint64(sh[56:64], entsize)
+		return sh
+	}
+
+	var sections []byte
+	sections = append(sections, makeSH(0, 0, 0, 0, 0, 0, 0, 0)...)
+	sections = append(sections, makeSH(1, 1, 6, codeOff, uint64(len(code)), 0, 0, 0)...)
+	sections = append(sections, makeSH(7, 3, 0, symstrtabOff, uint64(len(symstrtab)), 0, 0, 0)...)
+	sections = append(sections, makeSH(15, 2, 0, symtabOff, uint64(len(nullSym)+len(progSym)), 2, 1, 24)...)
+	sections = append(sections, makeSH(23, 3, 0, shstrtabOff, uint64(len(shstrtab)), 0, 0, 0)...)
+
+	hdr := make([]byte, 64)
+	copy(hdr[0:4], []byte{0x7f, 'E', 'L', 'F'})
+	hdr[4] = 2
+	hdr[5] = 1
+	hdr[6] = 1
+	binary.LittleEndian.PutUint16(hdr[16:18], 1)
+	binary.LittleEndian.PutUint16(hdr[18:20], 0xF7)
+	binary.LittleEndian.PutUint32(hdr[20:24], 1)
+	binary.LittleEndian.PutUint16(hdr[52:54], 64)
+	binary.LittleEndian.PutUint16(hdr[58:60], 64)
+	binary.LittleEndian.PutUint64(hdr[40:48], shOff)
+	binary.LittleEndian.PutUint16(hdr[60:62], 5)
+	binary.LittleEndian.PutUint16(hdr[62:64], 4)
+
+	var out []byte
+	out = append(out, hdr...)
+	out = append(out, code...)
+	out = append(out, []byte(symstrtab)...)
+	out = append(out, nullSym...)
+	out = append(out, progSym...)
+	out = append(out, []byte(shstrtab)...)
+	out = append(out, sections...)
+
+	return out
+}
@@ -46,3 +46,50 @@ func stripTrailingUndef(args string) string {
 	}
 	return args
 }
+
+// callbackHelpers maps the kernel names of the BPF helpers that take a
+// callback function to the position of the callback among their Go
+// parameters.
+var callbackHelpers = map[string]int{
+	"loop":               1,
+	"for_each_map_elem":  1,
+	"timer_set_callback": 1,
+	"user_ringbuf_drain": 1,
+	"find_vma":           2,
+}
+
+// callbackPos returns the position of the callback among the Go parameters
+// of the helper funcName, if it takes one. The helper is matched by its
+// kernel symbol, so aliases resolve to the helper they call. The body of
+// bpfForNum counts as a callback too.
+func callbackPos(funcName string) (int, bool) {
+	if funcName == forNumName {
+		return forNumCallbackPos, true
+	}
+	id, ok := helperIDs[funcName]
+	if !ok {
+		return 0, false
+	}
+	pos, ok := callbackHelpers[bpfHelperNames[id]]
+	return pos, ok
+}
+
+// callbackArg returns the function passed as the callback at Go parameter
+// pos of a callback helper call with arguments args. TinyGo passes a Go func
+// value as two pointers, its closure context and the function, so the
+// callback takes the arguments pos and pos+1. Parameter attributes such as
+// nonnull are skipped.
+func callbackArg(args []string, pos int) (context, fn string, ok bool) {
+	if pos+1 >= len(args) {
+		return "", "", false
+	}
+	ctx, callback := strings.Fields(args[pos]), strings.Fields(args[pos+1])
+	if len(ctx) < 2 || ctx[0] != "ptr" || len(callback) < 2 || callback[0] != "ptr" {
+		return "", "", false
+	}
+	fn = callback[len(callback)-1]
+	if !strings.HasPrefix(fn, "@") {
+		return "", "", false
+	}
+	return ctx[len(ctx)-1], fn, true
+}
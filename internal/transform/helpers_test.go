@@ -46,3 +46,49 @@ func TestStripTrailingUndef(t *testing.T) {
 		}
 	}
 }
+
+func TestCallbackArg(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        string
+		pos         int
+		wantContext string
+		wantFn      string
+		wantOK      bool
+	}{
+		{"func value", "i32 10, ptr undef, ptr @main.loopBody, ptr %sum, i64 0", 1, "undef", "@main.loopBody", true},
+		{"param attributes", "i32 10, ptr undef, ptr nonnull @main.loopBody, ptr %sum, i64 0", 1, "undef", "@main.loopBody", true},
+		{"closure", "i32 10, ptr %env, ptr @main.run$1, ptr null, i64 0", 1, "%env", "@main.run$1", true},
+		{"pointer argument", "i32 10, ptr %cb, ptr %sum, i64 0", 1, "", "", false},
+		{"too few arguments", "ptr %task, i64 %addr, ptr undef", 2, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			context, fn, ok := callbackArg(splitTypeArgs(tt.args), tt.pos)
+			if context != tt.wantContext || fn != tt.wantFn || ok != tt.wantOK {
+				t.Errorf("callbackArg() = %q, %q, %v, want %q, %q, %v", context, fn, ok, tt.wantContext, tt.wantFn, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCallbackPos(t *testing.T) {
+	tests := []struct {
+		funcName string
+		want     int
+		wantOK   bool
+	}{
+		{"main.bpfLoop", 1, true},
+		{"main.bpfFindVma", 2, true},
+		{"main.bpfUserRingbufDrain", 1, true},
+		{"main.bpfMapLookupElem", 0, false},
+		{"main.bpfUnknown", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.funcName, func(t *testing.T) {
+			if got, ok := callbackPos(tt.funcName); got != tt.want || ok != tt.wantOK {
+				t.Errorf("callbackPos(%q) = %d, %v, want %d, %v", tt.funcName, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+// --- Open-coded iterator kfunc prototypes ---
+//
+// The kernel's open-coded iterators are kfuncs used in a new/next/destroy
+// sequence on an iterator state kept on the stack. A program either writes
+// the loop with them:
+//
+//	bpfIterNumNew(&it, 0, n)
+//	for p := bpfIterNumNext(&it); p != nil; p = bpfIterNumNext(&it) { ... }
+//	bpfIterNumDestroy(&it)
+//
+// or calls bpfForNum, which iteratorsModule lowers to that sequence.
+//
+// Go declarations named bpfIter... are renamed like bpfKfunc ones. TinyGo
+// emits no debug info for extern declarations, so their BTF prototypes come
+// from the table below: each declaration gets a DISubprogram and the .ksyms
+// section, from which llc emits the extern BTF FUNC that cilium/ebpf and
+// libbpf resolve against the kernel. The verifier wants the iterator state in
+// an 8-byte aligned stack slot, so the alloca passed to the new kfunc is
+// aligned to 8. A state inside a larger variable is rejected, since aligning
+// the field would mean changing the layout of the variable.
+
+// openCodedIterators describes the iterator kfuncs: bpf_iter_<name>_new takes
+// the iterator state followed by args, and bpf_iter_<name>_next returns next.
+var openCodedIterators = []struct {
+	name string
+	size int
+	args []string
+	next string
+}{
+	{"num", 8, []string{"int", "int"}, "int *"},
+	{"bits", 16, []string{"unsigned long long *", "unsigned int"}, "int *"},
+	{"task", 24, []string{"struct task_struct *", "unsigned int"}, "struct task_struct *"},
+	{"task_vma", 8, []string{"struct task_struct *", "unsigned long long"}, "struct vm_area_struct *"},
+	{"css", 24, []string{"struct cgroup_subsys_state *", "unsigned int"}, "struct cgroup_subsys_state *"},
+	{"css_task", 8, []string{"struct cgroup_subsys_state *", "unsigned int"}, "struct task_struct *"},
+}
+
+// iteratorKfunc is the C prototype of an iterator kfunc, return type first.
+type iteratorKfunc struct {
+	proto     []string
+	stateSize int
+}
+
+// iteratorKfuncs maps the kernel names of the iterator kfuncs to their
+// prototypes.
+var iteratorKfuncs = func() map[string]iteratorKfunc {
+	kfuncs := make(map[string]iteratorKfunc, 3*len(openCodedIterators))
+	for _, it := range openCodedIterators {
+		state := "struct bpf_iter_" + it.name + " *"
+		kfuncs["bpf_iter_"+it.name+"_new"] = iteratorKfunc{append([]string{"int", state}, it.args...), it.size}
+		kfuncs["bpf_iter_"+it.name+"_next"] = iteratorKfunc{[]string{it.next, state}, it.size}
+		kfuncs["bpf_iter_"+it.name+"_destroy"] = iteratorKfunc{[]string{"void", state}, it.size}
+	}
+	return kfuncs
+}()
+
+// isKfuncName reports whether a Go function name is a kfunc declaration:
+// bpfKfunc... or an open-coded iterator bpfIter....
+func isKfuncName(name string) bool {
+	return strings.HasPrefix(name, "main.bpfKfunc") || strings.HasPrefix(name, "main.bpfIter")
+}
+
+// iteratorKfuncsModule gives the iterator kfunc declarations, already renamed
+// to their kernel names, BTF prototypes and the .ksyms section, and aligns
+// the iterator state passed to them.
+func iteratorKfuncsModule(m *ir.Module) error {
+	var file string
+	for _, mn := range m.MetadataNodes {
+		if mn.Kind == "DIFile" {
+			file = fmt.Sprintf("!%d", mn.ID)
+			break
+		}
+	}
+	var decls []*ir.TopLevelEntry
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		if !e.Removed && e.Kind == ir.TopDeclare && e.Declare != nil && iteratorKfuncs[e.Declare.Name].proto != nil {
+			decls = append(decls, e)
+		}
+	}
+	if len(decls) == 0 {
+		return nil
+	}
+	di := &iteratorDI{next: findMaxMetaIDFromModule(m) + 1, file: file, types: make(map[string]string)}
+	for _, e := range decls {
+		d := e.Declare
+		if file == "" {
+			return fmt.Errorf("iterator kfunc %s needs debug info for its BTF; do not build with -no-debug", d.Name)
+		}
+		kf := iteratorKfuncs[d.Name]
+		params := splitTypeArgs(d.Params)
+		if len(params) != len(kf.proto)-1 {
+			return fmt.Errorf("iterator kfunc %s takes %d arguments, declared with %d", d.Name, len(kf.proto)-1, len(params))
+		}
+		e.Raw = fmt.Sprintf(`declare !dbg %s %s @%s(%s) section ".ksyms"`, di.subprogram(d.Name, kf), d.RetType, d.Name, d.Params)
+	}
+	for _, raw := range di.nodes {
+		appendMetaEntryToModule(m, raw)
+	}
+	return alignIteratorStates(m)
+}
+
+// alignIteratorStates aligns the allocas passed as iterator state to the
+// iterator new kfuncs to 8 bytes, and rejects state that is not a local
+// variable of its own.
+func alignIteratorStates(m *ir.Module) error {
+	for _, fn := range m.Functions {
+		if fn.Removed {
+			continue
+		}
+		ir.EnsureBlocks(fn)
+		for _, block := range fn.Blocks {
+			for _, inst := range block.Instructions {
+				if inst.Kind != ir.InstCall || inst.Call == nil {
+					continue
+				}
+				name := strings.TrimPrefix(inst.Call.Callee, "@")
+				if _, ok := iteratorKfuncs[name]; !ok || !strings.HasSuffix(name, "_new") {
+					continue
+				}
+				fields := strings.Fields(firstCommaArg(inst.Call.Args))
+				state := fields[len(fields)-1]
+				if !strings.HasPrefix(state, "%") {
+					return fmt.Errorf("%s in %s: the iterator state %s must be a local variable", name, fn.Name, state)
+				}
+				alloca := findAlloca(fn, state)
+				if alloca == nil {
+					return fmt.Errorf("%s in %s: the iterator state %s must be a local variable of its own, not a field or element of a larger one, so that it can be 8-byte aligned", name, fn.Name, state)
+				}
+				if alloca.Alloca.Align < 8 {
+					alloca.Alloca.Align = 8
+					alloca.Modified = true
+					fn.Modified = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findAlloca returns the alloca instruction defining ssaName in fn, if any.
+func findAlloca(fn *ir.Function, ssaName string) *ir.Instruction {
+	for _, block := range fn.Blocks {
+		for _, inst := range block.Instructions {
+			if inst.SSAName == ssaName && inst.Kind == ir.InstAlloca && inst.Alloca != nil {
+				return inst
+			}
+		}
+	}
+	return nil
+}
+
+// iteratorDI emits the debug info for iterator kfunc prototypes, sharing
+// type nodes between them.
+type iteratorDI struct {
+	nodes []string
+	next  int
+	file  string
+	types map[string]string
+}
+
+// add records a metadata node and returns its reference.
+func (d *iteratorDI) add(format string, args ...any) string {
+	ref := fmt.Sprintf("!%d", d.next)
+	d.next++
+	d.nodes = append(d.nodes, ref+" = "+fmt.Sprintf(format, args...))
+	return ref
+}
+
+// subprogram emits the DISubprogram declaring the kfunc name.
+func (d *iteratorDI) subprogram(name string, kf iteratorKfunc) string {
+	types := make([]string, len(kf.proto))
+	for i, c := range kf.proto {
+		types[i] = d.typ(c, kf.stateSize)
+	}
+	proto := d.add("!DISubroutineType(types: !{%s})", strings.Join(types, ", "))
+	return d.add(`!DISubprogram(name: "%s", scope: %s, file: %s, type: %s, flags: DIFlagPrototyped, spFlags: DISPFlagOptimized)`,
+		name, d.file, d.file, proto)
+}
+
+// typ returns the node for the C type c; stateSize is the size of the
+// iterator state struct.
+func (d *iteratorDI) typ(c string, stateSize int) string {
+	if c == "void" {
+		return "null"
+	}
+	if ref, ok := d.types[c]; ok {
+		return ref
+	}
+	var ref string
+	switch {
+	case strings.HasSuffix(c, " *"):
+		ref = d.add("!DIDerivedType(tag: DW_TAG_pointer_type, baseType: %s, size: 64)", d.typ(strings.TrimSuffix(c, " *"), stateSize))
+	case strings.HasPrefix(c, "struct bpf_iter_"):
+		ref = d.add(`!DICompositeType(tag: DW_TAG_structure_type, name: "%s", file: %s, size: %d, elements: !{})`,
+			strings.TrimPrefix(c, "struct "), d.file, 8*stateSize)
+	case strings.HasPrefix(c, "struct "):
+		ref = d.add(`!DICompositeType(tag: DW_TAG_structure_type, name: "%s", file: %s, elements: !{})`,
+			strings.TrimPrefix(c, "struct "), d.file)
+	case c == "int":
+		ref = d.add(`!DIBasicType(name: "int", size: 32, encoding: DW_ATE_signed)`)
+	case c == "unsigned int":
+		ref = d.add(`!DIBasicType(name: "unsigned int", size: 32, encoding: DW_ATE_unsigned)`)
+	default:
+		ref = d.add(`!DIBasicType(name: "%s", size: 64, encoding: DW_ATE_unsigned)`, c)
+	}
+	d.types[c] = ref
+	return ref
+}
@@ -0,0 +1,117 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+func TestIteratorKfuncsModule(t *testing.T) {
+	const debugInfo = `
+
+!2 = !DIFile(filename: "main.go", directory: "/src")`
+	tests := []struct {
+		name     string
+		input    string
+		contains []string
+		absent   []string
+		wantErr  string
+	}{
+		{
+			name: "task iterator",
+			input: `define i32 @prog(ptr %ctx) {
+entry:
+  %it = alloca [24 x i8], align 4
+  %0 = call i32 @bpf_iter_task_new(ptr %it, ptr null, i32 0)
+  %1 = call ptr @bpf_iter_task_next(ptr %it)
+  ret i32 0
+}
+
+declare i32 @bpf_iter_task_new(ptr, ptr, i32)
+
+declare ptr @bpf_iter_task_next(ptr)` + debugInfo,
+			contains: []string{
+				"%it = alloca [24 x i8], align 8",
+				`declare !dbg !10 i32 @bpf_iter_task_new(ptr, ptr, i32) section ".ksyms"`,
+				`declare !dbg !12 ptr @bpf_iter_task_next(ptr) section ".ksyms"`,
+				`!4 = !DICompositeType(tag: DW_TAG_structure_type, name: "bpf_iter_task", file: !2, size: 192, elements: !{})`,
+				`!6 = !DICompositeType(tag: DW_TAG_structure_type, name: "task_struct", file: !2, elements: !{})`,
+				`!9 = !DISubroutineType(types: !{!3, !5, !7, !8})`,
+				`!11 = !DISubroutineType(types: !{!7, !5})`,
+			},
+		},
+		{
+			name: "other kfuncs untouched",
+			input: `declare ptr @bpf_task_from_pid(i32)
+
+declare ptr @bpf_iter_num_new_thing(ptr)` + debugInfo,
+			absent: []string{".ksyms", "DISubprogram"},
+		},
+		{
+			name: "state in a global",
+			input: `@it = global [8 x i8] zeroinitializer
+
+define i32 @prog(ptr %ctx) {
+entry:
+  %0 = call i32 @bpf_iter_num_new(ptr @it, i32 0, i32 10)
+  ret i32 0
+}
+
+declare i32 @bpf_iter_num_new(ptr, i32, i32)` + debugInfo,
+			wantErr: "bpf_iter_num_new in prog: the iterator state @it must be a local variable",
+		},
+		{
+			name: "state in a struct field",
+			input: `define i32 @prog(ptr %ctx) {
+entry:
+  %s = alloca { i32, [8 x i8] }, align 4
+  %it = getelementptr inbounds { i32, [8 x i8] }, ptr %s, i32 0, i32 1
+  %0 = call i32 @bpf_iter_num_new(ptr %it, i32 0, i32 10)
+  ret i32 0
+}
+
+declare i32 @bpf_iter_num_new(ptr, i32, i32)` + debugInfo,
+			wantErr: "bpf_iter_num_new in prog: the iterator state %it must be a local variable of its own",
+		},
+		{
+			name:    "wrong number of arguments",
+			input:   "declare i32 @bpf_iter_num_new(ptr, i32)" + debugInfo,
+			wantErr: "iterator kfunc bpf_iter_num_new takes 3 arguments, declared with 2",
+		},
+		{
+			name:    "without debug info",
+			input:   "declare i32 @bpf_iter_num_new(ptr, i32, i32)",
+			wantErr: "iterator kfunc bpf_iter_num_new needs debug info",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ir.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = iteratorKfuncsModule(m)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := ir.Serialize(m)
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output missing %q\n%s", s, out)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(out, s) {
+					t.Errorf("output should not contain %q", s)
+				}
+			}
+		})
+	}
+}
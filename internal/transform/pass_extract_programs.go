@@ -3,20 +3,44 @@ package transform
 import (
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 
 	"github.com/kyleseneker/tinybpf/internal/ir"
 )
 
-// extractProgramsModule keeps only the specified BPF program functions and removes runtime functions.
-func extractProgramsModule(m *ir.Module, programNames []string, verbose bool, w io.Writer) error {
+// extractProgramsModule keeps only the specified BPF program functions and
+// the subprograms, and removes runtime functions.
+func extractProgramsModule(m *ir.Module, programNames, globalFuncs []string, verbose bool, w io.Writer) error {
 	if w == nil {
 		w = io.Discard
 	}
+	subprograms, err := findSubprograms(m, globalFuncs)
+	if err != nil {
+		return err
+	}
 	programSet, err := buildProgramSet(m, programNames)
 	if err != nil {
 		return err
 	}
+	for name, global := range subprograms {
+		delete(programSet, name)
+		if verbose {
+			linkage := "static"
+			if global {
+				linkage = "global"
+			}
+			fmt.Fprintf(w, "[transform] keeping %s subprogram: %s\n", linkage, name)
+		}
+	}
+	for _, name := range tailCallTargets(m) {
+		if !programSet[name] {
+			programSet[name] = true
+			if verbose {
+				fmt.Fprintf(w, "[transform] keeping tail call target: %s\n", name)
+			}
+		}
+	}
 	if verbose {
 		for name := range programSet {
 			fmt.Fprintf(w, "[transform] keeping program: %s\n", name)
@@ -31,7 +55,7 @@ func extractProgramsModule(m *ir.Module, programNames []string, verbose bool, w
 			len(names), names)
 	}
 	for _, fn := range m.Functions {
-		if !programSet[fn.Name] {
+		if _, ok := subprograms[fn.Name]; !ok && !programSet[fn.Name] {
 			fn.Removed = true
 		}
 	}
@@ -84,6 +108,34 @@ func buildProgramSet(m *ir.Module, programNames []string) (map[string]bool, erro
 	return programSet, nil
 }
 
+// tailCallTargets returns the functions referenced from map definition
+// initializers, such as the programs in the Values of a program array, so
+// that they are kept even when not listed with --programs.
+func tailCallTargets(m *ir.Module) []string {
+	defined := make(map[string]bool, len(m.Functions))
+	for _, fn := range m.Functions {
+		defined[fn.Name] = true
+	}
+	var targets []string
+	for _, g := range m.Globals {
+		if !isMapDefGlobal(g) {
+			continue
+		}
+		init := g.Initializer
+		for pos := strings.IndexByte(init, '@'); pos >= 0; pos = strings.IndexByte(init, '@') {
+			j := pos + 1
+			for j < len(init) && isIdentCharByte(init[j]) {
+				j++
+			}
+			if name := init[pos+1 : j]; defined[name] && !slices.Contains(targets, name) {
+				targets = append(targets, name)
+			}
+			init = init[j:]
+		}
+	}
+	return targets
+}
+
 // markRuntimeGlobalsRemoved flags runtime-internal globals for removal.
 func markRuntimeGlobalsRemoved(m *ir.Module) {
 	for _, g := range m.Globals {
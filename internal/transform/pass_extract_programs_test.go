@@ -12,8 +12,10 @@ func TestExtractProgramsModule(t *testing.T) {
 	tests := []struct {
 		name        string
 		funcs       []*ir.Function
+		globals     []*ir.Global
 		entries     []ir.TopLevelEntry
 		programs    []string
+		globalFuncs []string
 		verbose     bool
 		wantOutput  string
 		wantRemoved []bool
@@ -39,6 +41,28 @@ func TestExtractProgramsModule(t *testing.T) {
 			},
 			wantRemoved: []bool{false, true},
 		},
+		{
+			name:  "keeps tail call targets",
+			funcs: []*ir.Function{{Name: "dispatcher"}, {Name: "stage"}, {Name: "unused"}},
+			globals: []*ir.Global{{
+				Name:        "main.stages",
+				Type:        `%"main.bpfMapProgs[main.progArrayMap, uint32]"`,
+				Initializer: "{ ptr null, i32 2, [2 x ptr] [ptr @stage, ptr null] }",
+			}},
+			programs:    []string{"dispatcher"},
+			verbose:     true,
+			wantOutput:  "keeping tail call target: stage",
+			wantRemoved: []bool{false, false, true},
+		},
+		{
+			name:        "keeps subprograms",
+			funcs:       []*ir.Function{{Name: "my_prog"}, {Name: "main.parse"}, {Name: "main.unused"}},
+			programs:    []string{"my_prog"},
+			globalFuncs: []string{"parse"},
+			verbose:     true,
+			wantOutput:  "keeping global subprogram: main.parse",
+			wantRemoved: []bool{false, false, true},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -60,10 +84,10 @@ func TestExtractProgramsModule(t *testing.T) {
 					}
 				}
 			}
-			m := &ir.Module{Functions: tt.funcs, Entries: entries}
+			m := &ir.Module{Functions: tt.funcs, Globals: tt.globals, Entries: entries}
 
 			var buf bytes.Buffer
-			err := extractProgramsModule(m, tt.programs, tt.verbose, &buf)
+			err := extractProgramsModule(m, tt.programs, tt.globalFuncs, tt.verbose, &buf)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -17,9 +17,12 @@ const (
 
 var allocaSizeRe = regexp.MustCompile(`alloca \[(\d+) x i8\]`)
 
-// finalizeModule adds a GPL license if missing and removes unreferenced definitions.
+// finalizeModule renames kfuncs, adds a GPL license if missing and removes unreferenced definitions.
 func finalizeModule(m *ir.Module, w io.Writer) error {
 	stripKfuncPrefixModule(m)
+	if err := iteratorKfuncsModule(m); err != nil {
+		return err
+	}
 	stripAbortCallsModule(m, w)
 	if err := addLicenseModule(m); err != nil {
 		return err
@@ -131,7 +134,8 @@ func isAbortCallLine(line string) bool {
 }
 
 // kernelKfuncName translates a Go kfunc symbol like `main.bpfKfuncBpfTaskFromPid`
-// to the kernel kfunc symbol `bpf_task_from_pid`. Callers pass the full Go
+// to the kernel kfunc symbol `bpf_task_from_pid`, and an iterator such as
+// `main.bpfIterNumNew` to `bpf_iter_num_new`. Callers pass the full Go
 // symbol including the `main.` package prefix.
 func kernelKfuncName(goName string) string {
 	name := strings.TrimPrefix(goName, "main.")
@@ -140,18 +144,19 @@ func kernelKfuncName(goName string) string {
 }
 
 // stripKfuncPrefixModule renames kfunc declarations and call sites from
-// @main.bpfKfuncXxx to the kernel kfunc name (CamelCase -> snake_case), and
-// strips the trailing TinyGo context pointer (ptr undef) from kfunc call
-// arguments. The naming convention: a Go function `bpfKfuncBpfTaskFromPid`
-// maps to the kernel kfunc `bpf_task_from_pid`.
+// @main.bpfKfuncXxx or @main.bpfIterXxx to the kernel kfunc name (CamelCase
+// -> snake_case), and strips the trailing TinyGo context pointer from kfunc
+// declarations and their call arguments. The naming convention: a Go
+// function `bpfKfuncBpfTaskFromPid` maps to the kernel kfunc `bpf_task_from_pid`.
 func stripKfuncPrefixModule(m *ir.Module) {
 	var renames []mapRename
+	withContext := make(map[string]bool)
 	for i := range m.Entries {
 		e := &m.Entries[i]
 		if e.Removed || e.Kind != ir.TopDeclare || e.Declare == nil {
 			continue
 		}
-		if !strings.HasPrefix(e.Declare.Name, "main.bpfKfunc") {
+		if !isKfuncName(e.Declare.Name) {
 			continue
 		}
 		oldName := e.Declare.Name
@@ -162,6 +167,13 @@ func stripKfuncPrefixModule(m *ir.Module) {
 		})
 		e.Declare.Name = newName
 		e.Raw = strings.ReplaceAll(e.Raw, "@"+oldName, "@"+newName)
+		if kfuncHasContext(m, "@"+oldName, e.Declare.Params) {
+			withContext["@"+newName] = true
+			params := splitTypeArgs(e.Declare.Params)
+			trimmed := strings.Join(params[:len(params)-1], ", ")
+			e.Raw = strings.Replace(e.Raw, "("+e.Declare.Params+")", "("+trimmed+")", 1)
+			e.Declare.Params = trimmed
+		}
 	}
 	if len(renames) == 0 {
 		return
@@ -172,13 +184,43 @@ func stripKfuncPrefixModule(m *ir.Module) {
 		}
 		ir.EnsureBlocks(fn)
 		fn.Modified = true
-		applyKfuncRenames(fn, renames)
+		applyKfuncRenames(fn, renames, withContext)
+	}
+}
+
+// kfuncHasContext reports whether the kfunc callee, declared with params,
+// takes the trailing TinyGo context pointer: its last parameter is a pointer
+// and every call passes undef for it. A kfunc whose own last parameter is a
+// pointer, called with a real argument there, keeps it.
+func kfuncHasContext(m *ir.Module, callee, params string) bool {
+	decl := splitTypeArgs(params)
+	if len(decl) == 0 || decl[len(decl)-1] != "ptr" {
+		return false
+	}
+	for _, fn := range m.Functions {
+		if fn.Removed {
+			continue
+		}
+		ir.EnsureBlocks(fn)
+		for _, block := range fn.Blocks {
+			for _, inst := range block.Instructions {
+				if inst.Kind != ir.InstCall || inst.Call == nil || inst.Call.Callee != callee {
+					continue
+				}
+				args := splitTypeArgs(inst.Call.Args)
+				if len(args) != len(decl) || args[len(args)-1] != "ptr undef" {
+					return false
+				}
+			}
+		}
 	}
+	return true
 }
 
 // applyKfuncRenames renames kfunc references in a function and strips the
-// trailing TinyGo context pointer from their call arguments.
-func applyKfuncRenames(fn *ir.Function, renames []mapRename) {
+// trailing TinyGo context pointer from the call arguments of the kfuncs in
+// withContext.
+func applyKfuncRenames(fn *ir.Function, renames []mapRename, withContext map[string]bool) {
 	for _, r := range renames {
 		renameInFunction(fn, r.oldRef, r.newRef)
 	}
@@ -188,10 +230,16 @@ func applyKfuncRenames(fn *ir.Function, renames []mapRename) {
 				continue
 			}
 			for _, r := range renames {
-				if inst.Call.Callee == r.newRef {
-					inst.Call.Args = stripTrailingUndef(inst.Call.Args)
-					inst.Modified = true
+				// renameInFunction only rewrites the raw text of unmodified instructions.
+				if inst.Call.Callee != r.oldRef && inst.Call.Callee != r.newRef {
+					continue
+				}
+				inst.Call.Callee = r.newRef
+				if withContext[r.newRef] {
+					args := splitTypeArgs(inst.Call.Args)
+					inst.Call.Args = strings.Join(args[:len(args)-1], ", ")
 				}
+				inst.Modified = true
 			}
 		}
 	}
@@ -65,16 +65,20 @@ func TestStripKfuncPrefixModule(t *testing.T) {
 	tests := []struct {
 		name         string
 		declName     string
+		declParams   string
 		declRaw      string
 		bodyRaw      []string
 		wantDeclName string
 		wantNoPrefix bool
 		wantStripArg bool
+		wantParams   string
+		wantArgs     string
 	}{
 		{
-			name:     "renames kfunc declares and strips context args",
-			declName: "main.bpfKfuncMyHelper",
-			declRaw:  "declare ptr @main.bpfKfuncMyHelper(ptr, i32, ptr)",
+			name:       "renames kfunc declares and strips context args",
+			declName:   "main.bpfKfuncMyHelper",
+			declParams: "ptr, i32, ptr",
+			declRaw:    "declare ptr @main.bpfKfuncMyHelper(ptr, i32, ptr)",
 			bodyRaw: []string{
 				"entry:",
 				"  %0 = call ptr @main.bpfKfuncMyHelper(ptr %ctx, i32 42, ptr undef)",
@@ -82,6 +86,22 @@ func TestStripKfuncPrefixModule(t *testing.T) {
 			wantDeclName: "my_helper",
 			wantNoPrefix: true,
 			wantStripArg: true,
+			wantParams:   "ptr, i32",
+			wantArgs:     "ptr %ctx, i32 42",
+		},
+		{
+			name:       "keeps a trailing pointer parameter passed by the caller",
+			declName:   "main.bpfKfuncMyHelper",
+			declParams: "i32, ptr",
+			declRaw:    "declare void @main.bpfKfuncMyHelper(i32, ptr)",
+			bodyRaw: []string{
+				"entry:",
+				"  call void @main.bpfKfuncMyHelper(i32 1, ptr %task)",
+			},
+			wantDeclName: "my_helper",
+			wantNoPrefix: true,
+			wantParams:   "i32, ptr",
+			wantArgs:     "i32 1, ptr %task",
 		},
 		{
 			name:         "no kfuncs is no-op",
@@ -93,7 +113,7 @@ func TestStripKfuncPrefixModule(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			decl := &ir.Declare{Name: tt.declName, Raw: tt.declRaw}
+			decl := &ir.Declare{Name: tt.declName, Params: tt.declParams, Raw: tt.declRaw}
 			fn := &ir.Function{
 				Name:    "my_prog",
 				Raw:     "define i32 @my_prog() {",
@@ -129,6 +149,19 @@ func TestStripKfuncPrefixModule(t *testing.T) {
 					}
 				}
 			}
+			if tt.wantParams != "" && decl.Params != tt.wantParams {
+				t.Errorf("declare params = %q, want %q", decl.Params, tt.wantParams)
+			}
+			if tt.wantArgs != "" {
+				ir.EnsureBlocks(fn)
+				for _, block := range fn.Blocks {
+					for _, inst := range block.Instructions {
+						if inst.Kind == ir.InstCall && inst.Call != nil && inst.Call.Args != tt.wantArgs {
+							t.Errorf("call args = %q, want %q", inst.Call.Args, tt.wantArgs)
+						}
+					}
+				}
+			}
 		})
 	}
 }
@@ -0,0 +1,211 @@
+package transform
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kyleseneker/tinybpf/diag"
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+// --- Bounded loops over the num iterator ---
+//
+// bpfForNum(start, end, body, ctx) is the Go counterpart of libbpf's bpf_for
+// macro. It is not a helper: each call is lowered to an open-coded loop over
+// the num iterator, with the state in an 8-byte stack slot of its own:
+//
+//	bpf_iter_num_new(&it, start, end)
+//	for p := bpf_iter_num_next(&it); p != nil; p = bpf_iter_num_next(&it) {
+//		if body(*p, ctx) != 0 {
+//			break
+//		}
+//	}
+//	bpf_iter_num_destroy(&it)
+//
+// The destroy call is always emitted, on the single exit of the loop. The
+// body is a callback like those of bpf_loop, kept as a static subprogram;
+// the verifier checks it once however many times the loop runs.
+
+const (
+	// forNumName is the Go function lowered to a num iterator loop.
+	forNumName = "main.bpfForNum"
+	// forNumCallbackPos is the position of the body among its Go parameters.
+	forNumCallbackPos = 2
+)
+
+// forNumKfuncs are the declarations of the num iterator kfuncs the lowered
+// loops call. iteratorKfuncsModule gives them their BTF prototypes.
+var forNumKfuncs = []struct{ name, ret, params string }{
+	{"bpf_iter_num_new", "i32", "ptr, i32, i32"},
+	{"bpf_iter_num_next", "ptr", "ptr"},
+	{"bpf_iter_num_destroy", "void", "ptr"},
+}
+
+// iteratorsModule lowers bpfForNum calls to open-coded num iterator loops.
+func iteratorsModule(m *ir.Module) error {
+	l := &forNumLowering{m: m}
+	var errs []error
+	for _, fn := range m.Functions {
+		if fn.Removed {
+			continue
+		}
+		ir.EnsureBlocks(fn)
+		var allocas []*ir.Instruction
+		for b := 0; b < len(fn.Blocks); b++ {
+			block := fn.Blocks[b]
+			for i, inst := range block.Instructions {
+				if inst.Kind != ir.InstCall || inst.Call == nil || inst.Call.Callee != "@"+forNumName {
+					continue
+				}
+				loop, alloca, err := l.lower(fn, block, i)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("bpfForNum in %s: %w", fn.Name, err))
+					continue
+				}
+				allocas = append(allocas, alloca)
+				fn.Blocks = slices.Insert(fn.Blocks, b+1, loop...)
+				fn.Modified = true
+				break
+			}
+		}
+		if len(allocas) > 0 {
+			fn.Blocks[0].Instructions = append(allocas, fn.Blocks[0].Instructions...)
+		}
+	}
+	if len(errs) == 0 && l.next > 0 {
+		l.declareKfuncs()
+	}
+	return diag.WrapErrors(diag.StageTransform, "iterators", errs,
+		"declare it as func bpfForNum(start, end int32, body func(i int32, ctx unsafe.Pointer) int64, ctx unsafe.Pointer) "+
+			"and pass a top-level function as the body")
+}
+
+// forNumLowering carries the state of iteratorsModule across calls.
+type forNumLowering struct {
+	m    *ir.Module
+	next int // number of loops lowered so far
+}
+
+// lower splits block at the bpfForNum call at index i and returns the loop
+// blocks to insert after it, the last of which continues with the rest of
+// block, and the alloca of the iterator state for the entry block.
+func (l *forNumLowering) lower(fn *ir.Function, block *ir.BasicBlock, i int) ([]*ir.BasicBlock, *ir.Instruction, error) {
+	call := block.Instructions[i]
+	args := splitTypeArgs(stripTrailingUndef(call.Call.Args))
+	if len(args) != 5 || !strings.HasPrefix(args[0], "i32 ") || !strings.HasPrefix(args[1], "i32 ") {
+		return nil, nil, fmt.Errorf("unexpected arguments %q", call.Call.Args)
+	}
+	context, callee, ok := callbackArg(args, forNumCallbackPos)
+	if !ok {
+		return nil, nil, fmt.Errorf("the body must be a function")
+	}
+	if context != "undef" && context != "null" {
+		return nil, nil, fmt.Errorf("the body must be a top-level function, not a closure or method value")
+	}
+	body := findFunction(l.m, callee[1:])
+	if body == nil || body.RetType != "i64" || len(splitTypeArgs(body.Params)) != 2 {
+		return nil, nil, fmt.Errorf("the body %s must be a func(i int32, ctx unsafe.Pointer) int64", callee[1:])
+	}
+	if block.Label == "" {
+		return nil, nil, fmt.Errorf("the call is in an unlabeled block")
+	}
+	if call.SSAName != "" {
+		return nil, nil, fmt.Errorf("bpfForNum returns nothing")
+	}
+
+	l.next++
+	name := func(s string) string { return fmt.Sprintf("__for.%d.%s", l.next, s) }
+	it := "%" + name("it")
+	head, loop, exit := name("head"), name("body"), name("exit")
+	bodyRet := body.RetType
+	if strings.Contains(body.Raw[:strings.Index(body.Raw, "@")], " fastcc ") {
+		bodyRet = "fastcc " + bodyRet
+	}
+	newCall := func(ssa, ret, callee, args string) *ir.Instruction {
+		return &ir.Instruction{
+			SSAName:  ssa,
+			Kind:     ir.InstCall,
+			Call:     &ir.CallInst{RetType: ret, Callee: callee, Args: args},
+			Metadata: call.Metadata,
+			Modified: true,
+		}
+	}
+	other := func(line string, a ...any) *ir.Instruction {
+		return &ir.Instruction{Kind: ir.InstOther, Raw: "  " + fmt.Sprintf(line, a...), Modified: true}
+	}
+
+	tail := slices.Clone(block.Instructions[i+1:])
+	block.Instructions = append(block.Instructions[:i],
+		newCall("%"+name("new"), "i32", "@bpf_iter_num_new", fmt.Sprintf("ptr %s, i32 %s, i32 %s", it, operandValue(args[0]), operandValue(args[1]))),
+		other("br label %%%s", head))
+	blocks := []*ir.BasicBlock{
+		{Label: head, Instructions: []*ir.Instruction{
+			newCall("%"+name("p"), "ptr", "@bpf_iter_num_next", "ptr "+it),
+			other("%%%s = icmp eq ptr %%%s, null", name("done"), name("p")),
+			other("br i1 %%%s, label %%%s, label %%%s", name("done"), exit, loop),
+		}},
+		{Label: loop, Instructions: []*ir.Instruction{
+			other("%%%s = load i32, ptr %%%s, align 4", name("i"), name("p")),
+			newCall("%"+name("ret"), bodyRet, callee, fmt.Sprintf("i32 %%%s, ptr %s", name("i"), operandValue(args[4]))),
+			other("%%%s = icmp ne i64 %%%s, 0", name("stop"), name("ret")),
+			other("br i1 %%%s, label %%%s, label %%%s", name("stop"), exit, head),
+		}},
+		{Label: exit, Instructions: append([]*ir.Instruction{
+			newCall("", "void", "@bpf_iter_num_destroy", "ptr "+it),
+		}, tail...)},
+	}
+	renamePhiPredecessor(fn, block.Label, exit)
+	alloca := &ir.Instruction{
+		SSAName:  it,
+		Kind:     ir.InstAlloca,
+		Alloca:   &ir.AllocaInst{Type: "[8 x i8]", Align: 8},
+		Modified: true,
+	}
+	return blocks, alloca, nil
+}
+
+// declareKfuncs adds the declarations of the num iterator kfuncs that the
+// module does not declare yet.
+func (l *forNumLowering) declareKfuncs() {
+	var entries []ir.TopLevelEntry
+	for _, kf := range forNumKfuncs {
+		if slices.ContainsFunc(l.m.Declares, func(d *ir.Declare) bool { return !d.Removed && d.Name == kf.name }) {
+			continue
+		}
+		raw := fmt.Sprintf("declare %s @%s(%s)", kf.ret, kf.name, kf.params)
+		decl := &ir.Declare{Name: kf.name, RetType: kf.ret, Params: kf.params, Raw: raw}
+		l.m.Declares = append(l.m.Declares, decl)
+		entries = append(entries, ir.TopLevelEntry{Kind: ir.TopDeclare, Raw: raw, Declare: decl}, ir.TopLevelEntry{Kind: ir.TopBlank})
+	}
+	if idx := findFirstFuncEntry(l.m); idx >= 0 {
+		l.m.Entries = slices.Insert(l.m.Entries, idx, entries...)
+	} else {
+		l.m.Entries = append(l.m.Entries, entries...)
+	}
+}
+
+// findFunction returns the function definition named name, if any.
+func findFunction(m *ir.Module, name string) *ir.Function {
+	for _, fn := range m.Functions {
+		if !fn.Removed && fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+// renamePhiPredecessor makes the phi nodes of fn that take a value from the
+// block labeled from take it from the block labeled to, after the end of the
+// block has moved there.
+func renamePhiPredecessor(fn *ir.Function, from, to string) {
+	for _, block := range fn.Blocks {
+		for _, inst := range block.Instructions {
+			if !strings.Contains(inst.Raw, " = phi ") || !strings.Contains(inst.Raw, "%"+from+" ]") {
+				continue
+			}
+			inst.Raw = strings.ReplaceAll(inst.Raw, "%"+from+" ]", "%"+to+" ]")
+			inst.Modified = true
+		}
+	}
+}
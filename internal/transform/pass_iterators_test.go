@@ -0,0 +1,155 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+// forNumBody is a bpfForNum body after the subprograms pass dropped its
+// context parameter.
+const forNumBody = `
+
+define internal i64 @main.addIndex(i32 %i, ptr %data) unnamed_addr {
+entry:
+  ret i64 0
+}
+
+declare void @main.bpfForNum(i32, i32, ptr, ptr, ptr, ptr)`
+
+func TestIteratorsModule(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		contains []string
+		absent   []string
+		wantErr  string
+	}{
+		{
+			name: "loop",
+			input: `define i32 @count(ptr %ctx) {
+entry:
+  %sum = alloca i64, align 8
+  call void @main.bpfForNum(i32 0, i32 %n, ptr undef, ptr nonnull @main.addIndex, ptr nonnull %sum, ptr undef), !dbg !20
+  ret i32 0
+}` + forNumBody,
+			contains: []string{
+				"declare i32 @bpf_iter_num_new(ptr, i32, i32)\n\ndeclare ptr @bpf_iter_num_next(ptr)\n\ndeclare void @bpf_iter_num_destroy(ptr)",
+				"entry:\n  %__for.1.it = alloca [8 x i8], align 8\n  %sum = alloca i64, align 8\n" +
+					"  %__for.1.new = call i32 @bpf_iter_num_new(ptr %__for.1.it, i32 0, i32 %n), !dbg !20\n" +
+					"  br label %__for.1.head\n" +
+					"__for.1.head:\n" +
+					"  %__for.1.p = call ptr @bpf_iter_num_next(ptr %__for.1.it), !dbg !20\n" +
+					"  %__for.1.done = icmp eq ptr %__for.1.p, null\n" +
+					"  br i1 %__for.1.done, label %__for.1.exit, label %__for.1.body\n" +
+					"__for.1.body:\n" +
+					"  %__for.1.i = load i32, ptr %__for.1.p, align 4\n" +
+					"  %__for.1.ret = call i64 @main.addIndex(i32 %__for.1.i, ptr %sum), !dbg !20\n" +
+					"  %__for.1.stop = icmp ne i64 %__for.1.ret, 0\n" +
+					"  br i1 %__for.1.stop, label %__for.1.exit, label %__for.1.head\n" +
+					"__for.1.exit:\n" +
+					"  call void @bpf_iter_num_destroy(ptr %__for.1.it), !dbg !20\n" +
+					"  ret i32 0\n}",
+			},
+			absent: []string{"call void @main.bpfForNum"},
+		},
+		{
+			name: "two loops and a phi",
+			input: `define i32 @count(ptr %ctx) {
+entry:
+  %sum = alloca i64, align 8
+  %0 = icmp eq ptr %ctx, null
+  call void @main.bpfForNum(i32 0, i32 8, ptr undef, ptr @main.addIndex, ptr %sum, ptr undef)
+  call void @main.bpfForNum(i32 8, i32 16, ptr undef, ptr @main.addIndex, ptr %sum, ptr undef)
+  br i1 %0, label %a, label %b
+a:
+  br label %b
+b:
+  %1 = phi i32 [ 1, %entry ], [ 2, %a ]
+  ret i32 %1
+}` + forNumBody,
+			contains: []string{
+				"entry:\n  %__for.1.it = alloca [8 x i8], align 8\n  %__for.2.it = alloca [8 x i8], align 8\n",
+				"__for.1.exit:\n  call void @bpf_iter_num_destroy(ptr %__for.1.it)\n" +
+					"  %__for.2.new = call i32 @bpf_iter_num_new(ptr %__for.2.it, i32 8, i32 16)\n",
+				"__for.2.exit:\n  call void @bpf_iter_num_destroy(ptr %__for.2.it)\n  br i1 %0, label %a, label %b",
+				"%1 = phi i32 [ 1, %__for.2.exit ], [ 2, %a ]",
+			},
+		},
+		{
+			name: "kfunc already declared",
+			input: `define i32 @count(ptr %ctx) {
+entry:
+  call void @main.bpfForNum(i32 0, i32 8, ptr undef, ptr @main.addIndex, ptr null, ptr undef)
+  ret i32 0
+}
+
+declare ptr @bpf_iter_num_next(ptr)` + forNumBody,
+			contains: []string{"declare i32 @bpf_iter_num_new(ptr, i32, i32)\n\ndeclare void @bpf_iter_num_destroy(ptr)"},
+			absent:   []string{"declare ptr @bpf_iter_num_next(ptr)\n\ndeclare ptr @bpf_iter_num_next(ptr)"},
+		},
+		{
+			name: "closure body",
+			input: `define i32 @count(ptr %ctx) {
+entry:
+  %sum = alloca i64, align 8
+  call void @main.bpfForNum(i32 0, i32 8, ptr %sum, ptr @main.addIndex, ptr null, ptr undef)
+  ret i32 0
+}` + forNumBody,
+			wantErr: "the body must be a top-level function, not a closure or method value",
+		},
+		{
+			name: "wrong body signature",
+			input: `define i32 @count(ptr %ctx) {
+entry:
+  call void @main.bpfForNum(i32 0, i32 8, ptr undef, ptr @main.step, ptr null, ptr undef)
+  ret i32 0
+}
+
+define internal i32 @main.step(i32 %i) {
+entry:
+  ret i32 0
+}`,
+			wantErr: "the body main.step must be a func(i int32, ctx unsafe.Pointer) int64",
+		},
+		{
+			name: "64-bit bounds",
+			input: `define i32 @count(ptr %ctx) {
+entry:
+  call void @main.bpfForNum(i64 0, i64 8, ptr undef, ptr @main.addIndex, ptr null, ptr undef)
+  ret i32 0
+}` + forNumBody,
+			wantErr: "unexpected arguments",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ir.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = iteratorsModule(m)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := ir.Serialize(m)
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output missing %q\n%s", s, out)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(out, s) {
+					t.Errorf("output should not contain %q", s)
+				}
+			}
+		})
+	}
+}
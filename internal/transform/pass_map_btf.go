@@ -127,14 +127,25 @@ func stripPointerName(line string) string {
 
 // --- Map BTF pass ---
 
-// mapBTFPassModule strips map name prefixes, rewrites map definitions for BTF, and sanitizes DI names.
-func mapBTFPassModule(m *ir.Module) error {
+// mapBTFPassModule rewrites typed map definitions, strips map name prefixes (including references from
+// map-in-map initializers), rewrites legacy map definitions for BTF, adds the BTF tags of struct fields,
+// and sanitizes DI names.
+func mapBTFPassModule(m *ir.Module, tags map[string]string) error {
+	if err := rewriteTypedMapsModule(m); err != nil {
+		return err
+	}
 	if err := stripMapPrefixModule(m); err != nil {
 		return err
 	}
 	if err := rewriteMapForBTFModule(m); err != nil {
 		return err
 	}
+	if err := fieldTagsModule(m, tags); err != nil {
+		return err
+	}
+	if err := nameKernelStructsModule(m); err != nil {
+		return err
+	}
 	return sanitizeBTFNamesModule(m)
 }
 
@@ -267,7 +278,7 @@ func rewriteMapForBTFModule(m *ir.Module) error {
 // detectMapFieldCount returns the field count from a bpfMapDef type, defaulting to 5.
 func detectMapFieldCount(m *ir.Module) (int, error) {
 	for _, td := range m.TypeDefs {
-		if strings.Contains(td.Name, "bpfMapDef") {
+		if strings.Contains(td.Name, "bpfMapDef") && !isTypedMapDef(m, td.Name) {
 			fc := len(td.Fields)
 			if fc < 5 || fc > 7 {
 				return 0, fmt.Errorf("bpfMapDef type has %d fields (expected 5-7): %s", fc, td.Raw)
@@ -289,7 +300,7 @@ func collectMapDefs(m *ir.Module, fieldCount int) ([]astMapDef, error) {
 	var maps []astMapDef
 	var errs []error
 	for i, e := range m.Entries {
-		if e.Removed || e.Kind != ir.TopGlobal || e.Global == nil {
+		if e.Removed || e.Kind != ir.TopGlobal || e.Global == nil || isTypedMapDef(m, e.Global.Type) {
 			continue
 		}
 		trimmed := strings.TrimSpace(e.Raw)
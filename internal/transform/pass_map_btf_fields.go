@@ -0,0 +1,83 @@
+package transform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kyleseneker/tinybpf/diag"
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+// --- Special map value fields ---
+//
+// The verifier finds bpf_spin_lock and bpf_timer fields in a map value by
+// the name and size of the struct type of the member, without looking
+// through typedefs. TinyGo describes a Go type such as bpfSpinLockT as a
+// typedef named main.bpfSpinLockT of an anonymous struct, so the struct gets
+// the kernel name and references to the typedef are pointed at the struct,
+// which makes the field recognized wherever it sits in a BTF-typed map value.
+
+// kernelStructs maps Go types to the kernel struct they stand for and its
+// size in bytes.
+var kernelStructs = map[string]struct {
+	name string
+	size int
+}{
+	"main.bpfSpinLockT": {"bpf_spin_lock", 4},
+	"main.bpfTimer":     {"bpf_timer", 16},
+}
+
+// nameKernelStructsModule gives the debug info of the bpfSpinLockT and
+// bpfTimer types the kernel struct names.
+func nameKernelStructsModule(m *ir.Module) error {
+	metaByID := make(map[int]*ir.MetadataNode, len(m.MetadataNodes))
+	for _, mn := range m.MetadataNodes {
+		metaByID[mn.ID] = mn
+	}
+	structOf := make(map[string]string)
+	var errs []error
+	for _, mn := range m.MetadataNodes {
+		if mn.Kind != "DIDerivedType" || mn.Fields["tag"] != "DW_TAG_typedef" {
+			continue
+		}
+		ks, ok := kernelStructs[mn.Fields["name"]]
+		if !ok {
+			continue
+		}
+		st := resolveTypedef(mn.Fields["baseType"], metaByID)
+		if st == nil || st.Kind != "DICompositeType" || st.Fields["tag"] != "DW_TAG_structure_type" {
+			errs = append(errs, fmt.Errorf("%s must be a struct type to stand for struct %s", mn.Fields["name"], ks.name))
+			continue
+		}
+		if size := st.Fields["size"]; size != strconv.Itoa(8*ks.size) {
+			errs = append(errs, fmt.Errorf("%s is %s bits; struct %s is %d bytes", mn.Fields["name"], size, ks.name, ks.size))
+			continue
+		}
+		updateMetaEntry(m, mn.ID, func(raw string) string {
+			return strings.Replace(raw, `name: "`+mn.Fields["name"]+`"`, `name: "`+ks.name+`"`, 1)
+		})
+		updateMetaEntry(m, st.ID, func(raw string) string {
+			return setStructName(raw, st.Fields["name"], ks.name)
+		})
+		structOf[fmt.Sprintf("!%d", mn.ID)] = fmt.Sprintf("!%d", st.ID)
+	}
+	for _, mn := range m.MetadataNodes {
+		if ref, ok := structOf[mn.Fields["baseType"]]; ok {
+			updateMetaEntry(m, mn.ID, func(raw string) string {
+				return reBaseType.ReplaceAllString(raw, "baseType: "+ref)
+			})
+		}
+	}
+	return diag.WrapErrors(diag.StageTransform, "map-btf", errs,
+		"declare them as type bpfSpinLockT struct{ _ uint32 } and type bpfTimer struct{ _ [2]uint64 }")
+}
+
+// setStructName sets the name of the DICompositeType raw, which is currently
+// old or unnamed.
+func setStructName(raw, old, name string) string {
+	if old != "" {
+		return strings.Replace(raw, `name: "`+old+`"`, `name: "`+name+`"`, 1)
+	}
+	return strings.Replace(raw, "tag: DW_TAG_structure_type, ", `tag: DW_TAG_structure_type, name: "`+name+`", `, 1)
+}
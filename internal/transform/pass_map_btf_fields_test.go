@@ -0,0 +1,80 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+func TestNameKernelStructsModule(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		contains []string
+		absent   []string
+		wantErr  string
+	}{
+		{
+			name: "spin lock and timer",
+			input: `!1 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.bpfSpinLockT", baseType: !2)
+!2 = !DICompositeType(tag: DW_TAG_structure_type, size: 32, align: 32, elements: !{})
+!3 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.bpfTimer", baseType: !4)
+!4 = !DICompositeType(tag: DW_TAG_structure_type, name: "main.bpfTimer", size: 128, align: 64, elements: !{})
+!5 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.value", baseType: !6)
+!6 = !DICompositeType(tag: DW_TAG_structure_type, size: 192, align: 64, elements: !{!7, !8})
+!7 = !DIDerivedType(tag: DW_TAG_member, name: "lock", baseType: !1, size: 32, align: 32)
+!8 = !DIDerivedType(tag: DW_TAG_member, name: "timer", baseType: !3, size: 128, align: 64, offset: 64)`,
+			contains: []string{
+				`!1 = !DIDerivedType(tag: DW_TAG_typedef, name: "bpf_spin_lock", baseType: !2)`,
+				`!2 = !DICompositeType(tag: DW_TAG_structure_type, name: "bpf_spin_lock", size: 32, align: 32, elements: !{})`,
+				`!3 = !DIDerivedType(tag: DW_TAG_typedef, name: "bpf_timer", baseType: !4)`,
+				`!4 = !DICompositeType(tag: DW_TAG_structure_type, name: "bpf_timer", size: 128, align: 64, elements: !{})`,
+				`!7 = !DIDerivedType(tag: DW_TAG_member, name: "lock", baseType: !2, size: 32, align: 32)`,
+				`!8 = !DIDerivedType(tag: DW_TAG_member, name: "timer", baseType: !4, size: 128, align: 64, offset: 64)`,
+			},
+			absent: []string{"main.bpfSpinLockT", "main.bpfTimer"},
+		},
+		{
+			name: "wrong size",
+			input: `!1 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.bpfSpinLockT", baseType: !2)
+!2 = !DICompositeType(tag: DW_TAG_structure_type, size: 64, align: 32, elements: !{})`,
+			wantErr: "main.bpfSpinLockT is 64 bits; struct bpf_spin_lock is 4 bytes",
+		},
+		{
+			name: "not a struct",
+			input: `!1 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.bpfTimer", baseType: !2)
+!2 = !DIBasicType(name: "uint64", size: 64, encoding: DW_ATE_unsigned)`,
+			wantErr: "main.bpfTimer must be a struct type to stand for struct bpf_timer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ir.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = nameKernelStructsModule(m)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := ir.Serialize(m)
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output missing %q\n%s", s, out)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(out, s) {
+					t.Errorf("output should not contain %q", s)
+				}
+			}
+		})
+	}
+}
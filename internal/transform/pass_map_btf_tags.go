@@ -0,0 +1,187 @@
+package transform
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kyleseneker/tinybpf/diag"
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+// --- BTF tags from Go struct tags ---
+//
+// A bpf struct tag on a field of a Go struct adds BTF tags to it:
+//
+//	type taskRef struct {
+//		Task *bpfCoreTaskStruct `bpf:"kptr"`
+//	}
+//
+// kptr, kptr_untrusted and percpu_kptr are the type tags of libbpf's
+// __kptr, __kptr_untrusted and __percpu_kptr, which the verifier looks for
+// between a pointer field and its struct in a map value. type_tag=NAME and
+// decl_tag=NAME add any other btf_type_tag or btf_decl_tag. The tags become
+// LLVM annotations: decl tags on the member, type tags on a pointer type of
+// the member's own, so that other uses of the pointer type stay untagged.
+// The struct behind a kptr is named like a CO-RE type, bpfCoreTaskStruct
+// becoming task_struct, since the verifier resolves it by name.
+
+// kptrTags are the type tags that mark a kernel object reference.
+var kptrTags = []string{"kptr", "kptr_untrusted", "percpu_kptr"}
+
+// fieldTags are the BTF tags of one struct field.
+type fieldTags struct {
+	typeTags []string
+	declTags []string
+}
+
+// parseFieldTags parses the value of a bpf struct tag.
+func parseFieldTags(value string) (fieldTags, error) {
+	var ft fieldTags
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		switch name, arg, _ := strings.Cut(tag, "="); {
+		case slices.Contains(kptrTags, tag):
+			ft.typeTags = append(ft.typeTags, tag)
+		case name == "type_tag" && arg != "":
+			ft.typeTags = append(ft.typeTags, arg)
+		case name == "decl_tag" && arg != "":
+			ft.declTags = append(ft.declTags, arg)
+		default:
+			return ft, fmt.Errorf("unknown bpf tag %q", tag)
+		}
+	}
+	return ft, nil
+}
+
+// fieldTagsModule adds the BTF tags in tags, keyed by Go struct type and
+// field name (e.g. "main.taskRef.Task"), to the debug info of the fields.
+// Types without debug info are not used by the program and are skipped.
+func fieldTagsModule(m *ir.Module, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	metaByID := make(map[int]*ir.MetadataNode, len(m.MetadataNodes))
+	typedefs := make(map[string]*ir.MetadataNode)
+	for _, mn := range m.MetadataNodes {
+		metaByID[mn.ID] = mn
+		if mn.Kind == "DIDerivedType" && mn.Fields["tag"] == "DW_TAG_typedef" {
+			typedefs[mn.Fields["name"]] = mn
+		}
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	e := &tagEmitter{m: m, nextID: findMaxMetaIDFromModule(m) + 1, named: make(map[int]bool)}
+	var errs []error
+	for _, key := range keys {
+		if err := e.tagField(key, tags[key], typedefs, metaByID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return diag.WrapErrors(diag.StageTransform, "map-btf", errs,
+		"use bpf:\"kptr\", \"kptr_untrusted\", \"percpu_kptr\", \"type_tag=NAME\" or \"decl_tag=NAME\"; type tags need a pointer field")
+}
+
+// tagEmitter appends the annotation nodes of field tags.
+type tagEmitter struct {
+	m      *ir.Module
+	nextID int
+	named  map[int]bool // kptr target structs already named
+}
+
+// emit appends the metadata node body under a fresh ID and returns its reference.
+func (e *tagEmitter) emit(format string, args ...any) string {
+	ref := fmt.Sprintf("!%d", e.nextID)
+	e.nextID++
+	appendMetaEntryToModule(e.m, ref+" = "+fmt.Sprintf(format, args...))
+	return ref
+}
+
+// annotations emits the annotation list of the BTF tags of kind.
+func (e *tagEmitter) annotations(kind string, tags []string) string {
+	refs := make([]string, len(tags))
+	for i, tag := range tags {
+		refs[i] = e.emit(`!{!"%s", !"%s"}`, kind, tag)
+	}
+	return e.emit("!{%s}", strings.Join(refs, ", "))
+}
+
+// tagField adds the tags of the struct field key to its member node.
+func (e *tagEmitter) tagField(key, value string, typedefs map[string]*ir.MetadataNode, metaByID map[int]*ir.MetadataNode) error {
+	ft, err := parseFieldTags(value)
+	if err != nil {
+		return fmt.Errorf("field %s: %w", key, err)
+	}
+	i := strings.LastIndex(key, ".")
+	td := typedefs[key[:i]]
+	if td == nil {
+		return nil
+	}
+	var member *ir.MetadataNode
+	for _, mem := range structMembers(fmt.Sprintf("!%d", td.ID), metaByID) {
+		if mem.Fields["name"] == key[i+1:] {
+			member = mem
+			break
+		}
+	}
+	if member == nil {
+		return fmt.Errorf("field %s: not found in the debug info of %s", key, key[:i])
+	}
+
+	var declTags string
+	if len(ft.declTags) > 0 {
+		declTags = e.annotations("btf_decl_tag", ft.declTags)
+	}
+	baseType := member.Fields["baseType"]
+	if len(ft.typeTags) > 0 {
+		ptr := metaByID[parseMetaID(baseType)]
+		if ptr == nil || ptr.Fields["tag"] != "DW_TAG_pointer_type" {
+			return fmt.Errorf("field %s: type tags %s need a pointer field", key, strings.Join(ft.typeTags, ", "))
+		}
+		if slices.ContainsFunc(ft.typeTags, func(tag string) bool { return slices.Contains(kptrTags, tag) }) {
+			if err := e.nameKptrTarget(ptr.Fields["baseType"], metaByID); err != nil {
+				return fmt.Errorf("field %s: %w", key, err)
+			}
+		}
+		baseType = e.emit("!DIDerivedType(tag: DW_TAG_pointer_type, baseType: %s, size: 64, annotations: %s)",
+			ptr.Fields["baseType"], e.annotations("btf_type_tag", ft.typeTags))
+	}
+	updateMetaEntry(e.m, member.ID, func(raw string) string {
+		raw = reBaseType.ReplaceAllString(raw, "baseType: "+baseType)
+		if declTags != "" {
+			raw = raw[:strings.LastIndex(raw, ")")] + ", annotations: " + declTags + ")"
+		}
+		return raw
+	})
+	return nil
+}
+
+// nameKptrTarget gives the Go struct type ref that a kptr points to the name
+// the verifier resolves it by.
+func (e *tagEmitter) nameKptrTarget(ref string, metaByID map[int]*ir.MetadataNode) error {
+	td := metaByID[parseMetaID(ref)]
+	st := resolveTypedef(ref, metaByID)
+	if td == nil || td.Fields["tag"] != "DW_TAG_typedef" || st == nil || st.Fields["tag"] != "DW_TAG_structure_type" {
+		return fmt.Errorf("a kptr must point to a named struct type")
+	}
+	if e.named[st.ID] {
+		return nil
+	}
+	e.named[st.ID] = true
+	name := td.Fields["name"]
+	if _, after, ok := strings.Cut(name, "bpfCore"); ok {
+		name = camelToSnake(after)
+	}
+	updateMetaEntry(e.m, td.ID, func(raw string) string {
+		return strings.Replace(raw, `name: "`+td.Fields["name"]+`"`, `name: "`+name+`"`, 1)
+	})
+	updateMetaEntry(e.m, st.ID, func(raw string) string {
+		return setStructName(raw, st.Fields["name"], name)
+	})
+	return nil
+}
@@ -0,0 +1,99 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+func TestFieldTagsModule(t *testing.T) {
+	const debugInfo = `!1 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.taskRef", baseType: !2)
+!2 = !DICompositeType(tag: DW_TAG_structure_type, size: 128, align: 64, elements: !20)
+!3 = !DIDerivedType(tag: DW_TAG_member, name: "Task", baseType: !5, size: 64, align: 64)
+!4 = !DIDerivedType(tag: DW_TAG_member, name: "Count", baseType: !8, size: 64, align: 64, offset: 64)
+!5 = !DIDerivedType(tag: DW_TAG_pointer_type, name: "*main.bpfCoreTaskStruct", baseType: !6, size: 64, align: 64, dwarfAddressSpace: 0)
+!6 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.bpfCoreTaskStruct", baseType: !7)
+!7 = !DICompositeType(tag: DW_TAG_structure_type, size: 32, align: 32, elements: !{})
+!8 = !DIBasicType(name: "uint64", size: 64, encoding: DW_ATE_unsigned)
+!20 = !{!3, !4}`
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		contains []string
+		wantErr  string
+	}{
+		{
+			name: "kptr",
+			tags: map[string]string{"main.taskRef.Task": "kptr"},
+			contains: []string{
+				`!3 = !DIDerivedType(tag: DW_TAG_member, name: "Task", baseType: !23, size: 64, align: 64)`,
+				`!5 = !DIDerivedType(tag: DW_TAG_pointer_type, name: "*main.bpfCoreTaskStruct", baseType: !6,`,
+				`!6 = !DIDerivedType(tag: DW_TAG_typedef, name: "task_struct", baseType: !7)`,
+				`!7 = !DICompositeType(tag: DW_TAG_structure_type, name: "task_struct", size: 32, align: 32, elements: !{})`,
+				`!21 = !{!"btf_type_tag", !"kptr"}`,
+				`!22 = !{!21}`,
+				`!23 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: !6, size: 64, annotations: !22)`,
+			},
+		},
+		{
+			name: "decl and type tags",
+			tags: map[string]string{
+				"main.taskRef.Task":  "type_tag=rcu, decl_tag=owned",
+				"main.taskRef.Count": "decl_tag=counter",
+			},
+			contains: []string{
+				`!4 = !DIDerivedType(tag: DW_TAG_member, name: "Count", baseType: !8, size: 64, align: 64, offset: 64, annotations: !22)`,
+				`!21 = !{!"btf_decl_tag", !"counter"}`,
+				`!3 = !DIDerivedType(tag: DW_TAG_member, name: "Task", baseType: !27, size: 64, align: 64, annotations: !24)`,
+				`!23 = !{!"btf_decl_tag", !"owned"}`,
+				`!25 = !{!"btf_type_tag", !"rcu"}`,
+				`!27 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: !6, size: 64, annotations: !26)`,
+				`!6 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.bpfCoreTaskStruct", baseType: !7)`,
+			},
+		},
+		{
+			name: "type without debug info",
+			tags: map[string]string{"main.unused.Task": "kptr"},
+		},
+		{
+			name:    "type tag on a non-pointer",
+			tags:    map[string]string{"main.taskRef.Count": "kptr"},
+			wantErr: "field main.taskRef.Count: type tags kptr need a pointer field",
+		},
+		{
+			name:    "unknown tag",
+			tags:    map[string]string{"main.taskRef.Task": "kpointer"},
+			wantErr: `field main.taskRef.Task: unknown bpf tag "kpointer"`,
+		},
+		{
+			name:    "missing field",
+			tags:    map[string]string{"main.taskRef.Owner": "kptr"},
+			wantErr: "field main.taskRef.Owner: not found in the debug info of main.taskRef",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ir.Parse(debugInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = fieldTagsModule(m, tt.tags)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := ir.Serialize(m)
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output missing %q\n%s", s, out)
+				}
+			}
+		})
+	}
+}
@@ -126,7 +126,7 @@ func TestMapBTFPassModule(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := mapBTFPassModule(tt.module)
+			err := mapBTFPassModule(tt.module, nil)
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("mapBTFPassModule() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -356,7 +356,7 @@ func TestMapBTFPassModule_error_propagation(t *testing.T) {
 	m := &ir.Module{
 		TypeDefs: []*ir.TypeDef{{Name: "%main.bpfMapDef", Fields: []string{"i32"}, Raw: "%main.bpfMapDef = type { i32 }"}},
 	}
-	err := mapBTFPassModule(m)
+	err := mapBTFPassModule(m, nil)
 	if err == nil {
 		t.Fatal("expected error from bad bpfMapDef field count")
 	}
@@ -0,0 +1,739 @@
+package transform
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kyleseneker/tinybpf/diag"
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+// --- Typed BTF map definitions ---
+//
+// A typed map definition is a struct whose type name starts with "bpfMap",
+// other than the legacy all-integer bpfMapDef. Its Key and Value fields are
+// pointers to the key and value types, emitted as libbpf's
+// __type(key, K)/__type(value, V), so that the loader infers the sizes and
+// tools can print the contents. The map type comes from a Type field or from
+// a map kind type argument:
+//
+//	type bpfMap[T any, K, V any] struct {
+//		Key        *K
+//		Value      *V
+//		MaxEntries uint32
+//	}
+//
+//	var conns = bpfMap[hashMap, connKey, connVal]{MaxEntries: 1024}
+//
+// Arrays and hashes of maps take an Inner field, a typed map definition used
+// as the inner map template, and an optional Values array of pointers to the
+// initial inner maps. Both become libbpf's __array(values, ...) member, with
+// relocations to the inner map globals. A progArrayMap takes a Values array
+// of programs instead, the tail call targets of __array(values, int (void *)).
+
+// typedMapIntFields maps the integer fields of a typed map definition to
+// their libbpf names.
+var typedMapIntFields = map[string]string{
+	"Type":       "type",
+	"KeySize":    "key_size",
+	"ValueSize":  "value_size",
+	"MaxEntries": "max_entries",
+	"MapFlags":   "map_flags",
+	"Pinning":    "pinning",
+	"NumaNode":   "numa_node",
+	"MapExtra":   "map_extra",
+}
+
+// mapKinds maps the names of map kind type arguments to BPF_MAP_TYPE_* values
+// from include/uapi/linux/bpf.h.
+var mapKinds = map[string]int64{
+	"hashMap":                1,
+	"arrayMap":               2,
+	"progArrayMap":           3,
+	"perfEventArrayMap":      4,
+	"percpuHashMap":          5,
+	"percpuArrayMap":         6,
+	"stackTraceMap":          7,
+	"cgroupArrayMap":         8,
+	"lruHashMap":             9,
+	"lruPercpuHashMap":       10,
+	"lpmTrieMap":             11,
+	"arrayOfMaps":            12,
+	"hashOfMaps":             13,
+	"devMap":                 14,
+	"sockMap":                15,
+	"cpuMap":                 16,
+	"xskMap":                 17,
+	"sockHashMap":            18,
+	"cgroupStorageMap":       19,
+	"reuseportSockarrayMap":  20,
+	"percpuCgroupStorageMap": 21,
+	"queueMap":               22,
+	"stackMap":               23,
+	"skStorageMap":           24,
+	"devMapHash":             25,
+	"ringbufMap":             27,
+	"inodeStorageMap":        28,
+	"taskStorageMap":         29,
+	"bloomFilterMap":         30,
+	"userRingbufMap":         31,
+	"cgrpStorageMap":         32,
+	"arenaMap":               33,
+}
+
+// mapDefTypeName splits an IR struct type such as
+// %"main.bpfMap[main.hashMap, main.connKey, main.connVal]" into its
+// unqualified name and type arguments. ok is false unless the name starts
+// with "bpfMap".
+func mapDefTypeName(irType string) (name string, args []string, ok bool) {
+	s := strings.TrimPrefix(irType, "%")
+	s = strings.Trim(s, `"`)
+	if i := strings.IndexByte(s, '['); i >= 0 && strings.HasSuffix(s, "]") {
+		args = splitTypeArgs(s[i+1 : len(s)-1])
+		s = s[:i]
+	}
+	name = s[strings.LastIndexByte(s, '.')+1:]
+	return name, args, strings.HasPrefix(name, "bpfMap")
+}
+
+// splitTypeArgs splits a type argument list on top-level commas.
+func splitTypeArgs(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i := range len(s) {
+		switch s[i] {
+		case '[', '{', '(':
+			depth++
+		case ']', '}', ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+// isMapDefGlobal reports whether g holds a map definition, either a legacy
+// bpfMapDef or a typed one.
+func isMapDefGlobal(g *ir.Global) bool {
+	_, _, ok := mapDefTypeName(g.Type)
+	return ok
+}
+
+// isTypedMapDef reports whether a map definition of IR type irType uses the
+// typed layout rather than the legacy bpfMapDef of integer fields.
+func isTypedMapDef(m *ir.Module, irType string) bool {
+	name, args, ok := mapDefTypeName(irType)
+	if !ok {
+		return false
+	}
+	if name != "bpfMapDef" || len(args) > 0 {
+		return true
+	}
+	for _, td := range m.TypeDefs {
+		if td.Name == irType {
+			for _, f := range td.Fields {
+				if f != "i32" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// typedMapMember is one member of the BTF map definition emitted for a
+// typed map.
+type typedMapMember struct {
+	cName  string
+	value  int64  // integer members
+	ptrRef string // key and value: the DI pointer type, e.g. "!33"
+
+	// values of a map-in-map or program array: the inner map template and
+	// the globals of the initial inner maps or the programs, "" for empty
+	// slots.
+	inner    []typedMapMember
+	slots    []string
+	programs bool
+}
+
+// typedMapDef is a typed map definition global and the BTF members to emit.
+type typedMapDef struct {
+	entryIdx int
+	name     string
+	varMeta  int // DIGlobalVariable metadata ID
+	typeRef  string
+	members  []typedMapMember
+}
+
+// rewriteTypedMapsModule replaces each typed map definition with a
+// libbpf-style BTF map definition of its own, pointing the map's debug info
+// at a struct of pointer members.
+func rewriteTypedMapsModule(m *ir.Module) error {
+	metaByID := make(map[int]*ir.MetadataNode, len(m.MetadataNodes))
+	for _, mn := range m.MetadataNodes {
+		metaByID[mn.ID] = mn
+	}
+	mapGlobals := make(map[string]bool)
+	for _, e := range m.Entries {
+		if !e.Removed && e.Kind == ir.TopGlobal && e.Global != nil && isMapDefGlobal(e.Global) {
+			mapGlobals["@"+e.Global.Name] = true
+		}
+	}
+	funcs := make(map[string]bool, len(m.Functions))
+	for _, fn := range m.Functions {
+		if !fn.Removed {
+			funcs["@"+fn.Name] = true
+		}
+	}
+
+	var defs []typedMapDef
+	var errs []error
+	for i, e := range m.Entries {
+		if e.Removed || e.Kind != ir.TopGlobal || e.Global == nil || !isTypedMapDef(m, e.Global.Type) {
+			continue
+		}
+		def, err := parseTypedMapDef(e.Global, metaByID, mapGlobals, funcs)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		def.entryIdx = i
+		defs = append(defs, def)
+	}
+	if err := diag.WrapErrors(diag.StageTransform, "map-btf", errs,
+		"typed map definitions need Key/Value pointer fields, integer fields named like Type or MaxEntries, and a map kind such as bpfMap[hashMap, K, V]"); err != nil {
+		return err
+	}
+	if len(defs) == 0 {
+		return nil
+	}
+
+	e := &typedMapEmitter{m: m, nextID: findMaxMetaIDFromModule(m) + 1}
+	e.intTypeID = e.emit("!DIBasicType(name: \"int\", size: 32, encoding: DW_ATE_signed)")
+	for _, def := range defs {
+		structID := e.emitStruct(def.members)
+		retypeGlobalVariableMeta(m, def.varMeta, def.typeRef, structID)
+		rewriteTypedMapGlobal(&m.Entries[def.entryIdx], def.members)
+	}
+	return nil
+}
+
+// typedMapEmitter appends the debug info of BTF map definitions to a module.
+type typedMapEmitter struct {
+	m         *ir.Module
+	nextID    int
+	intTypeID int
+}
+
+// emit appends the metadata node body under a fresh ID and returns the ID.
+func (e *typedMapEmitter) emit(body string) int {
+	id := e.nextID
+	e.nextID++
+	appendMetaEntryToModule(e.m, fmt.Sprintf("!%d = %s", id, body))
+	return id
+}
+
+// emitStruct emits a struct of 64-bit pointer members and returns its ID.
+// Integers are encoded as pointers to int arrays of that length, and values
+// as an array of pointers to the inner map template or to int (void *).
+func (e *typedMapEmitter) emitStruct(members []typedMapMember) int {
+	memberIDs := make([]string, len(members))
+	for i, mem := range members {
+		base, size := mem.ptrRef, 64
+		switch {
+		case mem.cName == "values":
+			ptrID := e.emit(fmt.Sprintf("!DIDerivedType(tag: DW_TAG_pointer_type, baseType: !%d, size: 64)",
+				e.emitValuesTarget(mem)))
+			subrangeID := e.emit(fmt.Sprintf("!DISubrange(count: %d)", len(mem.slots)))
+			size = len(mem.slots) * 64
+			base = fmt.Sprintf("!%d", e.emit(fmt.Sprintf(
+				"!DICompositeType(tag: DW_TAG_array_type, baseType: !%d, size: %d, elements: !{!%d})",
+				ptrID, size, subrangeID)))
+		case base == "":
+			subrangeID := e.emit(fmt.Sprintf("!DISubrange(count: %d)", mem.value))
+			arrayID := e.emit(fmt.Sprintf("!DICompositeType(tag: DW_TAG_array_type, baseType: !%d, elements: !{!%d})",
+				e.intTypeID, subrangeID))
+			base = fmt.Sprintf("!%d", e.emit(fmt.Sprintf(
+				"!DIDerivedType(tag: DW_TAG_pointer_type, baseType: !%d, size: 64)", arrayID)))
+		}
+		memberIDs[i] = fmt.Sprintf("!%d", e.emit(fmt.Sprintf(
+			"!DIDerivedType(tag: DW_TAG_member, name: \"%s\", baseType: %s, size: %d, offset: %d)",
+			mem.cName, base, size, i*64)))
+	}
+	return e.emit(fmt.Sprintf("!DICompositeType(tag: DW_TAG_structure_type, size: %d, align: 64, elements: !{%s})",
+		typedMapSlots(members)*64, strings.Join(memberIDs, ", ")))
+}
+
+// emitValuesTarget emits the type the values of mem point to: the inner map
+// template, or the int (void *) prototype of a program.
+func (e *typedMapEmitter) emitValuesTarget(mem typedMapMember) int {
+	if !mem.programs {
+		return e.emitStruct(mem.inner)
+	}
+	voidPtrID := e.emit("!DIDerivedType(tag: DW_TAG_pointer_type, baseType: null, size: 64)")
+	return e.emit(fmt.Sprintf("!DISubroutineType(types: !{!%d, !%d})", e.intTypeID, voidPtrID))
+}
+
+// typedMapSlots returns the number of pointer-sized slots in a map definition.
+func typedMapSlots(members []typedMapMember) int {
+	n := len(members)
+	if last := len(members) - 1; last >= 0 && members[last].cName == "values" {
+		n += len(members[last].slots) - 1
+	}
+	return n
+}
+
+// parseTypedMapDef reads the fields of the typed map definition global g
+// from its debug info and initializer.
+func parseTypedMapDef(g *ir.Global, metaByID map[int]*ir.MetadataNode, mapGlobals, funcs map[string]bool) (typedMapDef, error) {
+	def := typedMapDef{name: g.Name}
+	varNode := globalVariableMeta(g, metaByID)
+	if varNode == nil {
+		return def, fmt.Errorf("map %s: typed map definitions need debug info; do not build with -no-debug", g.Name)
+	}
+	def.varMeta = varNode.ID
+	def.typeRef = varNode.Fields["type"]
+
+	p := &typedMapParser{metaByID: metaByID, mapGlobals: mapGlobals, funcs: funcs}
+	members, err := p.parse(g.Type, g.Initializer, def.typeRef)
+	if err != nil {
+		return def, fmt.Errorf("map %s: %w", g.Name, err)
+	}
+	def.members = members
+	return def, nil
+}
+
+// typedMapParser collects the BTF members of one typed map definition.
+type typedMapParser struct {
+	metaByID   map[int]*ir.MetadataNode
+	mapGlobals map[string]bool
+	funcs      map[string]bool
+	nested     bool // parsing an inner map template
+
+	mapType           int64
+	hasType           bool
+	hasKey, hasValue  bool
+	members           []typedMapMember
+	inner             *typedMapMember
+	innerRef, slotRef string // DI types of Inner and of the Values elements
+}
+
+// parse returns the BTF members of a map definition of IR type irType with
+// initializer init and DI type typeRef, the "type" member first and
+// "values" last.
+func (p *typedMapParser) parse(irType, init, typeRef string) ([]typedMapMember, error) {
+	fields := structMembers(typeRef, p.metaByID)
+	elems, err := splitInitializer(init, len(fields))
+	if err != nil {
+		return nil, err
+	}
+	if p.mapType, p.hasType, err = mapKindFromType(irType); err != nil {
+		return nil, err
+	}
+	for i, field := range fields {
+		if err := p.addField(field, elems[i]); err != nil {
+			return nil, err
+		}
+	}
+	if !p.hasType {
+		return nil, errors.New("no map type; add a Type field or a map kind type argument such as hashMap")
+	}
+	return p.finish()
+}
+
+// addField records the DI member field with initializer element elem.
+func (p *typedMapParser) addField(field *ir.MetadataNode, elem string) error {
+	name := field.Fields["name"]
+	switch {
+	case name == "Key" || name == "Value":
+		return p.addKeyValue(name, field.Fields["baseType"], elem)
+	case name == "Inner" || name == "Values":
+		if p.nested {
+			return fmt.Errorf("inner map template has an %s field; nested inner maps are not supported", name)
+		}
+		if name == "Inner" {
+			return p.addInner(field.Fields["baseType"], elem)
+		}
+		return p.addValues(field.Fields["baseType"], elem)
+	case name == "Type":
+		v, err := initializerInt(elem)
+		if err != nil {
+			return fmt.Errorf("field Type: %w", err)
+		}
+		if p.hasType && v != 0 && v != p.mapType {
+			return fmt.Errorf("field Type %d conflicts with the map kind type argument (%d)", v, p.mapType)
+		}
+		if !p.hasType {
+			p.mapType, p.hasType = v, true
+		}
+	case typedMapIntFields[name] != "":
+		v, err := initializerInt(elem)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
+		p.members = append(p.members, typedMapMember{cName: typedMapIntFields[name], value: v})
+	default:
+		return fmt.Errorf("unknown map definition field %q", name)
+	}
+	return nil
+}
+
+// addKeyValue records a Key or Value pointer field, skipping zero-sized types.
+func (p *typedMapParser) addKeyValue(name, ref, elem string) error {
+	if v, err := initializerInt(elem); err != nil || v != 0 {
+		return fmt.Errorf("field %s must be nil", name)
+	}
+	ptr := p.metaByID[parseMetaID(ref)]
+	if ptr == nil || ptr.Fields["tag"] != "DW_TAG_pointer_type" {
+		return fmt.Errorf("field %s must be a pointer to the %s type", name, strings.ToLower(name))
+	}
+	if isZeroSized(ptr.Fields["baseType"], p.metaByID) {
+		return nil
+	}
+	p.hasKey = p.hasKey || name == "Key"
+	p.hasValue = p.hasValue || name == "Value"
+	p.members = append(p.members, typedMapMember{cName: strings.ToLower(name), ptrRef: ref})
+	return nil
+}
+
+// addInner records the inner map template of a map-in-map from the Inner
+// field, itself a typed map definition.
+func (p *typedMapParser) addInner(ref, elem string) error {
+	typ, init := splitTypedValue(elem)
+	if _, _, ok := mapDefTypeName(typ); !ok {
+		return fmt.Errorf("field Inner must be a typed map definition, not %s", typ)
+	}
+	inner := &typedMapParser{metaByID: p.metaByID, nested: true}
+	members, err := inner.parse(typ, init, ref)
+	if err != nil {
+		return fmt.Errorf("inner map template: %w", err)
+	}
+	p.innerRef = ref
+	if p.inner == nil {
+		p.inner = &typedMapMember{cName: "values"}
+	}
+	p.inner.inner = members
+	return nil
+}
+
+// addValues records the Values array: pointers to the initial inner maps of
+// a map-in-map, or the programs of a program array. The slots are checked
+// against the map type in finish.
+func (p *typedMapParser) addValues(ref, elem string) error {
+	arr := resolveTypedef(ref, p.metaByID)
+	if arr == nil || arr.Fields["tag"] != "DW_TAG_array_type" {
+		return errors.New("field Values must be an array of inner maps or programs")
+	}
+	slots, err := initializerRefs(elem)
+	if err != nil {
+		return fmt.Errorf("field Values: %w", err)
+	}
+	p.slotRef = arr.Fields["baseType"]
+	if p.inner == nil {
+		p.inner = &typedMapMember{cName: "values"}
+	}
+	p.inner.slots = slots
+	return nil
+}
+
+// finish validates the collected fields and returns the members in order.
+func (p *typedMapParser) finish() ([]typedMapMember, error) {
+	members := []typedMapMember{{cName: "type", value: p.mapType}}
+	for _, mem := range p.members {
+		if (mem.cName == "key_size" && p.hasKey) || (mem.cName == "value_size" && p.hasValue) {
+			if mem.value != 0 {
+				return nil, fmt.Errorf("%s is inferred from the %s type; remove it", mem.cName, strings.TrimSuffix(mem.cName, "_size"))
+			}
+			continue
+		}
+		members = append(members, mem)
+	}
+	if p.inner == nil {
+		return members, nil
+	}
+	if p.mapType == mapKinds["progArrayMap"] {
+		return p.finishProgArray(members)
+	}
+	switch {
+	case p.mapType != mapKinds["arrayOfMaps"] && p.mapType != mapKinds["hashOfMaps"] && p.innerRef == "":
+		return nil, errors.New("only arrayOfMaps, hashOfMaps and progArrayMap maps take a Values field")
+	case p.mapType != mapKinds["arrayOfMaps"] && p.mapType != mapKinds["hashOfMaps"]:
+		return nil, errors.New("only arrayOfMaps and hashOfMaps maps take an Inner map template")
+	case p.innerRef == "":
+		return nil, errors.New("field Values needs an Inner field with the inner map template")
+	case p.hasValue:
+		return nil, errors.New("field Value is not allowed with Inner; the map stores references to inner maps")
+	}
+	if p.slotRef != "" {
+		ptr := resolveTypedef(p.slotRef, p.metaByID)
+		if ptr == nil || ptr.Fields["tag"] != "DW_TAG_pointer_type" ||
+			resolveTypedef(ptr.Fields["baseType"], p.metaByID) != resolveTypedef(p.innerRef, p.metaByID) {
+			return nil, errors.New("field Values must hold pointers to the Inner type")
+		}
+	}
+	for _, s := range p.inner.slots {
+		if s != "" && !p.mapGlobals[s] {
+			return nil, fmt.Errorf("field Values refers to %s, which is not a map", s)
+		}
+	}
+	return append(members, *p.inner), nil
+}
+
+// finishProgArray validates the Values of a program array, whose slots are
+// the tail call targets.
+func (p *typedMapParser) finishProgArray(members []typedMapMember) ([]typedMapMember, error) {
+	switch {
+	case p.innerRef != "":
+		return nil, errors.New("field Inner is not allowed in a progArrayMap; its Values are programs")
+	case p.hasValue:
+		return nil, errors.New("field Value is not allowed with Values; the map stores references to programs")
+	}
+	for _, s := range p.inner.slots {
+		if s != "" && !p.funcs[s] {
+			return nil, fmt.Errorf("field Values refers to %s, which is not a program", s)
+		}
+	}
+	p.inner.programs = true
+	return append(members, *p.inner), nil
+}
+
+// mapKindFromType returns the BPF map type named by the first type argument
+// of a generic map definition type, if any.
+func mapKindFromType(irType string) (mapType int64, ok bool, err error) {
+	_, args, _ := mapDefTypeName(irType)
+	if len(args) == 0 {
+		return 0, false, nil
+	}
+	kind := args[0][strings.LastIndexByte(args[0], '.')+1:]
+	if t, ok := mapKinds[kind]; ok {
+		return t, true, nil
+	}
+	if len(args) < 3 {
+		return 0, false, nil
+	}
+	if suggestion := closestName(kind, mapKinds); suggestion != "" {
+		return 0, false, fmt.Errorf("unknown map kind %q (did you mean %q?)", kind, suggestion)
+	}
+	return 0, false, fmt.Errorf("unknown map kind %q", kind)
+}
+
+// globalVariableMeta returns the DIGlobalVariable attached to g.
+func globalVariableMeta(g *ir.Global, metaByID map[int]*ir.MetadataNode) *ir.MetadataNode {
+	for _, ma := range g.Metadata {
+		if ma.Key != "dbg" {
+			continue
+		}
+		expr := metaByID[parseMetaID(ma.Value)]
+		if expr == nil {
+			return nil
+		}
+		v := metaByID[parseMetaID(expr.Fields["var"])]
+		if v == nil || v.Kind != "DIGlobalVariable" {
+			return nil
+		}
+		return v
+	}
+	return nil
+}
+
+// resolveTypedef follows typedefs from the DI type ref to the type behind them.
+func resolveTypedef(ref string, metaByID map[int]*ir.MetadataNode) *ir.MetadataNode {
+	node := metaByID[parseMetaID(ref)]
+	for node != nil && node.Kind == "DIDerivedType" && node.Fields["tag"] == "DW_TAG_typedef" {
+		node = metaByID[parseMetaID(node.Fields["baseType"])]
+	}
+	return node
+}
+
+// structMembers returns the DW_TAG_member nodes of the struct type ref.
+func structMembers(ref string, metaByID map[int]*ir.MetadataNode) []*ir.MetadataNode {
+	st := resolveTypedef(ref, metaByID)
+	if st == nil || st.Kind != "DICompositeType" {
+		return nil
+	}
+	var members []*ir.MetadataNode
+	for _, id := range resolveMetaRefsFromAST(st.Fields["elements"], metaByID) {
+		if mem := metaByID[id]; mem != nil && mem.Fields["tag"] == "DW_TAG_member" {
+			members = append(members, mem)
+		}
+	}
+	return members
+}
+
+// isZeroSized reports whether the DI type ref has no size, like struct{}.
+func isZeroSized(ref string, metaByID map[int]*ir.MetadataNode) bool {
+	node := resolveTypedef(ref, metaByID)
+	if node == nil || node.Kind != "DICompositeType" {
+		return false
+	}
+	size := node.Fields["size"]
+	return size == "" || size == "0"
+}
+
+// splitInitializer returns the n elements of a struct initializer such as
+// "{ ptr null, i32 1024 }", each with its type, or n empty elements for
+// zeroinitializer.
+func splitInitializer(init string, n int) ([]string, error) {
+	if init == "zeroinitializer" {
+		return make([]string, n), nil
+	}
+	body := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(init, "{"), "}"))
+	if body == "" {
+		return nil, fmt.Errorf("initializer %q does not match the %d fields in its debug info", init, n)
+	}
+	elems := splitTypeArgs(body)
+	if len(elems) != n {
+		return nil, fmt.Errorf("initializer %q does not match the %d fields in its debug info", init, n)
+	}
+	return elems, nil
+}
+
+// splitTypedValue splits an initializer element such as "i32 7" or
+// `%"main.bpfMap[...]" { ptr null }` into its type and value.
+func splitTypedValue(elem string) (typ, val string) {
+	end := 0
+	switch {
+	case strings.HasPrefix(elem, `%"`):
+		end = strings.IndexByte(elem[2:], '"') + 3
+	case strings.HasPrefix(elem, "["), strings.HasPrefix(elem, "{"):
+		depth := 0
+		for end < len(elem) {
+			switch elem[end] {
+			case '[', '{':
+				depth++
+			case ']', '}':
+				depth--
+			}
+			end++
+			if depth == 0 {
+				break
+			}
+		}
+	default:
+		end = strings.IndexByte(elem, ' ')
+	}
+	if end <= 0 || end > len(elem) {
+		return elem, ""
+	}
+	return elem[:end], strings.TrimSpace(elem[end:])
+}
+
+// initializerInt returns the value of an integer or null pointer element;
+// an empty element is zero.
+func initializerInt(elem string) (int64, error) {
+	if elem == "" {
+		return 0, nil
+	}
+	typ, val := splitTypedValue(elem)
+	switch {
+	case typ == "ptr" && val == "null":
+		return 0, nil
+	case strings.HasPrefix(typ, "i"):
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unsupported value %q", elem)
+		}
+		return v, nil
+	}
+	return 0, fmt.Errorf("unsupported value %q", elem)
+}
+
+// initializerRefs returns the globals referenced by an array of pointers
+// such as "[2 x ptr] [ptr @a, ptr null]", or of Go func values such as
+// "[1 x { ptr, ptr }] [{ ptr, ptr } { ptr undef, ptr @f }]", "" for nil
+// elements.
+func initializerRefs(elem string) ([]string, error) {
+	typ, val := splitTypedValue(elem)
+	var n int
+	if _, err := fmt.Sscanf(typ, "[%d x ", &n); err != nil ||
+		(!strings.HasSuffix(typ, " x ptr]") && !strings.HasSuffix(typ, " x { ptr, ptr }]")) {
+		return nil, fmt.Errorf("unsupported value %q", elem)
+	}
+	refs := make([]string, n)
+	if val == "zeroinitializer" || val == "undef" || n == 0 {
+		return refs, nil
+	}
+	parts := splitTypeArgs(strings.TrimSuffix(strings.TrimPrefix(val, "["), "]"))
+	if len(parts) != n {
+		return nil, fmt.Errorf("unsupported value %q", elem)
+	}
+	for i, part := range parts {
+		ref, ok := initializerRef(part)
+		if !ok {
+			return nil, fmt.Errorf("unsupported value %q", part)
+		}
+		refs[i] = ref
+	}
+	return refs, nil
+}
+
+// initializerRef returns the global referenced by a pointer element such as
+// "ptr @a", or by the function pointer of a func value element such as
+// "{ ptr, ptr } { ptr undef, ptr @f }"; ok is false for other values.
+func initializerRef(elem string) (ref string, ok bool) {
+	typ, val := splitTypedValue(elem)
+	switch {
+	case val == "null" || val == "zeroinitializer" || val == "undef":
+		return "", true
+	case typ == "ptr" && strings.HasPrefix(val, "@"):
+		return val, true
+	case typ == "{ ptr, ptr }":
+		fields := splitTypeArgs(strings.TrimSuffix(strings.TrimPrefix(val, "{"), "}"))
+		if len(fields) == 2 {
+			return initializerRef(fields[1])
+		}
+	}
+	return "", false
+}
+
+// retypeGlobalVariableMeta points the DIGlobalVariable varID, of type
+// oldRef, at the struct typeID.
+func retypeGlobalVariableMeta(m *ir.Module, varID int, oldRef string, typeID int) {
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		if e.Removed || e.Kind != ir.TopMetadata || e.Metadata == nil || e.Metadata.ID != varID {
+			continue
+		}
+		for _, end := range []string{",", ")"} {
+			if strings.Contains(e.Raw, "type: "+oldRef+end) {
+				e.Raw = strings.Replace(e.Raw, "type: "+oldRef+end, fmt.Sprintf("type: !%d%s", typeID, end), 1)
+				return
+			}
+		}
+		return
+	}
+}
+
+// rewriteTypedMapGlobal replaces a typed map global's type and initializer
+// with pointer fields, zero except for the initial inner maps of a
+// map-in-map.
+func rewriteTypedMapGlobal(e *ir.TopLevelEntry, members []typedMapMember) {
+	g := e.Global
+	ptrFields := strings.Repeat("ptr, ", len(members))
+	typ := "{ " + strings.TrimSuffix(ptrFields, ", ") + " }"
+	init := "zeroinitializer"
+	if last := members[len(members)-1]; last.cName == "values" {
+		ptrFields = strings.Repeat("ptr, ", len(members)-1)
+		array := fmt.Sprintf("[%d x ptr]", len(last.slots))
+		typ = "{ " + ptrFields + array + " }"
+		if slices.ContainsFunc(last.slots, func(s string) bool { return s != "" }) {
+			refs := make([]string, len(last.slots))
+			for i, s := range last.slots {
+				refs[i] = "ptr " + cmp.Or(s, "null")
+			}
+			init = "{ " + strings.Repeat("ptr null, ", len(members)-1) + array + " [" + strings.Join(refs, ", ") + "] }"
+		}
+	}
+	g.Type = typ
+	g.Initializer = init
+	g.Align = 8
+	e.Raw = ir.SerializeGlobal(g)
+}
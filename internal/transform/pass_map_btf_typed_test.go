@@ -0,0 +1,405 @@
+package transform
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+func TestMapDefTypeName(t *testing.T) {
+	tests := []struct {
+		irType   string
+		wantName string
+		wantArgs []string
+		wantOK   bool
+	}{
+		{"%main.bpfMapDef", "bpfMapDef", nil, true},
+		{`%"main.bpfMap[main.hashMap, main.connKey, uint64]"`, "bpfMap", []string{"main.hashMap", "main.connKey", "uint64"}, true},
+		{`%"main.bpfMap[main.arrayMap,[16]uint8,main.val]"`, "bpfMap", []string{"main.arrayMap", "[16]uint8", "main.val"}, true},
+		{"%main.bpfMapConns", "bpfMapConns", nil, true},
+		{"%main.connKey", "connKey", nil, false},
+		{"i32", "i32", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.irType, func(t *testing.T) {
+			name, args, ok := mapDefTypeName(tt.irType)
+			if name != tt.wantName || !slices.Equal(args, tt.wantArgs) || ok != tt.wantOK {
+				t.Errorf("mapDefTypeName(%q) = %q, %q, %v; want %q, %q, %v",
+					tt.irType, name, args, ok, tt.wantName, tt.wantArgs, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSplitInitializer(t *testing.T) {
+	tests := []struct {
+		name    string
+		init    string
+		n       int
+		want    []string
+		wantErr string
+	}{
+		{"zeroinitializer", "zeroinitializer", 2, []string{"", ""}, ""},
+		{"flat", "{ ptr null, i32 1024 }", 2, []string{"ptr null", "i32 1024"}, ""},
+		{
+			"nested", `{ i32 13, %"main.bpfMap[main.hashMap, uint32, uint64]" { ptr null, i32 8 }, [2 x ptr] [ptr @a, ptr null] }`, 3,
+			[]string{"i32 13", `%"main.bpfMap[main.hashMap, uint32, uint64]" { ptr null, i32 8 }`, "[2 x ptr] [ptr @a, ptr null]"}, "",
+		},
+		{"field count mismatch", "{ i32 1, i32 2 }", 3, nil, "does not match the 3 fields"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitInitializer(tt.init, tt.n)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitializerValues(t *testing.T) {
+	ints := []struct {
+		elem    string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"ptr null", 0, false},
+		{"i32 1024", 1024, false},
+		{"i64 -1", -1, false},
+		{"ptr @other", 0, true},
+		{"i32 x", 0, true},
+	}
+	for _, tt := range ints {
+		got, err := initializerInt(tt.elem)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("initializerInt(%q) = %d, %v; want %d, error %v", tt.elem, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	refs := []struct {
+		elem    string
+		want    []string
+		wantErr bool
+	}{
+		{"[2 x ptr] [ptr @main.a, ptr null]", []string{"@main.a", ""}, false},
+		{"[3 x ptr] zeroinitializer", []string{"", "", ""}, false},
+		{"[0 x ptr] zeroinitializer", []string{}, false},
+		{"[2 x { ptr, ptr }] [{ ptr, ptr } { ptr undef, ptr @stage }, { ptr, ptr } zeroinitializer]", []string{"@stage", ""}, false},
+		{"[1 x { ptr, ptr }] [{ ptr, ptr } { ptr null, i32 1 }]", nil, true},
+		{"[1 x i32] [i32 1]", nil, true},
+		{"[2 x ptr] [ptr @main.a]", nil, true},
+	}
+	for _, tt := range refs {
+		got, err := initializerRefs(tt.elem)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("initializerRefs(%q) = %q, %v; want %q, error %v", tt.elem, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// typedMapIR returns a module defining the map conns with the given IR type,
+// initializer and DI members (!6 onwards, listed in !5). !10 and !15 are
+// pointers to an 8-byte key and a zero-sized struct, !14 is uint32.
+func typedMapIR(irType, init, members string, memberRefs string) string {
+	return `%` + strings.TrimPrefix(irType, "%") + ` = type { ptr, ptr, i32 }
+
+@main.conns = internal global ` + irType + ` ` + init + `, align 8, !dbg !0
+
+!0 = !DIGlobalVariableExpression(var: !1, expr: !DIExpression())
+!1 = distinct !DIGlobalVariable(name: "main.conns", linkageName: "main.conns", scope: !2, file: !2, line: 24, type: !3, isLocal: false, isDefinition: true, align: 64)
+!2 = !DIFile(filename: "conns.go", directory: "/src")
+!3 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.conns", baseType: !4)
+!4 = !DICompositeType(tag: DW_TAG_structure_type, size: 160, align: 64, elements: !5)
+!5 = !{` + memberRefs + `}
+` + members + `
+!10 = !DIDerivedType(tag: DW_TAG_pointer_type, name: "*main.connKey", baseType: !11, size: 64, align: 64, dwarfAddressSpace: 0)
+!11 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.connKey", baseType: !12)
+!12 = !DICompositeType(tag: DW_TAG_structure_type, size: 64, align: 32, elements: !13)
+!13 = !{}
+!14 = !DIBasicType(name: "uint32", size: 32, encoding: DW_ATE_unsigned)
+!15 = !DIDerivedType(tag: DW_TAG_pointer_type, name: "*struct{}", baseType: !16, size: 64, align: 64, dwarfAddressSpace: 0)
+!16 = !DICompositeType(tag: DW_TAG_structure_type, align: 8, elements: !13)`
+}
+
+const (
+	keyMember   = `!6 = !DIDerivedType(tag: DW_TAG_member, name: "Key", baseType: !10, size: 64, align: 64)`
+	valueMember = `!7 = !DIDerivedType(tag: DW_TAG_member, name: "Value", baseType: !15, size: 64, align: 64, offset: 64)`
+	maxMember   = `!8 = !DIDerivedType(tag: DW_TAG_member, name: "MaxEntries", baseType: !14, size: 32, align: 32, offset: 128)`
+)
+
+func TestRewriteTypedMapsModule(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		contains []string
+		absent   []string
+		wantErr  string
+	}{
+		{
+			name: "generic definition",
+			input: typedMapIR(`%"main.bpfMap[main.hashMap, main.connKey, struct{}]"`,
+				"{ ptr null, ptr null, i32 1024 }",
+				keyMember+"\n"+valueMember+"\n"+maxMember, "!6, !7, !8"),
+			contains: []string{
+				`@main.conns = internal global { ptr, ptr, ptr } zeroinitializer, align 8, !dbg !0`,
+				`type: !27, isLocal: false`,
+				`!18 = !DISubrange(count: 1)`,
+				`!21 = !DIDerivedType(tag: DW_TAG_member, name: "type", baseType: !20, size: 64, offset: 0)`,
+				`!22 = !DIDerivedType(tag: DW_TAG_member, name: "key", baseType: !10, size: 64, offset: 64)`,
+				`!23 = !DISubrange(count: 1024)`,
+				`name: "max_entries", baseType: !25, size: 64, offset: 128)`,
+				`!27 = !DICompositeType(tag: DW_TAG_structure_type, size: 192, align: 64, elements: !{!21, !22, !26})`,
+			},
+			absent: []string{`name: "value"`},
+		},
+		{
+			name: "Type field",
+			input: typedMapIR("%main.bpfMapConns", "{ i32 9, ptr null, i32 64 }",
+				`!6 = !DIDerivedType(tag: DW_TAG_member, name: "Type", baseType: !14, size: 32, align: 32)`+"\n"+
+					`!7 = !DIDerivedType(tag: DW_TAG_member, name: "Key", baseType: !10, size: 64, align: 64, offset: 64)`+"\n"+
+					maxMember, "!6, !7, !8"),
+			contains: []string{
+				`!18 = !DISubrange(count: 9)`,
+				`name: "key", baseType: !10`,
+				`!23 = !DISubrange(count: 64)`,
+			},
+		},
+		{
+			name: "user ring buffer",
+			input: strings.Replace(typedMapIR(`%"main.bpfMap[main.userRingbufMap, main.connKey, struct{}]"`,
+				"zeroinitializer", keyMember, "!6"), "align 8", "align 4", 1),
+			contains: []string{
+				`@main.conns = internal global { ptr, ptr } zeroinitializer, align 8, !dbg !0`,
+				`!18 = !DISubrange(count: 31)`,
+			},
+		},
+		{
+			name: "unknown map kind",
+			input: typedMapIR(`%"main.bpfMap[main.hashMapp, main.connKey, struct{}]"`, "zeroinitializer",
+				keyMember, "!6"),
+			wantErr: `unknown map kind "hashMapp" (did you mean "hashMap"?)`,
+		},
+		{
+			name:    "no map type",
+			input:   typedMapIR("%main.bpfMapConns", "zeroinitializer", keyMember, "!6"),
+			wantErr: "no map type",
+		},
+		{
+			name: "key size with key type",
+			input: typedMapIR(`%"main.bpfMap[main.hashMap, main.connKey, struct{}]"`, "{ ptr null, i32 8 }",
+				keyMember+"\n"+`!7 = !DIDerivedType(tag: DW_TAG_member, name: "KeySize", baseType: !14, size: 32, align: 32, offset: 64)`,
+				"!6, !7"),
+			wantErr: "key_size is inferred from the key type",
+		},
+		{
+			name: "unknown field",
+			input: typedMapIR(`%"main.bpfMap[main.hashMap, main.connKey, struct{}]"`, "zeroinitializer",
+				`!6 = !DIDerivedType(tag: DW_TAG_member, name: "Entries", baseType: !14, size: 32, align: 32)`, "!6"),
+			wantErr: `unknown map definition field "Entries"`,
+		},
+		{
+			name:    "no debug info",
+			input:   "@main.conns = internal global %main.bpfMapConns zeroinitializer, align 8",
+			wantErr: "typed map definitions need debug info",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ir.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = rewriteTypedMapsModule(m)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := ir.Serialize(m)
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output missing %q\n%s", s, out)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(out, s) {
+					t.Errorf("output should not contain %q", s)
+				}
+			}
+		})
+	}
+}
+
+// mapInMapIR defines the inner map tenantA and the outer map tenants, a
+// bpfMapOfMaps with an Inner template and two Values slots. The map kind,
+// @stage is a program for the Values of a program array, and
+// the Inner and Values initializer elements and the outer DI members are
+// substituted for KIND, INNER, VALUES and MEMBERS.
+const mapInMapIR = `%inner = type { ptr, i32 }
+%"main.bpfMapOfMaps[main.KIND, uint32, main.inner]" = type { ptr, i32, %inner, [2 x ptr] }
+
+@main.other = global i32 0
+@main.tenantA = global %"main.bpfMap[main.hashMap, uint32, uint64]" { ptr null, i32 128 }, align 8, !dbg !0
+@main.tenants = global %"main.bpfMapOfMaps[main.KIND, uint32, main.inner]" { ptr null, i32 64INNERVALUES }, align 8, !dbg !20
+
+define i32 @stage(ptr %ctx) {
+entry:
+  ret i32 0
+}
+
+!0 = !DIGlobalVariableExpression(var: !1, expr: !DIExpression())
+!1 = distinct !DIGlobalVariable(name: "main.tenantA", scope: !2, file: !2, line: 10, type: !3, isLocal: false, isDefinition: true)
+!2 = !DIFile(filename: "main.go", directory: "/src")
+!3 = !DIDerivedType(tag: DW_TAG_typedef, name: "main.inner", baseType: !4)
+!4 = !DICompositeType(tag: DW_TAG_structure_type, size: 128, align: 64, elements: !5)
+!5 = !{!6, !7}
+!6 = !DIDerivedType(tag: DW_TAG_member, name: "Key", baseType: !9, size: 64, align: 64)
+!7 = !DIDerivedType(tag: DW_TAG_member, name: "MaxEntries", baseType: !14, size: 32, align: 32, offset: 64)
+!9 = !DIDerivedType(tag: DW_TAG_pointer_type, name: "*uint32", baseType: !14, size: 64, align: 64, dwarfAddressSpace: 0)
+!14 = !DIBasicType(name: "uint32", size: 32, encoding: DW_ATE_unsigned)
+!20 = !DIGlobalVariableExpression(var: !21, expr: !DIExpression())
+!21 = distinct !DIGlobalVariable(name: "main.tenants", scope: !2, file: !2, line: 12, type: !24, isLocal: false, isDefinition: true)
+!24 = !DICompositeType(tag: DW_TAG_structure_type, size: 384, align: 64, elements: !26)
+!26 = !{MEMBERS}
+!27 = !DIDerivedType(tag: DW_TAG_member, name: "Key", baseType: !9, size: 64, align: 64)
+!28 = !DIDerivedType(tag: DW_TAG_member, name: "MaxEntries", baseType: !14, size: 32, align: 32, offset: 64)
+!29 = !DIDerivedType(tag: DW_TAG_member, name: "Inner", baseType: !3, size: 128, align: 64, offset: 128)
+!30 = !DIDerivedType(tag: DW_TAG_member, name: "Values", baseType: !32, size: 128, align: 64, offset: 256)
+!32 = !DICompositeType(tag: DW_TAG_array_type, baseType: !33, size: 128, align: 64, elements: !34)
+!33 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: !3, size: 64, align: 64, dwarfAddressSpace: 0)
+!34 = !{!35}
+!35 = !DISubrange(count: 2, lowerBound: 0)`
+
+// mapInMapInput substitutes the placeholders of mapInMapIR.
+func mapInMapInput(kind, inner, values, members string) string {
+	s := strings.NewReplacer("KIND", kind, "INNER", inner, "VALUES", values, "MEMBERS", members).Replace(mapInMapIR)
+	return strings.ReplaceAll(s, "%inner", `%"main.bpfMap[main.hashMap, uint32, uint64]"`)
+}
+
+func TestRewriteMapInMap(t *testing.T) {
+	const (
+		inner   = `, %inner { ptr null, i32 128 }`
+		values  = `, [2 x ptr] [ptr @main.tenantA, ptr null]`
+		members = "!27, !28, !29, !30"
+	)
+	tests := []struct {
+		name     string
+		kind     string
+		inner    string
+		values   string
+		members  string
+		contains []string
+		wantErr  string
+	}{
+		{
+			name: "initial inner maps", kind: "hashOfMaps", inner: inner, values: values, members: members,
+			contains: []string{
+				`@main.tenants = global { ptr, ptr, ptr, [2 x ptr] } { ptr null, ptr null, ptr null, [2 x ptr] [ptr @main.tenantA, ptr null] }, align 8`,
+				`!47 = !DISubrange(count: 13)`,
+				`!65 = !DICompositeType(tag: DW_TAG_structure_type, size: 192, align: 64, elements: !{!59, !60, !64})`,
+				`!66 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: !65, size: 64)`,
+				`!68 = !DICompositeType(tag: DW_TAG_array_type, baseType: !66, size: 128, elements: !{!67})`,
+				`!69 = !DIDerivedType(tag: DW_TAG_member, name: "values", baseType: !68, size: 128, offset: 192)`,
+				`!70 = !DICompositeType(tag: DW_TAG_structure_type, size: 320, align: 64, elements: !{!50, !51, !55, !69})`,
+				`type: !70, isLocal: false`,
+			},
+		},
+		{
+			name: "inner template only", kind: "arrayOfMaps", inner: inner, values: ", [2 x ptr] zeroinitializer", members: members,
+			contains: []string{
+				`@main.tenants = global { ptr, ptr, ptr, [2 x ptr] } zeroinitializer, align 8`,
+				`!DISubrange(count: 12)`,
+			},
+		},
+		{
+			name: "values without inner", kind: "hashOfMaps", values: values, members: "!27, !28, !30",
+			wantErr: "field Values needs an Inner field",
+		},
+		{
+			name: "inner on a hash map", kind: "hashMap", inner: inner, values: values, members: members,
+			wantErr: "only arrayOfMaps and hashOfMaps maps take an Inner map template",
+		},
+		{
+			name: "value that is not a map", kind: "hashOfMaps", inner: inner, values: `, [2 x ptr] [ptr @main.other, ptr null]`, members: members,
+			wantErr: "field Values refers to @main.other, which is not a map",
+		},
+		{
+			name: "program array", kind: "progArrayMap", values: `, [2 x ptr] [ptr null, ptr @stage]`, members: "!27, !28, !30",
+			contains: []string{
+				`@main.tenants = global { ptr, ptr, ptr, [2 x ptr] } { ptr null, ptr null, ptr null, [2 x ptr] [ptr null, ptr @stage] }, align 8`,
+				`!56 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: null, size: 64)`,
+				`!57 = !DISubroutineType(types: !{!36, !56})`,
+				`!58 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: !57, size: 64)`,
+				`!61 = !DIDerivedType(tag: DW_TAG_member, name: "values", baseType: !60, size: 128, offset: 192)`,
+			},
+		},
+		{
+			name: "program array with a map value", kind: "progArrayMap", values: values, members: "!27, !28, !30",
+			wantErr: "field Values refers to @main.tenantA, which is not a program",
+		},
+		{
+			name: "inner on a program array", kind: "progArrayMap", inner: inner, values: values, members: members,
+			wantErr: "field Inner is not allowed in a progArrayMap",
+		},
+		{
+			name: "values on a hash map", kind: "hashMap", values: values, members: "!27, !28, !30",
+			wantErr: "only arrayOfMaps, hashOfMaps and progArrayMap maps take a Values field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ir.Parse(mapInMapInput(tt.kind, tt.inner, tt.values, tt.members))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = rewriteTypedMapsModule(m)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := ir.Serialize(m)
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output missing %q\n%s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestIsTypedMapDef(t *testing.T) {
+	m := &ir.Module{TypeDefs: []*ir.TypeDef{
+		{Name: "%main.bpfMapDef", Fields: []string{"i32", "i32", "i32", "i32", "i32"}},
+	}}
+	if isTypedMapDef(m, "%main.bpfMapDef") {
+		t.Error("legacy bpfMapDef reported as typed")
+	}
+	if !isTypedMapDef(m, `%"main.bpfMap[main.hashMap, uint32, uint64]"`) {
+		t.Error("generic bpfMap not reported as typed")
+	}
+	m.TypeDefs[0].Fields[1] = "ptr"
+	if !isTypedMapDef(m, "%main.bpfMapDef") {
+		t.Error("bpfMapDef with a pointer field not reported as typed")
+	}
+}
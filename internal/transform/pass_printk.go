@@ -0,0 +1,573 @@
+package transform
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kyleseneker/tinybpf/diag"
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+// --- bpfPrintk ---
+//
+// bpfPrintk is the counterpart of libbpf's bpf_printk macro:
+//
+//	//go:extern bpf_printk
+//	func bpfPrintk(format string, args ...any) int64
+//
+//	bpfPrintk("pid=%d comm=%s\n", pid, &comm[0])
+//
+// TinyGo passes the format as a pointer and a length, and the arguments as a
+// slice of interfaces, each a type code followed by the value packed into a
+// pointer. The call becomes bpf_trace_printk for up to three arguments and
+// bpf_trace_vprintk (Linux 5.16+), which takes the arguments as an array of
+// u64, for more.
+// The format must be a string constant; it is copied NUL-terminated into
+// .rodata, where bpf_trace_vprintk requires it. Every verb is checked against
+// the Go type of its argument, and formats that the kernel's
+// bpf_bprintf_prepare would refuse at run time are rejected at build time.
+
+const (
+	printkMaxArgs    = 12 // MAX_BPRINTF_VARARGS
+	printkInlineArgs = 3  // bpf_trace_printk's argument registers
+	printkFmtPrefix  = "__bpf_printk_fmt"
+	interfaceTypePfx = `@"reflect/types.type:`
+)
+
+// printkVerb is a conversion in a bpfPrintk format.
+type printkVerb struct {
+	spec string // e.g. "%-5lu", "%pI4"
+	conv byte   // 'd' for d, i, u, x and X; 'c', 's' or 'p'
+	long bool   // l or ll: the integer verb prints 64 bits
+}
+
+// parsePrintkFormat returns the verbs of format, accepting what the kernel's
+// bpf_bprintf_prepare accepts.
+func parsePrintkFormat(format string) ([]printkVerb, error) {
+	var verbs []printkVerb
+	at := func(i int) byte {
+		if i < len(format) {
+			return format[i]
+		}
+		return 0
+	}
+	for i := 0; i < len(format); i++ {
+		if c := format[i]; c >= 0x7f || (c < ' ' && !strings.ContainsRune("\t\n\v\f\r", rune(c))) {
+			return nil, fmt.Errorf("format contains the non-printable byte %#02x", c)
+		}
+		if format[i] != '%' {
+			continue
+		}
+		if at(i+1) == '%' {
+			i++
+			continue
+		}
+		start := i
+		i++
+		for at(i) != 0 && strings.IndexByte("0+- ", at(i)) >= 0 {
+			i++
+		}
+		for '0' <= at(i) && at(i) <= '9' {
+			i++
+		}
+		v := printkVerb{conv: 'd'}
+		switch c := at(i); {
+		case c == 'p':
+			v.conv = 'p'
+			switch next := at(i + 1); {
+			case (next == 'k' || next == 'u') && at(i+2) == 's':
+				v.conv = 's'
+				i += 2
+			case next == 'K' || next == 'x' || next == 's' || next == 'S':
+				i++
+			case (next == 'i' || next == 'I') && (at(i+2) == '4' || at(i+2) == '6'):
+				i += 2
+			case next != 0 && next != ' ' && !strings.ContainsRune("\t\n\v\f\r", rune(next)) && !isPunct(next):
+				return nil, fmt.Errorf("unsupported verb %s", format[start:i+2])
+			}
+		case c == 's' || c == 'c':
+			v.conv = c
+		default:
+			if c == 'l' {
+				v.long = true
+				i++
+				if at(i) == 'l' {
+					i++
+				}
+			}
+			if at(i) == 0 {
+				return nil, fmt.Errorf("format ends in the middle of verb %s", format[start:])
+			}
+			if strings.IndexByte("diuxX", at(i)) < 0 {
+				return nil, fmt.Errorf("unsupported verb %s", format[start:i+1])
+			}
+		}
+		v.spec = format[start : i+1]
+		verbs = append(verbs, v)
+	}
+	return verbs, nil
+}
+
+// isPunct reports whether c is ASCII punctuation.
+func isPunct(c byte) bool {
+	return c > ' ' && c < 0x7f && !('0' <= c && c <= '9') && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z')
+}
+
+// printkArg is a bpfPrintk argument: the Go type from its interface type code
+// and the value packed into a pointer.
+type printkArg struct {
+	goType string
+	kind   byte // 'i' signed integer, 'u' unsigned integer, 'p' pointer
+	bits   int
+	value  string
+}
+
+// checkPrintkArg checks that verb v can print argument i.
+func checkPrintkArg(v printkVerb, a printkArg, i int) error {
+	switch {
+	case v.conv == 'd' || v.conv == 'c':
+		if a.kind == 'p' {
+			return fmt.Errorf("verb %s needs an integer, but argument %d is %s", v.spec, i+1, a.goType)
+		}
+		if v.conv == 'd' && a.bits == 64 && !v.long {
+			long := v.spec[:len(v.spec)-1] + "l" + v.spec[len(v.spec)-1:]
+			return fmt.Errorf("verb %s prints 32 bits, but argument %d is %s; use %s", v.spec, i+1, a.goType, long)
+		}
+	case v.conv == 's':
+		if a.kind != 'p' {
+			return fmt.Errorf("verb %s needs a pointer to a NUL-terminated string, but argument %d is %s", v.spec, i+1, a.goType)
+		}
+	case a.kind != 'p' && a.goType != "uintptr":
+		return fmt.Errorf("verb %s needs a pointer, but argument %d is %s", v.spec, i+1, a.goType)
+	}
+	return nil
+}
+
+// printkModule lowers bpfPrintk calls to bpf_trace_printk and
+// bpf_trace_vprintk, and warns on w about the kernel version that
+// bpf_trace_vprintk needs.
+func printkModule(m *ir.Module, w io.Writer) error {
+	p := &printkLowering{m: m, formats: make(map[string]string)}
+	var errs []error
+	var vprintk []string
+	for _, fn := range m.Functions {
+		if fn.Removed {
+			continue
+		}
+		ir.EnsureBlocks(fn)
+		var allocas []*ir.Instruction
+		for _, block := range fn.Blocks {
+			for i := 0; i < len(block.Instructions); i++ {
+				inst := block.Instructions[i]
+				if inst.Kind != ir.InstCall || inst.Call == nil || inst.Call.Callee != "@main.bpfPrintk" {
+					continue
+				}
+				pre, alloca, err := p.lower(fn, inst)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("bpfPrintk in %s: %w", fn.Name, err))
+					continue
+				}
+				if alloca != nil {
+					allocas = append(allocas, alloca)
+					if !slices.Contains(vprintk, fn.Name) {
+						vprintk = append(vprintk, fn.Name)
+					}
+				}
+				block.Instructions = slices.Insert(block.Instructions, i, pre...)
+				i += len(pre)
+				fn.Modified = true
+			}
+		}
+		if len(allocas) > 0 {
+			fn.Blocks[0].Instructions = append(allocas, fn.Blocks[0].Instructions...)
+		}
+	}
+	if len(vprintk) > 0 && w != nil {
+		fmt.Fprintf(w, "[transform] bpfPrintk with more than %d arguments in %s uses bpf_trace_vprintk, which needs Linux 5.16 or later; "+
+			"use --variant builds to fall back on older kernels\n", printkInlineArgs, strings.Join(vprintk, ", "))
+	}
+	return diag.WrapErrors(diag.StageTransform, "printk", errs,
+		"pass a string constant format and integer or pointer arguments; the kernel accepts %d, %i, %u, %x and %X "+
+			"with an optional l or ll, %c, %s, %p, %pK, %px, %ps, %pS, %pks, %pus, %pI4, %pi4, %pI6 and %pi6")
+}
+
+// printkLowering carries the state of printkModule across calls.
+type printkLowering struct {
+	m       *ir.Module
+	formats map[string]string // format text -> .rodata global
+	next    int               // next SSA name suffix
+}
+
+// ssa returns a fresh SSA name.
+func (p *printkLowering) ssa() string {
+	p.next++
+	return fmt.Sprintf("%%__printk.%d", p.next)
+}
+
+// lower returns the instructions to insert before the bpfPrintk call inst,
+// and an alloca for the entry block, and rewrites inst into the helper call.
+func (p *printkLowering) lower(fn *ir.Function, inst *ir.Instruction) ([]*ir.Instruction, *ir.Instruction, error) {
+	params := splitTypeArgs(stripTrailingUndef(inst.Call.Args))
+	if len(params) != 5 {
+		return nil, nil, fmt.Errorf("declare it as func bpfPrintk(format string, args ...any)")
+	}
+	operand := func(i int) string { return operandValue(params[i]) }
+	format, err := p.formatString(operand(0), operand(1))
+	if err != nil {
+		return nil, nil, err
+	}
+	verbs, err := parsePrintkFormat(format)
+	if err != nil {
+		return nil, nil, fmt.Errorf("format %q: %w", format, err)
+	}
+	n, err := strconv.Atoi(operand(3))
+	if err != nil {
+		return nil, nil, fmt.Errorf("list the arguments in the call instead of passing a slice")
+	}
+	if n > printkMaxArgs {
+		return nil, nil, fmt.Errorf("%d arguments, the kernel prints at most %d", n, printkMaxArgs)
+	}
+	if len(verbs) != n {
+		return nil, nil, fmt.Errorf("format %q has %d verbs for %d arguments", format, len(verbs), n)
+	}
+	args, err := p.arguments(fn, operand(2), n)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, v := range verbs {
+		if err := checkPrintkArg(v, args[i], i); err != nil {
+			return nil, nil, fmt.Errorf("format %q: %w", format, err)
+		}
+	}
+
+	var pre []*ir.Instruction
+	add := func(line string, a ...any) {
+		pre = append(pre, &ir.Instruction{Kind: ir.InstOther, Raw: "  " + fmt.Sprintf(line, a...), Modified: true})
+	}
+	values := make([]string, n)
+	for i, a := range args {
+		if a.kind == 'p' {
+			values[i] = "ptr " + a.value
+			continue
+		}
+		wide := p.ssa()
+		if a.bits == 64 {
+			add("%s = ptrtoint ptr %s to i64", wide, a.value)
+		} else {
+			narrow, ext := p.ssa(), "zext"
+			if a.kind == 'i' {
+				ext = "sext"
+			}
+			add("%s = ptrtoint ptr %s to i%d", narrow, a.value, a.bits)
+			add("%s = %s i%d %s to i64", wide, ext, a.bits, narrow)
+		}
+		values[i] = "i64 " + wide
+	}
+
+	fmtArgs := fmt.Sprintf("ptr @%s, i32 %d", p.formatGlobal(format), len(format)+1)
+	if n <= printkInlineArgs {
+		inst.Call.Callee = fmt.Sprintf("inttoptr (i64 %d to ptr)", helperIDs["main.bpfTracePrintk"])
+		inst.Call.Args = strings.Join(append([]string{fmtArgs}, values...), ", ")
+		inst.Modified = true
+		return pre, nil, nil
+	}
+	data := p.ssa()
+	alloca := &ir.Instruction{
+		SSAName:  data,
+		Kind:     ir.InstAlloca,
+		Alloca:   &ir.AllocaInst{Type: fmt.Sprintf("[%d x i64]", n), Align: 8},
+		Modified: true,
+	}
+	for i, v := range values {
+		slot := p.ssa()
+		add("%s = getelementptr inbounds [%d x i64], ptr %s, i64 0, i64 %d", slot, n, data, i)
+		add("store %s, ptr %s, align 8", v, slot)
+	}
+	inst.Call.Callee = fmt.Sprintf("inttoptr (i64 %d to ptr)", helperIDs["main.bpfTraceVprintk"])
+	inst.Call.Args = fmt.Sprintf("%s, ptr %s, i32 %d", fmtArgs, data, 8*n)
+	inst.Modified = true
+	return pre, alloca, nil
+}
+
+// formatString returns the string constant global ref of length length.
+func (p *printkLowering) formatString(ref, length string) (string, error) {
+	n, err := strconv.Atoi(length)
+	if err == nil && strings.HasPrefix(ref, "@") {
+		for _, e := range p.m.Entries {
+			raw := strings.TrimSpace(e.Raw)
+			if e.Removed || !strings.HasPrefix(raw, ref+" = ") || !strings.Contains(raw, " constant [") {
+				continue
+			}
+			start := strings.Index(raw, `c"`)
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(raw[start+2:], '"')
+			if end < 0 {
+				break
+			}
+			if s := decodeIRString(raw[start+2 : start+2+end]); n <= len(s) {
+				return s[:n], nil
+			}
+			break
+		}
+	}
+	return "", fmt.Errorf("the format must be a string constant")
+}
+
+// formatGlobal returns the .rodata global holding format NUL-terminated,
+// adding it to the module on first use.
+func (p *printkLowering) formatGlobal(format string) string {
+	if name, ok := p.formats[format]; ok {
+		return name
+	}
+	name := printkFmtPrefix
+	if len(p.formats) > 0 {
+		name = fmt.Sprintf("%s.%d", printkFmtPrefix, len(p.formats))
+	}
+	p.formats[format] = name
+	g := &ir.Global{
+		Name:        name,
+		Linkage:     "internal constant",
+		Type:        fmt.Sprintf("[%d x i8]", len(format)+1),
+		Initializer: `c"` + encodeIRString(format+"\x00") + `"`,
+		Section:     ".rodata",
+		Align:       1,
+		Modified:    true,
+	}
+	p.m.Globals = append(p.m.Globals, g)
+	entries := []ir.TopLevelEntry{{Kind: ir.TopGlobal, Global: g}, {Kind: ir.TopBlank}}
+	if i := findFirstFuncEntry(p.m); i >= 0 {
+		p.m.Entries = slices.Insert(p.m.Entries, i, entries...)
+	} else {
+		p.m.Entries = append(p.m.Entries, entries...)
+	}
+	return name
+}
+
+// arguments finds the n interfaces TinyGo stores into the varargs array base
+// before the call, following constant getelementptrs from it.
+func (p *printkLowering) arguments(fn *ir.Function, base string, n int) ([]printkArg, error) {
+	codes, values := make([]string, n), make([]string, n)
+	defs := make(map[string]*ir.Instruction)
+	offsets := map[string]int{base: 0}
+	for _, block := range fn.Blocks {
+		for _, inst := range block.Instructions {
+			if inst.SSAName != "" {
+				defs[inst.SSAName] = inst
+			}
+			if inst.Kind == ir.InstGEP && inst.GEP != nil {
+				if off, ok := offsets[inst.GEP.Base]; ok {
+					if d, ok := gepByteOffset(inst.GEP); ok {
+						offsets[inst.SSAName] = off + d
+					}
+				}
+				continue
+			}
+			raw := strings.TrimSpace(inst.Raw)
+			if inst.Kind != ir.InstOther || !strings.HasPrefix(raw, "store ") {
+				continue
+			}
+			ops := splitTypeArgs(strings.TrimPrefix(raw, "store "))
+			if len(ops) < 2 {
+				continue
+			}
+			off, ok := offsets[operandValue(ops[1])]
+			if !ok || off/16 >= n {
+				continue
+			}
+			typ, _ := splitTypedValue(ops[0])
+			val := operandValue(ops[0])
+			switch {
+			case typ == "ptr" && off%16 == 0:
+				codes[off/16] = val
+			case typ == "ptr" && off%16 == 8:
+				values[off/16] = val
+			case isInterfaceType(typ) && off%16 == 0:
+				codes[off/16], values[off/16] = interfaceParts(val, defs)
+			}
+		}
+	}
+	args := make([]printkArg, n)
+	for i := range args {
+		if codes[i] == "" || values[i] == "" {
+			return nil, fmt.Errorf("cannot find argument %d in the variadic arguments", i+1)
+		}
+		a, err := p.argType(codes[i], 0)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		a.value = values[i]
+		args[i] = a
+	}
+	return args, nil
+}
+
+// argType classifies the Go type with the interface type code ref.
+func (p *printkLowering) argType(ref string, depth int) (printkArg, error) {
+	code, ok := strings.CutPrefix(ref, interfaceTypePfx)
+	if !ok || depth > 4 {
+		return printkArg{}, fmt.Errorf("unrecognized type code %s", ref)
+	}
+	code = strings.TrimSuffix(code, `"`)
+	name := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(code, "pointer:", "*"), "basic:", ""), "named:", "")
+	basic := strings.TrimPrefix(code, "basic:")
+	switch {
+	case strings.HasPrefix(code, "pointer:") || basic == "unsafe.Pointer" || basic == "Pointer":
+		return printkArg{goType: name, kind: 'p', bits: 64}, nil
+	case basic == "int" || basic == "uint" || basic == "uintptr":
+		return printkArg{goType: name, kind: basic[0], bits: 64}, nil
+	case strings.HasPrefix(basic, "int") || strings.HasPrefix(basic, "uint"):
+		bits, err := strconv.Atoi(strings.TrimLeft(basic, "intu"))
+		if err == nil && slices.Contains([]int{8, 16, 32, 64}, bits) {
+			return printkArg{goType: name, kind: basic[0], bits: bits}, nil
+		}
+	case strings.HasPrefix(code, "named:"):
+		// A named type's code refers to its pointer type, then its underlying type.
+		for _, e := range p.m.Entries {
+			raw := strings.TrimSpace(e.Raw)
+			if e.Removed || !strings.HasPrefix(raw, ref+" = ") {
+				continue
+			}
+			for rest := raw[len(ref):]; ; {
+				i := strings.Index(rest, interfaceTypePfx)
+				if i < 0 {
+					break
+				}
+				rest = rest[i:]
+				end := len(interfaceTypePfx) + strings.IndexByte(rest[len(interfaceTypePfx):], '"') + 1
+				if under := rest[:end]; under != interfaceTypePfx+"pointer:"+code+`"` {
+					a, err := p.argType(under, depth+1)
+					a.goType = name
+					return a, err
+				}
+				rest = rest[end:]
+			}
+		}
+	}
+	return printkArg{}, fmt.Errorf("bpfPrintk cannot print type %s; pass an integer or a pointer", name)
+}
+
+// interfaceParts returns the type code and value of the interface value val,
+// a constant or built with insertvalue.
+func interfaceParts(val string, defs map[string]*ir.Instruction) (code, value string) {
+	for range 4 {
+		if strings.HasPrefix(val, "{") {
+			fields := splitTypeArgs(strings.TrimSuffix(strings.TrimPrefix(val, "{"), "}"))
+			if len(fields) == 2 {
+				code = cmp.Or(code, operandValue(fields[0]))
+				value = cmp.Or(value, operandValue(fields[1]))
+			}
+			break
+		}
+		def := defs[val]
+		if def == nil {
+			break
+		}
+		raw := strings.TrimSpace(def.Raw)
+		_, rhs, _ := strings.Cut(raw, "= insertvalue ")
+		ops := splitTypeArgs(rhs)
+		if len(ops) != 3 {
+			break
+		}
+		switch ops[2] {
+		case "0":
+			code = cmp.Or(code, operandValue(ops[1]))
+		case "1":
+			value = cmp.Or(value, operandValue(ops[1]))
+		}
+		val = operandValue(ops[0])
+	}
+	if code == "undef" || code == "poison" {
+		code = ""
+	}
+	if value == "undef" || value == "poison" {
+		value = ""
+	}
+	return code, value
+}
+
+// operandValue returns the value of a typed operand such as
+// "ptr nonnull %x", without its parameter attributes.
+func operandValue(s string) string {
+	_, val := splitTypedValue(strings.TrimSpace(s))
+	for {
+		word, rest, ok := strings.Cut(val, " ")
+		if !ok || !slices.ContainsFunc([]string{"nonnull", "noundef", "readonly", "nocapture", "align", "dereferenceable"},
+			func(attr string) bool { return strings.HasPrefix(word, attr) }) {
+			return val
+		}
+		val = strings.TrimSpace(rest)
+		if word == "align" {
+			_, val, _ = strings.Cut(val, " ")
+		}
+	}
+}
+
+// isInterfaceType reports whether typ is TinyGo's interface type.
+func isInterfaceType(typ string) bool {
+	return typ == "%runtime._interface" || typ == "{ ptr, ptr }"
+}
+
+// gepByteOffset returns the byte offset of a getelementptr with constant
+// indices into integers, pointers, interfaces and arrays of them.
+func gepByteOffset(g *ir.GEPInst) (int, bool) {
+	typ := strings.NewReplacer("%runtime._interface", "[2 x ptr]", "{ ptr, ptr }", "[2 x ptr]").Replace(g.BaseType)
+	off := 0
+	for i, idx := range g.Indices {
+		if strings.HasPrefix(idx, "!") {
+			break
+		}
+		f := strings.Fields(idx)
+		k, err := strconv.Atoi(f[len(f)-1])
+		if err != nil {
+			return 0, false
+		}
+		if i > 0 {
+			elem, ok := strings.CutPrefix(typ, "[")
+			if _, elem, ok = strings.Cut(elem, " x "); !ok {
+				return 0, false
+			}
+			typ = strings.TrimSuffix(elem, "]")
+		}
+		size, err := irTypeSize(typ)
+		if err != nil {
+			return 0, false
+		}
+		off += k * size
+	}
+	return off, true
+}
+
+// decodeIRString decodes the body of an LLVM c"..." string.
+func decodeIRString(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// encodeIRString encodes s as the body of an LLVM c"..." string.
+func encodeIRString(s string) string {
+	var b strings.Builder
+	for i := range len(s) {
+		if c := s[i]; c >= ' ' && c < 0x7f && c != '"' && c != '\\' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "\\%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,238 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+func TestParsePrintkFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		specs   []string
+		wantErr string
+	}{
+		{format: "no verbs\n"},
+		{format: "100%% done"},
+		{format: "%d %i %u %x %X %ld %llu %c", specs: []string{"%d", "%i", "%u", "%x", "%X", "%ld", "%llu", "%c"}},
+		{format: "%-5d|%08llx|% d", specs: []string{"%-5d", "%08llx", "% d"}},
+		{format: "%s %pks %pus", specs: []string{"%s", "%pks", "%pus"}},
+		{format: "%p %pK %px %ps %pS %p.", specs: []string{"%p", "%pK", "%px", "%ps", "%pS", "%p"}},
+		{format: "%pI4 %pi6", specs: []string{"%pI4", "%pi6"}},
+		{format: "%f", wantErr: "unsupported verb %f"},
+		{format: "%lf", wantErr: "unsupported verb %lf"},
+		{format: "%pB", wantErr: "unsupported verb %pB"},
+		{format: "%pI5", wantErr: "unsupported verb %pI"},
+		{format: "tail %l", wantErr: "format ends in the middle of verb %l"},
+		{format: "bell\a", wantErr: "non-printable byte 0x07"},
+		{format: "caf\xc3\xa9", wantErr: "non-printable byte 0xc3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			verbs, err := parsePrintkFormat(tt.format)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var specs []string
+			for _, v := range verbs {
+				specs = append(specs, v.spec)
+			}
+			if strings.Join(specs, " ") != strings.Join(tt.specs, " ") {
+				t.Errorf("verbs %q, want %q", specs, tt.specs)
+			}
+		})
+	}
+}
+
+func TestPrintkModule(t *testing.T) {
+	const types = `
+%runtime._interface = type { ptr, ptr }
+
+@"reflect/types.type:basic:int8" = linkonce_odr unnamed_addr constant { i8, ptr } { i8 3, ptr null }
+@"reflect/types.type:basic:int32" = linkonce_odr unnamed_addr constant { i8, ptr } { i8 5, ptr null }
+@"reflect/types.type:basic:uint32" = linkonce_odr unnamed_addr constant { i8, ptr } { i8 10, ptr null }
+@"reflect/types.type:basic:uint64" = linkonce_odr unnamed_addr constant { i8, ptr } { i8 11, ptr null }
+@"reflect/types.type:basic:string" = linkonce_odr unnamed_addr constant { i8, ptr } { i8 17, ptr null }
+@"reflect/types.type:pointer:basic:uint8" = linkonce_odr unnamed_addr constant { i8, i16, ptr } { i8 21, i16 0, ptr null }
+@"reflect/types.type:named:main.pid" = linkonce_odr unnamed_addr constant { i8, i16, ptr, ptr } { i8 -54, i16 0, ptr @"reflect/types.type:pointer:named:main.pid", ptr @"reflect/types.type:basic:uint32" }
+`
+	// call returns a function that calls bpfPrintk with format and the
+	// interface values args.
+	call := func(format string, args ...string) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "@\"main$string\" = internal unnamed_addr constant [%d x i8] c\"%s\", align 1\n", len(format), encodeIRString(format))
+		b.WriteString(types)
+		fmt.Fprintf(&b, "\ndefine i32 @prog(ptr %%ctx) {\nentry:\n  %%varargs = alloca [%d x i8], align 4\n", 16*len(args))
+		for i, a := range args {
+			fmt.Fprintf(&b, "  %%a%d = getelementptr inbounds [%d x %%runtime._interface], ptr %%varargs, i64 0, i64 %d\n", i, len(args), i)
+			fmt.Fprintf(&b, "  store %%runtime._interface %s, ptr %%a%d, align 8\n", a, i)
+		}
+		fmt.Fprintf(&b, "  %%r = call i64 @main.bpfPrintk(ptr nonnull @\"main$string\", i64 %d, ptr nonnull %%varargs, i64 %d, i64 %d, ptr undef), !dbg !9\n", len(format), len(args), len(args))
+		b.WriteString("  ret i32 0\n}\n")
+		return b.String()
+	}
+	iface := func(typ, val string) string {
+		return `{ ptr @"reflect/types.type:` + typ + `", ptr ` + val + ` }`
+	}
+	tests := []struct {
+		name     string
+		input    string
+		contains []string
+		warning  string
+		wantErr  string
+	}{
+		{
+			name:  "trace_printk",
+			input: call("pid=%d comm=%s\n", iface("basic:uint32", "%pid"), iface("pointer:basic:uint8", "%comm")),
+			contains: []string{
+				`@__bpf_printk_fmt = internal constant [16 x i8] c"pid=%d comm=%s\0A\00", section ".rodata", align 1`,
+				"%__printk.2 = ptrtoint ptr %pid to i32",
+				"%__printk.1 = zext i32 %__printk.2 to i64",
+				"%r = call i64 inttoptr (i64 6 to ptr)(ptr @__bpf_printk_fmt, i32 16, i64 %__printk.1, ptr %comm), !dbg !9",
+			},
+		},
+		{
+			name:  "no arguments",
+			input: call("hello"),
+			contains: []string{
+				"%r = call i64 inttoptr (i64 6 to ptr)(ptr @__bpf_printk_fmt, i32 6)",
+			},
+		},
+		{
+			name: "trace_vprintk",
+			input: call("%d %d %llx %d", iface("basic:int8", "inttoptr (i8 -1 to ptr)"), iface("basic:int32", "%x"),
+				iface("basic:uint64", "%y"), iface("named:main.pid", "%pid")),
+			contains: []string{
+				"%__printk.2 = ptrtoint ptr inttoptr (i8 -1 to ptr) to i8",
+				"%__printk.1 = sext i8 %__printk.2 to i64",
+				"%__printk.5 = ptrtoint ptr %y to i64",
+				"%__printk.7 = ptrtoint ptr %pid to i32",
+				"%__printk.8 = alloca [4 x i64], align 8",
+				"%__printk.12 = getelementptr inbounds [4 x i64], ptr %__printk.8, i64 0, i64 3",
+				"store i64 %__printk.6, ptr %__printk.12, align 8",
+				"%r = call i64 inttoptr (i64 177 to ptr)(ptr @__bpf_printk_fmt, i32 14, ptr %__printk.8, i32 32), !dbg !9",
+			},
+			warning: "bpfPrintk with more than 3 arguments in prog uses bpf_trace_vprintk, which needs Linux 5.16 or later",
+		},
+		{
+			name:    "verb count",
+			input:   call("%d %d", iface("basic:int32", "%x")),
+			wantErr: `bpfPrintk in prog: format "%d %d" has 2 verbs for 1 arguments`,
+		},
+		{
+			name:    "64-bit integer",
+			input:   call("%x", iface("basic:uint64", "%x")),
+			wantErr: "verb %x prints 32 bits, but argument 1 is uint64; use %lx",
+		},
+		{
+			name:    "string verb",
+			input:   call("%s", iface("basic:uint32", "%x")),
+			wantErr: "verb %s needs a pointer to a NUL-terminated string, but argument 1 is uint32",
+		},
+		{
+			name:    "integer verb",
+			input:   call("%u", iface("pointer:basic:uint8", "%p")),
+			wantErr: "verb %u needs an integer, but argument 1 is *uint8",
+		},
+		{
+			name:    "unprintable type",
+			input:   call("%s", iface("basic:string", "%s")),
+			wantErr: "argument 1: bpfPrintk cannot print type string",
+		},
+		{
+			name:    "kernel rejects format",
+			input:   call("%f", iface("basic:uint32", "%x")),
+			wantErr: `format "%f": unsupported verb %f`,
+		},
+		{
+			name:    "too many arguments",
+			input:   call(strings.Repeat("%d", 13), strings.Split(strings.Repeat(iface("basic:int32", "%x")+";", 13), ";")[:13]...),
+			wantErr: "13 arguments, the kernel prints at most 12",
+		},
+		{
+			name: "format not a constant",
+			input: `define i32 @prog(ptr %ctx, ptr %f) {
+entry:
+  %r = call i64 @main.bpfPrintk(ptr %f, i64 4, ptr null, i64 0, i64 0, ptr undef)
+  ret i32 0
+}`,
+			wantErr: "the format must be a string constant",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ir.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stdout strings.Builder
+			err = printkModule(m, &stdout)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := ir.Serialize(m)
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output missing %q\n%s", s, out)
+				}
+			}
+			if strings.Contains(out, "@main.bpfPrintk(") {
+				t.Errorf("bpfPrintk call left in output\n%s", out)
+			}
+			if !strings.Contains(stdout.String(), tt.warning) || (tt.warning == "") != (stdout.Len() == 0) {
+				t.Errorf("warning %q, want %q", stdout.String(), tt.warning)
+			}
+		})
+	}
+}
+
+func TestPrintkFormatDedup(t *testing.T) {
+	long := strings.Repeat("0123456789", 20) + "\n"
+	short := "hi\n"
+	var b strings.Builder
+	fmt.Fprintf(&b, "@\"main$string\" = internal unnamed_addr constant [%d x i8] c\"%s\", align 1\n", len(long), encodeIRString(long))
+	fmt.Fprintf(&b, "@\"main$string.1\" = internal unnamed_addr constant [%d x i8] c\"%s\", align 1\n", len(short), encodeIRString(short))
+	printk := func(str string, n int) string {
+		return fmt.Sprintf("  call i64 @main.bpfPrintk(ptr @\"%s\", i64 %d, ptr null, i64 0, i64 0, ptr undef)\n", str, n)
+	}
+	for _, fn := range []string{"prog_a", "prog_b"} {
+		fmt.Fprintf(&b, "\ndefine i32 @%s(ptr %%ctx) {\nentry:\n", fn)
+		b.WriteString(printk("main$string", len(long)))
+		b.WriteString(printk("main$string.1", len(short)))
+		b.WriteString(printk("main$string", len(long)))
+		b.WriteString("  ret i32 0\n}\n")
+	}
+	m, err := ir.Parse(b.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := printkModule(m, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := ir.Serialize(m)
+	for s, want := range map[string]int{
+		"@__bpf_printk_fmt = internal constant [202 x i8]": 1,
+		"@__bpf_printk_fmt.1 = internal constant [4 x i8]": 1,
+		"(ptr @__bpf_printk_fmt, i32 202)":                 4,
+		"(ptr @__bpf_printk_fmt.1, i32 4)":                 2,
+		"@__bpf_printk_fmt.2":                              0,
+	} {
+		if got := strings.Count(out, s); got != want {
+			t.Errorf("%q appears %d times, want %d\n%s", s, got, want, out)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package transform
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/kyleseneker/tinybpf/diag"
@@ -38,10 +39,12 @@ func rewriteHelpersModule(m *ir.Module) error {
 }
 
 // rewriteHelperInst rewrites a single BPF helper call instruction from Go-style to inttoptr-based.
+// A Go function passed to a callback helper is reduced to the function pointer,
+// which llc emits as the ld_imm64 function reference the verifier expects.
 func rewriteHelperInst(inst *ir.Instruction, fn *ir.Function) error {
 	callee := inst.Call.Callee
 	funcName := strings.TrimPrefix(callee, "@")
-	if strings.HasPrefix(funcName, "main.bpfCore") || strings.HasPrefix(funcName, "main.bpfKfunc") {
+	if strings.HasPrefix(funcName, "main.bpfCore") || isKfuncName(funcName) {
 		return nil
 	}
 	helperID, ok := helperIDs[funcName]
@@ -49,6 +52,15 @@ func rewriteHelperInst(inst *ir.Instruction, fn *ir.Function) error {
 		return unknownHelperErr(funcName)
 	}
 	args := stripTrailingUndef(inst.Call.Args)
+	if pos, ok := callbackPos(funcName); ok {
+		parts := splitTypeArgs(args)
+		if context, _, ok := callbackArg(parts, pos); ok {
+			if context != "undef" && context != "null" {
+				return fmt.Errorf("%s: the callback must be a top-level function, not a closure or method value", funcName)
+			}
+			args = strings.Join(slices.Delete(parts, pos, pos+1), ", ")
+		}
+	}
 	inst.Call.Callee = fmt.Sprintf("inttoptr (i64 %d to ptr)", helperID)
 	inst.Call.Args = args
 	inst.Modified = true
@@ -1,41 +1,99 @@
 package transform
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/kyleseneker/tinybpf/internal/ir"
 )
 
 // sectionsPassModule assigns ELF sections to globals and program functions in a single pass.
-func sectionsPassModule(m *ir.Module, sections map[string]string) error {
-	if err := assignDataSectionsModule(m); err != nil {
+func sectionsPassModule(m *ir.Module, sections map[string]string, globalVars []string) error {
+	if err := assignDataSectionsModule(m, globalVars); err != nil {
 		return err
 	}
 	return assignProgramSectionsModule(m, sections)
 }
 
-// assignDataSectionsModule assigns .data, .bss, or .rodata sections to globals that lack one.
-func assignDataSectionsModule(m *ir.Module) error {
+// assignDataSectionsModule assigns .data, .bss, or .rodata sections to globals
+// that lack one, and exposes the Go variables named in globalVars to loaders.
+func assignDataSectionsModule(m *ir.Module, globalVars []string) error {
+	exposed := make(map[string]bool, len(globalVars))
 	for _, g := range m.Globals {
-		if g.Section != "" {
-			continue
-		}
 		if isRuntimeGlobal(g.Name) {
 			continue
 		}
-		raw := g.Raw
-		if strings.Contains(raw, "%main.bpfMapDef") {
+		if isMapDefGlobal(g) {
 			continue
 		}
-		section := classifyGlobalSectionFromAST(g)
-		if section != "" {
+		if g.Section == "" {
+			section := classifyGlobalSectionFromAST(g)
+			if section == "" {
+				continue
+			}
 			g.Section = section
 			g.Modified = true
 		}
+		if name, ok := globalVarName(g.Name, globalVars); ok {
+			exposeGoVariable(g)
+			exposed[name] = true
+			g.Modified = true
+		}
+	}
+	var missing []string
+	for _, name := range globalVars {
+		if !exposed[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("global variable(s) not exposed: %v (a variable that no program uses is removed)", missing)
 	}
 	return nil
 }
 
+// globalVarName returns the entry of globalVars that names the Go variable
+// name, with or without the "main." qualifier.
+func globalVarName(name string, globalVars []string) (string, bool) {
+	for _, v := range globalVars {
+		if v == name || "main."+v == name {
+			return v, isGoVariable(name)
+		}
+	}
+	return "", false
+}
+
+// exposeGoVariable gives a Go package variable external linkage so that it
+// becomes a global ELF symbol, which loaders expose as a variable. A .rodata
+// variable is also made a non-constant global so that opt keeps its loads
+// and the loader can rewrite its value before the programs are verified.
+// Only the variables marked //bpf:global are exposed; the others keep
+// internal linkage, and read-only ones stay constants that opt can fold.
+func exposeGoVariable(g *ir.Global) {
+	if !isGoVariable(g.Name) || !strings.HasPrefix(g.Linkage, "internal ") {
+		return
+	}
+	g.Linkage = strings.TrimPrefix(g.Linkage, "internal ")
+	if g.Section == ".rodata" {
+		g.Linkage = strings.Replace(g.Linkage, "constant", "global", 1)
+	}
+}
+
+// isGoVariable reports whether name is a package-qualified Go identifier
+// such as "main.targetPID", as opposed to a compiler-generated global.
+func isGoVariable(name string) bool {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 || i == len(name)-1 {
+		return false
+	}
+	for j, c := range name[i+1:] {
+		if c != '_' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && (j == 0 || !('0' <= c && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
 // classifyGlobalSectionFromAST returns the ELF section name for a global based on its linkage.
 func classifyGlobalSectionFromAST(g *ir.Global) string {
 	if g.Initializer == "zeroinitializer" {
@@ -70,10 +128,13 @@ func assignProgramSectionsModule(m *ir.Module, sections map[string]string) error
 				fn.Raw = insertSection(fn.Raw, sec)
 			}
 		}
-		if e.Kind == ir.TopGlobal && e.Global != nil && strings.Contains(e.Raw, "bpfMapDef") {
+		if e.Kind == ir.TopGlobal && e.Global != nil && isMapDefGlobal(e.Global) {
+			g := e.Global
 			e.Raw = strings.Replace(e.Raw, " internal ", " ", 1)
+			g.Linkage = strings.TrimPrefix(g.Linkage, "internal ")
 			if !strings.Contains(e.Raw, " section ") {
 				e.Raw = insertSectionAttr(e.Raw, ".maps")
+				g.Section = ".maps"
 			}
 		}
 	}
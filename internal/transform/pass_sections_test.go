@@ -45,7 +45,7 @@ func TestSectionsPassModule(t *testing.T) {
 					{Kind: ir.TopFunction, Function: fn, Raw: fn.Raw},
 				},
 			}
-			if err := sectionsPassModule(m, tt.sections); err != nil {
+			if err := sectionsPassModule(m, tt.sections, nil); err != nil {
 				t.Fatal(err)
 			}
 			if g.Section != tt.wantGlobalSect {
@@ -58,6 +58,123 @@ func TestSectionsPassModule(t *testing.T) {
 	}
 }
 
+func TestAssignDataSectionsGlobalVars(t *testing.T) {
+	const input = `@main.targetPID = internal constant i32 0, align 4
+@main.hits = internal global i64 0, align 8
+@main.limit = internal constant i32 64, align 4
+@main.debug = internal global i8 1, align 1
+@main.placed = internal global i32 0, section ".data", align 4
+`
+	tests := []struct {
+		name       string
+		globalVars []string
+		contains   []string
+		wantErr    string
+	}{
+		{
+			name: "no variables exposed",
+			contains: []string{
+				`@main.targetPID = internal constant i32 0, section ".rodata", align 4`,
+				`@main.hits = internal global i64 0, section ".data", align 8`,
+				`@main.limit = internal constant i32 64, section ".rodata", align 4`,
+			},
+		},
+		{
+			name:       "marked variables only",
+			globalVars: []string{"main.targetPID", "hits"},
+			contains: []string{
+				`@main.targetPID = global i32 0, section ".rodata", align 4`,
+				`@main.hits = global i64 0, section ".data", align 8`,
+				`@main.limit = internal constant i32 64, section ".rodata", align 4`,
+				`@main.debug = internal global i8 1, section ".data", align 1`,
+			},
+		},
+		{
+			name:       "missing variable",
+			globalVars: []string{"main.targetPID", "main.unused"},
+			wantErr:    "global variable(s) not exposed: [main.unused]",
+		},
+		{
+			name:       "variable with a section already",
+			globalVars: []string{"main.placed"},
+			contains:   []string{`@main.placed = global i32 0, section ".data", align 4`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ir.Parse(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = assignDataSectionsModule(m, tt.globalVars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := ir.Serialize(m)
+			for _, want := range tt.contains {
+				if !strings.Contains(out, want) {
+					t.Errorf("output missing %q\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestExposeGoVariable(t *testing.T) {
+	tests := []struct {
+		name        string
+		global      ir.Global
+		wantLinkage string
+	}{
+		{"internal .bss variable", ir.Global{Name: "main.counter", Linkage: "internal global", Section: ".bss"}, "global"},
+		{"internal .data variable", ir.Global{Name: "main.targetPID", Linkage: "internal global", Section: ".data"}, "global"},
+		{"internal .rodata variable", ir.Global{Name: "main.limit", Linkage: "internal constant", Section: ".rodata"}, "global"},
+		{"unnamed_addr kept", ir.Global{Name: "main.limit", Linkage: "internal unnamed_addr constant", Section: ".rodata"}, "unnamed_addr global"},
+		{"already global", ir.Global{Name: "main.counter", Linkage: "global", Section: ".data"}, "global"},
+		{"compiler string", ir.Global{Name: "main$string", Linkage: "internal unnamed_addr constant", Section: ".rodata"}, "internal unnamed_addr constant"},
+		{"compiler pack", ir.Global{Name: "main.foo$pack", Linkage: "internal global", Section: ".data"}, "internal global"},
+		{"private global", ir.Global{Name: "main.x", Linkage: "private constant", Section: ".rodata"}, "private constant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tt.global
+			exposeGoVariable(&g)
+			if g.Linkage != tt.wantLinkage {
+				t.Errorf("linkage = %q, want %q", g.Linkage, tt.wantLinkage)
+			}
+		})
+	}
+}
+
+func TestIsGoVariable(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"main.targetPID", true},
+		{"main._x1", true},
+		{"github.com/acme/probe.limit", true},
+		{"main.1x", false},
+		{"main.", false},
+		{".str", false},
+		{"counter", false},
+		{"main.foo$alloc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isGoVariable(tt.name); got != tt.want {
+				t.Errorf("isGoVariable(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestClassifyGlobalSectionFromAST(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -0,0 +1,239 @@
+package transform
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+// --- BPF-to-BPF subprograms ---
+//
+// TinyGo leaves a Go function marked //go:noinline as a function of its own.
+// Rather than dropping it or turning it into a program, it is kept as a
+// subprogram in .text, which loaders link into each program that calls it.
+// Functions passed as callbacks to helpers such as bpf_loop are kept the
+// same way. Static subprograms keep internal linkage and are verified as
+// part of each caller. Global ones, marked //bpf:global, get external
+// linkage and global BTF func_info so that the verifier checks them once,
+// independently of their callers; their unsafe.Pointer parameters are
+// tagged with btf_decl_tag("arg:ctx") as the program context.
+
+// findSubprograms returns the functions to keep as subprograms, mapped to
+// whether they are global: non-runtime functions with internal linkage and
+// the noinline attribute, callbacks passed to helpers such as bpf_loop, and
+// the functions named in globals.
+func findSubprograms(m *ir.Module, globals []string) (map[string]bool, error) {
+	noinline := make(map[string]bool)
+	for _, ag := range m.AttrGroups {
+		if slices.Contains(tokenizeAttrs(ag.Body), "noinline") {
+			noinline["#"+ag.ID] = true
+		}
+	}
+	defined := make(map[string]bool, len(m.Functions))
+	subprograms := make(map[string]bool)
+	for _, fn := range m.Functions {
+		if fn.Removed {
+			continue
+		}
+		defined[fn.Name] = true
+		if isRuntimeFunc(fn.Name) || !strings.HasPrefix(fn.Raw, "define internal ") {
+			continue
+		}
+		if noinline[fn.AttrRef] || slices.Contains(strings.Fields(fn.Attrs), "noinline") {
+			subprograms[fn.Name] = false
+		}
+	}
+	for _, name := range callbackFuncs(m) {
+		if _, ok := subprograms[name]; !ok && defined[name] {
+			subprograms[name] = false
+		}
+	}
+	var missing []string
+	for _, name := range globals {
+		switch {
+		case defined[name]:
+			subprograms[name] = true
+		case defined["main."+name]:
+			subprograms["main."+name] = true
+		default:
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("global function(s) not found in IR: %v (mark them //go:noinline so that TinyGo does not inline them)", missing)
+	}
+	return subprograms, nil
+}
+
+// callbackFuncs returns the functions passed as callbacks to BPF helpers.
+func callbackFuncs(m *ir.Module) []string {
+	var names []string
+	for _, fn := range m.Functions {
+		if fn.Removed {
+			continue
+		}
+		ir.EnsureBlocks(fn)
+		for _, block := range fn.Blocks {
+			for _, inst := range block.Instructions {
+				if inst.Kind != ir.InstCall || inst.Call == nil {
+					continue
+				}
+				pos, ok := callbackPos(strings.TrimPrefix(inst.Call.Callee, "@"))
+				if !ok {
+					continue
+				}
+				if _, callback, ok := callbackArg(splitTypeArgs(inst.Call.Args), pos); ok && !slices.Contains(names, callback[1:]) {
+					names = append(names, callback[1:])
+				}
+			}
+		}
+	}
+	return names
+}
+
+// subprogramsModule prepares the subprograms for BPF-to-BPF calls: it drops
+// the unused TinyGo context parameter, places them in .text and gives global
+// ones external linkage and global BTF func_info.
+func subprogramsModule(m *ir.Module, globals []string) error {
+	subprograms, err := findSubprograms(m, globals)
+	if err != nil || len(subprograms) == 0 {
+		return err
+	}
+	metaByID := make(map[int]*ir.MetadataNode, len(m.MetadataNodes))
+	for _, mn := range m.MetadataNodes {
+		metaByID[mn.ID] = mn
+	}
+	nextID := findMaxMetaIDFromModule(m) + 1
+	ctxTagID := -1
+	callbacks := callbackFuncs(m)
+	for _, fn := range m.Functions {
+		global, ok := subprograms[fn.Name]
+		if !ok || fn.Removed {
+			continue
+		}
+		dropped, err := dropContextParam(fn, slices.Contains(callbacks, fn.Name))
+		if err != nil {
+			return err
+		}
+		if dropped {
+			dropContextArgs(m, "@"+fn.Name)
+		}
+		if global {
+			fn.Raw = strings.Replace(fn.Raw, "define internal ", "define ", 1)
+			sp := subprogramMeta(fn, metaByID)
+			if sp == nil {
+				return fmt.Errorf("global function %s has no debug info; do not build with -no-debug", fn.Name)
+			}
+			updateMetaEntry(m, sp.ID, clearLocalToUnit)
+			for _, arg := range ctxParams(sp.ID, m.MetadataNodes, metaByID) {
+				if ctxTagID < 0 {
+					ctxTagID = nextID
+					nextID++
+					appendMetaEntryToModule(m, fmt.Sprintf(`!%d = !{!"btf_decl_tag", !"arg:ctx"}`, ctxTagID))
+				}
+				updateMetaEntry(m, arg.ID, func(raw string) string {
+					return strings.TrimSuffix(raw, ")") + fmt.Sprintf(", annotations: !{!%d})", ctxTagID)
+				})
+			}
+		}
+		fn.Raw = insertSection(fn.Raw, ".text")
+		fn.Modified = true
+	}
+	return nil
+}
+
+// dropContextParam removes the trailing TinyGo context parameter from the
+// definition of fn if its body does not use it, and reports whether it did.
+// A callback that uses it is an error: the helper calls it with the kernel's
+// arguments only, so the context would be whatever is left in the register.
+func dropContextParam(fn *ir.Function, callback bool) (bool, error) {
+	params := splitTypeArgs(fn.Params)
+	last := params[len(params)-1]
+	if !strings.HasSuffix(last, " %context") {
+		return false, nil
+	}
+	for _, line := range fn.BodyRaw {
+		if !strings.Contains(line, "%context") {
+			continue
+		}
+		if callback {
+			return false, fmt.Errorf("callback %s uses its closure context, which BPF helpers do not pass; make it a top-level function that does not capture variables", fn.Name)
+		}
+		return false, nil
+	}
+	trimmed := strings.Join(params[:len(params)-1], ", ")
+	fn.Raw = strings.Replace(fn.Raw, "("+fn.Params+")", "("+trimmed+")", 1)
+	fn.Params = trimmed
+	return true, nil
+}
+
+// dropContextArgs removes the trailing context argument from every call to
+// callee.
+func dropContextArgs(m *ir.Module, callee string) {
+	for _, fn := range m.Functions {
+		if fn.Removed {
+			continue
+		}
+		ir.EnsureBlocks(fn)
+		for _, block := range fn.Blocks {
+			for _, inst := range block.Instructions {
+				if inst.Kind != ir.InstCall || inst.Call == nil || inst.Call.Callee != callee {
+					continue
+				}
+				args := splitTypeArgs(inst.Call.Args)
+				inst.Call.Args = strings.Join(args[:len(args)-1], ", ")
+				inst.Modified = true
+				fn.Modified = true
+			}
+		}
+	}
+}
+
+// subprogramMeta returns the DISubprogram attached to fn.
+func subprogramMeta(fn *ir.Function, metaByID map[int]*ir.MetadataNode) *ir.MetadataNode {
+	for _, ma := range fn.Metadata {
+		if sp := metaByID[parseMetaID(ma.Value)]; ma.Key == "dbg" && sp != nil && sp.Kind == "DISubprogram" {
+			return sp
+		}
+	}
+	return nil
+}
+
+// clearLocalToUnit removes DISPFlagLocalToUnit from a DISubprogram, which
+// makes its BTF func_info linkage global.
+func clearLocalToUnit(raw string) string {
+	for _, flag := range []string{"DISPFlagLocalToUnit | ", " | DISPFlagLocalToUnit", "spFlags: DISPFlagLocalToUnit, "} {
+		raw = strings.Replace(raw, flag, "", 1)
+	}
+	return raw
+}
+
+// ctxParams returns the unsafe.Pointer parameters of the DISubprogram spID:
+// its DILocalVariable arguments whose type is a pointer without a base type.
+func ctxParams(spID int, nodes []*ir.MetadataNode, metaByID map[int]*ir.MetadataNode) []*ir.MetadataNode {
+	var params []*ir.MetadataNode
+	for _, mn := range nodes {
+		if mn.Kind != "DILocalVariable" || mn.Fields["arg"] == "" || parseMetaID(mn.Fields["scope"]) != spID {
+			continue
+		}
+		typ := resolveTypedef(mn.Fields["type"], metaByID)
+		if typ != nil && typ.Fields["tag"] == "DW_TAG_pointer_type" && cmp.Or(typ.Fields["baseType"], "null") == "null" {
+			params = append(params, mn)
+		}
+	}
+	return params
+}
+
+// updateMetaEntry applies update to the raw text of the metadata node id.
+func updateMetaEntry(m *ir.Module, id int, update func(string) string) {
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		if !e.Removed && e.Kind == ir.TopMetadata && e.Metadata != nil && e.Metadata.ID == id {
+			e.Raw = update(e.Raw)
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kyleseneker/tinybpf/internal/ir"
+)
+
+// subprogramIR is a program calling the noinline Go functions main.add and
+// main.packetLen, which take an unsafe.Pointer context.
+const subprogramIR = `define i32 @xdp_prog(ptr %ctx) #0 !dbg !40 {
+entry:
+  %0 = call fastcc i32 @main.packetLen(ptr %ctx, ptr undef) #2, !dbg !70
+  %1 = call fastcc i32 @main.add(i32 %0, i32 1, ptr undef) #2, !dbg !70
+  ret i32 %1
+}
+
+define internal fastcc i32 @main.add(i32 %a, i32 %b, ptr %context) unnamed_addr #1 !dbg !60 {
+entry:
+  %0 = add i32 %a, %b
+  ret i32 %0
+}
+
+define internal fastcc i32 @main.packetLen(ptr %ctx, ptr %context) unnamed_addr #1 !dbg !80 {
+entry:
+  %0 = load i32, ptr %ctx, align 4
+  ret i32 %0
+}
+
+define internal fastcc i32 @main.inlined(ptr %context) unnamed_addr #0 {
+entry:
+  ret i32 0
+}
+
+attributes #0 = { nounwind }
+attributes #1 = { noinline nounwind }
+attributes #2 = { nounwind }
+
+!2 = !DIFile(filename: "main.go", directory: "/src")
+!40 = distinct !DISubprogram(name: "xdp_prog", scope: !2, file: !2, line: 20, type: !41, spFlags: DISPFlagLocalToUnit | DISPFlagDefinition | DISPFlagOptimized, unit: !50)
+!41 = !DISubroutineType(types: !42)
+!42 = !{!44, !45}
+!44 = !DIBasicType(name: "int32", size: 32, encoding: DW_ATE_signed)
+!45 = !DIDerivedType(tag: DW_TAG_pointer_type, name: "unsafe.Pointer", baseType: null, size: 64, align: 64, dwarfAddressSpace: 0)
+!50 = distinct !DICompileUnit(language: DW_LANG_Go, file: !2, producer: "TinyGo", isOptimized: true, runtimeVersion: 0, emissionKind: FullDebug)
+!60 = distinct !DISubprogram(name: "main.add", scope: !2, file: !2, line: 30, type: !61, spFlags: DISPFlagLocalToUnit | DISPFlagDefinition | DISPFlagOptimized, unit: !50)
+!61 = !DISubroutineType(types: !62)
+!62 = !{!44, !44, !44}
+!64 = !DILocalVariable(name: "a", arg: 1, scope: !60, file: !2, line: 30, type: !44)
+!70 = !DILocation(line: 21, column: 2, scope: !40)
+!80 = distinct !DISubprogram(name: "main.packetLen", scope: !2, file: !2, line: 40, type: !41, spFlags: DISPFlagLocalToUnit | DISPFlagDefinition | DISPFlagOptimized, unit: !50)
+!84 = !DILocalVariable(name: "ctx", arg: 1, scope: !80, file: !2, line: 40, type: !45)`
+
+func TestSubprogramsModule(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		globals  []string
+		contains []string
+		absent   []string
+		wantErr  string
+	}{
+		{
+			name:  "static subprograms",
+			input: subprogramIR,
+			contains: []string{
+				`define internal fastcc i32 @main.add(i32 %a, i32 %b) unnamed_addr #1 section ".text"`,
+				`define internal fastcc i32 @main.packetLen(ptr %ctx) unnamed_addr #1 section ".text"`,
+				`call fastcc i32 @main.add(i32 %0, i32 1)`,
+				`call fastcc i32 @main.packetLen(ptr %ctx)`,
+				`define i32 @xdp_prog(ptr %ctx) #0 !dbg !40 {`,
+				`define internal fastcc i32 @main.inlined(ptr %context) unnamed_addr #0 {`,
+			},
+			absent: []string{"annotations:"},
+		},
+		{
+			name:    "global subprogram",
+			input:   subprogramIR,
+			globals: []string{"packetLen"},
+			contains: []string{
+				`define fastcc i32 @main.packetLen(ptr %ctx) unnamed_addr #1 section ".text"`,
+				`!80 = distinct !DISubprogram(name: "main.packetLen", scope: !2, file: !2, line: 40, type: !41, spFlags: DISPFlagDefinition | DISPFlagOptimized, unit: !50)`,
+				`!85 = !{!"btf_decl_tag", !"arg:ctx"}`,
+				`!84 = !DILocalVariable(name: "ctx", arg: 1, scope: !80, file: !2, line: 40, type: !45, annotations: !{!85})`,
+				`define internal fastcc i32 @main.add(i32 %a, i32 %b)`,
+			},
+			absent: []string{`!64 = !DILocalVariable(name: "a", arg: 1, scope: !60, file: !2, line: 30, type: !44, annotations`},
+		},
+		{
+			name:    "global function without debug info",
+			input:   subprogramIR,
+			globals: []string{"main.inlined"},
+			wantErr: "global function main.inlined has no debug info",
+		},
+		{
+			name:    "global function not found",
+			input:   subprogramIR,
+			globals: []string{"main.parse"},
+			wantErr: "global function(s) not found in IR: [main.parse]",
+		},
+		{
+			name: "context parameter in use",
+			input: `define internal i32 @main.closure(i32 %a, ptr %context) #0 {
+entry:
+  %0 = load i32, ptr %context, align 4
+  ret i32 %0
+}
+
+attributes #0 = { noinline }`,
+			contains: []string{`define internal i32 @main.closure(i32 %a, ptr %context) #0 section ".text"`},
+		},
+		{
+			name: "callback uses context",
+			input: `define i32 @prog(ptr %ctx) {
+entry:
+  %0 = call i64 @main.bpfLoop(i32 4, ptr undef, ptr @main.step, ptr null, i64 0, ptr undef)
+  ret i32 0
+}
+
+define internal i64 @main.step(i32 %i, ptr %data, ptr %context) {
+entry:
+  %0 = load i64, ptr %context, align 8
+  ret i64 %0
+}`,
+			wantErr: "callback main.step uses its closure context",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ir.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = subprogramsModule(m, tt.globals)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v should contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := ir.Serialize(m)
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output missing %q\n%s", s, out)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(out, s) {
+					t.Errorf("output should not contain %q", s)
+				}
+			}
+		})
+	}
+}
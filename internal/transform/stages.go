@@ -15,15 +15,24 @@ func buildModuleStages(opts Options) []moduleStage {
 	return []moduleStage{
 		{"module-rewrite", moduleRewriteModule},
 		{"extract-programs", func(m *ir.Module) error {
-			return extractProgramsModule(m, opts.Programs, opts.Verbose, opts.Stdout)
+			return extractProgramsModule(m, opts.Programs, opts.GlobalFuncs, opts.Verbose, opts.Stdout)
 		}},
+		{"subprograms", func(m *ir.Module) error {
+			return subprogramsModule(m, opts.GlobalFuncs)
+		}},
+		{"iterators", iteratorsModule},
 		{"replace-alloc", replaceAllocModule},
+		{"printk", func(m *ir.Module) error {
+			return printkModule(m, opts.Stdout)
+		}},
 		{"rewrite-helpers", rewriteHelpersModule},
 		{"core", corePassModule},
 		{"sections", func(m *ir.Module) error {
-			return sectionsPassModule(m, opts.Sections)
+			return sectionsPassModule(m, opts.Sections, opts.GlobalVars)
+		}},
+		{"map-btf", func(m *ir.Module) error {
+			return mapBTFPassModule(m, opts.FieldTags)
 		}},
-		{"map-btf", mapBTFPassModule},
 		{"finalize", func(m *ir.Module) error {
 			return finalizeModule(m, opts.Stdout)
 		}},
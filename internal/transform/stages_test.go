@@ -12,12 +12,15 @@ func TestBuildModuleStagesOrder(t *testing.T) {
 	}{
 		{0, "module-rewrite"},
 		{1, "extract-programs"},
-		{2, "replace-alloc"},
-		{3, "rewrite-helpers"},
-		{4, "core"},
-		{5, "sections"},
-		{6, "map-btf"},
-		{7, "finalize"},
+		{2, "subprograms"},
+		{3, "iterators"},
+		{4, "replace-alloc"},
+		{5, "printk"},
+		{6, "rewrite-helpers"},
+		{7, "core"},
+		{8, "sections"},
+		{9, "map-btf"},
+		{10, "finalize"},
 	}
 
 	stages := buildModuleStages(Options{Stdout: io.Discard})
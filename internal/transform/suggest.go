@@ -35,11 +35,17 @@ const maxSuggestDistance = 3
 // closestHelper returns the known helper name closest to name, or "" if no
 // match is within maxSuggestDistance edits.
 func closestHelper(name string) string {
+	return closestName(name, helperIDs)
+}
+
+// closestName returns the key of known closest to name, or "" if no match is
+// within maxSuggestDistance edits.
+func closestName(name string, known map[string]int64) string {
 	best, bestDist := "", maxSuggestDistance+1
-	for known := range helperIDs {
-		d := levenshtein(name, known)
+	for candidate := range known {
+		d := levenshtein(name, candidate)
 		if d < bestDist {
-			best, bestDist = known, d
+			best, bestDist = candidate, d
 		}
 	}
 	if bestDist > maxSuggestDistance {
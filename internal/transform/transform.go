@@ -14,11 +14,14 @@ import (
 
 // Options configures the IR transformation pass.
 type Options struct {
-	Programs []string
-	Sections map[string]string
-	Verbose  bool
-	Stdout   io.Writer
-	DumpDir  string
+	Programs    []string
+	Sections    map[string]string
+	GlobalFuncs []string          // Go functions kept as global BPF subprograms, e.g. "main.parseIPv4"
+	GlobalVars  []string          // Go variables exposed to loaders as global symbols, e.g. "main.targetPID"
+	FieldTags   map[string]string // bpf struct tags of Go struct fields, e.g. "main.taskRef.Task": "kptr"
+	Verbose     bool
+	Stdout      io.Writer
+	DumpDir     string
 }
 
 // Run reads a .ll file, applies all transformations, and writes the result.
@@ -458,6 +458,66 @@ entry:
 			contains: []string{`section ".maps"`, `@outer_map`},
 			absent:   []string{`@main.outer_map`},
 		},
+		{
+			name: "typed map BTF rewrite",
+			input: `target triple = "x86_64-unknown-linux-gnu"
+
+%"main.bpfMap[main.lruHashMap, uint32, uint64]" = type { ptr, ptr, i32 }
+
+@main.conns = global %"main.bpfMap[main.lruHashMap, uint32, uint64]" { ptr null, ptr null, i32 4096 }, align 4, !dbg !0
+
+define i32 @my_func(ptr %ctx) {
+entry:
+  ret i32 0
+}
+
+!0 = !DIGlobalVariableExpression(var: !1, expr: !DIExpression())
+!1 = distinct !DIGlobalVariable(name: "main.conns", scope: !2, file: !2, line: 3, type: !3, isLocal: false, isDefinition: true)
+!2 = !DIFile(filename: "main.go", directory: "/src")
+!3 = !DICompositeType(tag: DW_TAG_structure_type, size: 160, align: 64, elements: !4)
+!4 = !{!5, !6, !7}
+!5 = !DIDerivedType(tag: DW_TAG_member, name: "Key", baseType: !8, size: 64, align: 64)
+!6 = !DIDerivedType(tag: DW_TAG_member, name: "Value", baseType: !10, size: 64, align: 64, offset: 64)
+!7 = !DIDerivedType(tag: DW_TAG_member, name: "MaxEntries", baseType: !9, size: 32, align: 32, offset: 128)
+!8 = !DIDerivedType(tag: DW_TAG_pointer_type, name: "*uint32", baseType: !9, size: 64, align: 64, dwarfAddressSpace: 0)
+!9 = !DIBasicType(name: "uint32", size: 32, encoding: DW_ATE_unsigned)
+!10 = !DIDerivedType(tag: DW_TAG_pointer_type, name: "*uint64", baseType: !11, size: 64, align: 64, dwarfAddressSpace: 0)
+!11 = !DIBasicType(name: "uint64", size: 64, encoding: DW_ATE_unsigned)`,
+			opts:     Options{Stdout: io.Discard},
+			contains: []string{`@conns = global { ptr, ptr, ptr, ptr } zeroinitializer, section ".maps", align 8`, `name: "key", baseType: !8`, `DISubrange(count: 9)`},
+			absent:   []string{`@main.conns`},
+		},
+		{
+			name: "map-in-map BTF rewrite",
+			input: "target triple = \"x86_64-unknown-linux-gnu\"\n\n" +
+				"define i32 @my_func(ptr %ctx) {\nentry:\n  ret i32 0\n}\n\n" +
+				mapInMapInput("arrayOfMaps", `, %inner { ptr null, i32 128 }`,
+					`, [2 x ptr] [ptr null, ptr @main.tenantA]`, "!27, !28, !29, !30"),
+			opts: Options{Stdout: io.Discard},
+			contains: []string{
+				`@tenants = global { ptr, ptr, ptr, [2 x ptr] } { ptr null, ptr null, ptr null, [2 x ptr] [ptr null, ptr @tenantA] }, section ".maps", align 8`,
+				`@tenantA = global { ptr, ptr, ptr } zeroinitializer, section ".maps", align 8`,
+				`name: "values"`,
+			},
+			absent: []string{`@main.tenantA`},
+		},
+		{
+			name:  "noinline and global subprograms",
+			input: "target triple = \"x86_64-unknown-linux-gnu\"\n\n" + subprogramIR,
+			opts: Options{
+				Stdout:      io.Discard,
+				Programs:    []string{"xdp_prog"},
+				Sections:    map[string]string{"xdp_prog": "xdp"},
+				GlobalFuncs: []string{"main.packetLen"},
+			},
+			contains: []string{
+				`define i32 @xdp_prog(ptr %ctx) #0 section "xdp"`,
+				`define internal fastcc i32 @main.add(i32 %a, i32 %b) unnamed_addr #1 section ".text"`,
+				`define fastcc i32 @main.packetLen(ptr %ctx) unnamed_addr #1 section ".text"`,
+				`!"arg:ctx"`,
+			},
+			absent: []string{"@main.inlined", `section "main.add"`},
+		},
 		{
 			name: "ringbuf reserve and submit helpers",
 			input: `target triple = "x86_64-unknown-linux-gnu"
@@ -494,6 +554,108 @@ declare void @main.bpfTailCall(ptr, ptr, i32, ptr)`,
 			contains: []string{"inttoptr (i64 12 to ptr)", `section ".maps"`, "@jmp_table"},
 			absent:   []string{"@main.bpfTailCall"},
 		},
+		{
+			name: "tail call program array",
+			input: "target triple = \"x86_64-unknown-linux-gnu\"\n\n" +
+				"define i32 @dispatcher(ptr %ctx) {\nentry:\n" +
+				"  call void @main.bpfTailCall(ptr %ctx, ptr @main.tenants, i32 1, ptr undef)\n  ret i32 0\n}\n\n" +
+				"declare void @main.bpfTailCall(ptr, ptr, i32, ptr)\n\n" +
+				mapInMapInput("progArrayMap", "", `, [2 x ptr] [ptr null, ptr @stage]`, "!27, !28, !30"),
+			opts: Options{
+				Stdout:   io.Discard,
+				Programs: []string{"dispatcher"},
+				Sections: map[string]string{"dispatcher": "xdp", "stage": "xdp"},
+			},
+			contains: []string{
+				`@tenants = global { ptr, ptr, ptr, [2 x ptr] } { ptr null, ptr null, ptr null, [2 x ptr] [ptr null, ptr @stage] }, section ".maps", align 8`,
+				`define i32 @stage(ptr %ctx) section "xdp"`,
+				"!DISubroutineType(types: !{",
+			},
+			absent: []string{"@main.bpfTailCall", "@main.tenants"},
+		},
+		{
+			name: "bpf_loop callback",
+			input: `target triple = "x86_64-unknown-linux-gnu"
+
+define i32 @counter(ptr %ctx) {
+entry:
+  %sum = alloca i64, align 8
+  %0 = call i64 @main.bpfLoop(i32 10, ptr undef, ptr nonnull @main.loopBody, ptr %sum, i64 0, ptr undef)
+  ret i32 0
+}
+
+define internal i64 @main.loopBody(i32 %index, ptr %data, ptr %context) unnamed_addr {
+entry:
+  ret i64 0
+}
+
+declare i64 @main.bpfLoop(i32, ptr, ptr, ptr, i64, ptr)`,
+			opts: Options{
+				Stdout:   io.Discard,
+				Programs: []string{"counter"},
+				Sections: map[string]string{"counter": "xdp"},
+			},
+			contains: []string{
+				"call i64 inttoptr (i64 181 to ptr)(i32 10, ptr nonnull @main.loopBody, ptr %sum, i64 0)",
+				`define internal i64 @main.loopBody(i32 %index, ptr %data) unnamed_addr section ".text"`,
+			},
+			absent: []string{"@main.bpfLoop"},
+		},
+		{
+			name: "bpfForNum lowered to num iterator",
+			input: `target triple = "x86_64-unknown-linux-gnu"
+
+define i32 @counter(ptr %ctx) {
+entry:
+  %sum = alloca i64, align 8
+  call void @main.bpfForNum(i32 0, i32 1000, ptr undef, ptr nonnull @main.loopBody, ptr nonnull %sum, ptr undef)
+  ret i32 0
+}
+
+define internal i64 @main.loopBody(i32 %index, ptr %data, ptr %context) unnamed_addr {
+entry:
+  ret i64 0
+}
+
+declare void @main.bpfForNum(i32, i32, ptr, ptr, ptr, ptr)
+
+!2 = !DIFile(filename: "main.go", directory: "/src")`,
+			opts: Options{
+				Stdout:   io.Discard,
+				Programs: []string{"counter"},
+				Sections: map[string]string{"counter": "xdp"},
+			},
+			contains: []string{
+				"%__for.1.it = alloca [8 x i8], align 8",
+				"call i32 @bpf_iter_num_new(ptr %__for.1.it, i32 0, i32 1000)",
+				"call i64 @main.loopBody(i32 %__for.1.i, ptr %sum)",
+				"call void @bpf_iter_num_destroy(ptr %__for.1.it)",
+				`define internal i64 @main.loopBody(i32 %index, ptr %data) unnamed_addr section ".text"`,
+				`i32 @bpf_iter_num_new(ptr, i32, i32) section ".ksyms"`,
+			},
+			absent: []string{"@main.bpfForNum"},
+		},
+		{
+			name: "closure passed as callback",
+			input: `target triple = "x86_64-unknown-linux-gnu"
+
+define i32 @counter(ptr %ctx) {
+entry:
+  %sum = alloca i64, align 8
+  %0 = call i64 @main.bpfLoop(i32 10, ptr %sum, ptr @"main.counter$1", ptr null, i64 0, ptr undef)
+  ret i32 0
+}
+
+define internal i64 @"main.counter$1"(i32 %index, ptr %data, ptr %context) unnamed_addr {
+entry:
+  %0 = load i64, ptr %context, align 8
+  ret i64 0
+}
+
+declare i64 @main.bpfLoop(i32, ptr, ptr, ptr, i64, ptr)`,
+			opts:    Options{Stdout: io.Discard, Programs: []string{"counter"}},
+			wantErr: "main.bpfLoop: the callback must be a top-level function",
+		},
 		{
 			name: "spinlock helper rewrite",
 			input: `target triple = "x86_64-unknown-linux-gnu"
@@ -511,6 +673,81 @@ declare void @main.bpfSpinUnlock(ptr, ptr)`,
 			contains: []string{"inttoptr (i64 93 to ptr)", "inttoptr (i64 94 to ptr)"},
 			absent:   []string{"@main.bpfSpinLock", "@main.bpfSpinUnlock"},
 		},
+		{
+			name: "spin lock and timer helpers",
+			input: `target triple = "x86_64-unknown-linux-gnu"
+
+define i32 @arm(ptr %ctx) {
+entry:
+  %v = alloca [24 x i8], align 8
+  %timer = getelementptr inbounds i8, ptr %v, i64 8
+  call void @main.bpfSpinLock(ptr %v, ptr undef)
+  call void @main.bpfSpinUnlock(ptr %v, ptr undef)
+  %0 = call i64 @main.bpfTimerInit(ptr %timer, ptr %ctx, i64 1, ptr undef)
+  %1 = call i64 @main.bpfTimerSetCallback(ptr %timer, ptr undef, ptr nonnull @main.onTimer, ptr undef)
+  %2 = call i64 @main.bpfTimerStart(ptr %timer, i64 1000, i64 0, ptr undef)
+  ret i32 0
+}
+
+define internal i64 @main.onTimer(ptr %m, ptr %key, ptr %value, ptr %context) unnamed_addr {
+entry:
+  ret i64 0
+}
+
+declare void @main.bpfSpinLock(ptr, ptr)
+declare void @main.bpfSpinUnlock(ptr, ptr)
+declare i64 @main.bpfTimerInit(ptr, ptr, i64, ptr)
+declare i64 @main.bpfTimerSetCallback(ptr, ptr, ptr, ptr)
+declare i64 @main.bpfTimerStart(ptr, i64, i64, ptr)`,
+			opts: Options{
+				Stdout:   io.Discard,
+				Programs: []string{"arm"},
+				Sections: map[string]string{"arm": "xdp"},
+			},
+			contains: []string{
+				"call void inttoptr (i64 93 to ptr)(ptr %v)",
+				"call void inttoptr (i64 94 to ptr)(ptr %v)",
+				"call i64 inttoptr (i64 169 to ptr)(ptr %timer, ptr %ctx, i64 1)",
+				"call i64 inttoptr (i64 170 to ptr)(ptr %timer, ptr nonnull @main.onTimer)",
+				"call i64 inttoptr (i64 171 to ptr)(ptr %timer, i64 1000, i64 0)",
+				`define internal i64 @main.onTimer(ptr %m, ptr %key, ptr %value) unnamed_addr section ".text"`,
+			},
+			absent: []string{"@main.bpfSpinLock", "@main.bpfSpinUnlock", "@main.bpfTimer"},
+		},
+		{
+			name: "bpfPrintk",
+			input: `target triple = "x86_64-unknown-linux-gnu"
+
+%runtime._interface = type { ptr, ptr }
+
+@"main$string" = internal unnamed_addr constant [8 x i8] c"pid=%d\0A", align 1
+@"reflect/types.type:basic:uint32" = linkonce_odr unnamed_addr constant { i8, ptr } { i8 10, ptr null }
+
+define i32 @trace(ptr %ctx) {
+entry:
+  %varargs = alloca [16 x i8], align 8
+  %pid = call i64 @main.bpfGetCurrentPidTgid(ptr undef)
+  %p = inttoptr i64 %pid to ptr
+  store ptr @"reflect/types.type:basic:uint32", ptr %varargs, align 8
+  %v = getelementptr inbounds i8, ptr %varargs, i64 8
+  store ptr %p, ptr %v, align 8
+  %0 = call i64 @main.bpfPrintk(ptr nonnull @"main$string", i64 7, ptr nonnull %varargs, i64 1, i64 1, ptr undef)
+  ret i32 0
+}
+
+declare i64 @main.bpfGetCurrentPidTgid(ptr)
+declare i64 @main.bpfPrintk(ptr, i64, ptr, i64, i64, ptr)`,
+			opts: Options{
+				Stdout:   io.Discard,
+				Programs: []string{"trace"},
+				Sections: map[string]string{"trace": "tracepoint/syscalls/sys_enter_openat"},
+			},
+			contains: []string{
+				`@__bpf_printk_fmt = internal constant [8 x i8] c"pid=%d\0A\00", section ".rodata", align 1`,
+				"call i64 inttoptr (i64 6 to ptr)(ptr @__bpf_printk_fmt, i32 8, i64 %__printk.1)",
+			},
+			absent: []string{"@main.bpfPrintk"},
+		},
 		{
 			name: "LSM section assignment",
 			input: `target triple = "x86_64-unknown-linux-gnu"
@@ -534,9 +771,52 @@ entry:
 
 declare void @main.bpfKfuncBpfCastToKernCtx(ptr, ptr)`,
 			opts:     Options{Stdout: io.Discard},
-			contains: []string{"@bpf_cast_to_kern_ctx"},
+			contains: []string{"call void @bpf_cast_to_kern_ctx(ptr %ctx)", "declare void @bpf_cast_to_kern_ctx(ptr)"},
 			absent:   []string{"inttoptr", "@main.bpfKfuncBpfCastToKernCtx", "@bpfKfuncBpfCastToKernCtx"},
 		},
+		{
+			name: "open-coded iterator",
+			input: `target triple = "x86_64-unknown-linux-gnu"
+
+define i32 @my_func(ptr %ctx) !dbg !4 {
+entry:
+  %it = call align 8 dereferenceable(8) ptr @runtime.alloc(i64 8, ptr null, ptr undef)
+  %0 = call i32 @main.bpfIterNumNew(ptr nonnull %it, i32 0, i32 100, ptr undef)
+  br label %loop
+
+loop:
+  %1 = call ptr @main.bpfIterNumNext(ptr nonnull %it, ptr undef)
+  %2 = icmp eq ptr %1, null
+  br i1 %2, label %exit, label %loop
+
+exit:
+  call void @main.bpfIterNumDestroy(ptr nonnull %it, ptr undef)
+  ret i32 0
+}
+
+declare ptr @runtime.alloc(i64, ptr, ptr)
+
+declare i32 @main.bpfIterNumNew(ptr, i32, i32, ptr)
+
+declare ptr @main.bpfIterNumNext(ptr, ptr)
+
+declare void @main.bpfIterNumDestroy(ptr, ptr)
+
+!2 = !DIFile(filename: "main.go", directory: "/src")
+!4 = distinct !DISubprogram(name: "my_func", scope: !2, file: !2, spFlags: DISPFlagDefinition, unit: !5)
+!5 = distinct !DICompileUnit(language: DW_LANG_Go, file: !2, producer: "TinyGo", emissionKind: FullDebug)`,
+			opts: Options{Stdout: io.Discard},
+			contains: []string{
+				"%it = alloca [8 x i8], align 8",
+				"call i32 @bpf_iter_num_new(ptr nonnull %it, i32 0, i32 100)",
+				"call ptr @bpf_iter_num_next(ptr nonnull %it)",
+				"call void @bpf_iter_num_destroy(ptr nonnull %it)",
+				`declare !dbg !14 i32 @bpf_iter_num_new(ptr, i32, i32) section ".ksyms"`,
+				`!14 = !DISubprogram(name: "bpf_iter_num_new", scope: !2, file: !2, type: !13, flags: DIFlagPrototyped, spFlags: DISPFlagOptimized)`,
+				`!11 = !DICompositeType(tag: DW_TAG_structure_type, name: "bpf_iter_num", file: !2, size: 64, elements: !{})`,
+			},
+			absent: []string{"@main.bpfIter", "inttoptr"},
+		},
 		{
 			name: "multi-program extraction with sections",
 			input: `target triple = "x86_64-unknown-linux-gnu"
@@ -1,5 +1,6 @@
 // Package tinybpf compiles Go source or pre-compiled LLVM IR into
-// BPF ELF objects suitable for loading with cilium/ebpf or libbpf.
+// BPF ELF objects suitable for loading with cilium/ebpf or libbpf, and
+// generates type-safe Go loaders for them.
 package tinybpf
 
 import (
@@ -26,6 +27,14 @@ type Request struct {
 	// Defaults to "v3" if empty.
 	CPU string
 
+	// Tags are Go build tags passed to TinyGo. Requires Package.
+	Tags []string
+
+	// Variants, if set, build one object per variant instead of a single
+	// one, each written next to Output with the variant name inserted
+	// (e.g. "probe.v2.bpf.o"). List them most preferred first.
+	Variants []Variant
+
 	// EnableBTF injects BTF type information via pahole.
 	EnableBTF bool
 
@@ -41,6 +50,18 @@ type Request struct {
 	// (e.g. "handle_connect" -> "tracepoint/syscalls/sys_enter_connect").
 	Sections map[string]string
 
+	// GlobalFuncs lists Go functions (e.g. "main.parseIPv4") to keep as
+	// global BPF subprograms, which the verifier checks independently of
+	// their callers. Build adds the functions of Package marked
+	// //bpf:global.
+	GlobalFuncs []string
+
+	// GlobalVars lists Go package variables (e.g. "main.targetPID") to
+	// expose to loaders as global symbols, which generate turns into Config
+	// and Globals fields. Build adds the variables of Package marked
+	// //bpf:global.
+	GlobalVars []string
+
 	// OptProfile selects a named optimization profile:
 	// "conservative", "default", "aggressive", or "verifier-safe".
 	// Defaults to "default" if empty.
@@ -101,6 +122,33 @@ type Result struct {
 
 	// Maps lists the BPF map symbol names found in the output ELF.
 	Maps []string
+
+	// Variants lists the object built for each requested variant, in
+	// request order. Output is the first of them.
+	Variants []VariantOutput
+}
+
+// Variant is one build of a BPF object, for example for an older kernel.
+type Variant struct {
+	// Name is inserted into the output file name. It may contain letters,
+	// digits, '-' and '_'.
+	Name string
+
+	// CPU overrides Request.CPU for this variant.
+	CPU string
+
+	// Tags are added to Request.Tags for this variant, so that source
+	// files can opt in or out with //go:build constraints.
+	Tags []string
+}
+
+// VariantOutput is the object built for one [Variant].
+type VariantOutput struct {
+	// Name is the variant name.
+	Name string
+
+	// Output is the path to the variant's BPF ELF object.
+	Output string
 }
 
 // Toolchain configures explicit paths to LLVM and TinyGo binaries.
@@ -113,3 +161,82 @@ type Toolchain struct {
 	Objcopy  string
 	Pahole   string
 }
+
+// GenerateRequest describes the inputs and options for generating Go loader
+// code from a compiled BPF ELF object.
+type GenerateRequest struct {
+	// Object is the path to the BPF ELF object.
+	// Mutually exclusive with ObjectBytes.
+	Object string
+
+	// ObjectBytes is the contents of the BPF ELF object.
+	// Mutually exclusive with Object.
+	ObjectBytes []byte
+
+	// Package is the Go package name of the generated code. Required.
+	Package string
+
+	// Output, if set, is the path the generated loader is written to.
+	// With WithFakes, the fakes are written next to it (see [FakesPath]).
+	// Otherwise the code is only returned in the [GenerateResult].
+	Output string
+
+	// EmbedPath is the path of the object relative to the generated file,
+	// embedded with //go:embed. If empty, the loader takes the object path
+	// at load time. Required for the "bpf2go" style.
+	EmbedPath string
+
+	// Style selects the output style: "tinybpf", or "bpf2go" for a loader
+	// API-compatible with cilium/ebpf's bpf2go. Defaults to "tinybpf".
+	Style string
+
+	// Ident is the identifier prefix for the "bpf2go" style
+	// (e.g. "counter" for loadCounterObjects and counterObjects).
+	Ident string
+
+	// Types are BTF type names to emit as Go types.
+	Types []string
+
+	// Events maps ring buffer and perf event array names to the BTF type
+	// name of their records.
+	Events map[string]string
+
+	// PinPath is a bpffs directory emitted as the loader's DefaultPinPath.
+	// Must be absolute.
+	PinPath string
+
+	// WithFakes also generates map interfaces and in-memory fakes.
+	// Not supported with the "bpf2go" style.
+	WithFakes bool
+
+	// Variants are other builds of Object to embed as fallbacks, in order
+	// of preference after Object. The loader probes the kernel's features
+	// and loads the first variant it supports. Requires EmbedPath, and is
+	// not supported with the "bpf2go" style.
+	Variants []GenerateVariant
+}
+
+// GenerateVariant is a fallback build of the object in a [GenerateRequest].
+type GenerateVariant struct {
+	// Object is the path to the variant's BPF ELF object.
+	Object string
+
+	// EmbedPath is the variant's path relative to the generated file.
+	EmbedPath string
+}
+
+// GenerateResult holds the outputs of a successful [Generate].
+type GenerateResult struct {
+	// Source is the formatted Go source of the loader.
+	Source []byte
+
+	// Fakes is the formatted Go source of the map interfaces and fakes,
+	// populated when WithFakes was set.
+	Fakes []byte
+
+	// Programs lists the BPF program symbol names found in the object.
+	Programs []string
+
+	// Maps lists the BPF map symbol names found in the object.
+	Maps []string
+}